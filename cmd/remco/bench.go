@@ -0,0 +1,120 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/log"
+	"github.com/HeavyHorst/remco/pkg/template"
+)
+
+// runBench implements the bench subcommand.
+// It renders the templates of every resource against a snapshot of the backends
+// and prints the results in the benchstat format.
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	path := fs.String("config", defaultConfig, "path to the configuration file")
+	format := fs.String("format", "", formatUsage)
+	iterations := fs.Int("iterations", 1000, "number of render runs per resource")
+	fs.Parse(args)
+
+	if *iterations <= 0 {
+		fmt.Fprintln(os.Stderr, "iterations must be > 0")
+		return exitCodeError
+	}
+
+	cfg, err := NewConfigurationWithFormat(*path, *format)
+	if err != nil {
+		log.Error(err)
+		return exitCodeError
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt)
+	go func() {
+		<-signalChan
+		cancel()
+	}()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, ' ', 0)
+	fmt.Fprintf(w, "goos: %s\ngoarch: %s\npkg: remco\n", runtime.GOOS, runtime.GOARCH)
+	code := exitCodeOK
+	for _, r := range cfg.Resource {
+		result, err := template.Bench(ctx, r.resourceConfig(), *iterations)
+		if err != nil {
+			log.Error(fmt.Sprintf("benchmarking resource %s failed: %v", r.Name, err))
+			code = exitCodeError
+			continue
+		}
+		writeBenchResult(w, r.Name, result)
+	}
+	w.Flush()
+	return code
+}
+
+// writeBenchResult writes one benchmark line for the resource and one for every template.
+func writeBenchResult(w io.Writer, name string, result template.BenchResult) {
+	writeBenchLine(w, "BenchmarkResource/"+benchName(name), result.Process)
+	for _, t := range result.Templates {
+		writeBenchLine(w, "BenchmarkTemplate/"+benchName(name)+"/"+benchName(t.Dst), t.Durations)
+	}
+}
+
+func writeBenchLine(w io.Writer, name string, durations []time.Duration) {
+	if len(durations) == 0 {
+		return
+	}
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	mean := total / time.Duration(len(sorted))
+	rate := 0.0
+	if total > 0 {
+		rate = float64(len(sorted)) / total.Seconds()
+	}
+
+	fmt.Fprintf(w, "%s\t%d\t%d ns/op\t%.2f renders/s\t%d p50-ns\t%d p95-ns\t%d p99-ns\n",
+		name, len(sorted), mean.Nanoseconds(), rate,
+		percentile(sorted, 50).Nanoseconds(),
+		percentile(sorted, 95).Nanoseconds(),
+		percentile(sorted, 99).Nanoseconds())
+}
+
+// percentile returns the p-th percentile (nearest rank) of the sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// benchName converts s to a benchmark name without whitespace.
+func benchName(s string) string {
+	return strings.Join(strings.Fields(strings.TrimPrefix(s, "/")), "_")
+}
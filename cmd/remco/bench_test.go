@@ -0,0 +1,49 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/template"
+
+	. "gopkg.in/check.v1"
+)
+
+type BenchSuite struct{}
+
+var _ = Suite(&BenchSuite{})
+
+func (s *BenchSuite) TestPercentile(t *C) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+	t.Check(percentile(sorted, 50), Equals, time.Duration(50))
+	t.Check(percentile(sorted, 95), Equals, time.Duration(95))
+	t.Check(percentile(sorted, 99), Equals, time.Duration(99))
+	t.Check(percentile(sorted[:1], 99), Equals, time.Duration(1))
+}
+
+func (s *BenchSuite) TestWriteBenchResult(t *C) {
+	var buf bytes.Buffer
+	writeBenchResult(&buf, "my resource", template.BenchResult{
+		Process: []time.Duration{2000, 4000},
+		Templates: []template.TemplateBenchResult{
+			{Dst: "/etc/app.conf", Durations: []time.Duration{1000, 3000}},
+		},
+	})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	t.Assert(lines, HasLen, 2)
+	t.Check(lines[0], Equals, "BenchmarkResource/my_resource\t2\t3000 ns/op\t333333.33 renders/s\t2000 p50-ns\t4000 p95-ns\t4000 p99-ns")
+	t.Check(lines[1], Equals, "BenchmarkTemplate/my_resource/etc/app.conf\t2\t2000 ns/op\t500000.00 renders/s\t1000 p50-ns\t3000 p95-ns\t3000 p99-ns")
+}
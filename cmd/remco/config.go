@@ -9,15 +9,20 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
+	"sort"
+	"sync"
 
 	"github.com/BurntSushi/toml"
 	"github.com/HeavyHorst/remco/pkg/backends"
 	"github.com/HeavyHorst/remco/pkg/backends/plugin"
 	"github.com/HeavyHorst/remco/pkg/log"
+	"github.com/HeavyHorst/remco/pkg/runner"
 	"github.com/HeavyHorst/remco/pkg/telemetry"
 	"github.com/HeavyHorst/remco/pkg/template"
 	"github.com/pkg/errors"
@@ -52,6 +57,93 @@ func (c *BackendConfigs) GetBackends() []template.BackendConnector {
 	}
 }
 
+// Validate checks the configuration of every configured backend without connecting to it.
+func (c *BackendConfigs) Validate() error {
+	validators := []interface{ Validate() error }{}
+	if c.Etcd != nil {
+		validators = append(validators, c.Etcd)
+	}
+	if c.File != nil {
+		validators = append(validators, c.File)
+	}
+	if c.Env != nil {
+		validators = append(validators, c.Env)
+	}
+	if c.Consul != nil {
+		validators = append(validators, c.Consul)
+	}
+	if c.Vault != nil {
+		validators = append(validators, c.Vault)
+	}
+	if c.Redis != nil {
+		validators = append(validators, c.Redis)
+	}
+	if c.Zookeeper != nil {
+		validators = append(validators, c.Zookeeper)
+	}
+	if c.Mock != nil {
+		validators = append(validators, c.Mock)
+	}
+	for i := range c.Plugin {
+		validators = append(validators, &c.Plugin[i])
+	}
+	for _, v := range validators {
+		if err := v.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backends returns the template.Backend of every configured backend.
+func (c *BackendConfigs) backends() []*template.Backend {
+	var b []*template.Backend
+	if c.Etcd != nil {
+		b = append(b, &c.Etcd.Backend)
+	}
+	if c.File != nil {
+		b = append(b, &c.File.Backend)
+	}
+	if c.Env != nil {
+		b = append(b, &c.Env.Backend)
+	}
+	if c.Consul != nil {
+		b = append(b, &c.Consul.Backend)
+	}
+	if c.Vault != nil {
+		b = append(b, &c.Vault.Backend)
+	}
+	if c.Redis != nil {
+		b = append(b, &c.Redis.Backend)
+	}
+	if c.Zookeeper != nil {
+		b = append(b, &c.Zookeeper.Backend)
+	}
+	if c.Mock != nil {
+		b = append(b, &c.Mock.Backend)
+	}
+	for i := range c.Plugin {
+		b = append(b, &c.Plugin[i].Backend)
+	}
+	return b
+}
+
+// tlsFiles returns the TLS certificates and keys of the backends.
+// They are read when the backends connect.
+func (c *BackendConfigs) tlsFiles() []string {
+	var files []string
+	if c.Etcd != nil {
+		files = append(files, c.Etcd.ClientCert, c.Etcd.ClientKey, c.Etcd.ClientCaKeys)
+	}
+	if c.Consul != nil {
+		files = append(files, c.Consul.ClientCert, c.Consul.ClientKey, c.Consul.ClientCaKeys)
+	}
+	if c.Vault != nil {
+		files = append(files, c.Vault.ClientCert, c.Vault.ClientKey, c.Vault.ClientCaKeys)
+	}
+	return files
+}
+
 // Configuration is the representation of an config file
 type Configuration struct {
 	LogLevel   string `toml:"log_level"`
@@ -62,6 +154,24 @@ type Configuration struct {
 	LogFile    string `toml:"log_file"`
 	Resource   []Resource
 	Telemetry  telemetry.Telemetry
+
+	// IncludeFiles are glob patterns of resource files that are loaded in addition to the include_dir.
+	IncludeFiles []string `toml:"include_files"`
+
+	// StrictIncludes rejects the configuration if a resource file can't be loaded, the default is true.
+	// Otherwise the file is skipped and the remaining resources are started.
+	StrictIncludes *bool `toml:"strict_includes"`
+
+	// includeErrors holds the errors of the skipped resource files.
+	includeErrors []error
+
+	// Variables are static variables of all resources, the variables of a resource override them.
+	Variables       map[string]string
+	VariablesPrefix string `toml:"variables_prefix"`
+
+	// ControlFile lists the names of the resources that are paused on SIGUSR1 and resumed on SIGUSR2.
+	// The signals are forwarded to the child processes if it isn't set.
+	ControlFile string `toml:"control_file"`
 }
 
 type DefaultBackends struct {
@@ -72,39 +182,229 @@ type DefaultBackends struct {
 // Resource is the representation of an resource configuration
 type Resource struct {
 	Exec      template.ExecConfig
-	StartCmd  string `toml:"start_cmd" json:"start_cmd"`
-	ReloadCmd string `toml:"reload_cmd" json:"reload_cmd"`
-	Template  []*template.Renderer
-	Backends  BackendConfigs `toml:"backend"`
+	StartCmd  string                  `toml:"start_cmd" json:"start_cmd"`
+	ReloadCmd template.ReloadCommands `toml:"reload_cmd" json:"reload_cmd"`
+
+	ReloadTimeout int `toml:"reload_timeout" json:"reload_timeout"`
+	ReloadWait    int `toml:"reload_wait" json:"reload_wait"`
+	Template      []*template.Renderer
+	Backends      BackendConfigs `toml:"backend"`
+
+	PostSyncCmd     string `toml:"post_sync_cmd" json:"post_sync_cmd"`
+	PostSyncTimeout int    `toml:"post_sync_timeout" json:"post_sync_timeout"`
+
+	// the event hooks are notified about changed files and failed renders.
+	OnChange *template.EventHook `toml:"on_change" json:"on_change"`
+	OnError  *template.EventHook `toml:"on_error" json:"on_error"`
+
+	// the notify webhook receives a POST request after every render that changed a file.
+	NotifyWebhookURL     string            `toml:"notify_webhook_url" json:"notify_webhook_url"`
+	NotifyWebhookHeaders map[string]string `toml:"notify_webhook_headers" json:"notify_webhook_headers"`
+	NotifyWebhookTimeout int               `toml:"notify_webhook_timeout" json:"notify_webhook_timeout"`
+
+	ParallelBackends      bool `toml:"parallel_backends" json:"parallel_backends"`
+	ParallelTemplates     bool `toml:"parallel_templates" json:"parallel_templates"`
+	RequireAllBackends    bool `toml:"require_all_backends" json:"require_all_backends"`
+	MaxConcurrentBackends int  `toml:"max_concurrent_backends" json:"max_concurrent_backends"`
+	RenderOnShutdown      bool `toml:"render_on_shutdown" json:"render_on_shutdown"`
+	MaxStaleAge           int  `toml:"max_stale_age" json:"max_stale_age"`
+	HistorySize           int  `toml:"history_size" json:"history_size"`
+	CoalesceWindowMs      int  `toml:"coalesce_window_ms" json:"coalesce_window_ms"`
+	MinRenderInterval     int  `toml:"min_render_interval" json:"min_render_interval"`
+
+	RetryMin    int     `toml:"retry_min" json:"retry_min"`
+	RetryMax    int     `toml:"retry_max" json:"retry_max"`
+	RetryFactor float64 `toml:"retry_factor" json:"retry_factor"`
+	RetryJitter string  `toml:"retry_jitter" json:"retry_jitter"`
+
+	StartupMaxRetries   int  `toml:"startup_max_retries" json:"startup_max_retries"`
+	StartupTimeout      int  `toml:"startup_timeout" json:"startup_timeout"`
+	ProcessTimeout      int  `toml:"process_timeout" json:"process_timeout"`
+	StartChildOnFailure bool `toml:"start_child_on_failure" json:"start_child_on_failure"`
+	WaitForBackends     int  `toml:"wait_for_backends" json:"wait_for_backends"`
+
+	ReadinessGateFile string `toml:"readiness_gate_file" json:"readiness_gate_file"`
+
+	// the static variables are merged into the store, they override the global variables.
+	Variables       map[string]string `json:"variables"`
+	VariablesPrefix string            `toml:"variables_prefix" json:"variables_prefix"`
+
+	// MergeStrategy resolves the key collisions of the variables and the backends.
+	MergeStrategy string `toml:"merge_strategy" json:"merge_strategy"`
+
+	// DependsOn lists the names of the resources that must be ready before this resource is started.
+	DependsOn        []string `toml:"depends_on" json:"depends_on"`
+	DependsOnTimeout int      `toml:"depends_on_timeout" json:"depends_on_timeout"`
+	DependsOnHealthy bool     `toml:"depends_on_healthy" json:"depends_on_healthy"`
+
+	// a failed resource is restarted after an exponential backoff, it is abandoned after MaxRestarts consecutive restarts.
+	RestartBackoffMin int `toml:"restart_backoff_min" json:"restart_backoff_min"`
+	RestartBackoffMax int `toml:"restart_backoff_max" json:"restart_backoff_max"`
+	RestartResetAfter int `toml:"restart_reset_after" json:"restart_reset_after"`
+	MaxRestarts       int `toml:"max_restarts" json:"max_restarts"`
 
 	// defaults to the filename of the resource
 	Name string
+
+	// file is the configuration file the resource has been loaded from.
+	file string
 }
 
-func readFileAndExpandEnv(path string) ([]byte, error) {
+// resourceConfig returns the template.ResourceConfig of the resource.
+func (r Resource) resourceConfig() template.ResourceConfig {
+	backendConfigs := r.Backends.GetBackends()
+	for i := range r.Backends.Plugin {
+		backendConfigs = append(backendConfigs, &r.Backends.Plugin[i])
+	}
+
+	return template.ResourceConfig{
+		Exec:       r.Exec,
+		StartCmd:   r.StartCmd,
+		Template:   r.Template,
+		Name:       r.Name,
+		ConfigFile: r.file,
+		Connectors: backendConfigs,
+
+		Reload: template.ReloadConfig{
+			Cmd:             r.ReloadCmd,
+			Timeout:         r.ReloadTimeout,
+			Wait:            r.ReloadWait,
+			PostSyncCmd:     r.PostSyncCmd,
+			PostSyncTimeout: r.PostSyncTimeout,
+		},
+		Store: template.StoreConfig{
+			ParallelBackends:      r.ParallelBackends,
+			MaxConcurrentBackends: r.MaxConcurrentBackends,
+			RequireAllBackends:    r.RequireAllBackends,
+			MaxStaleAge:           r.MaxStaleAge,
+			Variables:             r.Variables,
+			VariablesPrefix:       r.VariablesPrefix,
+			MergeStrategy:         r.MergeStrategy,
+		},
+		Render: template.RenderConfig{
+			ParallelTemplates: r.ParallelTemplates,
+			ProcessTimeout:    r.ProcessTimeout,
+			CoalesceWindowMs:  r.CoalesceWindowMs,
+			MinRenderInterval: r.MinRenderInterval,
+			HistorySize:       r.HistorySize,
+			RenderOnShutdown:  r.RenderOnShutdown,
+			ReadinessGateFile: r.ReadinessGateFile,
+		},
+		Retry: template.RetryConfig{
+			Min:    r.RetryMin,
+			Max:    r.RetryMax,
+			Factor: r.RetryFactor,
+			Jitter: r.RetryJitter,
+		},
+		Startup: template.StartupConfig{
+			MaxRetries:          r.StartupMaxRetries,
+			Timeout:             r.StartupTimeout,
+			StartChildOnFailure: r.StartChildOnFailure,
+			WaitForBackends:     r.WaitForBackends,
+		},
+		Notify: template.NotifyConfig{
+			OnChange:       r.OnChange,
+			OnError:        r.OnError,
+			WebhookURL:     r.NotifyWebhookURL,
+			WebhookHeaders: r.NotifyWebhookHeaders,
+			WebhookTimeout: r.NotifyWebhookTimeout,
+		},
+	}
+}
+
+// runnerConfig returns the runner.Config of the resources.
+func runnerConfig(resources []Resource, reapLock *sync.RWMutex) runner.Config {
+	cfg := runner.Config{ReapLock: reapLock}
+	for _, r := range resources {
+		cfg.Resources = append(cfg.Resources, runner.ResourceConfig{
+			ResourceConfig:    r.resourceConfig(),
+			DependsOn:         r.DependsOn,
+			DependsOnTimeout:  r.DependsOnTimeout,
+			DependsOnHealthy:  r.DependsOnHealthy,
+			RestartBackoffMin: r.RestartBackoffMin,
+			RestartBackoffMax: r.RestartBackoffMax,
+			RestartResetAfter: r.RestartResetAfter,
+			MaxRestarts:       r.MaxRestarts,
+		})
+	}
+	return cfg
+}
+
+// readConfigFile reads the configuration file at path in the format and expands the environment variables.
+// A file that has been encrypted with sops is decrypted instead, its environment variables aren't expanded:
+// the decrypted secrets may contain a $.
+func readConfigFile(path, format string) ([]byte, error) {
 	buf, err := ioutil.ReadFile(path)
 	if err != nil {
 		return buf, errors.Wrap(err, "read file failed")
 	}
+	if isSopsEncrypted(buf, format) {
+		return decryptSops(path, format)
+	}
 	// expand the environment variables
 	buf = []byte(os.ExpandEnv(string(buf)))
 	return buf, nil
 }
 
+// configDigest returns a hash of the configuration file at `path`, the resource files of include_dir and include_files
+// and the TLS files of the backends of cfg. The digest changes if the configuration or the certificates the backends connect with change.
+func configDigest(path string, cfg Configuration) (string, error) {
+	includes, err := cfg.includeFiles()
+	if err != nil {
+		return "", err
+	}
+	files := append([]string{path}, includes...)
+	seen := make(map[string]bool)
+	for _, r := range cfg.Resource {
+		for _, f := range r.Backends.tlsFiles() {
+			if f != "" && !seen[f] {
+				seen[f] = true
+				files = append(files, f)
+			}
+		}
+	}
+
+	h := sha256.New()
+	for _, f := range files {
+		buf, err := ioutil.ReadFile(f)
+		if err != nil {
+			return "", errors.Wrap(err, "read file failed")
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", f, len(buf))
+		h.Write(buf)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // NewConfiguration reads the file at `path`, expand the environment variables
 // and unmarshals it to a new configuration struct.
+// The format (toml, yaml or json) is derived from the file extension.
 // It returns an error if any.
 func NewConfiguration(path string) (Configuration, error) {
+	return NewConfigurationWithFormat(path, "")
+}
+
+// NewConfigurationWithFormat is NewConfiguration for a configuration file in the format,
+// the format is derived from the file extension if it is empty.
+// The resource files of the include_dir and include_files always have the format of their file extension.
+func NewConfigurationWithFormat(path, format string) (Configuration, error) {
 	var c Configuration
 	var dbc DefaultBackends
 
-	buf, err := readFileAndExpandEnv(path)
+	format, err := configFormat(path, format)
+	if err != nil {
+		return c, err
+	}
+	buf, err := readConfigFile(path, format)
 	if err != nil {
 		return c, err
 	}
+	if buf, err = toTOML(buf, format, c, dbc); err != nil {
+		return c, errors.Wrapf(err, "%s unmarshal failed: %s", format, path)
+	}
 
 	if err := toml.Unmarshal(buf, &dbc); err != nil {
-		return c, errors.Wrapf(err, "toml unmarshal failed: %s", path)
+		return c, errors.Wrapf(err, "%s unmarshal failed: %s", format, path)
 	}
 
 	c.Resource = dbc.Resource
@@ -113,49 +413,33 @@ func NewConfiguration(path string) (Configuration, error) {
 	}
 
 	if err := toml.Unmarshal(buf, &c); err != nil {
-		return c, errors.Wrapf(err, "toml unmarshal failed: %s", path)
+		return c, errors.Wrapf(err, "%s unmarshal failed: %s", format, path)
 	}
 
-	for _, v := range c.Resource {
-		if v.Name == "" {
-			v.Name = filepath.Base(path)
-		}
+	setDefaultNames(c.Resource, path)
+	for i := range c.Resource {
+		c.Resource[i].file = path
+	}
+	if err := checkDuplicateNames(nil, c.Resource); err != nil {
+		return c, errors.Wrap(err, path)
 	}
 
-	if c.IncludeDir != "" {
-		files, err := ioutil.ReadDir(c.IncludeDir)
-		if err != nil {
-			return c, err
-		}
-		for _, file := range files {
-			if strings.HasSuffix(file.Name(), ".toml") {
-				fp := filepath.Join(c.IncludeDir, file.Name())
-
-				log.WithFields(logrus.Fields{
-					"path": fp,
-				}).Info("loading resource configuration")
-
-				buf, err := readFileAndExpandEnv(fp)
-				if err != nil {
-					return c, err
-				}
-				r := Resource{
-					Backends: dbc.Backends,
-				}
-				if err := toml.Unmarshal(buf, &r); err != nil {
-					return c, errors.Wrapf(err, "toml unmarshal failed: %s", fp)
-				}
-				// don't add empty resources
-				if len(r.Template) > 0 {
-					if r.Name == "" {
-						r.Name = file.Name()
-					}
-					c.Resource = append(c.Resource, r)
-				}
-			}
+	if err := c.loadIncludes(dbc.Backends); err != nil {
+		return c, err
+	}
+
+	c.mergeVariables()
+
+	for _, r := range c.Resource {
+		if err := r.Backends.Validate(); err != nil {
+			return c, errors.Wrapf(err, "resource %s", r.Name)
 		}
 	}
 
+	if err := runnerConfig(c.Resource, nil).Validate(); err != nil {
+		return c, err
+	}
+
 	if c.FilterDir != "" {
 		if err := template.RegisterCustomJsFilters(c.FilterDir); err != nil {
 			return c, err
@@ -167,6 +451,202 @@ func NewConfiguration(path string) (Configuration, error) {
 	return c, nil
 }
 
+// includeFiles returns the resource files of the include_dir (with the .toml, .yaml, .yml or .json suffix)
+// and the files matching the include_files patterns in lexical order, without duplicates.
+func (c *Configuration) includeFiles() ([]string, error) {
+	var files []string
+	if c.IncludeDir != "" {
+		entries, err := ioutil.ReadDir(c.IncludeDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if !e.IsDir() && isConfigFile(e.Name()) {
+				files = append(files, filepath.Join(c.IncludeDir, e.Name()))
+			}
+		}
+	}
+	for _, pattern := range c.IncludeFiles {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "include_files %q", pattern)
+		}
+		files = append(files, matches...)
+	}
+
+	sort.Strings(files)
+	var unique []string
+	for i, f := range files {
+		if i == 0 || f != files[i-1] {
+			unique = append(unique, f)
+		}
+	}
+	return unique, nil
+}
+
+// loadIncludes appends the resources of the include files to the configuration.
+// A file that can't be loaded rejects the configuration if strict_includes is enabled,
+// otherwise it is logged and skipped and the error is recorded in includeErrors.
+func (c *Configuration) loadIncludes(defaults BackendConfigs) error {
+	files, err := c.includeFiles()
+	if err != nil {
+		return err
+	}
+	strict := c.StrictIncludes == nil || *c.StrictIncludes
+	for _, fp := range files {
+		log.WithFields(logrus.Fields{
+			"path": fp,
+		}).Info("loading resource configuration")
+
+		resources, err := loadResourceFile(fp, defaults)
+		if err == nil {
+			err = checkDuplicateNames(c.Resource, resources)
+		}
+		if err != nil {
+			err = errors.Wrap(err, fp)
+			if strict {
+				return err
+			}
+			log.WithFields(logrus.Fields{
+				"path": fp,
+			}).Error(errors.Wrap(err, "skipping the resource file"))
+			c.includeErrors = append(c.includeErrors, err)
+			continue
+		}
+		c.Resource = append(c.Resource, resources...)
+	}
+	if len(c.includeErrors) > 0 {
+		log.Warning(fmt.Sprintf("%d of %d resource files couldn't be loaded, starting the remaining resources", len(c.includeErrors), len(files)))
+	}
+	return nil
+}
+
+// loadResourceFile reads the resources of an include file in the format of its file extension.
+// The file holds either a single resource or a [[resource]] list, the default backends apply to all of them.
+// Resources without templates are skipped, unnamed resources are named by setDefaultNames.
+func loadResourceFile(fp string, defaults BackendConfigs) ([]Resource, error) {
+	format, _ := configFormat(fp, "")
+	buf, err := readConfigFile(fp, format)
+	if err != nil {
+		return nil, err
+	}
+
+	var list struct {
+		Resource []Resource
+	}
+	unmarshalFailed := format + " unmarshal failed"
+	if buf, err = toTOML(buf, format, Resource{}, list); err != nil {
+		return nil, errors.Wrap(err, unmarshalFailed)
+	}
+	md, err := toml.Decode(string(buf), &list)
+	if err != nil {
+		return nil, errors.Wrap(err, unmarshalFailed)
+	}
+
+	var resources []Resource
+	if md.IsDefined("resource") {
+		resources = make([]Resource, len(list.Resource))
+		for i := range resources {
+			resources[i].Backends = defaults
+		}
+		list.Resource = resources
+		if err := toml.Unmarshal(buf, &list); err != nil {
+			return nil, errors.Wrap(err, unmarshalFailed)
+		}
+	} else {
+		r := Resource{Backends: defaults}
+		if err := toml.Unmarshal(buf, &r); err != nil {
+			return nil, errors.Wrap(err, unmarshalFailed)
+		}
+		resources = []Resource{r}
+	}
+
+	var result []Resource
+	for _, r := range resources {
+		// don't add empty resources
+		if len(r.Template) == 0 {
+			continue
+		}
+		r.file = fp
+		result = append(result, r)
+	}
+	setDefaultNames(result, fp)
+	return result, nil
+}
+
+// setDefaultNames names the unnamed resources of the file path after the file.
+// If the file holds several resources, their position in the file is appended, e.g. "services.toml#2".
+func setDefaultNames(resources []Resource, path string) {
+	for i := range resources {
+		if resources[i].Name != "" {
+			continue
+		}
+		resources[i].Name = filepath.Base(path)
+		if len(resources) > 1 {
+			resources[i].Name += fmt.Sprintf("#%d", i+1)
+		}
+	}
+}
+
+// checkDuplicateNames returns an error if a name of the added resources is already used
+// by another added resource or one of the existing resources.
+func checkDuplicateNames(existing, added []Resource) error {
+	files := make(map[string]string, len(existing)+len(added))
+	for _, r := range existing {
+		files[r.Name] = r.file
+	}
+	for _, r := range added {
+		if f, ok := files[r.Name]; ok {
+			return fmt.Errorf("duplicate resource name %q, it is also used in %s", r.Name, f)
+		}
+		files[r.Name] = r.file
+	}
+	return nil
+}
+
+// setOnetime overrides the configuration of every backend in every resource
+// to render the templates exactly once.
+func (c *Configuration) setOnetime() {
+	for _, r := range c.Resource {
+		for _, b := range r.Backends.backends() {
+			b.Onetime = true
+			b.Watch = false
+			b.Interval = 0
+			b.Schedule = ""
+		}
+	}
+}
+
+// mergeVariables merges the global variables into the variables of every resource.
+// The variables and the variables_prefix of a resource override the global ones.
+func (c *Configuration) mergeVariables() {
+	if len(c.Variables) == 0 && c.VariablesPrefix == "" {
+		return
+	}
+	for i := range c.Resource {
+		r := &c.Resource[i]
+		vars := make(map[string]string, len(c.Variables)+len(r.Variables))
+		for k, v := range c.Variables {
+			vars[k] = v
+		}
+		for k, v := range r.Variables {
+			vars[k] = v
+		}
+		r.Variables = vars
+		if r.VariablesPrefix == "" {
+			r.VariablesPrefix = c.VariablesPrefix
+		}
+	}
+}
+
+// disableExec removes the exec configuration of every resource,
+// so that neither the child processes nor their hooks are started.
+func (c *Configuration) disableExec() {
+	for i := range c.Resource {
+		c.Resource[i].Exec = template.ExecConfig{}
+	}
+}
+
 // configureLogger configures the global logger.
 // It sets the log level and log formatting.
 func (c *Configuration) configureLogger() {
@@ -0,0 +1,255 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/ghodss/yaml"
+)
+
+// The formats of the configuration files.
+const (
+	formatTOML = "toml"
+	formatYAML = "yaml"
+	formatJSON = "json"
+)
+
+// formatUsage is the usage of the -format flags.
+const formatUsage = "the format of the configuration file: toml, yaml or json, the default is derived from the file extension"
+
+// configFormat returns the format of the configuration file at path.
+// An explicit format wins, otherwise .yaml and .yml files are yaml, .json files are json and every other file is toml.
+func configFormat(path, explicit string) (string, error) {
+	switch strings.ToLower(explicit) {
+	case "":
+	case formatTOML, formatYAML, formatJSON:
+		return strings.ToLower(explicit), nil
+	case "yml":
+		return formatYAML, nil
+	default:
+		return "", fmt.Errorf("unknown configuration format %q, expected toml, yaml or json", explicit)
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return formatYAML, nil
+	case ".json":
+		return formatJSON, nil
+	}
+	return formatTOML, nil
+}
+
+// isConfigFile reports whether the include_dir entry name is a resource file.
+func isConfigFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".toml", ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// toTOML converts a yaml or json configuration to toml, so that every format is decoded
+// into the configuration structs the same way and every option has the same name in every format.
+// The integers of the fields of targets with a float type are converted to floats, toml doesn't convert them itself.
+// The syntax errors have the line (and for json the column) of the original file.
+func toTOML(buf []byte, format string, targets ...interface{}) ([]byte, error) {
+	var data interface{}
+	switch format {
+	case formatTOML:
+		return buf, nil
+	case formatYAML:
+		// the yaml errors have the line
+		j, err := yaml.YAMLToJSON(buf)
+		if err != nil {
+			return nil, err
+		}
+		if err := decodeJSON(j, &data); err != nil {
+			return nil, err
+		}
+	case formatJSON:
+		// Unmarshal checks the syntax of the whole file
+		if err := json.Unmarshal(buf, new(json.RawMessage)); err != nil {
+			return nil, jsonPositionError(buf, err)
+		}
+		if err := decodeJSON(buf, &data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown configuration format %q", format)
+	}
+
+	data, err := normalizeValue(data, "")
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		// an empty document
+		return nil, nil
+	}
+	table, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("the configuration must be a table, got %T", data)
+	}
+	for _, t := range targets {
+		coerceFloats(table, reflect.TypeOf(t))
+	}
+
+	var out bytes.Buffer
+	if err := toml.NewEncoder(&out).Encode(table); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// decodeJSON decodes buf into v, the numbers are decoded as json.Number to keep the integers.
+func decodeJSON(buf []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// jsonPositionError adds the line and the column of a json syntax error.
+func jsonPositionError(buf []byte, err error) error {
+	e, ok := err.(*json.SyntaxError)
+	if !ok || e.Offset > int64(len(buf)) {
+		return err
+	}
+	before := buf[:e.Offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n') - 1
+	return fmt.Errorf("line %d, column %d: %v", line, column, err)
+}
+
+// normalizeValue converts the decoded json values to the types the toml encoder supports.
+// Null values are dropped, like unset options.
+func normalizeValue(v interface{}, key string) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			n, err := normalizeValue(e, joinKey(key, k))
+			if err != nil {
+				return nil, err
+			}
+			if n != nil {
+				m[k] = n
+			}
+		}
+		return m, nil
+	case []interface{}:
+		list := make([]interface{}, 0, len(v))
+		for i, e := range v {
+			n, err := normalizeValue(e, fmt.Sprintf("%s[%d]", key, i))
+			if err != nil {
+				return nil, err
+			}
+			if n == nil {
+				return nil, fmt.Errorf("%s[%d]: null is not allowed in a list", key, i)
+			}
+			list = append(list, n)
+		}
+		return list, nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		return f, nil
+	case nil, string, bool:
+		return v, nil
+	}
+	return nil, fmt.Errorf("%s: unsupported value %v", key, v)
+}
+
+func joinKey(key, k string) string {
+	if key == "" {
+		return k
+	}
+	return key + "." + k
+}
+
+// coerceFloats converts the integers of the float fields of t in v to floats.
+// The fields are matched like the toml decoder matches them: by the toml tag or case-insensitively by the field name.
+func coerceFloats(v interface{}, t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64:
+		if i, ok := v.(int64); ok {
+			return float64(i)
+		}
+	case reflect.Slice, reflect.Array:
+		if list, ok := v.([]interface{}); ok {
+			for i := range list {
+				list[i] = coerceFloats(list[i], t.Elem())
+			}
+		}
+	case reflect.Map:
+		if m, ok := v.(map[string]interface{}); ok {
+			for k := range m {
+				m[k] = coerceFloats(m[k], t.Elem())
+			}
+		}
+	case reflect.Struct:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return v
+		}
+		fields := make(map[string]reflect.Type)
+		collectFields(t, fields)
+		for k := range m {
+			if ft, ok := fields[k]; ok {
+				m[k] = coerceFloats(m[k], ft)
+			} else if ft, ok := fields[strings.ToLower(k)]; ok {
+				m[k] = coerceFloats(m[k], ft)
+			}
+		}
+	}
+	return v
+}
+
+// collectFields adds the exported fields of t (and its embedded structs) by their toml names
+// and by their lower case field names.
+func collectFields(t reflect.Type, fields map[string]reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("toml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				collectFields(ft, fields)
+				continue
+			}
+		}
+		if name != "" {
+			fields[name] = f.Type
+		} else {
+			fields[strings.ToLower(f.Name)] = f.Type
+		}
+	}
+}
@@ -9,8 +9,11 @@
 package main
 
 import (
+	"bytes"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/HeavyHorst/remco/pkg/backends"
@@ -163,5 +166,372 @@ func (s *FilterSuite) TestNewConf(t *C) {
 	if err != nil {
 		t.Error(err)
 	}
-	t.Check(cfg, DeepEquals, expected)
+	exp := expected
+	exp.Resource = append([]Resource(nil), expected.Resource...)
+	exp.Resource[0].file = s.cfgPath
+	exp.Resource[1].file = "/tmp/resource.d/test.toml"
+	t.Check(cfg, DeepEquals, exp)
+}
+
+func (s *FilterSuite) TestConfigDigest(t *C) {
+	dir, err := ioutil.TempDir("", "remco-digest")
+	t.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	cfgPath := filepath.Join(dir, "config")
+	cert := filepath.Join(dir, "cert.pem")
+	cfg := Configuration{
+		IncludeDir: filepath.Join(dir, "resource.d"),
+		Resource: []Resource{{
+			Backends: BackendConfigs{Etcd: &backends.EtcdConfig{ClientCert: cert}},
+		}},
+	}
+	t.Assert(os.Mkdir(cfg.IncludeDir, 0755), IsNil)
+	t.Assert(ioutil.WriteFile(cfgPath, []byte(testFile), 0644), IsNil)
+	t.Assert(ioutil.WriteFile(filepath.Join(cfg.IncludeDir, "a.toml"), []byte(resourceFile), 0644), IsNil)
+	t.Assert(ioutil.WriteFile(cert, []byte("cert"), 0644), IsNil)
+
+	digest, err := configDigest(cfgPath, cfg)
+	t.Assert(err, IsNil)
+	again, err := configDigest(cfgPath, cfg)
+	t.Assert(err, IsNil)
+	t.Check(again, Equals, digest)
+
+	// files without the .toml suffix aren't loaded
+	t.Assert(ioutil.WriteFile(filepath.Join(cfg.IncludeDir, "a.toml.bak"), []byte("ignored"), 0644), IsNil)
+	again, err = configDigest(cfgPath, cfg)
+	t.Assert(err, IsNil)
+	t.Check(again, Equals, digest)
+
+	// every change is detected
+	changes := []func(){
+		// a renamed resource file changes the default resource name
+		func() {
+			t.Assert(os.Rename(filepath.Join(cfg.IncludeDir, "a.toml"), filepath.Join(cfg.IncludeDir, "b.toml")), IsNil)
+		},
+		func() { t.Assert(ioutil.WriteFile(cfgPath, []byte(testFile+"\n"), 0644), IsNil) },
+		// a rotated certificate
+		func() { t.Assert(ioutil.WriteFile(cert, []byte("rotated"), 0644), IsNil) },
+	}
+	for _, change := range changes {
+		change()
+		changed, err := configDigest(cfgPath, cfg)
+		t.Assert(err, IsNil)
+		t.Check(changed, Not(Equals), digest)
+		digest = changed
+	}
+
+	t.Assert(os.Remove(cert), IsNil)
+	_, err = configDigest(cfgPath, cfg)
+	t.Check(err, NotNil)
+}
+
+func (s *FilterSuite) TestSetOnetime(t *C) {
+	cfg, err := NewConfiguration(s.cfgPath)
+	t.Assert(err, IsNil)
+	cfg.setOnetime()
+	for _, r := range cfg.Resource {
+		for _, b := range r.Backends.backends() {
+			t.Check(b.Onetime, Equals, true)
+			t.Check(b.Watch, Equals, false)
+			t.Check(b.Interval, Equals, 0)
+			t.Check(b.Schedule, Equals, "")
+		}
+	}
+}
+
+func (s *FilterSuite) TestDisableExec(t *C) {
+	cfg, err := NewConfiguration(s.cfgPath)
+	t.Assert(err, IsNil)
+	t.Assert(cfg.Resource, Not(HasLen), 0)
+	for i := range cfg.Resource {
+		cfg.Resource[i].Exec = template.ExecConfig{Command: "sleep 60", PreStartCmd: "true"}
+	}
+	cfg.disableExec()
+	for _, r := range cfg.Resource {
+		t.Check(r.Exec.Command, Equals, "")
+		t.Check(r.Exec.PreStartCmd, Equals, "")
+	}
+}
+
+func (s *FilterSuite) TestNewConfInvalidBackend(t *C) {
+	f, err := ioutil.TempFile("", "remco-config")
+	t.Assert(err, IsNil)
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(`
+      [[resource]]
+		  name = "haproxy"
+		  [[resource.template]]
+	        src = "/tmp/test12345.tmpl"
+	        dst = "/tmp/test12345.cfg"
+	      [resource.backend.consul]
+		      keys = ["/"]
+		      client_cert = "/etc/remco/client.pem"
+`)
+	t.Assert(err, IsNil)
+	f.Close()
+
+	_, err = NewConfiguration(f.Name())
+	t.Assert(err, NotNil)
+	t.Check(err, ErrorMatches, "resource haproxy: invalid consul backend configuration: nodes: .*")
+}
+
+func (s *FilterSuite) TestNewConfVariables(t *C) {
+	dir, err := ioutil.TempDir("", "remco-config")
+	t.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src.tmpl")
+	t.Assert(ioutil.WriteFile(src, []byte("{{ getv(\"/vars/dc\") }}"), 0644), IsNil)
+	os.Setenv("REMCO_TEST_DC", "eu-west")
+	defer os.Unsetenv("REMCO_TEST_DC")
+
+	cfgPath := filepath.Join(dir, "config")
+	t.Assert(ioutil.WriteFile(cfgPath, []byte(`
+      [variables]
+        dc = "${REMCO_TEST_DC}"
+        role = "worker"
+      [[resource]]
+        name = "haproxy"
+        [resource.variables]
+          role = "lb"
+        [[resource.template]]
+          src = "`+src+`"
+          dst = "`+filepath.Join(dir, "haproxy.cfg")+`"
+        [resource.backend.env]
+          keys = ["/"]
+      [[resource]]
+        name = "nginx"
+        variables_prefix = "/static"
+        [[resource.template]]
+          src = "`+src+`"
+          dst = "`+filepath.Join(dir, "nginx.cfg")+`"
+        [resource.backend.env]
+          keys = ["/"]
+`), 0644), IsNil)
+
+	cfg, err := NewConfiguration(cfgPath)
+	t.Assert(err, IsNil)
+	t.Assert(cfg.Resource, HasLen, 2)
+	t.Check(cfg.Resource[0].Variables, DeepEquals, map[string]string{"dc": "eu-west", "role": "lb"})
+	t.Check(cfg.Resource[0].VariablesPrefix, Equals, "")
+	t.Check(cfg.Resource[1].Variables, DeepEquals, map[string]string{"dc": "eu-west", "role": "worker"})
+	t.Check(cfg.Resource[1].VariablesPrefix, Equals, "/static")
+}
+
+func (s *FilterSuite) TestValidateConfiguration(t *C) {
+	cfg := Configuration{Resource: []Resource{{
+		Name:     "haproxy",
+		Template: []*template.Renderer{{Src: "/nonexistent/haproxy.tmpl", Dst: "/tmp/haproxy.cfg"}},
+		Exec:     template.ExecConfig{KillTimeout: -1},
+	}}}
+	var buf bytes.Buffer
+	t.Check(validateConfiguration(&buf, cfg), Equals, false)
+	t.Check(strings.Count(buf.String(), "resource haproxy: "), Equals, 3)
+	t.Check(buf.String(), Matches, "(?s).*backend: at least one backend is required.*")
+}
+
+func (s *FilterSuite) TestNewConfIncludes(t *C) {
+	dir, err := ioutil.TempDir("", "remco-includes")
+	t.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	t.Assert(os.Mkdir(filepath.Join(dir, "resource.d"), 0755), IsNil)
+	t.Assert(os.Mkdir(filepath.Join(dir, "extra"), 0755), IsNil)
+
+	resource := func(name string) string {
+		return `
+        name = "` + name + `"
+        [[template]]
+          src = "/tmp/test12345.tmpl"
+          dst = "/tmp/test12345.cfg"
+        [backend.env]
+          keys = ["/"]
+`
+	}
+	files := map[string]string{
+		"resource.d/b.toml": strings.Replace(resource(""), `name = ""`, "", 1),
+		"resource.d/a.toml": `
+      [[resource]]
+        name = "a1"
+        [[resource.template]]
+          src = "/tmp/test12345.tmpl"
+          dst = "/tmp/a1.cfg"
+      [[resource]]
+        name = "a2"
+        [[resource.template]]
+          src = "/tmp/test12345.tmpl"
+          dst = "/tmp/a2.cfg"
+`,
+		"extra/c.toml":      resource("c"),
+		"extra/c.toml.bak":  resource("ignored"),
+		"resource.d/ignore": resource("ignored"),
+	}
+	for name, content := range files {
+		t.Assert(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), IsNil)
+	}
+
+	cfgPath := filepath.Join(dir, "config")
+	writeConfig := func(extra string) {
+		t.Assert(ioutil.WriteFile(cfgPath, []byte(`
+      include_dir = "`+filepath.Join(dir, "resource.d")+`"
+      include_files = ["`+filepath.Join(dir, "extra", "*.toml")+`", "`+filepath.Join(dir, "resource.d", "a.toml")+`"]
+      `+extra+`
+      [default_backends.env]
+        keys = ["/"]
+      [[resource]]
+        name = "main"
+        [[resource.template]]
+          src = "/tmp/test12345.tmpl"
+          dst = "/tmp/main.cfg"
+`), 0644), IsNil)
+	}
+	writeConfig("")
+
+	names := func(cfg Configuration) []string {
+		var result []string
+		for _, r := range cfg.Resource {
+			result = append(result, r.Name+":"+filepath.Base(r.file))
+		}
+		return result
+	}
+
+	// the files are loaded in lexical order, a file matched twice is loaded once
+	cfg, err := NewConfiguration(cfgPath)
+	t.Assert(err, IsNil)
+	t.Check(names(cfg), DeepEquals, []string{"main:config", "c:c.toml", "a1:a.toml", "a2:a.toml", "b.toml:b.toml"})
+	t.Check(cfg.Resource[2].Backends.Env, NotNil)
+	t.Check(cfg.Resource[1].resourceConfig().ConfigFile, Equals, filepath.Join(dir, "extra", "c.toml"))
+
+	// a broken file and a duplicate name reject the configuration
+	t.Assert(ioutil.WriteFile(filepath.Join(dir, "resource.d", "broken.toml"), []byte("[[template"), 0644), IsNil)
+	_, err = NewConfiguration(cfgPath)
+	t.Check(err, ErrorMatches, "(?s)"+filepath.Join(dir, "resource.d", "broken.toml")+": toml unmarshal failed.*")
+	t.Assert(os.Remove(filepath.Join(dir, "resource.d", "broken.toml")), IsNil)
+
+	t.Assert(ioutil.WriteFile(filepath.Join(dir, "resource.d", "d.toml"), []byte(resource("a1")), 0644), IsNil)
+	_, err = NewConfiguration(cfgPath)
+	t.Check(err, ErrorMatches, `.*d.toml: duplicate resource name "a1", it is also used in .*a.toml`)
+
+	// without strict_includes the remaining files are loaded
+	t.Assert(ioutil.WriteFile(filepath.Join(dir, "resource.d", "broken.toml"), []byte("[[template"), 0644), IsNil)
+	writeConfig("strict_includes = false")
+	cfg, err = NewConfiguration(cfgPath)
+	t.Assert(err, IsNil)
+	t.Check(names(cfg), DeepEquals, []string{"main:config", "c:c.toml", "a1:a.toml", "a2:a.toml", "b.toml:b.toml"})
+	t.Check(cfg.includeErrors, HasLen, 2)
+
+	var buf bytes.Buffer
+	t.Check(validateConfiguration(&buf, cfg), Equals, false)
+	t.Check(buf.String(), Matches, "(?s).*broken.toml: toml unmarshal failed.*d.toml: duplicate resource name.*")
+}
+
+func (s *FilterSuite) TestNewConfUnnamedResources(t *C) {
+	dir, err := ioutil.TempDir("", "remco-unnamed")
+	t.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	cfgPath := filepath.Join(dir, "config")
+	t.Assert(ioutil.WriteFile(cfgPath, []byte(`
+      [[resource]]
+        [[resource.template]]
+          src = "/tmp/test12345.tmpl"
+          dst = "/tmp/a.cfg"
+        [resource.backend.env]
+          keys = ["/"]
+      [[resource]]
+        [[resource.template]]
+          src = "/tmp/test12345.tmpl"
+          dst = "/tmp/b.cfg"
+        [resource.backend.env]
+          keys = ["/"]
+`), 0644), IsNil)
+
+	// several unnamed resources in one file get unique names
+	cfg, err := NewConfiguration(cfgPath)
+	t.Assert(err, IsNil)
+	t.Assert(cfg.Resource, HasLen, 2)
+	t.Check(cfg.Resource[0].Name, Equals, "config#1")
+	t.Check(cfg.Resource[1].Name, Equals, "config#2")
+}
+
+func (s *FilterSuite) TestNewConfFormats(t *C) {
+	dir, err := ioutil.TempDir("", "remco-formats")
+	t.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	t.Assert(os.Mkdir(filepath.Join(dir, "resource.d"), 0755), IsNil)
+
+	files := map[string]string{
+		"resource.d/a.toml": `
+        name = "a"
+        [[template]]
+          src = "/tmp/test12345.tmpl"
+          dst = "/tmp/a.cfg"
+`,
+		"resource.d/b.yml": `
+name: b
+retry_factor: 3
+template:
+  - src: /tmp/test12345.tmpl
+    dst: /tmp/b.cfg
+`,
+		"resource.d/c.json": `{
+  "resource": [
+    {"name": "c", "template": [{"src": "/tmp/test12345.tmpl", "dst": "/tmp/c.cfg"}], "exec": {"command": "sleep 10", "kill_timeout": 5}}
+  ]
+}`,
+	}
+	for name, content := range files {
+		t.Assert(ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644), IsNil)
+	}
+
+	cfgPath := filepath.Join(dir, "config.yaml")
+	t.Assert(ioutil.WriteFile(cfgPath, []byte(`
+log_level: debug
+include_dir: `+filepath.Join(dir, "resource.d")+`
+default_backends:
+  env:
+    keys: ["/"]
+resource:
+  - name: main
+    retry_factor: 1.5
+    reload_cmd: [echo 1, echo 2]
+    template:
+      - src: /tmp/test12345.tmpl
+        dst: /tmp/main.cfg
+`), 0644), IsNil)
+
+	// every format is decoded into the same structs, a mixed include_dir is loaded in lexical order
+	cfg, err := NewConfiguration(cfgPath)
+	t.Assert(err, IsNil)
+	t.Assert(cfg.Resource, HasLen, 4)
+	var names []string
+	for _, r := range cfg.Resource {
+		names = append(names, r.Name)
+		t.Check(r.Backends.Env, NotNil)
+	}
+	t.Check(names, DeepEquals, []string{"main", "a", "b", "c"})
+	t.Check(cfg.LogLevel, Equals, "debug")
+	t.Check(cfg.Resource[0].RetryFactor, Equals, 1.5)
+	t.Check(cfg.Resource[0].ReloadCmd, DeepEquals, template.ReloadCommands{"echo 1", "echo 2"})
+	t.Check(cfg.Resource[2].RetryFactor, Equals, 3.0)
+	t.Check(cfg.Resource[3].Exec.Command, Equals, "sleep 10")
+	t.Check(cfg.Resource[3].Exec.KillTimeout, Equals, 5)
+
+	// -format overrides the file extension of the main configuration file
+	jsonPath := filepath.Join(dir, "config")
+	t.Assert(ioutil.WriteFile(jsonPath, []byte(`{"log_level": "info", "resource": [{"name": "main", "template": [{"src": "/tmp/test12345.tmpl", "dst": "/tmp/main.cfg"}], "backend": {"env": {"keys": ["/"]}}}]}`), 0644), IsNil)
+	cfg, err = NewConfigurationWithFormat(jsonPath, "json")
+	t.Assert(err, IsNil)
+	t.Check(cfg.Resource, HasLen, 1)
+	_, err = NewConfiguration(jsonPath)
+	t.Check(err, ErrorMatches, "(?s)toml unmarshal failed.*")
+	_, err = NewConfigurationWithFormat(jsonPath, "ini")
+	t.Check(err, ErrorMatches, `unknown configuration format "ini".*`)
+
+	// the syntax errors have the position in the original file
+	t.Assert(ioutil.WriteFile(jsonPath, []byte("{\n  \"log_level\": \"info\",\n  \"resource\" []\n}"), 0644), IsNil)
+	_, err = NewConfigurationWithFormat(jsonPath, "json")
+	t.Check(err, ErrorMatches, `json unmarshal failed: .*config: line 3, column 14: invalid character '\[' after object key`)
+
+	t.Assert(ioutil.WriteFile(filepath.Join(dir, "resource.d", "broken.yaml"), []byte("name: broken\ntemplate:\n  - src: a\n   dst: b\n"), 0644), IsNil)
+	_, err = NewConfiguration(cfgPath)
+	t.Check(err, ErrorMatches, `(?s).*broken.yaml: yaml unmarshal failed: yaml: line 3: .*`)
 }
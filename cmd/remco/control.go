@@ -0,0 +1,63 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+
+	"github.com/HeavyHorst/remco/pkg/log"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// readControlFile returns the resource names of the control file, one name per line.
+// Empty lines and lines starting with # are ignored.
+func readControlFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read the control file")
+	}
+	defer f.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name := strings.TrimSpace(scanner.Text())
+		if name == "" || strings.HasPrefix(name, "#") {
+			continue
+		}
+		names = append(names, name)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "couldn't read the control file")
+	}
+	return names, nil
+}
+
+// controlResources pauses (or resumes) the resources listed in the control file.
+func controlResources(run *Supervisor, path string, pause bool) {
+	names, err := readControlFile(path)
+	if err != nil {
+		log.WithFields(logrus.Fields{"control_file": path}).Error(err)
+		return
+	}
+	for _, name := range names {
+		var err error
+		if pause {
+			err = run.Pause(name)
+		} else {
+			err = run.Resume(name)
+		}
+		if err != nil {
+			log.WithFields(logrus.Fields{"control_file": path}).Error(err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type ControlSuite struct{}
+
+var _ = Suite(&ControlSuite{})
+
+func (s *ControlSuite) TestReadControlFile(t *C) {
+	path := filepath.Join(t.MkDir(), "control")
+	err := ioutil.WriteFile(path, []byte("# maintenance\nnginx\n\n  haproxy  \n"), 0644)
+	t.Assert(err, IsNil)
+
+	names, err := readControlFile(path)
+	t.Assert(err, IsNil)
+	t.Check(names, DeepEquals, []string{"nginx", "haproxy"})
+
+	_, err = readControlFile(filepath.Join(t.MkDir(), "missing"))
+	t.Check(err, ErrorMatches, "couldn't read the control file: .*")
+}
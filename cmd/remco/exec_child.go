@@ -0,0 +1,24 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/HeavyHorst/remco/pkg/template"
+)
+
+// runExecChild implements the internal exec-child subcommand,
+// remco uses it to start child processes with the environment and the user of the exec configuration.
+func runExecChild(args []string) int {
+	err := template.ExecChild(args)
+	fmt.Fprintln(os.Stderr, err)
+	return exitCodeError
+}
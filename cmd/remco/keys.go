@@ -0,0 +1,124 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/template"
+)
+
+// runKeys implements the keys subcommand.
+// It reads the backends of a resource once and prints the keys as the templates see them,
+// after the prefix of every backend has been stripped and the backends have been merged.
+func runKeys(args []string) int {
+	fs := flag.NewFlagSet("keys", flag.ExitOnError)
+	path := fs.String("config", defaultConfig, "path to the configuration file")
+	format := fs.String("format", "", formatUsage)
+	name := fs.String("resource", "", "the name of the resource, can be omitted if there is only one")
+	backend := fs.String("backend", "", "only read the backend with this name or type")
+	values := fs.Bool("values", false, "print the values too")
+	asJSON := fs.Bool("json", false, "print the keys as json")
+	timeout := fs.Int("timeout", 10, "the time in seconds to connect to and read the backends")
+	fs.Parse(args)
+
+	cfg, err := NewConfigurationWithFormat(*path, *format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeError
+	}
+	r, err := findResource(cfg, *name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeError
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*timeout)*time.Second)
+	defer cancel()
+	keys, err := template.Keys(ctx, r.resourceConfig(), *backend)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeError
+	}
+
+	if *asJSON {
+		if err := writeKeysJSON(os.Stdout, keys, *values); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitCodeError
+		}
+		return exitCodeOK
+	}
+	printKeys(os.Stdout, keys, *values)
+	return exitCodeOK
+}
+
+// findResource returns the resource with the name, the name can be empty if there is only one resource.
+func findResource(cfg Configuration, name string) (Resource, error) {
+	if name == "" {
+		if len(cfg.Resource) != 1 {
+			return Resource{}, fmt.Errorf("-resource is required, the configuration has %d resources", len(cfg.Resource))
+		}
+		return cfg.Resource[0], nil
+	}
+	for _, r := range cfg.Resource {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	return Resource{}, fmt.Errorf("unknown resource %q", name)
+}
+
+// printKeys writes a table with the keys, their source and the overridden sources of a collision to w.
+func printKeys(w io.Writer, keys []template.StoreKey, values bool) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if values {
+		fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE\tOVERRIDES")
+	} else {
+		fmt.Fprintln(tw, "KEY\tSOURCE\tOVERRIDES")
+	}
+	for _, k := range keys {
+		overrides := strings.Join(k.Overridden, ",")
+		if values {
+			fmt.Fprintf(tw, "%s\t%q\t%s\t%s\n", k.Key, k.Value, k.Source, overrides)
+		} else {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", k.Key, k.Source, overrides)
+		}
+	}
+	tw.Flush()
+}
+
+// writeKeysJSON writes the keys as a json array to w, the values are left out unless values is true.
+func writeKeysJSON(w io.Writer, keys []template.StoreKey, values bool) error {
+	type key struct {
+		Key        string   `json:"key"`
+		Source     string   `json:"source"`
+		Overridden []string `json:"overridden,omitempty"`
+	}
+	var v interface{} = keys
+	if !values {
+		list := make([]key, 0, len(keys))
+		for _, k := range keys {
+			list = append(list, key{k.Key, k.Source, k.Overridden})
+		}
+		v = list
+	} else if keys == nil {
+		v = []template.StoreKey{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,66 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"bytes"
+
+	"github.com/HeavyHorst/remco/pkg/template"
+
+	. "gopkg.in/check.v1"
+)
+
+type KeysSuite struct{}
+
+var _ = Suite(&KeysSuite{})
+
+var testKeys = []template.StoreKey{
+	{Key: "/port", Value: "8080", Source: "env", Overridden: []string{"consul"}},
+	{Key: "/vars/dc", Value: "eu-west", Source: template.VariablesSource},
+}
+
+func (s *KeysSuite) TestFindResource(t *C) {
+	cfg := Configuration{Resource: []Resource{{Name: "a"}, {Name: "b"}}}
+	r, err := findResource(cfg, "b")
+	t.Assert(err, IsNil)
+	t.Check(r.Name, Equals, "b")
+
+	_, err = findResource(cfg, "c")
+	t.Check(err, ErrorMatches, `unknown resource "c"`)
+	_, err = findResource(cfg, "")
+	t.Check(err, ErrorMatches, "-resource is required, the configuration has 2 resources")
+
+	r, err = findResource(Configuration{Resource: []Resource{{Name: "a"}}}, "")
+	t.Assert(err, IsNil)
+	t.Check(r.Name, Equals, "a")
+}
+
+func (s *KeysSuite) TestPrintKeys(t *C) {
+	var buf bytes.Buffer
+	printKeys(&buf, testKeys, false)
+	t.Check(buf.String(), Equals, "KEY       SOURCE     OVERRIDES\n/port     env        consul\n/vars/dc  variables  \n")
+
+	buf.Reset()
+	printKeys(&buf, testKeys, true)
+	t.Check(buf.String(), Equals, "KEY       VALUE      SOURCE     OVERRIDES\n/port     \"8080\"     env        consul\n/vars/dc  \"eu-west\"  variables  \n")
+}
+
+func (s *KeysSuite) TestWriteKeysJSON(t *C) {
+	var buf bytes.Buffer
+	t.Assert(writeKeysJSON(&buf, testKeys[:1], false), IsNil)
+	t.Check(buf.String(), Equals, "[\n  {\n    \"key\": \"/port\",\n    \"source\": \"env\",\n    \"overridden\": [\n      \"consul\"\n    ]\n  }\n]\n")
+
+	buf.Reset()
+	t.Assert(writeKeysJSON(&buf, testKeys[1:], true), IsNil)
+	t.Check(buf.String(), Equals, "[\n  {\n    \"key\": \"/vars/dc\",\n    \"value\": \"eu-west\",\n    \"source\": \"variables\"\n  }\n]\n")
+
+	buf.Reset()
+	t.Assert(writeKeysJSON(&buf, nil, true), IsNil)
+	t.Check(buf.String(), Equals, "[]\n")
+}
@@ -17,23 +17,72 @@ import (
 	"syscall"
 
 	"github.com/HeavyHorst/remco/pkg/log"
+	"github.com/HeavyHorst/remco/pkg/template"
 	"github.com/hashicorp/consul-template/signals"
 	"github.com/hashicorp/go-reap"
 	"github.com/sirupsen/logrus"
 )
 
+// exit codes of remco
+const (
+	exitCodeOK    = 0
+	exitCodeError = 1
+	// exitCodeChanged is returned in --once mode if at least one template has been changed.
+	exitCodeChanged = 2
+	// the failure exit codes are returned if the templates of at least one resource couldn't be processed in onetime mode.
+	// The most severe failure of all resources wins.
+	exitCodeSyncFailed    = 3
+	exitCodeCheckFailed   = 4
+	exitCodeRenderFailed  = 5
+	exitCodeBackendFailed = 6
+)
+
+const defaultConfig = "/etc/remco/config"
+
+// subcommands maps the name of a subcommand to its implementation.
+// Every subcommand parses its own flags.
+var subcommands = map[string]func(args []string) int{
+	"bench":                      runBench,
+	"keys":                       runKeys,
+	"once":                       runOnce,
+	"render":                     runRender,
+	"validate":                   runValidate,
+	"version":                    runVersion,
+	template.ExecChildSubcommand: runExecChild,
+}
+
 var (
 	configPath          string
+	configFileFormat    string
 	printVersionAndExit bool
+	onetime             bool
+	// withoutExec is set by the once subcommand to skip the exec child processes.
+	withoutExec bool
 )
 
 func init() {
-	const defaultConfig = "/etc/remco/config"
 	flag.StringVar(&configPath, "config", defaultConfig, "path to the configuration file")
+	flag.StringVar(&configFileFormat, "format", "", formatUsage)
 	flag.BoolVar(&printVersionAndExit, "version", false, "print version and exit")
+	flag.BoolVar(&onetime, "once", false, "render all templates once and exit, overrides the onetime, watch and interval settings of all backends")
 }
 
-func run() {
+// loadConfiguration reads the configuration file and applies the command line overrides.
+func loadConfiguration() (Configuration, error) {
+	cfg, err := NewConfigurationWithFormat(configPath, configFileFormat)
+	if err != nil {
+		return cfg, err
+	}
+	if onetime {
+		cfg.setOnetime()
+	}
+	if withoutExec {
+		cfg.disableExec()
+	}
+	return cfg, nil
+}
+
+func run() int {
 	// catch all signals
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan)
@@ -41,14 +90,21 @@ func run() {
 	done := make(chan struct{})
 	reapLock := &sync.RWMutex{}
 
-	cfg, err := NewConfiguration(configPath)
+	cfg, err := loadConfiguration()
 	if err != nil {
 		log.Fatal(err)
 	}
+	logVersion(currentBuildInfo())
 
 	run := NewSupervisor(cfg, reapLock, done)
 	defer run.Stop()
 
+	// SIGHUP reloads the configuration if it changed and re-renders all templates otherwise
+	digest, err := configDigest(configPath, cfg)
+	if err != nil {
+		log.Error(err)
+	}
+
 	// reap zombies if pid is 1
 	pidReapChan := make(reap.PidCh, 1)
 	errorReapChan := make(reap.ErrorCh, 1)
@@ -65,19 +121,35 @@ func run() {
 		case s := <-signalChan:
 			switch s {
 			case syscall.SIGHUP:
+				newDigest, err := configDigest(configPath, cfg)
+				if err == nil && newDigest == digest {
+					log.Info("the configuration didn't change, re-rendering all templates")
+					run.ForceRender()
+					continue
+				}
 				log.WithFields(logrus.Fields{
 					"file": configPath,
 				}).Info("loading new config")
-				newConf, err := NewConfiguration(configPath)
+				newConf, err := loadConfiguration()
 				if err != nil {
 					log.Error(err)
 					continue
 				}
 				run.Reload(newConf)
+				cfg = newConf
+				if digest, err = configDigest(configPath, cfg); err != nil {
+					log.Error(err)
+				}
+			case signals.SignalLookup["SIGUSR1"], signals.SignalLookup["SIGUSR2"]:
+				if cfg.ControlFile == "" {
+					run.SendSignal(s)
+					continue
+				}
+				controlResources(run, cfg.ControlFile, s == signals.SignalLookup["SIGUSR1"])
 			case signals.SignalLookup["SIGCHLD"]:
 			case os.Interrupt, syscall.SIGTERM:
 				log.Info(fmt.Sprintf("Captured %v. Exiting...", s))
-				return
+				return exitCodeOK
 			default:
 				run.SendSignal(s)
 			}
@@ -86,18 +158,32 @@ func run() {
 		case err := <-errorReapChan:
 			log.Error(fmt.Sprintf("Error reaping child process %v", err))
 		case <-done:
-			return
+			results := run.Results()
+			printSummary(os.Stderr, results)
+			if code := failureExitCode(results); code != exitCodeOK {
+				return code
+			}
+			if onetime && run.Changed() {
+				return exitCodeChanged
+			}
+			return exitCodeOK
 		}
 	}
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+
 	flag.Parse()
 
 	if printVersionAndExit {
-		printVersion()
+		printVersion(os.Stdout, currentBuildInfo())
 		return
 	}
 
-	run()
+	os.Exit(run())
 }
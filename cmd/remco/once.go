@@ -0,0 +1,28 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"flag"
+)
+
+// runOnce implements the once subcommand.
+// It renders the templates of all resources exactly once and exits with the exit codes of the -once flag.
+// The exec child processes (and their hooks) aren't started unless -with-exec is set.
+func runOnce(args []string) int {
+	fs := flag.NewFlagSet("once", flag.ExitOnError)
+	fs.StringVar(&configPath, "config", defaultConfig, "path to the configuration file")
+	fs.StringVar(&configFileFormat, "format", "", formatUsage)
+	withExec := fs.Bool("with-exec", false, "start the exec child processes of the resources")
+	fs.Parse(args)
+
+	onetime = true
+	withoutExec = !*withExec
+	return run()
+}
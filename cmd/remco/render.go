@@ -0,0 +1,155 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/backends"
+	"github.com/HeavyHorst/remco/pkg/template"
+)
+
+// stringList is a flag that can be given multiple times.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// renderFlags holds the flags of the render subcommand.
+type renderFlags struct {
+	src      string
+	out      string
+	backend  string
+	nodes    stringList
+	prefix   string
+	keys     stringList
+	values   stringList
+	fixtures string
+	timeout  int
+}
+
+// runRender implements the render subcommand.
+// It renders a single template once with the values of a backend, a fixtures file and literal key/value pairs
+// and writes the result to stdout or the -out file. No check, reload or exec commands are executed.
+func runRender(args []string) int {
+	var f renderFlags
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	fs.StringVar(&f.src, "src", "", "the template to render")
+	fs.StringVar(&f.out, "out", "", "write the result to this file instead of stdout")
+	fs.StringVar(&f.backend, "backend", "", "the type of the backend: consul, etcd, redis, zookeeper, vault (authenticated with $VAULT_TOKEN), env or file")
+	fs.Var(&f.nodes, "node", "a node of the backend (the path of the file backend), can be given multiple times")
+	fs.StringVar(&f.prefix, "prefix", "", "the key prefix of the backend")
+	fs.Var(&f.keys, "keys", "a key (below the prefix) of the backend, can be given multiple times, the default is /")
+	fs.Var(&f.values, "key", "a literal key=value pair that overrides the backends, can be given multiple times")
+	fs.StringVar(&f.fixtures, "fixtures", "", "a yaml or json file with key/value pairs")
+	fs.IntVar(&f.timeout, "timeout", 10, "the time in seconds to connect to and read the backends")
+	fs.Parse(args)
+
+	if f.src == "" {
+		fmt.Fprintln(os.Stderr, "-src is required")
+		return exitCodeError
+	}
+	connectors, values, err := f.connectors()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeError
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(f.timeout)*time.Second)
+	defer cancel()
+	var buf bytes.Buffer
+	if err := template.Render(ctx, f.src, connectors, values, &buf); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeError
+	}
+
+	if f.out == "" {
+		os.Stdout.Write(buf.Bytes())
+		return exitCodeOK
+	}
+	if err := ioutil.WriteFile(f.out, buf.Bytes(), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeError
+	}
+	return exitCodeOK
+}
+
+// connectors returns the connectors of the backend and the fixtures and the literal values.
+func (f renderFlags) connectors() (template.BackendConnectors, map[string]string, error) {
+	keys := []string(f.keys)
+	if len(keys) == 0 {
+		keys = []string{"/"}
+	}
+	b := template.Backend{Prefix: f.prefix, Keys: keys, Onetime: true}
+
+	var connectors template.BackendConnectors
+	if f.fixtures != "" {
+		connectors = append(connectors, &backends.FileConfig{Filepath: f.fixtures, Backend: template.Backend{Keys: []string{"/"}, Onetime: true}})
+	}
+
+	var c interface {
+		template.BackendConnector
+		Validate() error
+	}
+	switch f.backend {
+	case "":
+	case "consul":
+		c = &backends.ConsulConfig{Nodes: f.nodes, Backend: b}
+	case "etcd":
+		c = &backends.EtcdConfig{Nodes: f.nodes, Version: 3, Backend: b}
+	case "redis":
+		c = &backends.RedisConfig{Nodes: f.nodes, Backend: b}
+	case "zookeeper":
+		c = &backends.ZookeeperConfig{Nodes: f.nodes, Backend: b}
+	case "vault":
+		var node string
+		if len(f.nodes) > 0 {
+			node = f.nodes[0]
+		}
+		c = &backends.VaultConfig{Node: node, AuthToken: os.Getenv("VAULT_TOKEN"), Backend: b}
+	case "env":
+		c = &backends.EnvConfig{Backend: b}
+	case "file":
+		var file string
+		if len(f.nodes) > 0 {
+			file = f.nodes[0]
+		}
+		c = &backends.FileConfig{Filepath: file, Backend: b}
+	default:
+		return nil, nil, fmt.Errorf("unknown backend %q", f.backend)
+	}
+	if c != nil {
+		if err := c.Validate(); err != nil {
+			return nil, nil, err
+		}
+		connectors = append(connectors, c)
+	}
+
+	values := make(map[string]string, len(f.values))
+	for _, kv := range f.values {
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			return nil, nil, fmt.Errorf("invalid -key %q, expected key=value", kv)
+		}
+		values[path.Join("/", kv[:i])] = kv[i+1:]
+	}
+	return connectors, values, nil
+}
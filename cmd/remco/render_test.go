@@ -0,0 +1,48 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"github.com/HeavyHorst/remco/pkg/backends"
+
+	. "gopkg.in/check.v1"
+)
+
+type RenderSuite struct{}
+
+var _ = Suite(&RenderSuite{})
+
+func (s *RenderSuite) TestRenderConnectors(t *C) {
+	f := renderFlags{
+		backend:  "consul",
+		nodes:    stringList{"127.0.0.1:8500"},
+		prefix:   "/app",
+		fixtures: "/tmp/fixtures.yml",
+		values:   stringList{"port=80", "/db/host=db.local", "empty="},
+	}
+	connectors, values, err := f.connectors()
+	t.Assert(err, IsNil)
+	t.Assert(connectors, HasLen, 2)
+	t.Check(connectors.Types(), DeepEquals, []string{"file", "consul"})
+	consul := connectors[1].(*backends.ConsulConfig)
+	t.Check(consul.Nodes, DeepEquals, []string{"127.0.0.1:8500"})
+	t.Check(consul.Prefix, Equals, "/app")
+	t.Check(consul.Keys, DeepEquals, []string{"/"})
+	t.Check(values, DeepEquals, map[string]string{"/port": "80", "/db/host": "db.local", "/empty": ""})
+
+	for _, f := range []renderFlags{
+		{backend: "etcd"},
+		{backend: "ldap"},
+		{values: stringList{"port"}},
+		{values: stringList{"=80"}},
+	} {
+		_, _, err := f.connectors()
+		t.Check(err, NotNil, Commentf("%+v", f))
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+// sopsCommand is the sops binary that decrypts the encrypted configuration files.
+// It finds the age key in $SOPS_AGE_KEY_FILE or $SOPS_AGE_KEY.
+var sopsCommand = "sops"
+
+// sopsTimeout is the maximum amount of time the decryption of a configuration file may take.
+const sopsTimeout = 30 * time.Second
+
+// sopsMetadata is the part of the sops metadata that marks a file as encrypted.
+type sopsMetadata struct {
+	Sops *struct {
+		Mac string `json:"mac"`
+	} `json:"sops"`
+}
+
+// isSopsEncrypted reports whether the yaml or json configuration buf has been encrypted with sops,
+// that is whether it has a top-level sops table with a mac.
+// A file that can't be parsed isn't encrypted, its syntax errors are reported when it is decoded.
+func isSopsEncrypted(buf []byte, format string) bool {
+	var md sopsMetadata
+	switch format {
+	case formatYAML:
+		if yaml.Unmarshal(buf, &md) != nil {
+			return false
+		}
+	case formatJSON:
+		if json.Unmarshal(buf, &md) != nil {
+			return false
+		}
+	default:
+		return false
+	}
+	return md.Sops != nil && md.Sops.Mac != ""
+}
+
+// decryptSops decrypts the sops file at path with the sops binary. sops decrypts every value and verifies the mac,
+// so a file that decrypts has no undecryptable value.
+// The error names the file, the ciphertexts and the age secret keys in the output of sops are redacted.
+func decryptSops(path, format string) ([]byte, error) {
+	if _, err := exec.LookPath(sopsCommand); err != nil {
+		return nil, fmt.Errorf("%s is encrypted with sops, but %s isn't installed", path, sopsCommand)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sopsTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, sopsCommand, "--decrypt", "--input-type", format, "--output-type", format, path)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("decrypting %s with sops timed out after %s", path, sopsTimeout)
+		}
+		msg := redactSecrets(strings.TrimSpace(stderr.String()))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("decrypting %s with sops failed: %s", path, msg)
+	}
+	return out, nil
+}
+
+var (
+	sopsValuePattern = regexp.MustCompile(`ENC\[[^\]]*\]`)
+	ageKeyPattern    = regexp.MustCompile(`AGE-SECRET-KEY-1[0-9A-Za-z]+`)
+)
+
+// redactSecrets removes the encrypted values and the age secret keys from s.
+func redactSecrets(s string) string {
+	s = sopsValuePattern.ReplaceAllString(s, "ENC[redacted]")
+	return ageKeyPattern.ReplaceAllString(s, "AGE-SECRET-KEY-redacted")
+}
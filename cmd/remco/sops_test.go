@@ -0,0 +1,100 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type SopsSuite struct {
+	dir string
+}
+
+var _ = Suite(&SopsSuite{})
+
+// fakeSops "decrypts" the yaml file (the 6th argument) by dropping the sops table and the envelope of the values.
+// It fails like sops without an age key and prints a ciphertext and a secret key, which must be redacted.
+const fakeSops = `#!/bin/sh
+if [ -z "$SOPS_AGE_KEY_FILE" ]; then
+  echo "Failed to get the data key required to decrypt the SOPS file: ENC[AES256_GCM,data:c2VjcmV0,iv:aXY=,tag:dGFn,type:str] AGE-SECRET-KEY-1QYQSZQGPQYQSZQGPQYQSZQGPQYQSZQGP" >&2
+  exit 128
+fi
+sed -e '/^sops:/,$d' -e 's/ENC\[AES256_GCM,data:\([^,]*\),[^]]*\]/\1/' "$6"
+`
+
+const encryptedConfig = `log_level: info
+variables:
+  db_password: ENC[AES256_GCM,data:s3cret,iv:aXY=,tag:dGFn,type:str]
+  api_token: ENC[AES256_GCM,data:t0k$en$HOME,iv:aXY=,tag:dGFn,type:str]
+resource:
+  - name: app
+    template:
+      - src: /tmp/test12345.tmpl
+        dst: /tmp/app.cfg
+    backend:
+      env:
+        keys: ["/"]
+sops:
+  age:
+    - recipient: age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+  lastmodified: "2026-10-16T08:00:00Z"
+  mac: ENC[AES256_GCM,data:bWFj,iv:aXY=,tag:dGFn,type:str]
+  version: 3.7.3
+`
+
+func (s *SopsSuite) SetUpTest(t *C) {
+	s.dir = t.MkDir()
+	sopsCommand = filepath.Join(s.dir, "sops")
+	t.Assert(ioutil.WriteFile(sopsCommand, []byte(fakeSops), 0755), IsNil)
+}
+
+func (s *SopsSuite) TearDownTest(t *C) {
+	sopsCommand = "sops"
+	os.Unsetenv("SOPS_AGE_KEY_FILE")
+}
+
+func (s *SopsSuite) TestIsSopsEncrypted(t *C) {
+	t.Check(isSopsEncrypted([]byte(encryptedConfig), formatYAML), Equals, true)
+	t.Check(isSopsEncrypted([]byte(`{"data": "ENC[...]", "sops": {"mac": "ENC[...]"}}`), formatJSON), Equals, true)
+	t.Check(isSopsEncrypted([]byte("log_level: info\nsops: {}\n"), formatYAML), Equals, false)
+	t.Check(isSopsEncrypted([]byte("log_level = \"info\"\n[sops]\nmac = \"x\"\n"), formatTOML), Equals, false)
+	t.Check(isSopsEncrypted([]byte("{ broken"), formatJSON), Equals, false)
+}
+
+func (s *SopsSuite) TestNewConfEncrypted(t *C) {
+	path := filepath.Join(s.dir, "config.yaml")
+	t.Assert(ioutil.WriteFile(path, []byte(encryptedConfig), 0644), IsNil)
+
+	// the error names the file without the ciphertexts and the keys
+	_, err := NewConfiguration(path)
+	t.Assert(err, NotNil)
+	t.Check(err, ErrorMatches, "decrypting "+path+" with sops failed: Failed to get the data key.*")
+	t.Check(err, Not(ErrorMatches), ".*(c2VjcmV0|AGE-SECRET-KEY-1Q).*")
+
+	os.Setenv("SOPS_AGE_KEY_FILE", filepath.Join(s.dir, "keys.txt"))
+	cfg, err := NewConfiguration(path)
+	t.Assert(err, IsNil)
+	t.Check(cfg.Variables["db_password"], Equals, "s3cret")
+	// the decrypted secrets aren't expanded
+	t.Check(cfg.Variables["api_token"], Equals, "t0k$en$HOME")
+	t.Check(cfg.Resource, HasLen, 1)
+
+	sopsCommand = filepath.Join(s.dir, "missing")
+	_, err = NewConfiguration(path)
+	t.Check(err, ErrorMatches, path+" is encrypted with sops, but .*missing isn't installed")
+}
+
+func (s *SopsSuite) TestRedactSecrets(t *C) {
+	t.Check(redactSecrets("could not decrypt ENC[AES256_GCM,data:abc,iv:x,tag:y,type:str] with AGE-SECRET-KEY-1ABC"), Equals,
+		"could not decrypt ENC[redacted] with AGE-SECRET-KEY-redacted")
+}
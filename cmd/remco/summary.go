@@ -0,0 +1,63 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/HeavyHorst/remco/pkg/runner"
+	"github.com/HeavyHorst/remco/pkg/template"
+)
+
+// failureExitCodes maps the failures of the resources to the exit code of remco.
+var failureExitCodes = map[template.Failure]int{
+	template.FailureNone:    exitCodeOK,
+	template.FailureSync:    exitCodeSyncFailed,
+	template.FailureCheck:   exitCodeCheckFailed,
+	template.FailureRender:  exitCodeRenderFailed,
+	template.FailureBackend: exitCodeBackendFailed,
+	template.FailureConfig:  exitCodeError,
+}
+
+// failureExitCode returns the exit code of the most severe failure of all resources.
+func failureExitCode(results []runner.ResourceResult) int {
+	worst := template.FailureNone
+	for _, r := range results {
+		if f := template.FailureOf(r.Err); f > worst {
+			worst = f
+		}
+	}
+	return failureExitCodes[worst]
+}
+
+// printSummary writes a table with the result of every resource to w.
+// Nothing is written if there are no results.
+func printSummary(w io.Writer, results []runner.ResourceResult) {
+	if len(results) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RESOURCE\tRESULT\tERROR")
+	for _, r := range results {
+		result := template.FailureOf(r.Err).String()
+		if r.Err == nil && r.Changed {
+			result = "changed"
+		}
+		var msg string
+		if r.Err != nil {
+			// only the first line, template errors continue with an excerpt of the template
+			msg = strings.SplitN(r.Err.Error(), "\n", 2)[0]
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Name, result, msg)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,58 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	berr "github.com/HeavyHorst/remco/pkg/backends/error"
+	"github.com/HeavyHorst/remco/pkg/runner"
+	"github.com/pkg/errors"
+
+	. "gopkg.in/check.v1"
+)
+
+type SummarySuite struct{}
+
+var _ = Suite(&SummarySuite{})
+
+func (s *SummarySuite) TestFailureExitCode(t *C) {
+	t.Check(failureExitCode(nil), Equals, exitCodeOK)
+	t.Check(failureExitCode([]runner.ResourceResult{{Name: "a", Changed: true}}), Equals, exitCodeOK)
+
+	syncErr := fmt.Errorf("permission denied")
+	backendErr := errors.Wrap(berr.BackendError{Backend: "etcd", Message: "connection refused"}, "setVars failed")
+	t.Check(failureExitCode([]runner.ResourceResult{{Name: "a", Err: syncErr}}), Equals, exitCodeSyncFailed)
+	// the worst failure wins
+	t.Check(failureExitCode([]runner.ResourceResult{
+		{Name: "a", Err: syncErr},
+		{Name: "b", Err: backendErr},
+		{Name: "c"},
+	}), Equals, exitCodeBackendFailed)
+}
+
+func (s *SummarySuite) TestPrintSummary(t *C) {
+	var buf bytes.Buffer
+	printSummary(&buf, nil)
+	t.Check(buf.String(), Equals, "")
+
+	printSummary(&buf, []runner.ResourceResult{
+		{Name: "haproxy", Err: errors.Wrap(berr.BackendError{Backend: "etcd", Message: "connection refused"}, "setVars failed")},
+		{Name: "nginx", Changed: true},
+		{Name: "redis", Err: fmt.Errorf("template error\n    1 | excerpt")},
+	})
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	t.Assert(lines, HasLen, 4)
+	t.Check(strings.Fields(lines[0]), DeepEquals, []string{"RESOURCE", "RESULT", "ERROR"})
+	t.Check(lines[1], Matches, `haproxy\s+backend failed\s+setVars failed: connection refused`)
+	t.Check(lines[2], Matches, `nginx\s+changed\s*`)
+	t.Check(lines[3], Matches, `redis\s+sync failed\s+template error`)
+}
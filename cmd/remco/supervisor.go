@@ -12,15 +12,12 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
-	"math/rand"
 	"os"
 	"sync"
-	"time"
 
 	"github.com/HeavyHorst/remco/pkg/log"
+	"github.com/HeavyHorst/remco/pkg/runner"
 	"github.com/HeavyHorst/remco/pkg/telemetry"
-	"github.com/HeavyHorst/remco/pkg/template"
-	"github.com/pborman/uuid"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -30,28 +27,35 @@ type reloadSignal struct {
 	reloaded chan<- struct{}
 }
 
-// Supervisor runs
+// Supervisor runs the resources of the configuration with a runner.Runner,
+// which it replaces on every reload. It also manages the pid file and the telemetry.
 type Supervisor struct {
 	stopChan   chan struct{}
 	reloadChan chan reloadSignal
 	wg         sync.WaitGroup
 
-	signalChans      map[string]chan os.Signal
-	signalChansMutex sync.RWMutex
+	// runner runs the resources of the current configuration.
+	// paused holds the names of the paused resources, they stay paused if the configuration is reloaded.
+	runner      *runner.Runner
+	paused      map[string]bool
+	runnerMutex sync.RWMutex
 
 	pidFile   string
 	telemetry telemetry.Telemetry
 
 	reapLock *sync.RWMutex
+
+	changed      bool
+	changedMutex sync.Mutex
 }
 
 // NewSupervisor creates a new Supervisor
 func NewSupervisor(cfg Configuration, reapLock *sync.RWMutex, done chan struct{}) *Supervisor {
 	w := &Supervisor{
-		stopChan:    make(chan struct{}),
-		reloadChan:  make(chan reloadSignal),
-		signalChans: make(map[string]chan os.Signal),
-		reapLock:    reapLock,
+		stopChan:   make(chan struct{}),
+		reloadChan: make(chan reloadSignal),
+		reapLock:   reapLock,
+		paused:     make(map[string]bool),
 	}
 
 	w.pidFile = cfg.PidFile
@@ -150,32 +154,58 @@ func (ru *Supervisor) deletePid() error {
 	return os.Remove(ru.pidFile)
 }
 
-func (ru *Supervisor) addSignalChan(id string, sigchan chan os.Signal) {
-	ru.signalChansMutex.Lock()
-	defer ru.signalChansMutex.Unlock()
-	ru.signalChans[id] = sigchan
-}
-
-func (ru *Supervisor) removeSignalChan(id string) {
-	ru.signalChansMutex.Lock()
-	defer ru.signalChansMutex.Unlock()
-	delete(ru.signalChans, id)
+// current returns the runner of the current configuration, nil if the resources haven't been started yet.
+func (ru *Supervisor) current() *runner.Runner {
+	ru.runnerMutex.RLock()
+	defer ru.runnerMutex.RUnlock()
+	return ru.runner
 }
 
 // SendSignal forwards the given Signal to all child processes
 func (ru *Supervisor) SendSignal(s os.Signal) {
-	ru.signalChansMutex.RLock()
-	defer ru.signalChansMutex.RUnlock()
-	// try to send the signal to all child processes
-	// we don't block here if the signal can't be send
-	for _, v := range ru.signalChans {
-		select {
-		case v <- s:
-		default:
-		}
+	if r := ru.current(); r != nil {
+		r.SendSignal(s)
+	}
+}
+
+// ForceRender processes the templates of all running resources with the values of all their backends,
+// without reconnecting the backends. Child processes are only reloaded if their files changed.
+func (ru *Supervisor) ForceRender() {
+	if r := ru.current(); r != nil {
+		r.ForceRender()
+	}
+}
+
+// Pause pauses the resources with the given name, see runner.Runner.Pause.
+// They stay paused if the configuration is reloaded.
+func (ru *Supervisor) Pause(name string) error {
+	return ru.setPaused(name, true)
+}
+
+// Resume resumes the paused resources with the given name, see runner.Runner.Resume.
+func (ru *Supervisor) Resume(name string) error {
+	return ru.setPaused(name, false)
+}
+
+func (ru *Supervisor) setPaused(name string, paused bool) error {
+	ru.runnerMutex.Lock()
+	defer ru.runnerMutex.Unlock()
+	if ru.runner == nil {
+		return fmt.Errorf("no resources are running")
 	}
+	var err error
+	if paused {
+		err = ru.runner.Pause(name)
+	} else {
+		err = ru.runner.Resume(name)
+	}
+	if err == nil {
+		ru.paused[name] = paused
+	}
+	return err
 }
 
+// runResource runs the resources until they have stopped or stop receives a value.
 func (ru *Supervisor) runResource(r []Resource, stop, stopped chan struct{}) {
 	defer func() {
 		if stopped != nil {
@@ -183,91 +213,65 @@ func (ru *Supervisor) runResource(r []Resource, stop, stopped chan struct{}) {
 		}
 	}()
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	done := make(chan struct{})
-
-	wait := sync.WaitGroup{}
-	for _, v := range r {
-		wait.Add(1)
-		go func(r Resource) {
-			defer wait.Done()
-
-			backendConfigs := r.Backends.GetBackends()
-			for _, v := range r.Backends.Plugin {
-				backendConfigs = append(backendConfigs, &v)
-			}
-
-			rsc := template.ResourceConfig{
-				Exec:       r.Exec,
-				Template:   r.Template,
-				Name:       r.Name,
-				StartCmd:   r.StartCmd,
-				ReloadCmd:  r.ReloadCmd,
-				Connectors: backendConfigs,
-			}
-			res, err := template.NewResourceFromResourceConfig(ctx, ru.reapLock, rsc)
-			if err != nil {
-				log.Error(err)
-				return
-			}
-			defer res.Close()
-
-			id := uuid.New()
-			ru.addSignalChan(id, res.SignalChan)
-			defer ru.removeSignalChan(id)
-
-			restartChan := make(chan struct{}, 1)
-			restartChan <- struct{}{}
-
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-restartChan:
-					res.Monitor(ctx)
-					if res.Failed {
-						go func() {
-							// try to restart the resource after a random amount of time
-							rn := rand.Int63n(30)
-							log.WithFields(logrus.Fields{
-								"resource": r.Name,
-							}).Error(fmt.Sprintf("resource execution failed, restarting after %d seconds", rn))
-							time.Sleep(time.Duration(rn) * time.Second)
-							select {
-							case <-ctx.Done():
-								return
-							default:
-								restartChan <- struct{}{}
-							}
-						}()
-					} else {
-						return
-					}
-				}
+	rn, err := runner.New(runnerConfig(r, ru.reapLock))
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	ru.runnerMutex.Lock()
+	ru.runner = rn
+	for name, paused := range ru.paused {
+		if paused {
+			// the resource may have been removed from the configuration
+			if err := rn.Pause(name); err != nil {
+				delete(ru.paused, name)
 			}
-		}(v)
+		}
 	}
+	ru.runnerMutex.Unlock()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
 	go func() {
-		// If there is no goroutine left - quit
+		// If all resources have stopped - quit
 		// this is necessary for the onetime mode
-		wait.Wait()
+		rn.Run(ctx)
 		close(done)
 	}()
 
-	for {
-		select {
-		case <-stop:
-			cancel()
-			wait.Wait()
-			return
-		case <-done:
-			return
-		}
+	select {
+	case <-stop:
+		cancel()
+		<-done
+	case <-done:
+	}
+	if rn.Changed() {
+		ru.setChanged()
 	}
 }
 
+func (ru *Supervisor) setChanged() {
+	ru.changedMutex.Lock()
+	defer ru.changedMutex.Unlock()
+	ru.changed = true
+}
+
+// Changed reports whether at least one template of any resource has been changed.
+func (ru *Supervisor) Changed() bool {
+	ru.changedMutex.Lock()
+	defer ru.changedMutex.Unlock()
+	return ru.changed
+}
+
+// Results returns the outcome of the last run of every resource of the current configuration, sorted by name.
+func (ru *Supervisor) Results() []runner.ResourceResult {
+	if r := ru.current(); r != nil {
+		return r.Results()
+	}
+	return nil
+}
+
 // Reload with the new configuration.
 func (ru *Supervisor) Reload(cfg Configuration) {
 	reloaded := make(chan struct{})
@@ -9,9 +9,12 @@
 package main
 
 import (
+	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/HeavyHorst/remco/pkg/backends"
+	"github.com/HeavyHorst/remco/pkg/runner"
 	"github.com/HeavyHorst/remco/pkg/telemetry"
 	"github.com/HeavyHorst/remco/pkg/template"
 
@@ -63,13 +66,15 @@ type RunnerTestSuite struct {
 var _ = Suite(&RunnerTestSuite{})
 
 func (s *RunnerTestSuite) SetUpSuite(t *C) {
+	// the resources are validated, the template must exist
+	err := ioutil.WriteFile(exampleTemplates[0].Src, []byte("{{ getv(\"/foo\", \"bar\") }}"), 0644)
+	t.Assert(err, IsNil)
 	s.runner = NewSupervisor(exampleConfiguration, nil, make(chan struct{}))
 }
 
 func (s *RunnerTestSuite) TestNew(t *C) {
 	t.Check(s.runner.stopChan, NotNil)
 	t.Check(s.runner.reloadChan, NotNil)
-	t.Check(s.runner.signalChans, NotNil)
 	t.Check(s.runner.reapLock, IsNil)
 	t.Check(s.runner.pidFile, Equals, "/tmp/remco_test.pid")
 	t.Check(s.runner.telemetry, DeepEquals, exampleConfiguration.Telemetry)
@@ -85,17 +90,16 @@ func (s *RunnerTestSuite) TestDeletePid(t *C) {
 	t.Check(err, IsNil)
 }
 
-func (s *RunnerTestSuite) TestSignalChan(t *C) {
-	c := make(chan os.Signal, 1)
-	s.runner.addSignalChan("id", c)
+func (s *RunnerTestSuite) TestSendSignal(t *C) {
+	// the signals don't block, even if a resource can't receive them right now
 	s.runner.SendSignal(os.Interrupt)
-	t.Check(<-c, Equals, os.Interrupt)
-
-	// channel is full, should not block
-	c <- os.Interrupt
 	s.runner.SendSignal(os.Interrupt)
+}
 
-	s.runner.removeSignalChan("id")
+func (s *RunnerTestSuite) TestForceRender(t *C) {
+	// the requests don't block, even if a render is already requested
+	s.runner.ForceRender()
+	s.runner.ForceRender()
 }
 
 func (s *RunnerTestSuite) TestReload(t *C) {
@@ -105,7 +109,32 @@ func (s *RunnerTestSuite) TestReload(t *C) {
 	s.runner.Reload(new)
 }
 
+func (s *RunnerTestSuite) TestPauseAcrossReload(t *C) {
+	deadline := time.Now().Add(5 * time.Second)
+	for s.runner.current() == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Check(s.runner.Pause("unknown"), ErrorMatches, `unknown resource "unknown"`)
+	t.Assert(s.runner.Pause("test.toml"), IsNil)
+	old := s.runner.current()
+	s.runner.Reload(exampleConfiguration)
+	deadline = time.Now().Add(5 * time.Second)
+	for s.runner.current() == old && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the resource of the new configuration is paused as well
+	t.Assert(s.runner.current() != old, Equals, true)
+	t.Check(s.runner.current().Status()[0].Paused, Equals, true)
+	t.Assert(s.runner.Resume("test.toml"), IsNil)
+	t.Check(s.runner.current().Status()[0].Paused, Equals, false)
+}
+
 func (s *RunnerTestSuite) TearDownSuite(t *C) {
 	s.runner.Stop()
-	t.Check(s.runner.signalChans, HasLen, 0)
+	t.Assert(s.runner.current(), NotNil)
+	for _, status := range s.runner.current().Status() {
+		t.Check(status.State, Equals, runner.StateStopped)
+	}
+	os.Remove(exampleTemplates[0].Src)
+	os.Remove(exampleTemplates[0].Dst)
 }
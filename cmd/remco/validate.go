@@ -0,0 +1,75 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// runValidate implements the validate subcommand.
+// It loads the configuration (with the include directory) and prints every problem of every resource,
+// including the syntax errors of the templates and commands.
+// With -connect every backend is connected and read once.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	path := fs.String("config", defaultConfig, "path to the configuration file")
+	format := fs.String("format", "", formatUsage)
+	connect := fs.Bool("connect", false, "connect to the backends and read their keys")
+	fs.Parse(args)
+
+	cfg, err := NewConfigurationWithFormat(*path, *format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeError
+	}
+	valid := validateConfiguration(os.Stderr, cfg)
+	if *connect && !checkBackends(context.Background(), os.Stderr, cfg) {
+		valid = false
+	}
+	if !valid {
+		return exitCodeError
+	}
+	fmt.Fprintf(os.Stdout, "%s: %d resources are valid\n", *path, len(cfg.Resource))
+	return exitCodeOK
+}
+
+// validateConfiguration writes the problems of all resources (and the skipped resource files) to w.
+// It returns false if there are any.
+func validateConfiguration(w io.Writer, cfg Configuration) bool {
+	valid := true
+	for _, err := range cfg.includeErrors {
+		fmt.Fprintln(w, err)
+		valid = false
+	}
+	for _, r := range cfg.Resource {
+		rc := r.resourceConfig()
+		for _, err := range append(rc.Validate(), rc.CheckTemplates()...) {
+			fmt.Fprintf(w, "resource %s: %v\n", r.Name, err)
+			valid = false
+		}
+	}
+	return valid
+}
+
+// checkBackends writes the errors of the unreachable backends of all resources to w.
+// It returns false if there are any.
+func checkBackends(ctx context.Context, w io.Writer, cfg Configuration) bool {
+	valid := true
+	for _, r := range cfg.Resource {
+		for _, err := range r.resourceConfig().Connectors.Check(ctx) {
+			fmt.Fprintf(w, "resource %s: %v\n", r.Name, err)
+			valid = false
+		}
+	}
+	return valid
+}
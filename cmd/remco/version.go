@@ -9,8 +9,15 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
+	"os"
 	"runtime"
+
+	"github.com/HeavyHorst/remco/pkg/log"
+	"github.com/sirupsen/logrus"
 )
 
 // values set with linker flags
@@ -19,10 +26,60 @@ var version string
 var buildDate string
 var commit string
 
-func printVersion() {
-	fmt.Println("remco Version: " + version)
-	fmt.Println("UTC Build Time: " + buildDate)
-	fmt.Println("Git Commit Hash: " + commit)
-	fmt.Println("Go Version: " + runtime.Version())
-	fmt.Printf("Go OS/Arch: %s/%s\n", runtime.GOOS, runtime.GOARCH)
+// buildInfo holds the build metadata of remco.
+type buildInfo struct {
+	Version   string `json:"version"`
+	BuildDate string `json:"build_date"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+func currentBuildInfo() buildInfo {
+	return buildInfo{
+		Version:   version,
+		BuildDate: buildDate,
+		Commit:    commit,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+}
+
+func printVersion(w io.Writer, info buildInfo) {
+	fmt.Fprintln(w, "remco Version: "+info.Version)
+	fmt.Fprintln(w, "UTC Build Time: "+info.BuildDate)
+	fmt.Fprintln(w, "Git Commit Hash: "+info.Commit)
+	fmt.Fprintln(w, "Go Version: "+info.GoVersion)
+	fmt.Fprintf(w, "Go OS/Arch: %s/%s\n", info.OS, info.Arch)
+}
+
+// runVersion implements the version subcommand.
+// It prints the same information as the -version flag, with -json as a json object.
+func runVersion(args []string) int {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the version as json")
+	fs.Parse(args)
+
+	info := currentBuildInfo()
+	if !*asJSON {
+		printVersion(os.Stdout, info)
+		return exitCodeOK
+	}
+	if err := json.NewEncoder(os.Stdout).Encode(info); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitCodeError
+	}
+	return exitCodeOK
+}
+
+// logVersion logs the build metadata, it is called once at startup.
+func logVersion(info buildInfo) {
+	log.WithFields(logrus.Fields{
+		"version":    info.Version,
+		"build_date": info.BuildDate,
+		"commit":     info.Commit,
+		"go_version": info.GoVersion,
+	}).Info("starting remco")
 }
@@ -0,0 +1,41 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+
+	. "gopkg.in/check.v1"
+)
+
+type VersionSuite struct{}
+
+var _ = Suite(&VersionSuite{})
+
+var testBuildInfo = buildInfo{
+	Version:   "0.12.0",
+	BuildDate: "2026-10-16T08:00:00Z",
+	Commit:    "c545b14",
+	GoVersion: "go1.16",
+	OS:        "linux",
+	Arch:      "amd64",
+}
+
+func (s *VersionSuite) TestPrintVersion(t *C) {
+	var buf bytes.Buffer
+	printVersion(&buf, testBuildInfo)
+	t.Check(buf.String(), Equals, "remco Version: 0.12.0\nUTC Build Time: 2026-10-16T08:00:00Z\nGit Commit Hash: c545b14\nGo Version: go1.16\nGo OS/Arch: linux/amd64\n")
+}
+
+func (s *VersionSuite) TestBuildInfoJSON(t *C) {
+	data, err := json.Marshal(testBuildInfo)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, `{"version":"0.12.0","build_date":"2026-10-16T08:00:00Z","commit":"c545b14","go_version":"go1.16","os":"linux","arch":"amd64"}`)
+}
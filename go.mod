@@ -11,9 +11,12 @@ require (
 	github.com/armon/go-metrics v0.3.4
 	github.com/dlclark/regexp2 v1.2.0 // indirect
 	github.com/dop251/goja v0.0.0-20190912223329-aa89e6a4c733
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/ghodss/yaml v1.0.0
 	github.com/go-sourcemap/sourcemap v2.1.2+incompatible // indirect
 	github.com/hashicorp/consul-template v0.22.0
+	github.com/hashicorp/consul/api v1.2.0
+	github.com/hashicorp/vault/api v1.0.5-0.20190730042357-746c0b111519
 	github.com/hashicorp/go-reap v0.0.0-20170704170343-bf58d8a43e7b
 	github.com/juju/errors v0.0.0-20190930114154-d42613fe1ab9 // indirect
 	github.com/juju/loggo v0.0.0-20190526231331-6e530bcce5d8 // indirect
@@ -27,7 +30,9 @@ require (
 	github.com/pkg/errors v0.8.1
 	github.com/prometheus/client_golang v1.4.0
 	github.com/sirupsen/logrus v1.4.2
+	github.com/tevino/go-zookeeper v0.0.0-20170512024026-c218ec636bef
 	github.com/x-cray/logrus-prefixed-formatter v0.5.2
+	go.etcd.io/etcd v3.3.17+incompatible
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15
 	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22 // indirect
 )
@@ -0,0 +1,76 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"context"
+	"sort"
+
+	"github.com/HeavyHorst/easykv"
+	"github.com/HeavyHorst/remco/pkg/template"
+)
+
+// StoreClient is the client every backend sets as template.Backend.ReadWatcher in Connect.
+//
+// Implementations must fulfill the following contract,
+// which is verified by ContractTest in pkg/backends/testing:
+//
+// GetValues(keys) returns all KV-Pairs whose key starts with one of the given keys.
+// The returned keys are absolute ("/prefix/key"), nested keys are separated by "/".
+// Keys outside of the given prefixes must not be returned. A missing prefix is not an error,
+// the result is just empty. Created and updated keys are visible to the next GetValues call,
+// deleted keys are not returned anymore.
+//
+// WatchPrefix(ctx, prefix, opts...) blocks until a key below prefix has changed
+// after the given wait index (easykv.WithWaitIndex) and returns the new index.
+// A wait index of 0 may return immediately with the current index.
+// Every creation, update or deletion of a watched key must eventually let a blocked
+// WatchPrefix return. Spurious returns are allowed, since the caller just re-reads the values.
+// WatchPrefix must return easykv.ErrWatchCanceled once ctx is canceled and
+// easykv.ErrWatchNotSupported if the backend can't watch for changes.
+//
+// Close releases all resources of the client. The client must not be used afterwards.
+//
+// Clients whose reads can be canceled additionally implement template.ContextReader,
+// GetValuesContext(ctx, keys) is GetValues that returns once ctx is done.
+// Clients that can list the keys without transferring the values (consul, etcd v3 and zookeeper)
+// additionally implement KeyLister, ListKeys works with every client.
+type StoreClient = easykv.ReadWatcher
+
+// KeyLister is implemented by clients that can list the keys below a prefix without fetching their values.
+// ListKeys(ctx, prefix) returns the sorted keys GetValues([]string{prefix}) would return.
+type KeyLister interface {
+	ListKeys(ctx context.Context, prefix string) ([]string, error)
+}
+
+// ListKeys returns the sorted keys below prefix. It calls ListKeys if the client is a KeyLister
+// and returns the keys of GetValues otherwise.
+func ListKeys(ctx context.Context, client StoreClient, prefix string) ([]string, error) {
+	if l, ok := client.(KeyLister); ok {
+		return l.ListKeys(ctx, prefix)
+	}
+	values, err := getValuesContext(ctx, client, []string{prefix})
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// getValuesContext calls GetValuesContext if the client is a template.ContextReader and GetValues otherwise.
+func getValuesContext(ctx context.Context, client StoreClient, keys []string) (map[string]string, error) {
+	if r, ok := client.(template.ContextReader); ok {
+		return r.GetValuesContext(ctx, keys)
+	}
+	return client.GetValues(keys)
+}
@@ -9,6 +9,10 @@
 package backends
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
 	"github.com/HeavyHorst/easykv/consul"
 	berr "github.com/HeavyHorst/remco/pkg/backends/error"
 	"github.com/HeavyHorst/remco/pkg/log"
@@ -16,6 +20,12 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// Valid values of ConsulConfig.Mode.
+const (
+	consulModeKV      = "kv"
+	consulModeCatalog = "catalog"
+)
+
 // ConsulConfig represents the config for the consul backend.
 type ConsulConfig struct {
 	// Nodes is a list of backend nodes.
@@ -37,6 +47,21 @@ type ConsulConfig struct {
 	//The client CA key file.
 	ClientCaKeys string `toml:"client_ca_keys"`
 
+	// ConsulWatchMaxWait is the maximum time in seconds a blocking query waits for changes.
+	// The default is 55.
+	ConsulWatchMaxWait int `toml:"consul_watch_max_wait"`
+
+	// Mode selects the data source: "kv" (the default) reads the KV store,
+	// "catalog" exposes the services of the service catalog and their health as KV-Pairs
+	// like /services/<name>/<id>/address, /services/<name>/<id>/port and /services/<name>/<id>/status.
+	Mode string
+
+	// ConsulConnectService is the ID of the Consul Connect service remco is registered as.
+	// If set, the consul api is accessed with the leaf certificate of the service (fetched from the agent
+	// and rotated before it expires) instead of client_cert and client_key.
+	ConsulConnectService string `toml:"consul_connect_service"`
+
+	HTTPPoolConfig
 	template.Backend
 }
 
@@ -45,7 +70,7 @@ func (c *ConsulConfig) Connect() (template.Backend, error) {
 	if c == nil {
 		return template.Backend{}, berr.ErrNilConfig
 	}
-	c.Backend.Name = "consul"
+	c.Backend.Name = c.BackendName()
 
 	// No nodes are set but a SRVRecord is provided
 	if len(c.Nodes) == 0 && c.SRVRecord != "" {
@@ -61,17 +86,69 @@ func (c *ConsulConfig) Connect() (template.Backend, error) {
 		"nodes":   c.Nodes,
 	}).Info("set backend nodes")
 
-	client, err := consul.New(c.Nodes, consul.WithScheme(c.Scheme), consul.WithTLSOptions(consul.TLSOptions{
+	tlsOptions := consul.TLSOptions{
 		ClientCert:   c.ClientCert,
 		ClientKey:    c.ClientKey,
 		ClientCaKeys: c.ClientCaKeys,
-	}))
+	}
+	maxWait := time.Duration(c.ConsulWatchMaxWait) * time.Second
+	switch c.Mode {
+	case "", consulModeKV, consulModeCatalog:
+	default:
+		return c.Backend, fmt.Errorf("invalid consul mode %q", c.Mode)
+	}
+
+	if c.ConsulConnectService != "" {
+		return c.connectWithLeafCert(tlsOptions, maxWait)
+	}
 
+	apiClient, err := newConsulAPI(c.Nodes, c.Scheme, tlsOptions, c.HTTPPoolConfig)
 	if err != nil {
 		return c.Backend, err
 	}
 
-	c.Backend.ReadWatcher = client
+	if c.Mode == consulModeCatalog {
+		c.Backend.ReadWatcher = newConsulCatalog(consulAPI{apiClient}, maxWait)
+		return c.Backend, nil
+	}
+
+	// the values are read and watched with the same pooled connections
+	kv := apiClient.KV()
+	c.Backend.ReadWatcher = newConsulWatcher(consulKVReader{kv}, kv, maxWait)
+
+	return c.Backend, nil
+}
+
+// connectWithLeafCert fetches the Consul Connect leaf certificate of the service from the agent
+// and creates the client with it.
+func (c *ConsulConfig) connectWithLeafCert(tlsOptions consul.TLSOptions, maxWait time.Duration) (template.Backend, error) {
+	// the agent is asked for the certificate without a client certificate
+	agentClient, err := newConsulAPI(c.Nodes, c.Scheme, consul.TLSOptions{ClientCaKeys: tlsOptions.ClientCaKeys}, c.HTTPPoolConfig)
+	if err != nil {
+		return c.Backend, err
+	}
+	leaf, err := newConsulLeafCert(agentClient.Agent(), c.ConsulConnectService)
+	if err != nil {
+		return c.Backend, err
+	}
+	apiClient, err := newConsulConnectAPI(c.Nodes, c.Scheme, tlsOptions, c.HTTPPoolConfig, leaf)
+	if err != nil {
+		leaf.Close()
+		return c.Backend, err
+	}
 
+	log.WithFields(logrus.Fields{
+		"backend": c.Backend.Name,
+		"service": c.ConsulConnectService,
+	}).Info("using the consul connect leaf certificate")
+
+	var client easykv.ReadWatcher
+	if c.Mode == consulModeCatalog {
+		client = newConsulCatalog(consulAPI{apiClient}, maxWait)
+	} else {
+		kv := apiClient.KV()
+		client = newConsulWatcher(consulKVReader{kv}, kv, maxWait)
+	}
+	c.Backend.ReadWatcher = consulConnectClient{client, leaf}
 	return c.Backend, nil
 }
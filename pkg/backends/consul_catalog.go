@@ -0,0 +1,253 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"context"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+	"github.com/hashicorp/consul/api"
+)
+
+// consulCatalogPrefix is the key prefix of the services in catalog mode.
+const consulCatalogPrefix = "/services"
+
+// consulCatalogAPI is the subset of the consul catalog- and health-API used in catalog mode.
+type consulCatalogAPI interface {
+	Services(q *api.QueryOptions) (map[string][]string, *api.QueryMeta, error)
+	Service(service string, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error)
+	Checks(q *api.QueryOptions) (api.HealthChecks, *api.QueryMeta, error)
+}
+
+// consulAPI implements consulCatalogAPI with a consul api client.
+type consulAPI struct {
+	client *api.Client
+}
+
+func (c consulAPI) Services(q *api.QueryOptions) (map[string][]string, *api.QueryMeta, error) {
+	return c.client.Catalog().Services(q)
+}
+
+func (c consulAPI) Service(service string, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+	return c.client.Health().Service(service, "", false, q)
+}
+
+func (c consulAPI) Checks(q *api.QueryOptions) (api.HealthChecks, *api.QueryMeta, error) {
+	return c.client.Health().State(api.HealthAny, q)
+}
+
+// consulCatalogIndexes are the last seen indexes of the watched endpoints.
+type consulCatalogIndexes struct {
+	services, checks uint64
+	generation       uint64
+}
+
+// consulCatalog exposes the services of the consul catalog and their health as KV-Pairs:
+//   /services/<name>/<id>/address
+//   /services/<name>/<id>/port
+//   /services/<name>/<id>/status
+type consulCatalog struct {
+	api     consulCatalogAPI
+	maxWait time.Duration
+	sleep   func(ctx context.Context, d time.Duration) bool
+
+	mu      sync.Mutex
+	indexes map[string]consulCatalogIndexes
+}
+
+func newConsulCatalog(a consulCatalogAPI, maxWait time.Duration) *consulCatalog {
+	if maxWait <= 0 {
+		maxWait = defaultConsulWatchMaxWait
+	}
+	return &consulCatalog{
+		api:     a,
+		maxWait: maxWait,
+		sleep:   sleepContext,
+		indexes: make(map[string]consulCatalogIndexes),
+	}
+}
+
+// consulServiceWanted reports whether a key of the service name may start with one of the given keys.
+func consulServiceWanted(name string, keys []string) bool {
+	prefix := path.Join(consulCatalogPrefix, name)
+	for _, k := range keys {
+		if strings.HasPrefix(prefix, k) || strings.HasPrefix(k, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// GetValues queries all registered services and their instances.
+// Only the health of the services whose keys start with one of the given keys is queried.
+func (c *consulCatalog) GetValues(keys []string) (map[string]string, error) {
+	return c.GetValuesContext(context.Background(), keys)
+}
+
+// GetValuesContext is GetValues, the queries are canceled with ctx.
+func (c *consulCatalog) GetValuesContext(ctx context.Context, keys []string) (map[string]string, error) {
+	q := (&api.QueryOptions{}).WithContext(ctx)
+	services, _, err := c.api.Services(q)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make(map[string]string)
+	for name := range services {
+		if !consulServiceWanted(name, keys) {
+			continue
+		}
+		entries, _, err := c.api.Service(name, q)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			address := e.Service.Address
+			if address == "" {
+				address = e.Node.Address
+			}
+			prefix := path.Join(consulCatalogPrefix, name, e.Service.ID)
+			vars[prefix+"/address"] = address
+			vars[prefix+"/port"] = strconv.Itoa(e.Service.Port)
+			vars[prefix+"/status"] = e.Checks.AggregatedStatus()
+		}
+	}
+
+	for k := range vars {
+		if !hasAnyPrefix(k, keys) {
+			delete(vars, k)
+		}
+	}
+	return vars, nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchPrefix blocks until a service is (de)registered or a health check changes its status.
+//
+// Blocking queries on the catalog services and the health checks run concurrently.
+// Since they have independent indexes, the returned index is a generation counter of the prefix
+// and the indexes of both endpoints are tracked internally.
+// Forbidden requests (403) are retried with an exponential backoff.
+func (c *consulCatalog) WatchPrefix(ctx context.Context, prefix string, opts ...easykv.WatchOption) (uint64, error) {
+	var options easykv.WatchOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	c.mu.Lock()
+	last, ok := c.indexes[prefix]
+	c.mu.Unlock()
+
+	if !ok || options.WaitIndex == 0 || options.WaitIndex != last.generation {
+		// (re)start from the current indexes
+		last.services, last.checks = 0, 0
+	}
+
+	type result struct {
+		index uint64
+		err   error
+	}
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	services := make(chan result, 1)
+	checks := make(chan result, 1)
+	go func() {
+		i, err := c.block(wctx, prefix, last.services, func(q *api.QueryOptions) (*api.QueryMeta, error) {
+			_, meta, err := c.api.Services(q)
+			return meta, err
+		})
+		services <- result{i, err}
+	}()
+	go func() {
+		i, err := c.block(wctx, prefix, last.checks, func(q *api.QueryOptions) (*api.QueryMeta, error) {
+			_, meta, err := c.api.Checks(q)
+			return meta, err
+		})
+		checks <- result{i, err}
+	}()
+
+	next := last
+	var r result
+	if last.services == 0 {
+		// the current indexes of both endpoints are required
+		s, ch := <-services, <-checks
+		r = s
+		if r.err == nil {
+			r = ch
+		}
+		next.services, next.checks = s.index, ch.index
+	} else {
+		select {
+		case r = <-services:
+			next.services = r.index
+		case r = <-checks:
+			next.checks = r.index
+		}
+	}
+
+	if ctx.Err() != nil {
+		return options.WaitIndex, easykv.ErrWatchCanceled
+	}
+	if r.err != nil {
+		return options.WaitIndex, r.err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	next.generation = c.indexes[prefix].generation + 1
+	c.indexes[prefix] = next
+	return next.generation, nil
+}
+
+// block repeats the blocking query until the index of the endpoint differs from index.
+// An index of 0 returns the current index immediately.
+func (c *consulCatalog) block(ctx context.Context, prefix string, index uint64, query func(q *api.QueryOptions) (*api.QueryMeta, error)) (uint64, error) {
+	backoff := time.Second
+	for {
+		q := &api.QueryOptions{
+			WaitIndex: index,
+			WaitTime:  c.maxWait,
+		}
+		meta, err := query(q.WithContext(ctx))
+		if ctx.Err() != nil {
+			return index, easykv.ErrWatchCanceled
+		}
+		if err != nil {
+			if !isConsulForbidden(err) {
+				return index, err
+			}
+			if !consulForbiddenBackoff(ctx, c.sleep, prefix, &backoff) {
+				return index, easykv.ErrWatchCanceled
+			}
+			continue
+		}
+		backoff = time.Second
+
+		// a lower index (consul reset its index) is a change as well
+		if index == 0 || meta.LastIndex != index {
+			return meta.LastIndex, nil
+		}
+	}
+}
+
+// Close is a no-op, the consul api client has no resources to release.
+func (c *consulCatalog) Close() {}
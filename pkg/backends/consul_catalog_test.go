@@ -0,0 +1,154 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+	"github.com/hashicorp/consul/api"
+)
+
+// fakeConsulCatalog simulates the blocking queries of the catalog services and health checks endpoints.
+type fakeConsulCatalog struct {
+	mu            sync.Mutex
+	entries       map[string][]*api.ServiceEntry
+	servicesIndex uint64
+	checksIndex   uint64
+	changed       chan struct{}
+}
+
+func newFakeConsulCatalog(entries map[string][]*api.ServiceEntry) *fakeConsulCatalog {
+	return &fakeConsulCatalog{
+		entries:       entries,
+		servicesIndex: 5,
+		checksIndex:   7,
+		changed:       make(chan struct{}),
+	}
+}
+
+func (f *fakeConsulCatalog) wait(q *api.QueryOptions, index func() uint64) uint64 {
+	for {
+		f.mu.Lock()
+		i, changed := index(), f.changed
+		f.mu.Unlock()
+		if q == nil || q.WaitIndex == 0 || i != q.WaitIndex {
+			return i
+		}
+		select {
+		case <-changed:
+		case <-q.Context().Done():
+			return i
+		}
+	}
+}
+
+// bumpChecks simulates a changed health check.
+func (f *fakeConsulCatalog) bumpChecks() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.checksIndex++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+func (f *fakeConsulCatalog) Services(q *api.QueryOptions) (map[string][]string, *api.QueryMeta, error) {
+	i := f.wait(q, func() uint64 { return f.servicesIndex })
+	services := make(map[string][]string)
+	for name := range f.entries {
+		services[name] = nil
+	}
+	return services, &api.QueryMeta{LastIndex: i}, nil
+}
+
+func (f *fakeConsulCatalog) Service(service string, q *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+	return f.entries[service], &api.QueryMeta{}, nil
+}
+
+func (f *fakeConsulCatalog) Checks(q *api.QueryOptions) (api.HealthChecks, *api.QueryMeta, error) {
+	i := f.wait(q, func() uint64 { return f.checksIndex })
+	return nil, &api.QueryMeta{LastIndex: i}, nil
+}
+
+func serviceEntry(id, address, nodeAddress string, port int, status string) *api.ServiceEntry {
+	return &api.ServiceEntry{
+		Node:    &api.Node{Address: nodeAddress},
+		Service: &api.AgentService{ID: id, Address: address, Port: port},
+		Checks:  api.HealthChecks{{Status: status}},
+	}
+}
+
+func TestConsulCatalogGetValues(t *testing.T) {
+	c := newConsulCatalog(newFakeConsulCatalog(map[string][]*api.ServiceEntry{
+		"web": {
+			serviceEntry("web1", "10.0.0.1", "192.168.0.1", 80, api.HealthPassing),
+			serviceEntry("web2", "", "192.168.0.2", 8080, api.HealthCritical),
+		},
+		"db": {serviceEntry("db1", "10.0.0.3", "", 5432, api.HealthPassing)},
+	}), 0)
+
+	values, err := c.GetValues([]string{"/services/web"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"/services/web/web1/address": "10.0.0.1",
+		"/services/web/web1/port":    "80",
+		"/services/web/web1/status":  api.HealthPassing,
+		"/services/web/web2/address": "192.168.0.2",
+		"/services/web/web2/port":    "8080",
+		"/services/web/web2/status":  api.HealthCritical,
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("GetValues should return %v, got %v", expected, values)
+	}
+
+	values, err = c.GetValues([]string{"/services/db/db1/port"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 1 || values["/services/db/db1/port"] != "5432" {
+		t.Errorf("GetValues should only return the db port, got %v", values)
+	}
+}
+
+func TestConsulCatalogWatchPrefix(t *testing.T) {
+	fake := newFakeConsulCatalog(nil)
+	c := newConsulCatalog(fake, time.Second)
+
+	index, err := c.WatchPrefix(context.Background(), "/services", easykv.WithWaitIndex(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		fake.bumpChecks()
+	}()
+	next, err := c.WatchPrefix(context.Background(), "/services", easykv.WithWaitIndex(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == index {
+		t.Error("the index should change after a health check changed")
+	}
+	if c.indexes["/services"].checks != 8 {
+		t.Errorf("the checks index should be 8, got %d", c.indexes["/services"].checks)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := c.WatchPrefix(ctx, "/services", easykv.WithWaitIndex(next)); err != easykv.ErrWatchCanceled {
+		t.Errorf("WatchPrefix should return ErrWatchCanceled, got %v", err)
+	}
+}
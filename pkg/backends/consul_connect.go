@@ -0,0 +1,233 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"context"
+	"crypto/tls"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+	"github.com/HeavyHorst/easykv/consul"
+	"github.com/HeavyHorst/remco/pkg/log"
+	"github.com/hashicorp/consul/api"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// consulLeafMinBackoff is the delay before the first retry of a failed leaf certificate fetch.
+	consulLeafMinBackoff = 1 * time.Second
+
+	// consulLeafMaxBackoff is the maximum delay between two retries of a failed leaf certificate fetch.
+	consulLeafMaxBackoff = 1 * time.Minute
+)
+
+// consulAgent is the subset of the consul agent api used to fetch the leaf certificate.
+type consulAgent interface {
+	ConnectCALeaf(serviceID string, q *api.QueryOptions) (*api.LeafCert, *api.QueryMeta, error)
+}
+
+// consulLeafCert holds the Consul Connect leaf certificate of a service and keeps it valid.
+//
+// The certificate is fetched again at 2/3 of its validity period. Failed fetches are retried
+// with an exponential backoff, until then the previous certificate is used.
+type consulLeafCert struct {
+	agent   consulAgent
+	service string
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	// mu protects the certificate and the backoff
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	leaf *api.LeafCert
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// newConsulLeafCert fetches the leaf certificate of the service and starts its rotation.
+func newConsulLeafCert(agent consulAgent, service string) (*consulLeafCert, error) {
+	l := &consulLeafCert{
+		agent:      agent,
+		service:    service,
+		minBackoff: consulLeafMinBackoff,
+		maxBackoff: consulLeafMaxBackoff,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if err := l.fetch(); err != nil {
+		return nil, err
+	}
+	go l.rotate()
+	return l, nil
+}
+
+func (l *consulLeafCert) logger() *logrus.Entry {
+	return log.WithFields(logrus.Fields{
+		"backend": "consul",
+		"service": l.service,
+	})
+}
+
+// fetch fetches the leaf certificate and sets it.
+func (l *consulLeafCert) fetch() error {
+	leaf, _, err := l.agent.ConnectCALeaf(l.service, nil)
+	if err != nil {
+		return errors.Wrap(err, "couldn't fetch the consul connect leaf certificate")
+	}
+	cert, err := tls.X509KeyPair([]byte(leaf.CertPEM), []byte(leaf.PrivateKeyPEM))
+	if err != nil {
+		return errors.Wrap(err, "invalid consul connect leaf certificate")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cert = &cert
+	l.leaf = leaf
+	return nil
+}
+
+// renewAt returns the time at 2/3 of the validity period of the current certificate.
+func (l *consulLeafCert) renewAt() time.Time {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.leaf.ValidAfter.Add(l.leaf.ValidBefore.Sub(l.leaf.ValidAfter) * 2 / 3)
+}
+
+// GetClientCertificate returns the current certificate, it is used as tls.Config.GetClientCertificate.
+func (l *consulLeafCert) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.cert, nil
+}
+
+// sleep waits for d. It returns false if the rotation has been stopped in the meantime.
+func (l *consulLeafCert) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-l.stop:
+		return false
+	}
+}
+
+// rotate fetches the certificate before it expires until Close is called.
+func (l *consulLeafCert) rotate() {
+	defer close(l.done)
+	for {
+		if !l.sleep(time.Until(l.renewAt())) {
+			return
+		}
+		l.mu.RLock()
+		backoff := l.minBackoff
+		l.mu.RUnlock()
+		for {
+			err := l.fetch()
+			if err == nil {
+				l.mu.RLock()
+				l.logger().WithField("valid_before", l.leaf.ValidBefore.Format(time.RFC3339)).Info("rotated the consul connect leaf certificate")
+				l.mu.RUnlock()
+				break
+			}
+			l.logger().WithField("retry_in", backoff.String()).Error(err)
+			if !l.sleep(backoff) {
+				return
+			}
+			backoff *= 2
+			if backoff > l.maxBackoff {
+				backoff = l.maxBackoff
+			}
+		}
+	}
+}
+
+// Close stops the rotation of the certificate.
+func (l *consulLeafCert) Close() {
+	l.stopOnce.Do(func() {
+		close(l.stop)
+	})
+	<-l.done
+}
+
+// newConsulConnectAPI creates a consul api client that authenticates with the leaf certificate.
+// The CA settings of tls are used to verify the consul server, its client certificate settings are ignored.
+func newConsulConnectAPI(nodes []string, scheme string, tls consul.TLSOptions, pool HTTPPoolConfig, leaf *consulLeafCert) (*api.Client, error) {
+	conf := api.DefaultConfig()
+	pool.apply(conf.Transport)
+	conf.Scheme = scheme
+	if len(nodes) > 0 {
+		conf.Address = nodes[0]
+	}
+	if tls.ClientCaKeys != "" {
+		conf.TLSConfig.CAFile = tls.ClientCaKeys
+	}
+	tlsConfig, err := api.SetupTLSConfig(&conf.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.GetClientCertificate = leaf.GetClientCertificate
+	// NewClient keeps a TLS config that is already set
+	conf.Transport.TLSClientConfig = tlsConfig
+	return api.NewClient(conf)
+}
+
+// consulKVReader reads the values of the KV store with a consul api client.
+// It shares the api client (and its connection pool) with the watch.
+type consulKVReader struct {
+	kv consulKV
+}
+
+// GetValues returns the KV-Pairs of all given prefixes.
+func (r consulKVReader) GetValues(keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, key := range keys {
+		pairs, _, err := r.kv.List(strings.TrimPrefix(key, "/"), nil)
+		if err != nil {
+			return vars, err
+		}
+		for _, p := range pairs {
+			vars[path.Join("/", p.Key)] = string(p.Value)
+		}
+	}
+	return vars, nil
+}
+
+// Close does nothing.
+func (r consulKVReader) Close() {}
+
+// consulConnectClient stops the rotation of the leaf certificate when it is closed.
+type consulConnectClient struct {
+	easykv.ReadWatcher
+	leaf *consulLeafCert
+}
+
+// GetValuesContext is GetValues, the queries are canceled with ctx if the client supports it.
+func (c consulConnectClient) GetValuesContext(ctx context.Context, keys []string) (map[string]string, error) {
+	return getValuesContext(ctx, c.ReadWatcher, keys)
+}
+
+// ListKeys lists the keys below prefix with the wrapped client.
+func (c consulConnectClient) ListKeys(ctx context.Context, prefix string) ([]string, error) {
+	return ListKeys(ctx, c.ReadWatcher, prefix)
+}
+
+// Close stops the rotation of the leaf certificate and closes the client.
+func (c consulConnectClient) Close() {
+	c.leaf.Close()
+	c.ReadWatcher.Close()
+}
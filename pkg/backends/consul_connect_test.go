@@ -0,0 +1,193 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv/consul"
+	"github.com/hashicorp/consul/api"
+)
+
+// newTestLeafCert returns a self-signed leaf certificate of the service that is valid for validity.
+func newTestLeafCert(t *testing.T, service string, serial int64, validity time.Duration) *api.LeafCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: service},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &api.LeafCert{
+		SerialNumber:  big.NewInt(serial).String(),
+		CertPEM:       string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		PrivateKeyPEM: string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+		Service:       service,
+		ValidAfter:    now,
+		ValidBefore:   now.Add(validity),
+	}
+}
+
+// fakeConsulAgent issues a new leaf certificate on every request, the requests fail while down is set.
+type fakeConsulAgent struct {
+	t        *testing.T
+	validity time.Duration
+
+	mu       sync.Mutex
+	requests int
+	down     bool
+}
+
+func (f *fakeConsulAgent) ConnectCALeaf(serviceID string, q *api.QueryOptions) (*api.LeafCert, *api.QueryMeta, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	if f.down {
+		return nil, nil, errors.New("Unexpected response code: 500")
+	}
+	return newTestLeafCert(f.t, serviceID, int64(f.requests), f.validity), &api.QueryMeta{}, nil
+}
+
+func (f *fakeConsulAgent) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+func (f *fakeConsulAgent) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func leafSerial(t *testing.T, l *consulLeafCert) string {
+	cert, _ := l.GetClientCertificate(nil)
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.SerialNumber.String()
+}
+
+func TestConsulLeafCertRotation(t *testing.T) {
+	agent := &fakeConsulAgent{t: t, validity: 150 * time.Millisecond}
+	l, err := newConsulLeafCert(agent, "remco")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if serial := leafSerial(t, l); serial != "1" {
+		t.Errorf("expected the first certificate, got serial %s", serial)
+	}
+
+	// the certificate is fetched again at 2/3 of its validity
+	waitFor(t, 5*time.Second, func() bool { return agent.count() >= 2 })
+	waitFor(t, 5*time.Second, func() bool { return leafSerial(t, l) != "1" })
+}
+
+func TestConsulLeafCertRetry(t *testing.T) {
+	agent := &fakeConsulAgent{t: t, validity: 150 * time.Millisecond}
+	l, err := newConsulLeafCert(agent, "remco")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// failed fetches are retried, the previous certificate is kept
+	agent.setDown(true)
+	l.mu.Lock()
+	l.minBackoff = 10 * time.Millisecond
+	l.mu.Unlock()
+	waitFor(t, 5*time.Second, func() bool { return agent.count() >= 3 })
+	if serial := leafSerial(t, l); serial != "1" {
+		t.Errorf("expected the previous certificate, got serial %s", serial)
+	}
+	agent.setDown(false)
+	waitFor(t, 5*time.Second, func() bool { return leafSerial(t, l) != "1" })
+}
+
+func TestConsulLeafCertFetchError(t *testing.T) {
+	agent := &fakeConsulAgent{t: t, down: true}
+	if _, err := newConsulLeafCert(agent, "remco"); err == nil || !strings.Contains(err.Error(), "leaf certificate") {
+		t.Errorf("expected a fetch error, got %v", err)
+	}
+}
+
+func TestConsulConnectAPI(t *testing.T) {
+	agent := &fakeConsulAgent{t: t, validity: time.Hour}
+	l, err := newConsulLeafCert(agent, "remco")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// the server requires a client certificate
+	var clientCN string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) > 0 {
+			clientCN = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		w.Header().Set("X-Consul-Index", "1")
+		w.Write([]byte(`[{"Key":"app/port","Value":"ODA4MA=="}]`))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "remco-consul-connect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := writeTestFile(t, dir, "ca.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})))
+	apiClient, err := newConsulConnectAPI([]string{strings.TrimPrefix(server.URL, "https://")}, "https", consul.TLSOptions{ClientCaKeys: ca}, HTTPPoolConfig{}, l)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values, err := consulKVReader{apiClient.KV()}.GetValues([]string{"/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["/app/port"] != "8080" {
+		t.Errorf("unexpected values %v", values)
+	}
+	if clientCN != "remco" {
+		t.Errorf("expected the leaf certificate of remco, got %q", clientCN)
+	}
+}
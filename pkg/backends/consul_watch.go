@@ -0,0 +1,193 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+	"github.com/HeavyHorst/easykv/consul"
+	"github.com/HeavyHorst/remco/pkg/log"
+	"github.com/hashicorp/consul/api"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultConsulWatchMaxWait is the default wait time of a blocking query.
+	defaultConsulWatchMaxWait = 55 * time.Second
+
+	// consulWatchMaxBackoff is the maximum time to wait before retrying a forbidden request.
+	consulWatchMaxBackoff = 60 * time.Second
+)
+
+// consulKV is the subset of the consul KV-API used to read and watch a prefix.
+type consulKV interface {
+	List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+	Keys(prefix, separator string, q *api.QueryOptions) ([]string, *api.QueryMeta, error)
+}
+
+// consulReader reads the values of the KV store, it is a consulKVReader.
+type consulReader interface {
+	GetValues(keys []string) (map[string]string, error)
+	Close()
+}
+
+// consulWatcher adds the WatchPrefix implementation with long-polling blocking queries to a consulReader.
+type consulWatcher struct {
+	consulReader
+	kv      consulKV
+	maxWait time.Duration
+	sleep   func(ctx context.Context, d time.Duration) bool
+}
+
+func newConsulWatcher(client consulReader, kv consulKV, maxWait time.Duration) *consulWatcher {
+	if maxWait <= 0 {
+		maxWait = defaultConsulWatchMaxWait
+	}
+	return &consulWatcher{
+		consulReader: client,
+		kv:           kv,
+		maxWait:      maxWait,
+		sleep:        sleepContext,
+	}
+}
+
+// newConsulAPI creates a consul api client with the same settings as the easykv consul client
+// and the connection pool configured by pool.
+func newConsulAPI(nodes []string, scheme string, tls consul.TLSOptions, pool HTTPPoolConfig) (*api.Client, error) {
+	conf := api.DefaultConfig()
+	pool.apply(conf.Transport)
+	conf.Scheme = scheme
+	if len(nodes) > 0 {
+		conf.Address = nodes[0]
+	}
+	if tls.ClientCert != "" && tls.ClientKey != "" {
+		conf.TLSConfig.CertFile = tls.ClientCert
+		conf.TLSConfig.KeyFile = tls.ClientKey
+	}
+	if tls.ClientCaKeys != "" {
+		conf.TLSConfig.CAFile = tls.ClientCaKeys
+	}
+	return api.NewClient(conf)
+}
+
+// ListKeys returns the sorted keys below prefix, the values aren't transferred.
+func (c *consulWatcher) ListKeys(ctx context.Context, prefix string) ([]string, error) {
+	q := &api.QueryOptions{}
+	list, _, err := c.kv.Keys(strings.TrimPrefix(prefix, "/"), "", q.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(list))
+	keys := make([]string, 0, len(list))
+	for _, k := range list {
+		// a folder "a/" is the key "/a", as in GetValues
+		k = path.Join("/", k)
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// WatchPrefix blocks until the X-Consul-Index of the prefix differs from the wait index.
+//
+// The blocking queries only list the keys, the values are fetched by the following GetValues call.
+// Blocking queries that time out without a change are repeated transparently.
+// If consul resets the index to a lower value (e.g. after a leader election)
+// the prefix is re-polled from index 0, which reports the current index immediately.
+// Forbidden requests (403, e.g. after an ACL change) are retried with an exponential backoff.
+func (c *consulWatcher) WatchPrefix(ctx context.Context, prefix string, opts ...easykv.WatchOption) (uint64, error) {
+	var options easykv.WatchOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	waitIndex := options.WaitIndex
+	backoff := time.Second
+	for {
+		q := &api.QueryOptions{
+			WaitIndex: waitIndex,
+			WaitTime:  c.maxWait,
+		}
+		_, meta, err := c.kv.Keys(strings.TrimPrefix(prefix, "/"), "", q.WithContext(ctx))
+		if ctx.Err() != nil {
+			return options.WaitIndex, easykv.ErrWatchCanceled
+		}
+		if err != nil {
+			if !isConsulForbidden(err) {
+				return options.WaitIndex, err
+			}
+			if !consulForbiddenBackoff(ctx, c.sleep, prefix, &backoff) {
+				return options.WaitIndex, easykv.ErrWatchCanceled
+			}
+			continue
+		}
+		backoff = time.Second
+
+		switch {
+		case waitIndex == 0:
+			return meta.LastIndex, nil
+		case meta.LastIndex < waitIndex:
+			log.WithFields(logrus.Fields{
+				"backend":    "consul",
+				"prefix":     prefix,
+				"last_index": meta.LastIndex,
+				"wait_index": waitIndex,
+			}).Info("consul index went backwards - re-polling from index 0")
+			waitIndex = 0
+		case meta.LastIndex == waitIndex:
+			// the blocking query timed out without any change
+		default:
+			return meta.LastIndex, nil
+		}
+	}
+}
+
+// isConsulForbidden reports whether err is a 403 response of the consul api.
+func isConsulForbidden(err error) bool {
+	return strings.Contains(err.Error(), "Unexpected response code: 403")
+}
+
+// consulForbiddenBackoff waits before a forbidden request is retried and doubles the backoff.
+// It returns false if ctx is done.
+func consulForbiddenBackoff(ctx context.Context, sleep func(context.Context, time.Duration) bool, prefix string, backoff *time.Duration) bool {
+	log.WithFields(logrus.Fields{
+		"backend": "consul",
+		"prefix":  prefix,
+		"backoff": *backoff,
+	}).Warning("permission denied - retrying")
+	if !sleep(ctx, *backoff) {
+		return false
+	}
+	*backoff *= 2
+	if *backoff > consulWatchMaxBackoff {
+		*backoff = consulWatchMaxBackoff
+	}
+	return true
+}
+
+// sleepContext waits for d or until ctx is done.
+// It returns false if ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,224 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+	"github.com/HeavyHorst/easykv/consul"
+	"github.com/HeavyHorst/easykv/mock"
+	"github.com/hashicorp/consul/api"
+)
+
+type fakeResponse struct {
+	index uint64
+	err   error
+}
+
+// fakeConsulKV returns the configured responses in order and records the requested wait indexes.
+type fakeConsulKV struct {
+	responses   []fakeResponse
+	waitIndexes []uint64
+}
+
+func (f *fakeConsulKV) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	return nil, nil, errors.New("the watch should only list the keys")
+}
+
+func (f *fakeConsulKV) Keys(prefix, separator string, q *api.QueryOptions) ([]string, *api.QueryMeta, error) {
+	f.waitIndexes = append(f.waitIndexes, q.WaitIndex)
+	r := f.responses[0]
+	f.responses = f.responses[1:]
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	return nil, &api.QueryMeta{LastIndex: r.index}, nil
+}
+
+func newTestConsulWatcher(kv consulKV) (*consulWatcher, *[]time.Duration) {
+	var sleeps []time.Duration
+	w := newConsulWatcher(nil, kv, 0)
+	w.sleep = func(ctx context.Context, d time.Duration) bool {
+		sleeps = append(sleeps, d)
+		return true
+	}
+	return w, &sleeps
+}
+
+func TestConsulWatchPrefix(t *testing.T) {
+	kv := &fakeConsulKV{responses: []fakeResponse{{index: 10}, {index: 10}, {index: 12}}}
+	w, _ := newTestConsulWatcher(kv)
+
+	index, err := w.WatchPrefix(context.Background(), "/foo", easykv.WithWaitIndex(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 12 {
+		t.Errorf("index should be 12, got %d", index)
+	}
+	if len(kv.waitIndexes) != 3 {
+		t.Errorf("unchanged indexes should be re-polled, got %d requests", len(kv.waitIndexes))
+	}
+}
+
+func TestConsulWatchPrefixIndexReset(t *testing.T) {
+	kv := &fakeConsulKV{responses: []fakeResponse{{index: 3}, {index: 4}}}
+	w, _ := newTestConsulWatcher(kv)
+
+	index, err := w.WatchPrefix(context.Background(), "/foo", easykv.WithWaitIndex(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 4 {
+		t.Errorf("index should be 4, got %d", index)
+	}
+	expected := []uint64{10, 0}
+	for i, wi := range kv.waitIndexes {
+		if wi != expected[i] {
+			t.Errorf("request %d should use wait index %d, got %d", i, expected[i], wi)
+		}
+	}
+}
+
+func TestConsulWatchPrefixForbidden(t *testing.T) {
+	forbidden := errors.New("Unexpected response code: 403 (ACL not found)")
+	kv := &fakeConsulKV{responses: []fakeResponse{{err: forbidden}, {err: forbidden}, {index: 11}}}
+	w, sleeps := newTestConsulWatcher(kv)
+
+	index, err := w.WatchPrefix(context.Background(), "/foo", easykv.WithWaitIndex(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 11 {
+		t.Errorf("index should be 11, got %d", index)
+	}
+	if len(*sleeps) != 2 || (*sleeps)[0] != time.Second || (*sleeps)[1] != 2*time.Second {
+		t.Errorf("forbidden requests should be retried with a backoff, got %v", *sleeps)
+	}
+}
+
+func TestConsulWatchPrefixError(t *testing.T) {
+	kv := &fakeConsulKV{responses: []fakeResponse{{err: errors.New("Unexpected response code: 500")}}}
+	w, _ := newTestConsulWatcher(kv)
+
+	index, err := w.WatchPrefix(context.Background(), "/foo", easykv.WithWaitIndex(10))
+	if err == nil {
+		t.Error("other errors should be returned")
+	}
+	if index != 10 {
+		t.Errorf("index should be unchanged, got %d", index)
+	}
+}
+
+// newFakeConsulServer serves the KV pairs below /v1/kv/, as values (?recurse) or as keys (?keys).
+func newFakeConsulServer(pairs map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefix := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+		var keys []string
+		var list api.KVPairs
+		for k, v := range pairs {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, k)
+				list = append(list, &api.KVPair{Key: k, Value: []byte(v)})
+			}
+		}
+		w.Header().Set("X-Consul-Index", "1")
+		if _, ok := r.URL.Query()["keys"]; ok {
+			json.NewEncoder(w).Encode(keys)
+			return
+		}
+		json.NewEncoder(w).Encode(list)
+	}))
+}
+
+func newFakeConsulWatcher(t testing.TB, server *httptest.Server) *consulWatcher {
+	apiClient, err := newConsulAPI([]string{strings.TrimPrefix(server.URL, "http://")}, "http", consul.TLSOptions{}, HTTPPoolConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newConsulWatcher(consulKVReader{apiClient.KV()}, apiClient.KV(), 0)
+}
+
+func TestConsulListKeys(t *testing.T) {
+	server := newFakeConsulServer(map[string]string{
+		"app/":      "",
+		"app/port":  "8080",
+		"app/a/b":   "1",
+		"other/key": "2",
+	})
+	defer server.Close()
+	w := newFakeConsulWatcher(t, server)
+
+	keys, err := ListKeys(context.Background(), w, "/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := w.GetValues([]string{"/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the keys of GetValues, without fetching the values
+	if !reflect.DeepEqual(keys, []string{"/app", "/app/a/b", "/app/port"}) || len(values) != len(keys) {
+		t.Errorf("unexpected keys %v (values %v)", keys, values)
+	}
+}
+
+func TestListKeysFallback(t *testing.T) {
+	client, _ := mock.New(nil, map[string]string{"/app/b": "2", "/app/a": "1"})
+	keys, err := ListKeys(context.Background(), client, "/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keys, []string{"/app/a", "/app/b"}) {
+		t.Errorf("expected the sorted keys of GetValues, got %v", keys)
+	}
+
+	client, _ = mock.New(errors.New("unreachable"), nil)
+	if _, err := ListKeys(context.Background(), client, "/app"); err == nil {
+		t.Error("the error of GetValues should be returned")
+	}
+}
+
+// benchmarkConsulLargeValues compares listing the keys with fetching the values of 100 keys of 64KiB.
+func benchmarkConsulLargeValues(b *testing.B, keysOnly bool) {
+	pairs := make(map[string]string)
+	value := strings.Repeat("x", 64*1024)
+	for i := 0; i < 100; i++ {
+		pairs[fmt.Sprintf("certs/%03d", i)] = value
+	}
+	server := newFakeConsulServer(pairs)
+	defer server.Close()
+	w := newFakeConsulWatcher(b, server)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if keysOnly {
+			_, err = w.ListKeys(context.Background(), "/certs")
+		} else {
+			_, err = w.GetValues([]string{"/certs"})
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkConsulListKeysLargeValues(b *testing.B)  { benchmarkConsulLargeValues(b, true) }
+func BenchmarkConsulGetValuesLargeValues(b *testing.B) { benchmarkConsulLargeValues(b, false) }
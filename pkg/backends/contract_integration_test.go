@@ -0,0 +1,233 @@
+// +build integration
+
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/backends"
+	contract "github.com/HeavyHorst/remco/pkg/backends/testing"
+	"github.com/hashicorp/consul/api"
+	vaultapi "github.com/hashicorp/vault/api"
+	"go.etcd.io/etcd/clientv3"
+)
+
+// The integration tests run the contract test suite against real backends:
+//
+//   CONSUL_HTTP_ADDR=127.0.0.1:8500 ETCD_ENDPOINTS=127.0.0.1:2379 go test -tags integration ./pkg/backends/
+//
+// Backends without a configured address are skipped.
+// The vault tests need a dev mode server and its root token:
+//
+//   vault server -dev -dev-root-token-id=root
+//   VAULT_ADDR=http://127.0.0.1:8200 VAULT_TOKEN=root go test -tags integration ./pkg/backends/
+
+type consulWriter struct {
+	kv *api.KV
+}
+
+func (w consulWriter) Set(key, value string) error {
+	_, err := w.kv.Put(&api.KVPair{Key: strings.TrimPrefix(key, "/"), Value: []byte(value)}, nil)
+	return err
+}
+
+func (w consulWriter) Delete(key string) error {
+	_, err := w.kv.Delete(strings.TrimPrefix(key, "/"), nil)
+	return err
+}
+
+func TestConsulContract(t *testing.T) {
+	addr := os.Getenv("CONSUL_HTTP_ADDR")
+	if addr == "" {
+		t.Skip("CONSUL_HTTP_ADDR is not set")
+	}
+
+	conf := api.DefaultConfig()
+	conf.Address = addr
+	client, err := api.NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &backends.ConsulConfig{Nodes: []string{addr}, Scheme: "http"}
+	b, err := config.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	contract.ContractTest(t, b.ReadWatcher, consulWriter{client.KV()})
+}
+
+type etcdWriter struct {
+	client *clientv3.Client
+}
+
+func (w etcdWriter) Set(key, value string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := w.client.Put(ctx, key, value)
+	return err
+}
+
+func (w etcdWriter) Delete(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := w.client.Delete(ctx, key)
+	return err
+}
+
+func TestEtcdV3Contract(t *testing.T) {
+	endpoints := os.Getenv("ETCD_ENDPOINTS")
+	if endpoints == "" {
+		t.Skip("ETCD_ENDPOINTS is not set")
+	}
+	nodes := strings.Split(endpoints, ",")
+
+	client, err := clientv3.New(clientv3.Config{Endpoints: nodes, DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	config := &backends.EtcdConfig{Nodes: nodes, Version: 3}
+	b, err := config.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	contract.ContractTest(t, b.ReadWatcher, etcdWriter{client})
+}
+
+func TestVaultApprole(t *testing.T) {
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		t.Skip("VAULT_ADDR or VAULT_TOKEN is not set")
+	}
+
+	conf := vaultapi.DefaultConfig()
+	conf.Address = addr
+	client, err := vaultapi.NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(token)
+
+	// the dev server mounts a KV v2 engine at secret/, the test uses its own KV v1 engine
+	if err := client.Sys().Mount("remco-kv", &vaultapi.MountInput{Type: "kv", Options: map[string]string{"version": "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Sys().Unmount("remco-kv")
+	if err := client.Sys().EnableAuthWithOptions("remco-approle", &vaultapi.EnableAuthOptions{Type: "approle"}); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Sys().DisableAuth("remco-approle")
+
+	if err := client.Sys().PutPolicy("remco", `path "remco-kv/*" { capabilities = ["read", "list"] }`); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Sys().DeletePolicy("remco")
+	if _, err := client.Logical().Write("auth/remco-approle/role/remco", map[string]interface{}{
+		"token_policies": "remco",
+		"token_ttl":      "1h",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Logical().Write("remco-kv/app", map[string]interface{}{"value": "hello"}); err != nil {
+		t.Fatal(err)
+	}
+
+	roleID, err := client.Logical().Read("auth/remco-approle/role/remco/role-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretID, err := client.Logical().Write("auth/remco-approle/role/remco/secret-id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "remco-vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secretIDFile := dir + "/secret_id"
+	if err := ioutil.WriteFile(secretIDFile, []byte(secretID.Data["secret_id"].(string)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &backends.VaultConfig{
+		Node:         addr,
+		AuthMount:    "remco-approle",
+		RoleID:       roleID.Data["role_id"].(string),
+		SecretIDFile: secretIDFile,
+	}
+	b, err := config.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	values, err := b.ReadWatcher.GetValues([]string{"remco-kv/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["remco-kv/app"] != "hello" {
+		t.Errorf("unexpected values %v", values)
+	}
+}
+
+func TestVaultKVv2(t *testing.T) {
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		t.Skip("VAULT_ADDR or VAULT_TOKEN is not set")
+	}
+
+	conf := vaultapi.DefaultConfig()
+	conf.Address = addr
+	client, err := vaultapi.NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetToken(token)
+
+	// the dev server mounts a KV v2 engine at secret/
+	for _, user := range []string{"old", "remco"} {
+		if _, err := client.Logical().Write("secret/data/remco-test/db", map[string]interface{}{
+			"data": map[string]interface{}{"user": user},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer client.Logical().Delete("secret/metadata/remco-test/db")
+
+	for version, expected := range map[int]string{0: "remco", 1: "old"} {
+		config := &backends.VaultConfig{Node: addr, AuthToken: token, Version: version}
+		b, err := config.Connect()
+		if err != nil {
+			t.Fatal(err)
+		}
+		values, err := b.ReadWatcher.GetValues([]string{"/secret/remco-test"})
+		b.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if values["/secret/remco-test/db/user"] != expected {
+			t.Errorf("version %d: unexpected values %v", version, values)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/HeavyHorst/remco/pkg/backends"
+	contract "github.com/HeavyHorst/remco/pkg/backends/testing"
+)
+
+// fileWriter writes the keys as nested json objects to the file of the file backend.
+type fileWriter struct {
+	path string
+	data map[string]interface{}
+}
+
+func (w *fileWriter) Set(key, value string) error {
+	parts := strings.Split(strings.Trim(key, "/"), "/")
+	node := w.data
+	for _, p := range parts[:len(parts)-1] {
+		child, ok := node[p].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			node[p] = child
+		}
+		node = child
+	}
+	node[parts[len(parts)-1]] = value
+	return w.write()
+}
+
+func (w *fileWriter) Delete(key string) error {
+	parts := strings.Split(strings.Trim(key, "/"), "/")
+	node := w.data
+	for _, p := range parts[:len(parts)-1] {
+		child, ok := node[p].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		node = child
+	}
+	delete(node, parts[len(parts)-1])
+	return w.write()
+}
+
+func (w *fileWriter) write() error {
+	data, err := json.Marshal(w.data)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(w.path, data, 0644)
+}
+
+func TestFileContract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-contract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := &fileWriter{path: filepath.Join(dir, "data.json"), data: make(map[string]interface{})}
+	if err := w.write(); err != nil {
+		t.Fatal(err)
+	}
+
+	config := &backends.FileConfig{Filepath: w.path}
+	b, err := config.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	contract.ContractTest(t, b.ReadWatcher, w)
+}
+
+// envWriter sets the keys as environment variables.
+type envWriter struct{}
+
+func envKey(key string) string {
+	return strings.ToUpper(strings.Replace(strings.TrimPrefix(key, "/"), "/", "_", -1))
+}
+
+func (envWriter) Set(key, value string) error {
+	return os.Setenv(envKey(key), value)
+}
+
+func (envWriter) Delete(key string) error {
+	return os.Unsetenv(envKey(key))
+}
+
+func TestEnvContract(t *testing.T) {
+	config := &backends.EnvConfig{}
+	b, err := config.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	contract.ContractTest(t, b.ReadWatcher, envWriter{})
+}
@@ -0,0 +1,65 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+// The backend configs implement template.BackendDescriber,
+// the type is the name of the backend table in the configuration file.
+
+// BackendType returns "etcd".
+func (c *EtcdConfig) BackendType() string { return "etcd" }
+
+// BackendName returns "etcdv3" for the api version 3 and "etcd" otherwise.
+func (c *EtcdConfig) BackendName() string {
+	if c.Version == 3 {
+		return "etcdv3"
+	}
+	return "etcd"
+}
+
+// BackendType returns "consul".
+func (c *ConsulConfig) BackendType() string { return "consul" }
+
+// BackendName returns "consul".
+func (c *ConsulConfig) BackendName() string { return "consul" }
+
+// BackendType returns "env".
+func (c *EnvConfig) BackendType() string { return "env" }
+
+// BackendName returns "env".
+func (c *EnvConfig) BackendName() string { return "env" }
+
+// BackendType returns "file".
+func (c *FileConfig) BackendType() string { return "file" }
+
+// BackendName returns "file".
+func (c *FileConfig) BackendName() string { return "file" }
+
+// BackendType returns "mock".
+func (c *MockConfig) BackendType() string { return "mock" }
+
+// BackendName returns "mock".
+func (c *MockConfig) BackendName() string { return "mock" }
+
+// BackendType returns "redis".
+func (c *RedisConfig) BackendType() string { return "redis" }
+
+// BackendName returns "redis".
+func (c *RedisConfig) BackendName() string { return "redis" }
+
+// BackendType returns "vault".
+func (c *VaultConfig) BackendType() string { return "vault" }
+
+// BackendName returns "vault".
+func (c *VaultConfig) BackendName() string { return "vault" }
+
+// BackendType returns "zookeeper".
+func (c *ZookeeperConfig) BackendType() string { return "zookeeper" }
+
+// BackendName returns "zookeeper".
+func (c *ZookeeperConfig) BackendName() string { return "zookeeper" }
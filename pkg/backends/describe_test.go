@@ -0,0 +1,35 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/HeavyHorst/remco/pkg/template"
+)
+
+func TestBackendConnectors(t *testing.T) {
+	bc := template.BackendConnectors{
+		&EtcdConfig{Version: 3},
+		(*ConsulConfig)(nil),
+		&VaultConfig{},
+		&EnvConfig{},
+		(*FileConfig)(nil),
+	}
+	if n := bc.Count(); n != 3 {
+		t.Errorf("expected 3 configured backends, got %d", n)
+	}
+	if names := bc.Names(); !reflect.DeepEqual(names, []string{"etcdv3", "vault", "env"}) {
+		t.Errorf("unexpected names %v", names)
+	}
+	if types := bc.Types(); !reflect.DeepEqual(types, []string{"etcd", "vault", "env"}) {
+		t.Errorf("unexpected types %v", types)
+	}
+}
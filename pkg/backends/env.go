@@ -24,7 +24,7 @@ func (c *EnvConfig) Connect() (template.Backend, error) {
 	if c == nil {
 		return template.Backend{}, berr.ErrNilConfig
 	}
-	c.Backend.Name = "env"
+	c.Backend.Name = c.BackendName()
 
 	client, err := env.New()
 	if err != nil {
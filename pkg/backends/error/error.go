@@ -9,7 +9,11 @@
 // Package error describes errors in remco backends
 package error
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 // BackendError contains an error message and the name of the backend that produced the error
 type BackendError struct {
@@ -24,3 +28,26 @@ func (e BackendError) Error() string {
 
 // ErrNilConfig is returned if Connect is called on a nil Config
 var ErrNilConfig = errors.New("config is nil")
+
+// TimeoutError is returned if a backend operation takes longer than the configured timeout
+type TimeoutError struct {
+	Backend string
+	Timeout time.Duration
+}
+
+// Error is for the error interface
+func (e TimeoutError) Error() string {
+	return fmt.Sprintf("backend %s timed out after %s", e.Backend, e.Timeout)
+}
+
+// ConfigError is returned if the configuration of a backend is invalid
+type ConfigError struct {
+	Backend string
+	Field   string
+	Message string
+}
+
+// Error is for the error interface
+func (e ConfigError) Error() string {
+	return fmt.Sprintf("invalid %s backend configuration: %s: %s", e.Backend, e.Field, e.Message)
+}
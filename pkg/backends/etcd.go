@@ -9,11 +9,19 @@
 package backends
 
 import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
 	"github.com/HeavyHorst/easykv/etcd"
 	berr "github.com/HeavyHorst/remco/pkg/backends/error"
 	"github.com/HeavyHorst/remco/pkg/log"
 	"github.com/HeavyHorst/remco/pkg/template"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/pkg/transport"
 )
 
 // EtcdConfig represents the config for the etcd backend.
@@ -21,6 +29,10 @@ type EtcdConfig struct {
 	// Nodes is list of backend nodes.
 	Nodes []string
 
+	// FailoverEndpoints is a list of nodes of another etcd cluster that is used if the cluster of Nodes is unavailable.
+	// The same credentials, TLS settings and api-level are used for both clusters.
+	FailoverEndpoints []string `toml:"failover_endpoints"`
+
 	// The backend URI scheme (http or https).
 	// This is only used when the nodes are discovered via DNS srv records and the api level is 2.
 	//
@@ -45,6 +57,15 @@ type EtcdConfig struct {
 	// The password for the basic_auth authentication.
 	Password string
 
+	// A file with the password, e.g. a mounted kubernetes secret. It is read on every authentication.
+	PasswordFile string `toml:"password_file"`
+
+	// The TTL in seconds of the auth tokens of the etcd v3 cluster (--auth-token-ttl).
+	// The token is refreshed at 2/3 of the TTL.
+	//
+	// The default is 300.
+	TokenTTL int `toml:"token_ttl"`
+
 	// The etcd api-level to use (2 or 3).
 	//
 	// The default is 2.
@@ -63,11 +84,7 @@ func (c *EtcdConfig) Connect() (template.Backend, error) {
 		c.Version = 2
 	}
 
-	if c.Version == 3 {
-		c.Backend.Name = "etcdv3"
-	} else {
-		c.Backend.Name = "etcd"
-	}
+	c.Backend.Name = c.BackendName()
 
 	// No nodes are set but a SRVRecord is provided
 	if len(c.Nodes) == 0 && c.SRVRecord != "" {
@@ -92,10 +109,49 @@ func (c *EtcdConfig) Connect() (template.Backend, error) {
 		"nodes":   c.Nodes,
 	}).Info("set backend nodes")
 
-	client, err := etcd.New(c.Nodes,
+	client, err := c.newClient(c.Nodes)
+	if err != nil {
+		return c.Backend, err
+	}
+
+	if len(c.FailoverEndpoints) > 0 {
+		log.WithFields(logrus.Fields{
+			"backend": c.Backend.Name,
+			"nodes":   c.FailoverEndpoints,
+		}).Info("set backend failover nodes")
+
+		failover, err := c.newClient(c.FailoverEndpoints)
+		if err != nil {
+			client.Close()
+			return c.Backend, err
+		}
+		c.Backend.ReadWatcher = newEtcdFailoverClient(c.Backend.Name, client, failover, c.Nodes, c.FailoverEndpoints)
+		return c.Backend, nil
+	}
+
+	c.Backend.ReadWatcher = client
+	return c.Backend, nil
+}
+
+// newClient connects to the nodes. With api-level 3 and credentials the token of the client is kept valid.
+func (c *EtcdConfig) newClient(nodes []string) (easykv.ReadWatcher, error) {
+	if c.Version == 3 && c.Username != "" {
+		return newEtcdAuthClient(c.Backend.Name, func() (easykv.ReadWatcher, error) {
+			return c.connectNodes(nodes)
+		}, time.Duration(c.TokenTTL)*time.Second)
+	}
+	return c.connectNodes(nodes)
+}
+
+func (c *EtcdConfig) connectNodes(nodes []string) (easykv.ReadWatcher, error) {
+	password, err := readCredential(c.Password, c.PasswordFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read the password_file")
+	}
+	client, err := etcd.New(nodes,
 		etcd.WithBasicAuth(etcd.BasicAuthOptions{
 			Username: c.Username,
-			Password: c.Password,
+			Password: password,
 		}),
 		etcd.WithTLSOptions(etcd.TLSOptions{
 			ClientCert:   c.ClientCert,
@@ -103,11 +159,61 @@ func (c *EtcdConfig) Connect() (template.Backend, error) {
 			ClientCaKeys: c.ClientCaKeys,
 		}),
 		etcd.WithVersion(c.Version))
+	if err != nil || c.Version != 3 {
+		return client, err
+	}
+	kv, err := c.newEtcdv3API(nodes, password)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &etcdv3Client{ReadWatcher: client, kv: kv}, nil
+}
 
+// newEtcdv3API creates an etcd v3 client with the same settings as the easykv etcd client.
+func (c *EtcdConfig) newEtcdv3API(nodes []string, password string) (*clientv3.Client, error) {
+	cfg := clientv3.Config{
+		Endpoints:   nodes,
+		DialTimeout: 5 * time.Second,
+		Username:    c.Username,
+		Password:    password,
+	}
+	if c.ClientCaKeys != "" || (c.ClientCert != "" && c.ClientKey != "") {
+		tlsInfo := transport.TLSInfo{TrustedCAFile: c.ClientCaKeys}
+		if c.ClientCert != "" && c.ClientKey != "" {
+			tlsInfo.CertFile, tlsInfo.KeyFile = c.ClientCert, c.ClientKey
+		}
+		tlsConfig, err := tlsInfo.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		cfg.TLS = tlsConfig
+	}
+	return clientv3.New(cfg)
+}
+
+// etcdv3Client adds ListKeys to the easykv etcd v3 client.
+type etcdv3Client struct {
+	easykv.ReadWatcher
+	kv *clientv3.Client
+}
+
+// ListKeys returns the sorted keys below prefix, the values aren't transferred.
+func (c *etcdv3Client) ListKeys(ctx context.Context, prefix string) ([]string, error) {
+	resp, err := c.kv.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
 	if err != nil {
-		return c.Backend, err
+		return nil, err
+	}
+	keys := make([]string, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		keys = append(keys, string(kv.Key))
 	}
+	sort.Strings(keys)
+	return keys, nil
+}
 
-	c.Backend.ReadWatcher = client
-	return c.Backend, nil
+// Close closes both clients.
+func (c *etcdv3Client) Close() {
+	c.kv.Close()
+	c.ReadWatcher.Close()
 }
@@ -0,0 +1,286 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+	"github.com/HeavyHorst/remco/pkg/log"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// etcdDefaultTokenTTL is the default TTL of the auth tokens of an etcd cluster (--auth-token-ttl).
+	etcdDefaultTokenTTL = 5 * time.Minute
+
+	// etcdAuthMinBackoff is the delay before the first retry of a failed authentication.
+	etcdAuthMinBackoff = 1 * time.Second
+
+	// etcdAuthMaxBackoff is the maximum delay between two retries of a failed authentication.
+	etcdAuthMaxBackoff = 1 * time.Minute
+)
+
+// isEtcdAuthError reports whether err is an authentication error of etcd v3.
+func isEtcdAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "invalid auth token") ||
+		strings.Contains(msg, "authentication failed") ||
+		strings.Contains(msg, "revision of auth store is old")
+}
+
+// etcdAuthClient keeps the auth token of an etcd v3 client valid.
+//
+// The client authenticates with username and password when it connects. Since the token can't be
+// renewed, the client connects (and authenticates) again at 2/3 of the token TTL and replaces the
+// previous client; failed authentications are retried with an exponential backoff, until then
+// the previous client is used. A watch is re-established with the new client.
+// Reads and watches that fail with an auth error authenticate again right away.
+type etcdAuthClient struct {
+	name    string
+	connect func() (easykv.ReadWatcher, error)
+	refresh time.Duration
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	// authMu serializes the authentications.
+	authMu sync.Mutex
+
+	mu     sync.RWMutex
+	client easykv.ReadWatcher
+	// replaced is closed when client is replaced.
+	replaced chan struct{}
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// newEtcdAuthClient connects with connect and starts the refresh of the token.
+// connect must read the credentials on every call, so that a rotated password file is picked up.
+func newEtcdAuthClient(name string, connect func() (easykv.ReadWatcher, error), ttl time.Duration) (*etcdAuthClient, error) {
+	client, err := connect()
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		ttl = etcdDefaultTokenTTL
+	}
+	c := &etcdAuthClient{
+		name:       name,
+		connect:    connect,
+		refresh:    ttl * 2 / 3,
+		minBackoff: etcdAuthMinBackoff,
+		maxBackoff: etcdAuthMaxBackoff,
+		client:     client,
+		replaced:   make(chan struct{}),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go c.run()
+	return c, nil
+}
+
+func (c *etcdAuthClient) logger() *logrus.Entry {
+	return log.WithFields(logrus.Fields{
+		"backend": c.name,
+		"auth":    "password",
+	})
+}
+
+// current returns the client and a channel that is closed when it is replaced.
+func (c *etcdAuthClient) current() (easykv.ReadWatcher, <-chan struct{}) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.client, c.replaced
+}
+
+// replace sets the new client and closes the previous one.
+func (c *etcdAuthClient) replace(client easykv.ReadWatcher) {
+	c.mu.Lock()
+	old := c.client
+	c.client = client
+	close(c.replaced)
+	c.replaced = make(chan struct{})
+	c.mu.Unlock()
+	old.Close()
+}
+
+// reauthenticate replaces the client that was current when stale was returned by current with a new one.
+// Nothing happens if it has been replaced already. If retry is true, failed authentications are retried
+// with an exponential backoff until they succeed or the client is closed.
+// It returns false if the client couldn't be replaced.
+func (c *etcdAuthClient) reauthenticate(stale <-chan struct{}, retry bool) bool {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+
+	backoff := c.minBackoff
+	for {
+		select {
+		case <-stale:
+			return true
+		case <-c.stop:
+			return false
+		default:
+		}
+
+		client, err := c.connect()
+		if err == nil {
+			select {
+			case <-c.stop:
+				// closed during the authentication
+				client.Close()
+				return false
+			default:
+			}
+			c.replace(client)
+			c.logger().Debug("authenticated")
+			return true
+		}
+		if !retry {
+			c.logger().Error(err)
+			return false
+		}
+		c.logger().WithField("retry_in", backoff.String()).Error(err)
+		if !c.sleep(backoff) {
+			return false
+		}
+		backoff *= 2
+		if backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
+
+// sleep waits for d. It returns false if the client has been closed in the meantime.
+func (c *etcdAuthClient) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-c.stop:
+		return false
+	}
+}
+
+// run refreshes the token until the client is closed.
+func (c *etcdAuthClient) run() {
+	defer close(c.done)
+	for {
+		if !c.sleep(c.refresh) {
+			return
+		}
+		_, stale := c.current()
+		if !c.reauthenticate(stale, true) {
+			return
+		}
+	}
+}
+
+// GetValues queries etcd, it authenticates again once if the token has been rejected.
+func (c *etcdAuthClient) GetValues(keys []string) (map[string]string, error) {
+	return c.GetValuesContext(context.Background(), keys)
+}
+
+// GetValuesContext is GetValues, the queries are canceled with ctx if the client supports it.
+func (c *etcdAuthClient) GetValuesContext(ctx context.Context, keys []string) (map[string]string, error) {
+	client, stale := c.current()
+	values, err := getValuesContext(ctx, client, keys)
+	if err == nil || ctx.Err() != nil {
+		return values, err
+	}
+
+	select {
+	case <-stale:
+		// the client has been replaced (and closed) during the query
+	default:
+		if !isEtcdAuthError(err) || !c.reauthenticate(stale, false) {
+			return values, err
+		}
+	}
+	client, _ = c.current()
+	return getValuesContext(ctx, client, keys)
+}
+
+// ListKeys lists the keys below prefix with the current client, it authenticates again once if the token has been rejected.
+func (c *etcdAuthClient) ListKeys(ctx context.Context, prefix string) ([]string, error) {
+	client, stale := c.current()
+	keys, err := ListKeys(ctx, client, prefix)
+	if err == nil || ctx.Err() != nil {
+		return keys, err
+	}
+
+	select {
+	case <-stale:
+		// the client has been replaced (and closed) during the query
+	default:
+		if !isEtcdAuthError(err) || !c.reauthenticate(stale, false) {
+			return keys, err
+		}
+	}
+	client, _ = c.current()
+	return ListKeys(ctx, client, prefix)
+}
+
+// WatchPrefix watches the prefix with the current client.
+// The watch is re-established with the new client if the client is replaced,
+// or if the watch fails with an auth error once the client authenticated again.
+func (c *etcdAuthClient) WatchPrefix(ctx context.Context, prefix string, opts ...easykv.WatchOption) (uint64, error) {
+	for {
+		client, replaced := c.current()
+		wctx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-replaced:
+				cancel()
+			case <-wctx.Done():
+			}
+		}()
+		index, err := client.WatchPrefix(wctx, prefix, opts...)
+		cancel()
+		if ctx.Err() != nil {
+			return index, easykv.ErrWatchCanceled
+		}
+
+		select {
+		case <-replaced:
+			c.logger().Debug("re-establishing the watch with the new token")
+			continue
+		default:
+		}
+		if isEtcdAuthError(err) {
+			c.logger().Warning("the watch has been rejected, re-establishing it with a new token: ", err)
+			if c.reauthenticate(replaced, true) {
+				continue
+			}
+		}
+		return index, err
+	}
+}
+
+// Close stops the refresh of the token and closes the client.
+func (c *etcdAuthClient) Close() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+	<-c.done
+	// wait for an authentication in progress, it doesn't replace the client anymore
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	client, _ := c.current()
+	client.Close()
+}
@@ -0,0 +1,158 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+)
+
+var errEtcdInvalidToken = errors.New("etcdserver: invalid auth token")
+
+// expiredEtcd rejects the reads with an invalid token.
+type expiredEtcd struct {
+	*fakeEtcd
+}
+
+func (e expiredEtcd) GetValues(keys []string) (map[string]string, error) {
+	return nil, errEtcdInvalidToken
+}
+
+// fakeEtcdAuth hands out a new fakeEtcd on every connect, the connects fail while down is set.
+// The reads of the first client are rejected if expired is set.
+type fakeEtcdAuth struct {
+	mu      sync.Mutex
+	clients []*fakeEtcd
+	down    bool
+	expired bool
+}
+
+func (f *fakeEtcdAuth) connect() (easykv.ReadWatcher, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return nil, errors.New("etcdserver: authentication failed, invalid user ID or password")
+	}
+	c := &fakeEtcd{data: map[string]string{"/key": "value"}}
+	f.clients = append(f.clients, c)
+	if f.expired && len(f.clients) == 1 {
+		return expiredEtcd{c}, nil
+	}
+	return c, nil
+}
+
+func (f *fakeEtcdAuth) connects() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.clients)
+}
+
+func (f *fakeEtcdAuth) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func newTestAuthClient(t *testing.T, f *fakeEtcdAuth, ttl time.Duration) *etcdAuthClient {
+	c, err := newEtcdAuthClient("etcdv3", f.connect, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the backoff is read under authMu
+	c.authMu.Lock()
+	c.minBackoff = 10 * time.Millisecond
+	c.maxBackoff = 10 * time.Millisecond
+	c.authMu.Unlock()
+	return c
+}
+
+func TestEtcdAuthRefresh(t *testing.T) {
+	f := &fakeEtcdAuth{}
+	c := newTestAuthClient(t, f, 30*time.Millisecond)
+	defer c.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for f.connects() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("the token hasn't been refreshed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// failed authentications are retried, the previous client is used in the meantime
+	f.setDown(true)
+	connects := f.connects()
+	time.Sleep(100 * time.Millisecond)
+	if values, err := c.GetValues([]string{"/"}); err != nil || values["/key"] != "value" {
+		t.Errorf("expected the values of the previous client, got %v, %v", values, err)
+	}
+	f.setDown(false)
+	for f.connects() == connects {
+		if time.Now().After(deadline) {
+			t.Fatal("the authentication hasn't been retried")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestEtcdAuthGetValuesInvalidToken(t *testing.T) {
+	f := &fakeEtcdAuth{expired: true}
+	c := newTestAuthClient(t, f, time.Hour)
+	defer c.Close()
+
+	// the rejected read authenticates again and is retried with the new client
+	values, err := c.GetValues([]string{"/"})
+	if err != nil || values["/key"] != "value" || f.connects() != 2 {
+		t.Errorf("expected the values of the new client, got %v, %v (%d connects)", values, err, f.connects())
+	}
+
+	// other errors are returned as they are
+	f.clients[1].setDown(true)
+	if _, err := c.GetValues([]string{"/"}); err == nil || f.connects() != 2 {
+		t.Errorf("expected the error of the client, got %v (%d connects)", err, f.connects())
+	}
+}
+
+func TestEtcdAuthWatchPrefix(t *testing.T) {
+	f := &fakeEtcdAuth{}
+	c := newTestAuthClient(t, f, time.Hour)
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the watch is rejected with an auth error and re-established with a new token
+	f.clients[0].watchErr = errEtcdInvalidToken
+	result := make(chan error, 1)
+	go func() {
+		_, err := c.WatchPrefix(ctx, "/")
+		result <- err
+	}()
+	for f.connects() < 2 {
+		if ctx.Err() != nil {
+			t.Fatal("the watch hasn't authenticated again")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// the refreshed client is watched until the context is canceled
+	select {
+	case err := <-result:
+		t.Fatalf("the watch should have been re-established, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	cancel()
+	if err := <-result; err != easykv.ErrWatchCanceled {
+		t.Errorf("expected ErrWatchCanceled, got %v", err)
+	}
+}
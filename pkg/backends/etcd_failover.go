@@ -0,0 +1,263 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+	"github.com/HeavyHorst/remco/pkg/log"
+	"github.com/armon/go-metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// etcdFailoverProbeInterval is the interval in which the reachability of the primary cluster is checked while the failover cluster is active.
+	etcdFailoverProbeInterval = 10 * time.Second
+
+	// etcdFailoverProbeTimeout is the maximum time a probe of the primary cluster may take.
+	etcdFailoverProbeTimeout = 5 * time.Second
+)
+
+// The endpoint sets of an etcdFailoverClient.
+const (
+	etcdPrimary = iota
+	etcdFailover
+)
+
+var etcdEndpointSetNames = [...]string{"primary", "failover"}
+
+// etcdFailoverClient combines the clients of a primary and a failover etcd cluster.
+//
+// GetValues queries the active cluster and falls back to the other cluster if it fails.
+// The failover cluster stays active until a probe of the primary cluster succeeds: WatchPrefix
+// and, while the failover cluster is active, GetValues probe the primary cluster every probeInterval.
+// WatchPrefix watches the active cluster.
+// When the active cluster changes, WatchPrefix returns immediately, so that the templates are
+// rendered with the values of the new cluster, changes during the outage are picked up this way.
+type etcdFailoverClient struct {
+	name      string
+	clients   [2]easykv.ReadWatcher
+	endpoints [2][]string
+
+	probeInterval time.Duration
+	probeTimeout  time.Duration
+
+	mu     sync.Mutex
+	active int
+	// probed is the time of the last probe of the primary cluster by GetValues, or of the switch to the failover cluster
+	probed time.Time
+}
+
+func newEtcdFailoverClient(name string, primary, failover easykv.ReadWatcher, primaryEndpoints, failoverEndpoints []string) *etcdFailoverClient {
+	c := &etcdFailoverClient{
+		name:          name,
+		clients:       [2]easykv.ReadWatcher{primary, failover},
+		endpoints:     [2][]string{primaryEndpoints, failoverEndpoints},
+		probeInterval: etcdFailoverProbeInterval,
+		probeTimeout:  etcdFailoverProbeTimeout,
+	}
+	c.setGauge(etcdPrimary)
+	return c
+}
+
+// Active returns the name of the active endpoint set ("primary" or "failover").
+func (c *etcdFailoverClient) Active() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return etcdEndpointSetNames[c.active]
+}
+
+func (c *etcdFailoverClient) getActive() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active
+}
+
+// setActive switches to the endpoint set. It returns true if the active endpoint set changed.
+func (c *etcdFailoverClient) setActive(set int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active == set {
+		return false
+	}
+	c.active = set
+	if set == etcdFailover {
+		c.probed = time.Now()
+	}
+	c.setGauge(set)
+	log.WithFields(logrus.Fields{
+		"backend":   c.name,
+		"endpoints": c.endpoints[set],
+	}).Warning("switched to the " + etcdEndpointSetNames[set] + " endpoints")
+	return true
+}
+
+func (c *etcdFailoverClient) setGauge(set int) {
+	labels := []metrics.Label{{Name: "name", Value: c.name}}
+	metrics.SetGaugeWithLabels([]string{"backends", "failover_active"}, float32(set), labels)
+}
+
+// probeDue reports whether GetValues has to probe the primary cluster, that is whether the last probe
+// (or the switch to the failover cluster) is at least probeInterval ago.
+func (c *etcdFailoverClient) probeDue() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.probed) < c.probeInterval {
+		return false
+	}
+	c.probed = time.Now()
+	return true
+}
+
+// query calls read with the client of the active cluster and falls back to the other cluster if it fails.
+// While the failover cluster is active, the primary cluster is probed with keys every probeInterval
+// and activated again once it answers.
+func (c *etcdFailoverClient) query(ctx context.Context, keys []string, read func(easykv.ReadWatcher) error) error {
+	active := c.getActive()
+	if active == etcdFailover && c.probeDue() && c.probe(ctx, keys) {
+		c.setActive(etcdPrimary)
+		active = etcdPrimary
+	}
+	err := read(c.clients[active])
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	log.WithFields(logrus.Fields{
+		"backend":   c.name,
+		"endpoints": c.endpoints[active],
+	}).Error(etcdEndpointSetNames[active]+" endpoints failed: ", err)
+	other := etcdFailover - active
+	if err := read(c.clients[other]); err != nil {
+		return err
+	}
+	c.setActive(other)
+	return nil
+}
+
+// GetValues queries the active cluster and falls back to the other cluster if it fails.
+func (c *etcdFailoverClient) GetValues(keys []string) (map[string]string, error) {
+	return c.GetValuesContext(context.Background(), keys)
+}
+
+// GetValuesContext is GetValues, the queries are canceled with ctx if the clients support it.
+func (c *etcdFailoverClient) GetValuesContext(ctx context.Context, keys []string) (map[string]string, error) {
+	var values map[string]string
+	err := c.query(ctx, keys, func(client easykv.ReadWatcher) error {
+		var err error
+		values, err = getValuesContext(ctx, client, keys)
+		return err
+	})
+	return values, err
+}
+
+// ListKeys lists the keys below prefix like GetValuesContext reads the values.
+func (c *etcdFailoverClient) ListKeys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := c.query(ctx, []string{prefix}, func(client easykv.ReadWatcher) error {
+		var err error
+		keys, err = ListKeys(ctx, client, prefix)
+		return err
+	})
+	return keys, err
+}
+
+// probe reports whether the primary cluster answers within the probe timeout.
+func (c *etcdFailoverClient) probe(ctx context.Context, keys []string) bool {
+	// GetValues doesn't support cancelation
+	// the result channel is buffered so that the goroutine can always finish
+	result := make(chan error, 1)
+	go func() {
+		_, err := c.clients[etcdPrimary].GetValues(keys)
+		result <- err
+	}()
+
+	t := time.NewTimer(c.probeTimeout)
+	defer t.Stop()
+	select {
+	case err := <-result:
+		return err == nil
+	case <-t.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// WatchPrefix watches the prefix on the active cluster.
+//
+// The primary cluster is probed every probeInterval. WatchPrefix returns without an error
+// if the primary cluster becomes unavailable (the failover cluster is activated) or
+// available again (the primary cluster is activated). An error is only returned if
+// the failover cluster fails.
+func (c *etcdFailoverClient) WatchPrefix(ctx context.Context, prefix string, opts ...easykv.WatchOption) (uint64, error) {
+	var options easykv.WatchOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	active := c.getActive()
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		index uint64
+		err   error
+	}
+	watch := make(chan result, 1)
+	go func() {
+		index, err := c.clients[active].WatchPrefix(wctx, prefix, opts...)
+		watch <- result{index, err}
+	}()
+
+	ticker := time.NewTicker(c.probeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case r := <-watch:
+			if r.err == nil || r.err == easykv.ErrWatchCanceled || active == etcdFailover {
+				return r.index, r.err
+			}
+			// the primary cluster failed, render the templates with the values of the failover cluster
+			log.WithFields(logrus.Fields{
+				"backend":   c.name,
+				"endpoints": c.endpoints[etcdPrimary],
+			}).Error("watching the primary endpoints failed: ", r.err)
+			c.setActive(etcdFailover)
+			return options.WaitIndex, nil
+		case <-ticker.C:
+			set := etcdFailover
+			if c.probe(wctx, options.Keys) {
+				set = etcdPrimary
+			}
+			if ctx.Err() != nil {
+				return options.WaitIndex, easykv.ErrWatchCanceled
+			}
+			if set != active {
+				c.setActive(set)
+				return options.WaitIndex, nil
+			}
+		case <-ctx.Done():
+			return options.WaitIndex, easykv.ErrWatchCanceled
+		}
+	}
+}
+
+// Close closes the clients of both clusters.
+func (c *etcdFailoverClient) Close() {
+	for _, client := range c.clients {
+		client.Close()
+	}
+}
@@ -0,0 +1,176 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+)
+
+// fakeEtcd serves data unless it is down. WatchPrefix blocks until the context is canceled or watchErr is set.
+type fakeEtcd struct {
+	mu       sync.Mutex
+	data     map[string]string
+	down     bool
+	watchErr error
+}
+
+func (f *fakeEtcd) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *fakeEtcd) GetValues(keys []string) (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return nil, errors.New("connection refused")
+	}
+	return f.data, nil
+}
+
+func (f *fakeEtcd) WatchPrefix(ctx context.Context, prefix string, opts ...easykv.WatchOption) (uint64, error) {
+	if f.watchErr != nil {
+		return 0, f.watchErr
+	}
+	<-ctx.Done()
+	return 0, easykv.ErrWatchCanceled
+}
+
+func (f *fakeEtcd) Close() {}
+
+func newTestFailoverClient(primary, failover *fakeEtcd) *etcdFailoverClient {
+	c := newEtcdFailoverClient("etcd", primary, failover, []string{"http://a:2379"}, []string{"http://b:2379"})
+	c.probeInterval = 10 * time.Millisecond
+	c.probeTimeout = time.Second
+	return c
+}
+
+func TestEtcdFailoverGetValues(t *testing.T) {
+	primary := &fakeEtcd{data: map[string]string{"/key": "a"}}
+	failover := &fakeEtcd{data: map[string]string{"/key": "b"}}
+	c := newTestFailoverClient(primary, failover)
+
+	values, err := c.GetValues([]string{"/"})
+	if err != nil || values["/key"] != "a" || c.Active() != "primary" {
+		t.Errorf("expected the values of the primary cluster, got %v, %v (%s)", values, err, c.Active())
+	}
+
+	primary.setDown(true)
+	values, err = c.GetValues([]string{"/"})
+	if err != nil || values["/key"] != "b" || c.Active() != "failover" {
+		t.Errorf("expected the values of the failover cluster, got %v, %v (%s)", values, err, c.Active())
+	}
+
+	failover.setDown(true)
+	if _, err := c.GetValues([]string{"/"}); err == nil {
+		t.Error("expected an error if both clusters are down")
+	}
+
+	primary.setDown(false)
+	values, err = c.GetValues([]string{"/"})
+	if err != nil || values["/key"] != "a" || c.Active() != "primary" {
+		t.Errorf("expected the values of the primary cluster, got %v, %v (%s)", values, err, c.Active())
+	}
+}
+
+func TestEtcdFailoverGetValuesProbe(t *testing.T) {
+	primary := &fakeEtcd{data: map[string]string{"/key": "a"}}
+	failover := &fakeEtcd{data: map[string]string{"/key": "b"}}
+	c := newTestFailoverClient(primary, failover)
+	c.probeInterval = 100 * time.Millisecond
+
+	primary.setDown(true)
+	if _, err := c.GetValues([]string{"/"}); err != nil || c.Active() != "failover" {
+		t.Fatalf("expected a switch to the failover cluster, got %v (%s)", err, c.Active())
+	}
+
+	// the failover cluster stays active until the primary cluster is probed
+	primary.setDown(false)
+	values, err := c.GetValues([]string{"/"})
+	if err != nil || values["/key"] != "b" || c.Active() != "failover" {
+		t.Errorf("expected the values of the failover cluster, got %v, %v (%s)", values, err, c.Active())
+	}
+
+	time.Sleep(c.probeInterval)
+	values, err = c.GetValues([]string{"/"})
+	if err != nil || values["/key"] != "a" || c.Active() != "primary" {
+		t.Errorf("expected the values of the primary cluster, got %v, %v (%s)", values, err, c.Active())
+	}
+}
+
+func TestEtcdFailoverWatchPrefix(t *testing.T) {
+	primary := &fakeEtcd{}
+	failover := &fakeEtcd{}
+	c := newTestFailoverClient(primary, failover)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the primary cluster becomes unavailable
+	primary.setDown(true)
+	index, err := c.WatchPrefix(ctx, "/", easykv.WithWaitIndex(7))
+	if err != nil || index != 7 || c.Active() != "failover" {
+		t.Errorf("expected a switch to the failover cluster, got %d, %v (%s)", index, err, c.Active())
+	}
+
+	// the primary cluster is available again
+	primary.setDown(false)
+	index, err = c.WatchPrefix(ctx, "/", easykv.WithWaitIndex(7))
+	if err != nil || index != 7 || c.Active() != "primary" {
+		t.Errorf("expected a switch to the primary cluster, got %d, %v (%s)", index, err, c.Active())
+	}
+
+	// the watch of the primary cluster fails
+	primary.watchErr = errors.New("watch failed")
+	if _, err := c.WatchPrefix(ctx, "/"); err != nil || c.Active() != "failover" {
+		t.Errorf("expected a switch to the failover cluster, got %v (%s)", err, c.Active())
+	}
+
+	// the watch of the failover cluster fails
+	primary.setDown(true)
+	failover.watchErr = errors.New("watch failed")
+	if _, err := c.WatchPrefix(ctx, "/"); err == nil {
+		t.Error("expected an error if the failover cluster fails")
+	}
+}
+
+func TestEtcdFailoverWatchPrefixCanceled(t *testing.T) {
+	c := newTestFailoverClient(&fakeEtcd{}, &fakeEtcd{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := c.WatchPrefix(ctx, "/"); err != easykv.ErrWatchCanceled {
+		t.Errorf("expected ErrWatchCanceled, got %v", err)
+	}
+}
+
+func TestEtcdFailoverListKeys(t *testing.T) {
+	primary := &fakeEtcd{data: map[string]string{"/a": "1", "/b": "2"}}
+	failover := &fakeEtcd{data: map[string]string{"/a": "1"}}
+	c := newTestFailoverClient(primary, failover)
+
+	keys, err := ListKeys(context.Background(), c, "/")
+	if err != nil || len(keys) != 2 || c.Active() != "primary" {
+		t.Errorf("expected the keys of the primary cluster, got %v, %v (%s)", keys, err, c.Active())
+	}
+
+	primary.setDown(true)
+	keys, err = ListKeys(context.Background(), c, "/")
+	if err != nil || len(keys) != 1 || c.Active() != "failover" {
+		t.Errorf("expected the keys of the failover cluster, got %v, %v (%s)", keys, err, c.Active())
+	}
+}
@@ -9,6 +9,12 @@
 package backends
 
 import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
 	"github.com/HeavyHorst/easykv/file"
 	berr "github.com/HeavyHorst/remco/pkg/backends/error"
 	"github.com/HeavyHorst/remco/pkg/log"
@@ -33,7 +39,7 @@ func (c *FileConfig) Connect() (template.Backend, error) {
 		return template.Backend{}, berr.ErrNilConfig
 	}
 
-	c.Backend.Name = "file"
+	c.Backend.Name = c.BackendName()
 	log.WithFields(logrus.Fields{
 		"backend":  c.Backend.Name,
 		"filepath": c.Filepath,
@@ -43,6 +49,63 @@ func (c *FileConfig) Connect() (template.Backend, error) {
 	if err != nil {
 		return c.Backend, err
 	}
-	c.Backend.ReadWatcher = client
+	c.Backend.ReadWatcher = &fileClient{Client: client, path: c.Filepath}
 	return c.Backend, nil
 }
+
+// fileWatchDebounce combines the events of a file that is written in several steps into one change.
+const fileWatchDebounce = 100 * time.Millisecond
+
+// fileClient watches local files with a template.FileWatcher, so that atomic renames over the file
+// and files that are deleted and recreated are noticed. The watcher runs until the client is closed,
+// changes between two calls of WatchPrefix aren't missed.
+type fileClient struct {
+	*file.Client
+	path string
+
+	once    sync.Once
+	watcher *template.FileWatcher
+	err     error
+	cancel  context.CancelFunc
+}
+
+// WatchPrefix returns once the file changed. Remote files aren't supported.
+func (c *fileClient) WatchPrefix(ctx context.Context, prefix string, opts ...easykv.WatchOption) (uint64, error) {
+	if strings.HasPrefix(c.path, "http://") || strings.HasPrefix(c.path, "https://") {
+		return c.Client.WatchPrefix(ctx, prefix, opts...)
+	}
+	c.once.Do(c.startWatcher)
+	if c.err != nil {
+		return 0, c.err
+	}
+	select {
+	case <-c.watcher.Events():
+		return 1, nil
+	case <-ctx.Done():
+		return 0, easykv.ErrWatchCanceled
+	}
+}
+
+// startWatcher starts the watcher of the file.
+func (c *fileClient) startWatcher() {
+	w, err := template.NewFileWatcher(fileWatchDebounce, log.WithFields(logrus.Fields{"filepath": c.path}))
+	if err == nil {
+		err = w.Add(c.path)
+	}
+	if err != nil {
+		c.err = err
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.watcher, c.cancel = w, cancel
+	go w.Run(ctx)
+}
+
+// Close stops the watcher of the file.
+func (c *fileClient) Close() {
+	c.once.Do(func() {})
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.Client.Close()
+}
@@ -0,0 +1,72 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileWatchAtomicRename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "values.yml")
+	if err := ioutil.WriteFile(path, []byte("db: localhost\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := &FileConfig{Filepath: path}
+	b, err := config.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	replace := func(content string) {
+		tmp := filepath.Join(dir, ".values.yml.tmp")
+		if err := ioutil.WriteFile(tmp, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	watch := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		_, err := b.WatchPrefix(ctx, "/")
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- watch() }()
+	time.Sleep(200 * time.Millisecond)
+	replace("db: db.service.consul\n")
+	if err := <-done; err != nil {
+		t.Fatalf("the rename over the file should be noticed: %v", err)
+	}
+
+	// a change between two watches isn't missed
+	replace("db: 10.0.0.5\n")
+	time.Sleep(300 * time.Millisecond)
+	if err := watch(); err != nil {
+		t.Fatalf("the change before the watch should be noticed: %v", err)
+	}
+	values, err := b.GetValues([]string{"/"})
+	if err != nil || values["/db"] != "10.0.0.5" {
+		t.Errorf("unexpected values %v (%v)", values, err)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"net/http"
+	"time"
+
+	berr "github.com/HeavyHorst/remco/pkg/backends/error"
+)
+
+// The defaults of the HTTP connection pool.
+const (
+	defaultMaxIdleConns        = 100
+	defaultMaxIdleConnsPerHost = 10
+	defaultIdleConnTimeout     = 90 * time.Second
+)
+
+// HTTPPoolConfig configures the connection pool of the HTTP client of the consul and vault backends.
+// The connections are kept open between the GetValues calls, so that a call doesn't pay for a new TCP and TLS handshake.
+type HTTPPoolConfig struct {
+	// MaxIdleConns is the maximum number of idle connections. The default is 100.
+	MaxIdleConns int `toml:"max_idle_conns"`
+
+	// MaxIdleConnsPerHost is the maximum number of idle connections to a single node. The default is 10.
+	MaxIdleConnsPerHost int `toml:"max_idle_conns_per_host"`
+
+	// IdleConnTimeout is the time in seconds an idle connection is kept open. The default is 90.
+	IdleConnTimeout int `toml:"idle_conn_timeout"`
+
+	// DisableKeepAlives opens a new connection for every request.
+	DisableKeepAlives bool `toml:"disable_keep_alives"`
+}
+
+// apply configures the connection pool of t.
+func (c HTTPPoolConfig) apply(t *http.Transport) {
+	t.MaxIdleConns = defaultMaxIdleConns
+	if c.MaxIdleConns > 0 {
+		t.MaxIdleConns = c.MaxIdleConns
+	}
+	t.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	if c.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	}
+	t.IdleConnTimeout = defaultIdleConnTimeout
+	if c.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = time.Duration(c.IdleConnTimeout) * time.Second
+	}
+	t.DisableKeepAlives = c.DisableKeepAlives
+}
+
+// validate checks that the pool settings are not negative.
+func (c HTTPPoolConfig) validate(backend string) error {
+	switch {
+	case c.MaxIdleConns < 0:
+		return berr.ConfigError{Backend: backend, Field: "max_idle_conns", Message: "must not be negative"}
+	case c.MaxIdleConnsPerHost < 0:
+		return berr.ConfigError{Backend: backend, Field: "max_idle_conns_per_host", Message: "must not be negative"}
+	case c.IdleConnTimeout < 0:
+		return berr.ConfigError{Backend: backend, Field: "idle_conn_timeout", Message: "must not be negative"}
+	}
+	return nil
+}
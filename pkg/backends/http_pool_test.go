@@ -0,0 +1,107 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv/consul"
+)
+
+func TestHTTPPoolConfigApply(t *testing.T) {
+	tr := &http.Transport{DisableKeepAlives: true}
+	HTTPPoolConfig{}.apply(tr)
+	if tr.MaxIdleConns != 100 || tr.MaxIdleConnsPerHost != 10 || tr.IdleConnTimeout != 90*time.Second || tr.DisableKeepAlives {
+		t.Errorf("unexpected defaults %d %d %s %t", tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.IdleConnTimeout, tr.DisableKeepAlives)
+	}
+
+	HTTPPoolConfig{MaxIdleConns: 5, MaxIdleConnsPerHost: 2, IdleConnTimeout: 10, DisableKeepAlives: true}.apply(tr)
+	if tr.MaxIdleConns != 5 || tr.MaxIdleConnsPerHost != 2 || tr.IdleConnTimeout != 10*time.Second || !tr.DisableKeepAlives {
+		t.Errorf("unexpected settings %d %d %s %t", tr.MaxIdleConns, tr.MaxIdleConnsPerHost, tr.IdleConnTimeout, tr.DisableKeepAlives)
+	}
+}
+
+func TestVaultAPIConfigHTTP2(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+	ca := writeServerCA(t, server)
+	defer os.Remove(ca)
+
+	conf, err := newVaultAPIConfig(server.URL, "", "", ca, HTTPPoolConfig{MaxIdleConnsPerHost: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tr := conf.HttpClient.Transport.(*http.Transport); tr.MaxIdleConnsPerHost != 3 {
+		t.Errorf("the pool settings should be applied, got %d idle connections per host", tr.MaxIdleConnsPerHost)
+	}
+	resp, err := conf.HttpClient.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("expected HTTP/2, got %s", resp.Proto)
+	}
+}
+
+// writeServerCA writes the certificate of the TLS test server to a temporary file.
+func writeServerCA(t testing.TB, server *httptest.Server) string {
+	f, err := ioutil.TempFile("", "remco-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+// benchmarkConsulGetValues measures 100 consecutive GetValues calls against a TLS consul server.
+func benchmarkConsulGetValues(b *testing.B, pool HTTPPoolConfig) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Consul-Index", "1")
+		w.Write([]byte(`[{"Key":"app/port","Value":"ODA4MA=="}]`))
+	}))
+	server.StartTLS()
+	defer server.Close()
+	ca := writeServerCA(b, server)
+	defer os.Remove(ca)
+
+	apiClient, err := newConsulAPI([]string{strings.TrimPrefix(server.URL, "https://")}, "https", consul.TLSOptions{ClientCaKeys: ca}, pool)
+	if err != nil {
+		b.Fatal(err)
+	}
+	reader := consulKVReader{apiClient.KV()}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 100; j++ {
+			if _, err := reader.GetValues([]string{"/app"}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkConsulGetValuesPooled(b *testing.B) { benchmarkConsulGetValues(b, HTTPPoolConfig{}) }
+func BenchmarkConsulGetValuesWithoutKeepAlives(b *testing.B) {
+	benchmarkConsulGetValues(b, HTTPPoolConfig{DisableKeepAlives: true})
+}
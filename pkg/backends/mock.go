@@ -25,7 +25,7 @@ func (c *MockConfig) Connect() (template.Backend, error) {
 	if c == nil {
 		return template.Backend{}, berr.ErrNilConfig
 	}
-	c.Backend.Name = "mock"
+	c.Backend.Name = c.BackendName()
 	client, err := mock.New(c.Error, make(map[string]string))
 	if err != nil {
 		return c.Backend, err
@@ -33,13 +33,39 @@ type Plugin struct {
 	template.Backend
 }
 
+// Validate checks the configuration without starting the plugin.
+func (p *Plugin) Validate() error {
+	if p.Path == "" {
+		return berr.ConfigError{Backend: "plugin", Field: "path", Message: "required"}
+	}
+	if p.Schedule != "" {
+		if p.Interval > 0 {
+			return berr.ConfigError{Backend: "plugin", Field: "schedule", Message: "can't be combined with interval"}
+		}
+		if _, err := template.ParseSchedule(p.Schedule); err != nil {
+			return berr.ConfigError{Backend: "plugin", Field: "schedule", Message: err.Error()}
+		}
+	}
+	return nil
+}
+
+// BackendType returns "plugin".
+func (p *Plugin) BackendType() string {
+	return "plugin"
+}
+
+// BackendName returns the file name of the plugin.
+func (p *Plugin) BackendName() string {
+	return path.Base(p.Path)
+}
+
 // Connect creates the connection to the plugin and initializes it with the stored configuration.
 func (p *Plugin) Connect() (template.Backend, error) {
 	if p == nil {
 		return template.Backend{}, berr.ErrNilConfig
 	}
 
-	p.Backend.Name = path.Base(p.Path)
+	p.Backend.Name = p.BackendName()
 
 	client, err := pie.StartProviderCodec(jsonrpc.NewClientCodec, os.Stderr, p.Path)
 	if err != nil {
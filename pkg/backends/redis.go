@@ -39,7 +39,7 @@ func (c *RedisConfig) Connect() (template.Backend, error) {
 		return template.Backend{}, berr.ErrNilConfig
 	}
 
-	c.Backend.Name = "redis"
+	c.Backend.Name = c.BackendName()
 
 	// No nodes are set but a SRVRecord is provided
 	if len(c.Nodes) == 0 && c.SRVRecord != "" {
@@ -0,0 +1,177 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// Package testing provides a test suite that verifies the StoreClient contract of a backend.
+package testing
+
+import (
+	"context"
+	gotesting "testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+	"github.com/HeavyHorst/remco/pkg/backends"
+)
+
+// Prefix is the key prefix used by ContractTest.
+// All keys that are written by the suite are located below Prefix or OutsidePrefix.
+const (
+	Prefix        = "/remcocontract"
+	OutsidePrefix = "/remcooutside"
+)
+
+// WatchTimeout is the maximum amount of time a WatchPrefix call may take to report a change.
+var WatchTimeout = 10 * time.Second
+
+// KVWriter modifies the keys of the backend under test.
+// The keys are absolute paths like "/remcocontract/a/b",
+// the writer has to translate them to the key format of the backend.
+type KVWriter interface {
+	Set(key, value string) error
+	Delete(key string) error
+}
+
+// ContractTest verifies that client fulfills the StoreClient contract.
+// w is used to create, update and delete keys of the backend.
+func ContractTest(t *gotesting.T, client backends.StoreClient, w KVWriter) {
+	t.Run("Create", func(t *gotesting.T) {
+		set(t, w, Prefix+"/a", "1")
+		set(t, w, Prefix+"/b/c", "2")
+		expectValues(t, client, map[string]string{
+			Prefix + "/a":   "1",
+			Prefix + "/b/c": "2",
+		})
+	})
+
+	t.Run("Update", func(t *gotesting.T) {
+		set(t, w, Prefix+"/a", "3")
+		expectValues(t, client, map[string]string{
+			Prefix + "/a":   "3",
+			Prefix + "/b/c": "2",
+		})
+	})
+
+	t.Run("Delete", func(t *gotesting.T) {
+		del(t, w, Prefix+"/a")
+		expectValues(t, client, map[string]string{
+			Prefix + "/b/c": "2",
+		})
+	})
+
+	t.Run("PrefixScope", func(t *gotesting.T) {
+		set(t, w, OutsidePrefix+"/x", "4")
+		defer del(t, w, OutsidePrefix+"/x")
+		expectValues(t, client, map[string]string{
+			Prefix + "/b/c": "2",
+		})
+
+		values, err := client.GetValues([]string{Prefix + "/missing"})
+		if err != nil {
+			t.Fatalf("GetValues of a missing prefix should not fail: %v", err)
+		}
+		if len(values) != 0 {
+			t.Errorf("GetValues of a missing prefix should be empty, got %v", values)
+		}
+	})
+
+	t.Run("ListKeys", func(t *gotesting.T) {
+		set(t, w, OutsidePrefix+"/x", "4")
+		defer del(t, w, OutsidePrefix+"/x")
+		keys, err := backends.ListKeys(context.Background(), client, Prefix)
+		if err != nil {
+			t.Fatalf("ListKeys failed: %v", err)
+		}
+		if len(keys) != 1 || keys[0] != Prefix+"/b/c" {
+			t.Errorf("ListKeys should return [%s], got %v", Prefix+"/b/c", keys)
+		}
+	})
+
+	t.Run("Watch", func(t *gotesting.T) {
+		watchContract(t, client, w)
+	})
+
+	del(t, w, Prefix+"/b/c")
+}
+
+// watchContract verifies that a change of a watched key lets a blocked WatchPrefix return.
+func watchContract(t *gotesting.T, client backends.StoreClient, w KVWriter) {
+	keys := easykv.WithKeys([]string{Prefix})
+
+	// wait index 0 may return immediately with the current index
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	index, err := client.WatchPrefix(ctx, Prefix, keys, easykv.WithWaitIndex(0))
+	cancel()
+	switch err {
+	case nil, easykv.ErrWatchCanceled:
+	case easykv.ErrWatchNotSupported:
+		t.Skip("watch is not supported")
+	default:
+		t.Fatalf("WatchPrefix failed: %v", err)
+	}
+
+	type result struct {
+		index uint64
+		err   error
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), WatchTimeout)
+	defer cancel()
+	done := make(chan result, 1)
+	go func() {
+		i, err := client.WatchPrefix(ctx, Prefix, keys, easykv.WithWaitIndex(index))
+		done <- result{i, err}
+	}()
+
+	// give the watch some time to be established
+	time.Sleep(500 * time.Millisecond)
+	set(t, w, Prefix+"/watched", "1")
+	defer del(t, w, Prefix+"/watched")
+
+	r := <-done
+	if r.err != nil {
+		t.Fatalf("WatchPrefix should report the change, got: %v", r.err)
+	}
+
+	// a canceled watch returns ErrWatchCanceled (or nil for a spurious return)
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := client.WatchPrefix(ctx, Prefix, keys, easykv.WithWaitIndex(r.index)); err != nil && err != easykv.ErrWatchCanceled {
+		t.Errorf("WatchPrefix should return ErrWatchCanceled after cancelation, got: %v", err)
+	}
+}
+
+func set(t *gotesting.T, w KVWriter, key, value string) {
+	if err := w.Set(key, value); err != nil {
+		t.Fatalf("couldn't set %s: %v", key, err)
+	}
+}
+
+func del(t *gotesting.T, w KVWriter, key string) {
+	if err := w.Delete(key); err != nil {
+		t.Fatalf("couldn't delete %s: %v", key, err)
+	}
+}
+
+func expectValues(t *gotesting.T, client backends.StoreClient, expected map[string]string) {
+	values, err := client.GetValues([]string{Prefix})
+	if err != nil {
+		t.Fatalf("GetValues failed: %v", err)
+	}
+	if len(values) != len(expected) {
+		t.Errorf("GetValues should return %v, got %v", expected, values)
+		return
+	}
+	for k, v := range expected {
+		if values[k] != v {
+			t.Errorf("GetValues should return %v, got %v", expected, values)
+			return
+		}
+	}
+}
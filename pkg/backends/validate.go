@@ -0,0 +1,212 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"strings"
+	"unicode"
+
+	berr "github.com/HeavyHorst/remco/pkg/backends/error"
+	"github.com/HeavyHorst/remco/pkg/template"
+)
+
+// validateNodes checks that at least one node or a SRV record is configured.
+func validateNodes(backend string, nodes []string, srv SRVRecord) error {
+	if len(nodes) == 0 && srv == "" {
+		return berr.ConfigError{Backend: backend, Field: "nodes", Message: "at least one node or a srv_record is required"}
+	}
+	for _, n := range nodes {
+		if strings.TrimSpace(n) == "" {
+			return berr.ConfigError{Backend: backend, Field: "nodes", Message: "empty node"}
+		}
+	}
+	return nil
+}
+
+// validateTLS checks that the client certificate and key are either both set or both empty.
+func validateTLS(backend, cert, key string) error {
+	if cert != "" && key == "" {
+		return berr.ConfigError{Backend: backend, Field: "client_key", Message: "required if client_cert is set"}
+	}
+	if key != "" && cert == "" {
+		return berr.ConfigError{Backend: backend, Field: "client_cert", Message: "required if client_key is set"}
+	}
+	return nil
+}
+
+// validateBackend checks the settings shared by all backends.
+func validateBackend(backend string, b template.Backend) error {
+	for _, r := range b.Prefix {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return berr.ConfigError{Backend: backend, Field: "prefix", Message: "whitespace and control characters are not allowed"}
+		}
+	}
+	for _, elem := range strings.Split(b.Prefix, "/") {
+		if elem == ".." || elem == "." {
+			return berr.ConfigError{Backend: backend, Field: "prefix", Message: "relative path elements are not allowed"}
+		}
+	}
+	if b.MaxKeys < 0 {
+		return berr.ConfigError{Backend: backend, Field: "max_keys", Message: "must not be negative"}
+	}
+	if b.Schedule != "" {
+		if b.Interval > 0 {
+			return berr.ConfigError{Backend: backend, Field: "schedule", Message: "can't be combined with interval"}
+		}
+		if _, err := template.ParseSchedule(b.Schedule); err != nil {
+			return berr.ConfigError{Backend: backend, Field: "schedule", Message: err.Error()}
+		}
+	}
+	return nil
+}
+
+// Validate checks the configuration without connecting to the backend.
+func (c *EtcdConfig) Validate() error {
+	name := c.BackendName()
+	if c.Version != 0 && c.Version != 2 && c.Version != 3 {
+		return berr.ConfigError{Backend: name, Field: "version", Message: "must be 2 or 3"}
+	}
+	if err := validateNodes(name, c.Nodes, c.SRVRecord); err != nil {
+		return err
+	}
+	if c.Password != "" && c.PasswordFile != "" {
+		return berr.ConfigError{Backend: name, Field: "password_file", Message: "can't be combined with password"}
+	}
+	if c.Username != "" && c.Password == "" && c.PasswordFile == "" {
+		return berr.ConfigError{Backend: name, Field: "password", Message: "required if username is set"}
+	}
+	if (c.Password != "" || c.PasswordFile != "") && c.Username == "" {
+		return berr.ConfigError{Backend: name, Field: "username", Message: "required if password is set"}
+	}
+	if c.TokenTTL < 0 {
+		return berr.ConfigError{Backend: name, Field: "token_ttl", Message: "must not be negative"}
+	}
+	if err := validateTLS(name, c.ClientCert, c.ClientKey); err != nil {
+		return err
+	}
+	return validateBackend(name, c.Backend)
+}
+
+// Validate checks the configuration without connecting to the backend.
+func (c *FileConfig) Validate() error {
+	if c.Filepath == "" {
+		return berr.ConfigError{Backend: "file", Field: "filepath", Message: "required"}
+	}
+	return validateBackend("file", c.Backend)
+}
+
+// Validate checks the configuration without connecting to the backend.
+func (c *EnvConfig) Validate() error {
+	return validateBackend("env", c.Backend)
+}
+
+// Validate checks the configuration without connecting to the backend.
+func (c *ConsulConfig) Validate() error {
+	if err := validateNodes("consul", c.Nodes, c.SRVRecord); err != nil {
+		return err
+	}
+	if err := validateTLS("consul", c.ClientCert, c.ClientKey); err != nil {
+		return err
+	}
+	if c.ConsulConnectService != "" && c.ClientCert != "" {
+		return berr.ConfigError{Backend: "consul", Field: "consul_connect_service", Message: "can't be combined with client_cert and client_key"}
+	}
+	switch c.Mode {
+	case "", consulModeKV, consulModeCatalog:
+	default:
+		return berr.ConfigError{Backend: "consul", Field: "mode", Message: "must be " + consulModeKV + " or " + consulModeCatalog}
+	}
+	if err := c.HTTPPoolConfig.validate("consul"); err != nil {
+		return err
+	}
+	return validateBackend("consul", c.Backend)
+}
+
+// Validate checks the configuration without connecting to the backend.
+// The credentials required by the auth_type are checked, but not read from their files.
+func (c *VaultConfig) Validate() error {
+	if c.Node == "" {
+		return berr.ConfigError{Backend: "vault", Field: "node", Message: "required"}
+	}
+	authType, err := c.authType()
+	if err != nil {
+		return berr.ConfigError{Backend: "vault", Field: "auth_type", Message: err.Error()}
+	}
+	required := func(field, value string) error {
+		if value == "" {
+			return berr.ConfigError{Backend: "vault", Field: field, Message: "required by auth_type " + authType}
+		}
+		return nil
+	}
+	switch authType {
+	case "token", "github":
+		err = required("auth_token", c.AuthToken)
+	case "approle":
+		if err = required("role_id", c.RoleID+c.RoleIDFile); err == nil {
+			err = required("secret_id", c.SecretID+c.SecretIDFile)
+		}
+	case "kubernetes":
+		err = required("role_id", c.RoleID)
+	case "app-id":
+		if err = required("app_id", c.AppID); err == nil {
+			err = required("user_id", c.UserID)
+		}
+	case "userpass":
+		if err = required("username", c.Username); err == nil {
+			err = required("password", c.Password)
+		}
+	case "cert":
+		if err = required("client_cert", c.ClientCert); err == nil {
+			err = required("client_key", c.ClientKey)
+		}
+	default:
+		err = berr.ConfigError{Backend: "vault", Field: "auth_type", Message: "unknown auth_type " + authType}
+	}
+	if err != nil {
+		return err
+	}
+	if err := validateTLS("vault", c.ClientCert, c.ClientKey); err != nil {
+		return err
+	}
+	if err := c.HTTPPoolConfig.validate("vault"); err != nil {
+		return err
+	}
+	if c.PKI != nil {
+		if _, err := newVaultPKI("vault", nil, *c.PKI, c.Backend.Prefix); err != nil {
+			return berr.ConfigError{Backend: "vault", Field: "vault_pki", Message: err.Error()}
+		}
+	}
+	if c.Database != nil {
+		if _, err := newVaultDatabase("vault", nil, *c.Database, c.Backend.Prefix); err != nil {
+			return berr.ConfigError{Backend: "vault", Field: "vault_database", Message: err.Error()}
+		}
+	}
+	return validateBackend("vault", c.Backend)
+}
+
+// Validate checks the configuration without connecting to the backend.
+func (c *RedisConfig) Validate() error {
+	if err := validateNodes("redis", c.Nodes, c.SRVRecord); err != nil {
+		return err
+	}
+	return validateBackend("redis", c.Backend)
+}
+
+// Validate checks the configuration without connecting to the backend.
+func (c *ZookeeperConfig) Validate() error {
+	if err := validateNodes("zookeeper", c.Nodes, c.SRVRecord); err != nil {
+		return err
+	}
+	return validateBackend("zookeeper", c.Backend)
+}
+
+// Validate checks the configuration without connecting to the backend.
+func (c *MockConfig) Validate() error {
+	return validateBackend("mock", c.Backend)
+}
@@ -0,0 +1,79 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"testing"
+
+	berr "github.com/HeavyHorst/remco/pkg/backends/error"
+	"github.com/HeavyHorst/remco/pkg/template"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		config interface{ Validate() error }
+		field  string
+	}{
+		{&EtcdConfig{Nodes: []string{"http://127.0.0.1:2379"}}, ""},
+		{&EtcdConfig{SRVRecord: "_etcd._tcp.example.com", Version: 3}, ""},
+		{&EtcdConfig{}, "nodes"},
+		{&EtcdConfig{Nodes: []string{" "}}, "nodes"},
+		{&EtcdConfig{Nodes: []string{"n"}, Version: 4}, "version"},
+		{&EtcdConfig{Nodes: []string{"n"}, Username: "remco"}, "password"},
+		{&EtcdConfig{Nodes: []string{"n"}, Version: 3, Username: "remco", PasswordFile: "/run/secrets/etcd"}, ""},
+		{&EtcdConfig{Nodes: []string{"n"}, Username: "remco", Password: "secret", PasswordFile: "/run/secrets/etcd"}, "password_file"},
+		{&EtcdConfig{Nodes: []string{"n"}, PasswordFile: "/run/secrets/etcd"}, "username"},
+		{&EtcdConfig{Nodes: []string{"n"}, ClientCert: "cert.pem"}, "client_key"},
+		{&EtcdConfig{Nodes: []string{"n"}, ClientKey: "key.pem"}, "client_cert"},
+		{&EtcdConfig{Nodes: []string{"n"}, Backend: template.Backend{Prefix: "/app/../other"}}, "prefix"},
+		{&EtcdConfig{Nodes: []string{"n"}, Backend: template.Backend{Schedule: "CRON_TZ=Europe/Berlin 30 2 * * mon-fri"}}, ""},
+		{&EtcdConfig{Nodes: []string{"n"}, Backend: template.Backend{Schedule: "61 * * * *"}}, "schedule"},
+		{&EtcdConfig{Nodes: []string{"n"}, Backend: template.Backend{Schedule: "@daily", Interval: 60}}, "schedule"},
+		{&EtcdConfig{Nodes: []string{"n"}, Backend: template.Backend{MaxKeys: -1}}, "max_keys"},
+		{&FileConfig{Filepath: "/etc/remco/values.yml"}, ""},
+		{&FileConfig{}, "filepath"},
+		{&EnvConfig{Backend: template.Backend{Prefix: "/app\n"}}, "prefix"},
+		{&ConsulConfig{Nodes: []string{"127.0.0.1:8500"}, Mode: consulModeCatalog}, ""},
+		{&ConsulConfig{Nodes: []string{"127.0.0.1:8500"}, Mode: "services"}, "mode"},
+		{&ConsulConfig{Nodes: []string{"127.0.0.1:8500"}, ConsulConnectService: "remco"}, ""},
+		{&ConsulConfig{Nodes: []string{"n"}, ConsulConnectService: "remco", ClientCert: "cert.pem", ClientKey: "key.pem"}, "consul_connect_service"},
+		{&ConsulConfig{Nodes: []string{"n"}, HTTPPoolConfig: HTTPPoolConfig{MaxIdleConnsPerHost: -1}}, "max_idle_conns_per_host"},
+		{&RedisConfig{}, "nodes"},
+		{&ZookeeperConfig{SRVRecord: "_zk._tcp.example.com"}, ""},
+		{&MockConfig{Backend: template.Backend{Prefix: "/app"}}, ""},
+		{&VaultConfig{Node: "http://127.0.0.1:8200", AuthToken: "token"}, ""},
+		{&VaultConfig{AuthToken: "token"}, "node"},
+		{&VaultConfig{Node: "n"}, "auth_type"},
+		{&VaultConfig{Node: "n", AuthType: "approle", RoleIDFile: "/run/role_id"}, "secret_id"},
+		{&VaultConfig{Node: "n", AuthType: "userpass", Username: "remco"}, "password"},
+		{&VaultConfig{Node: "n", AuthType: "cert", ClientCert: "cert.pem"}, "client_key"},
+		{&VaultConfig{Node: "n", AuthType: "ldap"}, "auth_type"},
+		{&VaultConfig{Node: "n", AuthToken: "token", PKI: &VaultPKIConfig{PKIPath: "pki/issue/web"}}, "vault_pki"},
+		{&VaultConfig{Node: "n", AuthToken: "token", Database: &VaultDatabaseConfig{}}, "vault_database"},
+		{&VaultConfig{Node: "n", AuthToken: "token", HTTPPoolConfig: HTTPPoolConfig{IdleConnTimeout: -1}}, "idle_conn_timeout"},
+	}
+
+	for i, test := range tests {
+		err := test.config.Validate()
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("test %d: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		cerr, ok := err.(berr.ConfigError)
+		if !ok {
+			t.Errorf("test %d: expected a ConfigError for %s, got %v", i, test.field, err)
+			continue
+		}
+		if cerr.Field != test.field {
+			t.Errorf("test %d: expected an error for %s, got %v", i, test.field, err)
+		}
+	}
+}
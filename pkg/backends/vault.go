@@ -9,10 +9,13 @@
 package backends
 
 import (
-	"github.com/HeavyHorst/easykv/vault"
+	"fmt"
+	"os"
+
 	berr "github.com/HeavyHorst/remco/pkg/backends/error"
 	"github.com/HeavyHorst/remco/pkg/log"
 	"github.com/HeavyHorst/remco/pkg/template"
+	vaultapi "github.com/hashicorp/vault/api"
 	"github.com/sirupsen/logrus"
 )
 
@@ -23,7 +26,14 @@ type VaultConfig struct {
 
 	// The vault authentication type.
 	//   (token, approle, app-id, userpass, github, cert, kubernetes)
+	// The type is detected from the other settings if it is empty.
 	AuthType string `toml:"auth_type"`
+	// AuthMethod is an alias of AuthType.
+	AuthMethod string `toml:"auth_method"`
+
+	// The path the auth method is mounted at.
+	// Defaults to the name of the auth type, not used with auth_type=token.
+	AuthMount string `toml:"auth_mount"`
 
 	// The vault app ID.
 	// Only used with auth_type=app-id.
@@ -39,6 +49,15 @@ type VaultConfig struct {
 	// Only used with auth_type=approle.
 	SecretID string `toml:"secret_id"`
 
+	// Files with the RoleID and SecretID, used if role_id or secret_id are empty.
+	// The files are read on every login.
+	RoleIDFile   string `toml:"role_id_file"`
+	SecretIDFile string `toml:"secret_id_file"`
+
+	// The service account token used with auth_type=kubernetes.
+	// Defaults to /var/run/secrets/kubernetes.io/serviceaccount/token.
+	KubernetesTokenFile string `toml:"kubernetes_token_file"`
+
 	// The username for the userpass authentication.
 	Username string
 	// The password for the userpass authentication.
@@ -47,9 +66,24 @@ type VaultConfig struct {
 	// The vault authentication token. Only used with auth_type=token and github.
 	AuthToken string `toml:"auth_token"`
 
+	// PKI issues a certificate with the PKI secrets engine if set.
+	// The certificate, its private key and the CA chain are the values of /pki/certificate,
+	// /pki/private_key and /pki/ca_chain.
+	PKI *VaultPKIConfig `toml:"vault_pki"`
+
+	// Database reads dynamic credentials of the database secrets engine if set.
+	// The credentials are the values of /db/username and /db/password.
+	Database *VaultDatabaseConfig `toml:"vault_database"`
+
+	// Version pins the secrets of KV v2 engines to this version.
+	// 0 (the default) reads the latest version.
+	Version int
+
 	ClientCert   string `toml:"client_cert"`
 	ClientKey    string `toml:"client_key"`
 	ClientCaKeys string `toml:"client_ca_keys"`
+
+	HTTPPoolConfig
 	template.Backend
 }
 
@@ -59,44 +93,122 @@ func (c *VaultConfig) Connect() (template.Backend, error) {
 		return template.Backend{}, berr.ErrNilConfig
 	}
 
-	c.Backend.Name = "vault"
+	c.Backend.Name = c.BackendName()
+	c.Backend.Sensitive = true
 	log.WithFields(logrus.Fields{
 		"backend": c.Backend.Name,
 		"nodes":   []string{c.Node},
 	}).Info("set backend nodes")
 
-	tlsOps := vault.TLSOptions{
-		ClientCert:   c.ClientCert,
-		ClientKey:    c.ClientKey,
-		ClientCaKeys: c.ClientCaKeys,
+	authType, err := c.authType()
+	if err != nil {
+		return c.Backend, err
 	}
 
-	authOps := vault.BasicAuthOptions{
-		Username: c.Username,
-		Password: c.Password,
+	login, err := c.login(authType)
+	if err != nil {
+		return c.Backend, err
 	}
 
-	client, err := vault.New(c.Node, c.AuthType,
-		vault.WithBasicAuth(authOps),
-		vault.WithTLSOptions(tlsOps),
-		vault.WithAppID(c.AppID),
-		vault.WithUserID(c.UserID),
-		vault.WithRoleID(c.RoleID),
-		vault.WithSecretID(c.SecretID),
-		vault.WithToken(c.AuthToken))
-
+	conf, err := newVaultAPIConfig(c.Node, c.ClientCert, c.ClientKey, c.ClientCaKeys, c.HTTPPoolConfig)
 	if err != nil {
 		return c.Backend, err
 	}
+	api, err := vaultapi.NewClient(conf)
+	if err != nil {
+		return c.Backend, err
+	}
+	var pki *vaultPKI
+	if c.PKI != nil {
+		if pki, err = newVaultPKI(c.Backend.Name, api, *c.PKI, c.Backend.Prefix); err != nil {
+			return c.Backend, err
+		}
+	}
+	var db *vaultDatabase
+	if c.Database != nil {
+		if db, err = newVaultDatabase(c.Backend.Name, api, *c.Database, c.Backend.Prefix); err != nil {
+			return c.Backend, err
+		}
+	}
+	auth, err := newVaultAuthClient(c.Backend.Name, api, login)
+	if err != nil {
+		return c.Backend, err
+	}
+	if db != nil {
+		if err := db.start(); err != nil {
+			auth.Close()
+			return c.Backend, err
+		}
+	}
 
-	c.Backend.ReadWatcher = client
-
-	if c.Backend.Watch {
-		log.WithFields(logrus.Fields{
-			"backend": c.Backend.Name,
-		}).Warn("Watch is not supported, using interval instead")
-		c.Backend.Watch = false
+	c.Backend.ReadWatcher = &vaultClient{
+		vaultKV: newVaultKV(c.Backend.Name, api, c.Version),
+		pki:     pki,
+		db:      db,
+		auth:    auth,
 	}
 
 	return c.Backend, nil
 }
+
+func (c *VaultConfig) kubernetesTokenFile() string {
+	if c.KubernetesTokenFile != "" {
+		return c.KubernetesTokenFile
+	}
+	return vaultKubernetesTokenFile
+}
+
+// authType returns the configured auth type or detects it from the other settings:
+// auth_token selects token, a role and secret ID select approle, a role ID and an
+// existing service account token select kubernetes and a username and password select userpass.
+func (c *VaultConfig) authType() (string, error) {
+	if c.AuthType != "" && c.AuthMethod != "" && c.AuthType != c.AuthMethod {
+		return "", fmt.Errorf("auth_type %q and auth_method %q differ", c.AuthType, c.AuthMethod)
+	}
+	if c.AuthType != "" {
+		return c.AuthType, nil
+	}
+	if c.AuthMethod != "" {
+		return c.AuthMethod, nil
+	}
+
+	hasRole := c.RoleID != "" || c.RoleIDFile != ""
+	switch {
+	case c.AuthToken != "":
+		return "token", nil
+	case hasRole && (c.SecretID != "" || c.SecretIDFile != ""):
+		return "approle", nil
+	case c.RoleID != "":
+		if _, err := os.Stat(c.kubernetesTokenFile()); err == nil {
+			return "kubernetes", nil
+		}
+	case c.Username != "" && c.Password != "":
+		return "userpass", nil
+	}
+	return "", fmt.Errorf("couldn't detect the vault auth_type, please set it explicitly")
+}
+
+// login returns the login of the auth type.
+func (c *VaultConfig) login(authType string) (*vaultLogin, error) {
+	mount := c.AuthMount
+	if mount == "" {
+		mount = authType
+	}
+	switch authType {
+	case "token":
+		return newTokenLogin(c.AuthToken), nil
+	case "approle":
+		return newApproleLogin(mount, c.RoleID, c.RoleIDFile, c.SecretID, c.SecretIDFile), nil
+	case "kubernetes":
+		return newKubernetesLogin(mount, c.RoleID, c.kubernetesTokenFile()), nil
+	case "app-id":
+		return newAppIDLogin(mount, c.AppID, c.UserID), nil
+	case "github":
+		return newGithubLogin(mount, c.AuthToken), nil
+	case "userpass":
+		return newUserpassLogin(mount, c.Username, c.Password), nil
+	case "cert":
+		return newCertLogin(mount), nil
+	}
+	return nil, fmt.Errorf("unknown vault auth_type %q", authType)
+}
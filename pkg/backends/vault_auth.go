@@ -0,0 +1,334 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/log"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// vaultKubernetesTokenFile is the default location of the service account token in a kubernetes pod.
+const vaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+const (
+	// vaultLoginMinBackoff is the delay before the first retry of a failed login.
+	vaultLoginMinBackoff = 1 * time.Second
+
+	// vaultLoginMaxBackoff is the maximum delay between two retries of a failed login.
+	vaultLoginMaxBackoff = 1 * time.Minute
+)
+
+// vaultLogin logs in to vault with the credentials of an auth method.
+// The credentials are read on every login, so that rotated files are picked up.
+type vaultLogin struct {
+	method string
+	path   string
+	data   func() (map[string]interface{}, error)
+
+	// token is the static token of the token auth method.
+	token string
+}
+
+// login logs in to vault and returns the secret with the auth data.
+func (l *vaultLogin) login(c *vaultapi.Client) (*vaultapi.Secret, error) {
+	if l.path == "" {
+		c.SetToken(l.token)
+		if _, err := c.Auth().Token().LookupSelf(); err != nil {
+			return nil, errors.Wrap(err, "token lookup failed")
+		}
+		return &vaultapi.Secret{Auth: &vaultapi.SecretAuth{ClientToken: l.token}}, nil
+	}
+
+	data, err := l.data()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := c.Logical().Write(l.path, data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s login failed", l.method)
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return nil, fmt.Errorf("%s login failed: the response contains no token", l.method)
+	}
+	return secret, nil
+}
+
+// readCredential returns value or, if it is empty, the trimmed content of file.
+func readCredential(value, file string) (string, error) {
+	if value != "" || file == "" {
+		return value, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func newApproleLogin(mount, roleID, roleIDFile, secretID, secretIDFile string) *vaultLogin {
+	return &vaultLogin{
+		method: "approle",
+		path:   "auth/" + mount + "/login",
+		data: func() (map[string]interface{}, error) {
+			role, err := readCredential(roleID, roleIDFile)
+			if err != nil {
+				return nil, errors.Wrap(err, "couldn't read the role_id")
+			}
+			secret, err := readCredential(secretID, secretIDFile)
+			if err != nil {
+				return nil, errors.Wrap(err, "couldn't read the secret_id")
+			}
+			return map[string]interface{}{
+				"role_id":   role,
+				"secret_id": secret,
+			}, nil
+		},
+	}
+}
+
+func newKubernetesLogin(mount, role, tokenFile string) *vaultLogin {
+	return &vaultLogin{
+		method: "kubernetes",
+		path:   "auth/" + mount + "/login",
+		data: func() (map[string]interface{}, error) {
+			jwt, err := ioutil.ReadFile(tokenFile)
+			if err != nil {
+				return nil, errors.Wrap(err, "couldn't read the service account token")
+			}
+			return map[string]interface{}{
+				"jwt":  strings.TrimSpace(string(jwt)),
+				"role": role,
+			}, nil
+		},
+	}
+}
+
+func newAppIDLogin(mount, appID, userID string) *vaultLogin {
+	return &vaultLogin{
+		method: "app-id",
+		path:   "auth/" + mount + "/login",
+		data: func() (map[string]interface{}, error) {
+			return map[string]interface{}{
+				"app_id":  appID,
+				"user_id": userID,
+			}, nil
+		},
+	}
+}
+
+func newGithubLogin(mount, token string) *vaultLogin {
+	return &vaultLogin{
+		method: "github",
+		path:   "auth/" + mount + "/login",
+		data: func() (map[string]interface{}, error) {
+			return map[string]interface{}{"token": token}, nil
+		},
+	}
+}
+
+func newUserpassLogin(mount, username, password string) *vaultLogin {
+	return &vaultLogin{
+		method: "userpass",
+		path:   "auth/" + mount + "/login/" + username,
+		data: func() (map[string]interface{}, error) {
+			return map[string]interface{}{"password": password}, nil
+		},
+	}
+}
+
+func newCertLogin(mount string) *vaultLogin {
+	return &vaultLogin{
+		method: "cert",
+		path:   "auth/" + mount + "/login",
+		data: func() (map[string]interface{}, error) {
+			return nil, nil
+		},
+	}
+}
+
+// newTokenLogin returns a login that validates the token. The token is neither renewed nor replaced.
+func newTokenLogin(token string) *vaultLogin {
+	return &vaultLogin{method: "token", token: token}
+}
+
+// newVaultAPIConfig returns the configuration of a vault api client with the given TLS settings
+// and the connection pool configured by pool.
+// The pooled transport of the default configuration is kept, it negotiates HTTP/2 with the server.
+func newVaultAPIConfig(address, cert, key, caCert string, pool HTTPPoolConfig) (*vaultapi.Config, error) {
+	conf := vaultapi.DefaultConfig()
+	if conf.Error != nil {
+		return nil, conf.Error
+	}
+	conf.Address = address
+
+	transport := conf.HttpClient.Transport.(*http.Transport)
+	pool.apply(transport)
+	// the TLS config is modified in place, it holds the HTTP/2 protocol
+	tlsConfig := transport.TLSClientConfig
+	if cert != "" && key != "" {
+		clientCert, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+
+	if caCert != "" {
+		ca, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		caCertPool := x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(ca)
+		tlsConfig.RootCAs = caCertPool
+	}
+	return conf, nil
+}
+
+// vaultAuthClient logs in to vault with an auth method and keeps the token of the api client valid.
+//
+// The token is renewed in the background at 2/3 of its TTL. If the renewal fails, the token
+// isn't renewable or its max TTL is reached, the client logs in again. Failed logins are retried
+// with an exponential backoff, until then the previous token is used.
+type vaultAuthClient struct {
+	name  string
+	api   *vaultapi.Client
+	login *vaultLogin
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// newVaultAuthClient logs in and starts the renewal of the token.
+func newVaultAuthClient(name string, api *vaultapi.Client, login *vaultLogin) (*vaultAuthClient, error) {
+	c := &vaultAuthClient{
+		name:       name,
+		api:        api,
+		login:      login,
+		minBackoff: vaultLoginMinBackoff,
+		maxBackoff: vaultLoginMaxBackoff,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	secret, err := c.authenticate()
+	if err != nil {
+		return nil, err
+	}
+	go c.renew(secret)
+	return c, nil
+}
+
+func (c *vaultAuthClient) logger() *logrus.Entry {
+	return log.WithFields(logrus.Fields{
+		"backend": c.name,
+		"auth":    c.login.method,
+	})
+}
+
+// authenticate logs in and sets the new token.
+func (c *vaultAuthClient) authenticate() (*vaultapi.Secret, error) {
+	secret, err := c.login.login(c.api)
+	if err != nil {
+		return nil, err
+	}
+	c.api.SetToken(secret.Auth.ClientToken)
+	return secret, nil
+}
+
+// reauthenticate logs in again, failed logins are retried with an exponential backoff.
+// It returns false if the client has been closed.
+func (c *vaultAuthClient) reauthenticate() (*vaultapi.Secret, bool) {
+	backoff := c.minBackoff
+	for {
+		secret, err := c.authenticate()
+		if err == nil {
+			c.logger().Info("logged in")
+			return secret, true
+		}
+		c.logger().WithField("retry_in", backoff.String()).Error(err)
+		if !c.sleep(backoff) {
+			return nil, false
+		}
+		backoff *= 2
+		if backoff > c.maxBackoff {
+			backoff = c.maxBackoff
+		}
+	}
+}
+
+// sleep waits for d. It returns false if the client has been closed in the meantime.
+func (c *vaultAuthClient) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-c.stop:
+		return false
+	}
+}
+
+// renew keeps the token of secret valid until the client is closed.
+func (c *vaultAuthClient) renew(secret *vaultapi.Secret) {
+	defer close(c.done)
+	for {
+		auth := secret.Auth
+		ttl := time.Duration(auth.LeaseDuration) * time.Second
+		if ttl <= 0 {
+			// the token never expires
+			return
+		}
+		if !c.sleep(ttl * 2 / 3) {
+			return
+		}
+
+		if auth.Renewable {
+			renewed, err := c.api.Auth().Token().RenewSelf(0)
+			if err == nil && renewed != nil && renewed.Auth != nil {
+				if renewed.Auth.LeaseDuration < auth.LeaseDuration {
+					// the max TTL is reached, log in again before the token expires
+					renewed.Auth.Renewable = false
+				}
+				secret = renewed
+				continue
+			}
+			if err != nil {
+				c.logger().Error(errors.Wrap(err, "token renewal failed"))
+			}
+		}
+
+		var ok bool
+		if secret, ok = c.reauthenticate(); !ok {
+			return
+		}
+	}
+}
+
+// Close stops the renewal of the token.
+func (c *vaultAuthClient) Close() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+	<-c.done
+}
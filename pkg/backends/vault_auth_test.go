@@ -0,0 +1,304 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeVault implements the login, token and KV v1 endpoints used by the vault backend.
+// The value of secret/remco is the token that was used to read it.
+type fakeVault struct {
+	mu        sync.Mutex
+	ttl       int
+	renewable bool
+	failLogin bool
+	failRenew bool
+	logins    int
+	renewals  int
+	token     string
+	loginPath string
+	loginData map[string]interface{}
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	authResponse := func() {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]interface{}{
+				"client_token":   f.token,
+				"lease_duration": f.ttl,
+				"renewable":      f.renewable,
+			},
+		})
+	}
+
+	switch r.URL.Path {
+	case "/v1/auth/approle/login", "/v1/auth/kubernetes/login", "/v1/auth/k8s-prod/login":
+		f.loginPath = r.URL.Path
+		f.loginData = nil
+		json.NewDecoder(r.Body).Decode(&f.loginData)
+		if f.failLogin {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors":["invalid secret id"]}`)
+			return
+		}
+		f.logins++
+		f.token = fmt.Sprintf("token-%d", f.logins)
+		authResponse()
+		return
+	}
+
+	if r.Header.Get("X-Vault-Token") != f.token {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"errors":["permission denied"]}`)
+		return
+	}
+	switch r.URL.Path {
+	case "/v1/auth/token/lookup-self":
+		fmt.Fprintf(w, `{"data":{"id":%q}}`, f.token)
+	case "/v1/auth/token/renew-self":
+		if f.failRenew {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors":["permission denied"]}`)
+			return
+		}
+		f.renewals++
+		authResponse()
+	case "/v1/secret/remco":
+		if r.URL.Query().Get("list") != "" || r.Method == "LIST" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"errors":[]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"data":{"value":%q}}`, f.token)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"errors":[]}`)
+	}
+}
+
+func (f *fakeVault) counts() (logins, renewals int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.logins, f.renewals
+}
+
+func (f *fakeVault) set(fn func(f *fakeVault)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fn(f)
+}
+
+func writeTestFile(t testing.TB, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func getVaultValue(t *testing.T, c *VaultConfig) string {
+	values, err := c.Backend.ReadWatcher.GetValues([]string{"secret/remco"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return values["secret/remco"]
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestVaultAuthType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	jwt := writeTestFile(t, dir, "token", "jwt")
+
+	tests := []struct {
+		name     string
+		config   VaultConfig
+		expected string
+	}{
+		{"explicit", VaultConfig{AuthType: "cert", AuthToken: "t"}, "cert"},
+		{"auth_method", VaultConfig{AuthMethod: "github", AuthToken: "t"}, "github"},
+		{"token", VaultConfig{AuthToken: "t"}, "token"},
+		{"approle", VaultConfig{RoleID: "r", SecretID: "s"}, "approle"},
+		{"approle files", VaultConfig{RoleIDFile: "r", SecretIDFile: "s"}, "approle"},
+		{"kubernetes", VaultConfig{RoleID: "r", KubernetesTokenFile: jwt}, "kubernetes"},
+		{"userpass", VaultConfig{Username: "u", Password: "p"}, "userpass"},
+		{"undetectable", VaultConfig{RoleID: "r", KubernetesTokenFile: filepath.Join(dir, "missing")}, ""},
+		{"conflict", VaultConfig{AuthType: "token", AuthMethod: "approle"}, ""},
+	}
+	for _, test := range tests {
+		authType, err := test.config.authType()
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %q", test.name, authType)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if authType != test.expected {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, authType)
+		}
+	}
+}
+
+func TestVaultApproleLogin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fv := &fakeVault{}
+	server := httptest.NewServer(fv)
+	defer server.Close()
+
+	c := &VaultConfig{
+		Node:         server.URL,
+		RoleIDFile:   writeTestFile(t, dir, "role_id", "my-role\n"),
+		SecretIDFile: writeTestFile(t, dir, "secret_id", "my-secret\n"),
+	}
+	if _, err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Backend.Close()
+
+	if fv.loginPath != "/v1/auth/approle/login" {
+		t.Errorf("unexpected login path %q", fv.loginPath)
+	}
+	if fv.loginData["role_id"] != "my-role" || fv.loginData["secret_id"] != "my-secret" {
+		t.Errorf("unexpected login data %v", fv.loginData)
+	}
+	if v := getVaultValue(t, c); v != "token-1" {
+		t.Errorf("expected the value to be read with token-1, got %q", v)
+	}
+}
+
+func TestVaultKubernetesLogin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-vault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fv := &fakeVault{}
+	server := httptest.NewServer(fv)
+	defer server.Close()
+
+	c := &VaultConfig{
+		Node:                server.URL,
+		AuthMethod:          "kubernetes",
+		AuthMount:           "k8s-prod",
+		RoleID:              "remco",
+		KubernetesTokenFile: writeTestFile(t, dir, "token", "service-account-jwt"),
+	}
+	if _, err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Backend.Close()
+
+	if fv.loginPath != "/v1/auth/k8s-prod/login" {
+		t.Errorf("unexpected login path %q", fv.loginPath)
+	}
+	if fv.loginData["jwt"] != "service-account-jwt" || fv.loginData["role"] != "remco" {
+		t.Errorf("unexpected login data %v", fv.loginData)
+	}
+}
+
+func TestVaultLoginFailure(t *testing.T) {
+	fv := &fakeVault{failLogin: true}
+	server := httptest.NewServer(fv)
+	defer server.Close()
+
+	c := &VaultConfig{Node: server.URL, RoleID: "r", SecretID: "s"}
+	if _, err := c.Connect(); err == nil {
+		t.Fatal("expected the connect to fail")
+	}
+}
+
+func TestVaultTokenRenewal(t *testing.T) {
+	fv := &fakeVault{ttl: 1, renewable: true}
+	server := httptest.NewServer(fv)
+	defer server.Close()
+
+	c := &VaultConfig{Node: server.URL, RoleID: "r", SecretID: "s"}
+	if _, err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Backend.Close()
+
+	waitFor(t, 5*time.Second, func() bool {
+		_, renewals := fv.counts()
+		return renewals > 0
+	})
+	if logins, _ := fv.counts(); logins != 1 {
+		t.Errorf("expected the token to be renewed without a login, got %d logins", logins)
+	}
+
+	// a failed renewal is followed by a login, failed logins are retried
+	fv.set(func(f *fakeVault) {
+		f.failRenew = true
+		f.failLogin = true
+	})
+	time.Sleep(time.Second)
+	fv.set(func(f *fakeVault) { f.failLogin = false })
+	waitFor(t, 5*time.Second, func() bool {
+		logins, _ := fv.counts()
+		return logins > 1
+	})
+	if v := getVaultValue(t, c); v != "token-2" {
+		t.Errorf("expected the value to be read with token-2, got %q", v)
+	}
+}
+
+func TestVaultTokenMaxTTL(t *testing.T) {
+	fv := &fakeVault{ttl: 2, renewable: true}
+	server := httptest.NewServer(fv)
+	defer server.Close()
+
+	c := &VaultConfig{Node: server.URL, RoleID: "r", SecretID: "s"}
+	if _, err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Backend.Close()
+
+	// the renewal doesn't extend the TTL anymore
+	fv.set(func(f *fakeVault) { f.ttl = 1 })
+	waitFor(t, 5*time.Second, func() bool {
+		logins, _ := fv.counts()
+		return logins > 1
+	})
+	if _, renewals := fv.counts(); renewals != 1 {
+		t.Errorf("expected a login after the first capped renewal, got %d renewals", renewals)
+	}
+}
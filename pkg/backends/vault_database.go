@@ -0,0 +1,224 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/log"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// vaultDatabaseMount is the default mount path of the database secrets engine.
+const vaultDatabaseMount = "database"
+
+// VaultDatabaseConfig configures the dynamic credentials of the database secrets engine.
+type VaultDatabaseConfig struct {
+	// The role the credentials are generated for.
+	Role string `toml:"role"`
+
+	// The mount path of the database secrets engine. The default is "database".
+	MountPath string `toml:"mount_path"`
+}
+
+// vaultDatabase reads dynamic database credentials and keeps their lease valid.
+//
+// The lease is renewed after 2/3 of its duration. New credentials are read if the lease
+// can't be renewed (anymore), rotated receives a value afterwards.
+// The credentials are the values of the keys <base>/username and <base>/password.
+type vaultDatabase struct {
+	name      string
+	api       *vaultapi.Client
+	credsPath string
+	base      string
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mu     sync.Mutex
+	values map[string]string
+
+	rotated chan struct{}
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newVaultDatabase(name string, api *vaultapi.Client, config VaultDatabaseConfig, prefix string) (*vaultDatabase, error) {
+	if config.Role == "" {
+		return nil, fmt.Errorf("vault_database needs a role")
+	}
+	mount := strings.Trim(config.MountPath, "/")
+	if mount == "" {
+		mount = vaultDatabaseMount
+	}
+	return &vaultDatabase{
+		name:       name,
+		api:        api,
+		credsPath:  path.Join(mount, "creds", config.Role),
+		base:       path.Join("/", prefix, "db"),
+		minBackoff: vaultLoginMinBackoff,
+		maxBackoff: vaultLoginMaxBackoff,
+		rotated:    make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}, nil
+}
+
+func (d *vaultDatabase) logger() *logrus.Entry {
+	return log.WithFields(logrus.Fields{
+		"backend": d.name,
+		"path":    d.credsPath,
+	})
+}
+
+// start reads the credentials and starts the renewal of their lease.
+func (d *vaultDatabase) start() error {
+	secret, err := d.read()
+	if err != nil {
+		return err
+	}
+	go d.renew(secret)
+	return nil
+}
+
+// owns reports whether the key is one of the keys of the credentials (or their base).
+func (d *vaultDatabase) owns(key string) bool {
+	return isVaultSubKey(d.base, key)
+}
+
+// requested reports whether the credentials are below one of the keys.
+func (d *vaultDatabase) requested(keys []string) bool {
+	return vaultKeysInclude(keys, d.base)
+}
+
+// read reads new credentials.
+func (d *vaultDatabase) read() (*vaultapi.Secret, error) {
+	secret, err := d.api.Logical().Read(d.credsPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading the database credentials failed")
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("reading the database credentials failed: the response contains no credentials")
+	}
+	username, _ := secret.Data["username"].(string)
+	password, _ := secret.Data["password"].(string)
+	if username == "" {
+		return nil, fmt.Errorf("reading the database credentials failed: the response contains no credentials")
+	}
+
+	d.mu.Lock()
+	d.values = map[string]string{
+		d.base + "/username": username,
+		d.base + "/password": password,
+	}
+	d.mu.Unlock()
+
+	d.logger().WithFields(logrus.Fields{
+		"lease_id":       secret.LeaseID,
+		"lease_duration": secret.LeaseDuration,
+	}).Info("read new database credentials")
+	return secret, nil
+}
+
+// reread reads new credentials, failures are retried with an exponential backoff.
+// It returns false if the credentials have been closed.
+func (d *vaultDatabase) reread() (*vaultapi.Secret, bool) {
+	backoff := d.minBackoff
+	for {
+		secret, err := d.read()
+		if err == nil {
+			return secret, true
+		}
+		d.logger().WithField("retry_in", backoff.String()).Error(err)
+		if !d.sleep(backoff) {
+			return nil, false
+		}
+		backoff *= 2
+		if backoff > d.maxBackoff {
+			backoff = d.maxBackoff
+		}
+	}
+}
+
+// sleep waits for t. It returns false if the credentials have been closed in the meantime.
+func (d *vaultDatabase) sleep(t time.Duration) bool {
+	timer := time.NewTimer(t)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-d.stop:
+		return false
+	}
+}
+
+// renew keeps the lease of the credentials valid until the credentials are closed.
+func (d *vaultDatabase) renew(secret *vaultapi.Secret) {
+	defer close(d.done)
+	for {
+		ttl := time.Duration(secret.LeaseDuration) * time.Second
+		if ttl <= 0 {
+			// the credentials never expire
+			return
+		}
+		if !d.sleep(ttl * 2 / 3) {
+			return
+		}
+
+		if secret.Renewable {
+			renewed, err := d.api.Sys().Renew(secret.LeaseID, secret.LeaseDuration)
+			if err == nil && renewed != nil {
+				if renewed.LeaseDuration < secret.LeaseDuration {
+					// the max TTL is reached, read new credentials before the lease expires
+					renewed.Renewable = false
+				}
+				if renewed.LeaseID == "" {
+					renewed.LeaseID = secret.LeaseID
+				}
+				secret = renewed
+				continue
+			}
+			if err != nil {
+				d.logger().Error(errors.Wrap(err, "lease renewal failed"))
+			}
+		}
+
+		var ok bool
+		if secret, ok = d.reread(); !ok {
+			return
+		}
+		select {
+		case d.rotated <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// GetValues returns the credentials below the keys.
+func (d *vaultDatabase) GetValues(keys []string) map[string]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return filterVaultValues(d.values, keys)
+}
+
+// Close stops the renewal of the lease.
+func (d *vaultDatabase) Close() {
+	d.stopOnce.Do(func() {
+		close(d.stop)
+	})
+	<-d.done
+}
@@ -0,0 +1,178 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// fakeVaultDatabase generates credentials at database/creds/app.
+type fakeVaultDatabase struct {
+	mu        sync.Mutex
+	ttl       int
+	renewTTL  int
+	renewFail bool
+	reads     int
+	renewals  int
+}
+
+func (f *fakeVaultDatabase) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/v1/database/creds/app":
+		f.reads++
+		writeVaultSecret(w, map[string]interface{}{
+			"lease_id":       fmt.Sprintf("database/creds/app/%d", f.reads),
+			"lease_duration": f.ttl,
+			"renewable":      true,
+			"data": map[string]interface{}{
+				"username": fmt.Sprintf("user-%d", f.reads),
+				"password": fmt.Sprintf("password-%d", f.reads),
+			},
+		})
+	case "/v1/sys/leases/renew":
+		f.renewals++
+		if f.renewFail {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"errors":["lease not found"]}`))
+			return
+		}
+		writeVaultSecret(w, map[string]interface{}{
+			"lease_id":       fmt.Sprintf("database/creds/app/%d", f.reads),
+			"lease_duration": f.renewTTL,
+			"renewable":      true,
+		})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errors":[]}`))
+	}
+}
+
+func writeVaultSecret(w http.ResponseWriter, secret map[string]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(secret)
+}
+
+func (f *fakeVaultDatabase) counts() (reads, renewals int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reads, f.renewals
+}
+
+func newTestVaultDatabase(t *testing.T, f *fakeVaultDatabase) (*vaultDatabase, *vaultapi.Client, func()) {
+	server := httptest.NewServer(f)
+	conf := vaultapi.DefaultConfig()
+	conf.Address = server.URL
+	conf.MaxRetries = 0
+	api, err := vaultapi.NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.SetToken("token")
+	d, err := newVaultDatabase("vault", api, VaultDatabaseConfig{Role: "app"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.minBackoff = 10 * time.Millisecond
+	if err := d.start(); err != nil {
+		t.Fatal(err)
+	}
+	return d, api, func() {
+		d.Close()
+		server.Close()
+	}
+}
+
+func TestVaultDatabaseConfig(t *testing.T) {
+	if _, err := newVaultDatabase("vault", nil, VaultDatabaseConfig{}, ""); err == nil {
+		t.Error("expected an error without a role")
+	}
+	d, err := newVaultDatabase("vault", nil, VaultDatabaseConfig{Role: "app", MountPath: "/postgres/"}, "/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.credsPath != "postgres/creds/app" || d.base != "/app/db" {
+		t.Errorf("unexpected paths %s %s", d.credsPath, d.base)
+	}
+}
+
+func TestVaultDatabaseRenewal(t *testing.T) {
+	f := &fakeVaultDatabase{ttl: 1, renewTTL: 1}
+	d, _, stop := newTestVaultDatabase(t, f)
+	defer stop()
+
+	values := d.GetValues([]string{"/db"})
+	if values["/db/username"] != "user-1" || values["/db/password"] != "password-1" {
+		t.Errorf("unexpected credentials %v", values)
+	}
+
+	waitFor(t, 5*time.Second, func() bool {
+		_, renewals := f.counts()
+		return renewals >= 2
+	})
+	if reads, _ := f.counts(); reads != 1 {
+		t.Errorf("expected the lease to be renewed, read the credentials %d times", reads)
+	}
+	select {
+	case <-d.rotated:
+		t.Error("the credentials shouldn't have been rotated")
+	default:
+	}
+}
+
+func TestVaultDatabaseRotation(t *testing.T) {
+	f := &fakeVaultDatabase{ttl: 1, renewFail: true}
+	d, api, stop := newTestVaultDatabase(t, f)
+	defer stop()
+
+	kv := newVaultKV("vault", api, 0)
+	kv.watchInterval = 10 * time.Millisecond
+	c := &vaultClient{vaultKV: kv, db: d}
+
+	// the watch returns when new credentials have been read
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.WatchPrefix(ctx, "/", easykv.WithKeys([]string{"/db"})); err != nil {
+		t.Fatal(err)
+	}
+	values, err := c.GetValues([]string{"/db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["/db/username"] != "user-2" {
+		t.Errorf("expected new credentials, got %v", values)
+	}
+}
+
+func TestVaultDatabaseMaxTTL(t *testing.T) {
+	// the renewed lease is shorter, new credentials are read before it expires
+	f := &fakeVaultDatabase{ttl: 2, renewTTL: 1}
+	d, _, stop := newTestVaultDatabase(t, f)
+	defer stop()
+
+	select {
+	case <-d.rotated:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the credentials weren't rotated")
+	}
+	if reads, renewals := f.counts(); reads != 2 || renewals != 1 {
+		t.Errorf("unexpected reads %d and renewals %d", reads, renewals)
+	}
+}
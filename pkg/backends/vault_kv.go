@@ -0,0 +1,541 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+	"github.com/HeavyHorst/remco/pkg/log"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/sirupsen/logrus"
+)
+
+// vaultWatchInterval is the interval in which the secrets are polled for changes in watch mode.
+const vaultWatchInterval = 10 * time.Second
+
+// vaultMount is a secrets engine mount and the version of its KV engine.
+// The version of mounts that aren't KV engines is 1, their secrets are read as they are.
+type vaultMount struct {
+	path    string
+	version int
+}
+
+// vaultKV reads the secrets of KV v1 and v2 engines (and all other engines like KV v1).
+//
+// The version of the KV engine is detected with the sys/mounts api. If the token isn't allowed
+// to list the mounts, the mount of the secret is looked up with sys/internal/ui/mounts
+// (like the vault cli does). Secrets of undetectable mounts are read like KV v1 secrets.
+// The keys of the values are the paths of the secrets without the data/ segment of KV v2.
+type vaultKV struct {
+	name string
+	api  *vaultapi.Client
+
+	// version pins the secrets of KV v2 engines to this version, 0 is the latest version.
+	version int
+
+	watchInterval time.Duration
+
+	mu     sync.Mutex
+	mounts map[string]int
+}
+
+func newVaultKV(name string, api *vaultapi.Client, version int) *vaultKV {
+	return &vaultKV{
+		name:          name,
+		api:           api,
+		version:       version,
+		watchInterval: vaultWatchInterval,
+		mounts:        make(map[string]int),
+	}
+}
+
+// findMount returns the longest mount of mounts that contains p.
+func findMount(mounts map[string]int, p string) (vaultMount, bool) {
+	var m vaultMount
+	found := false
+	for mp, version := range mounts {
+		if strings.HasPrefix(p+"/", mp) && len(mp) > len(m.path) {
+			m = vaultMount{mp, version}
+			found = true
+		}
+	}
+	return m, found
+}
+
+func kvVersion(engine string, options map[string]string) int {
+	if (engine == "kv" || engine == "generic") && options["version"] == "2" {
+		return 2
+	}
+	return 1
+}
+
+// vaultRequest sends a GET request (with list=true if list is set) that is canceled with ctx.
+// Like the Logical() reads of the vault api, a 404 response without data or warnings returns no secret.
+func vaultRequest(ctx context.Context, api *vaultapi.Client, p string, params map[string][]string, list bool) (*vaultapi.Secret, error) {
+	r := api.NewRequest("GET", "/v1/"+p)
+	for k, values := range params {
+		for _, v := range values {
+			r.Params.Add(k, v)
+		}
+	}
+	if list {
+		r.Params.Set("list", "true")
+	}
+
+	resp, err := api.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		secret, perr := vaultapi.ParseSecret(resp.Body)
+		switch {
+		case perr == io.EOF:
+			return nil, nil
+		case perr != nil:
+			return nil, err
+		case secret != nil && (len(secret.Warnings) > 0 || len(secret.Data) > 0):
+			return secret, nil
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return vaultapi.ParseSecret(resp.Body)
+}
+
+// mountInfo returns the engine and the options of the mount in data.
+func mountInfo(data map[string]interface{}) (string, map[string]string) {
+	engine, _ := data["type"].(string)
+	options := make(map[string]string)
+	if opts, ok := data["options"].(map[string]interface{}); ok {
+		for k, v := range opts {
+			options[k] = fmt.Sprint(v)
+		}
+	}
+	return engine, options
+}
+
+// mountOf returns the mount of the secret path.
+func (kv *vaultKV) mountOf(ctx context.Context, p string) vaultMount {
+	p = strings.Trim(p, "/")
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if m, ok := findMount(kv.mounts, p); ok {
+		return m
+	}
+
+	mounts, err := vaultRequest(ctx, kv.api, "sys/mounts", nil, false)
+	if err == nil && mounts != nil {
+		for mp, mount := range mounts.Data {
+			if mount, ok := mount.(map[string]interface{}); ok {
+				kv.mounts[mp] = kvVersion(mountInfo(mount))
+			}
+		}
+		if m, ok := findMount(kv.mounts, p); ok {
+			return m
+		}
+	}
+
+	// the token probably isn't allowed to list the mounts
+	secret, uerr := vaultRequest(ctx, kv.api, "sys/internal/ui/mounts/"+p, nil, false)
+	if uerr == nil && secret != nil && secret.Data != nil {
+		mp, _ := secret.Data["path"].(string)
+		if mp != "" {
+			m := vaultMount{mp, kvVersion(mountInfo(secret.Data))}
+			kv.mounts[mp] = m.version
+			return m
+		}
+	}
+
+	log.WithFields(logrus.Fields{
+		"backend": kv.name,
+		"path":    p,
+	}).Debug("couldn't detect the mount of the secret, reading it as KV v1 secret")
+	return vaultMount{version: 1}
+}
+
+// kvPath returns the path of the secret p in the segment (data or metadata) of a KV v2 mount.
+func (m vaultMount) kvPath(segment, p string) string {
+	rest := strings.TrimPrefix(strings.Trim(p, "/")+"/", m.path)
+	return m.path + segment + "/" + strings.TrimSuffix(rest, "/")
+}
+
+// list returns the keys below p.
+func (kv *vaultKV) list(ctx context.Context, p string) ([]string, error) {
+	listPath := p
+	if m := kv.mountOf(ctx, p); m.version == 2 {
+		listPath = m.kvPath("metadata", p)
+	}
+	resp, err := vaultRequest(ctx, kv.api, strings.TrimPrefix(listPath, "/"), nil, true)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || resp.Data == nil {
+		return nil, nil
+	}
+	keyList, ok := resp.Data["keys"].([]interface{})
+	if !ok {
+		return nil, nil
+	}
+	var keys []string
+	for _, k := range keyList {
+		if k, ok := k.(string); ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// read returns the data of the secret p, nil if it doesn't exist (or has been deleted).
+func (kv *vaultKV) read(ctx context.Context, p string) (map[string]interface{}, error) {
+	m := kv.mountOf(ctx, p)
+	if m.version != 2 {
+		resp, err := vaultRequest(ctx, kv.api, strings.TrimPrefix(p, "/"), nil, false)
+		if err != nil || resp == nil {
+			return nil, err
+		}
+		return resp.Data, nil
+	}
+
+	var params map[string][]string
+	if kv.version > 0 {
+		params = map[string][]string{"version": {strconv.Itoa(kv.version)}}
+	}
+	resp, err := vaultRequest(ctx, kv.api, m.kvPath("data", p), params, false)
+	if err != nil || resp == nil || resp.Data == nil {
+		return nil, err
+	}
+	data, _ := resp.Data["data"].(map[string]interface{})
+	return data, nil
+}
+
+// walkTree recursively adds the branches below key to branches.
+func (kv *vaultKV) walkTree(ctx context.Context, key string, branches map[string]bool) {
+	// strip trailing slash as long as it's not the only character
+	if last := len(key) - 1; last > 0 && key[last] == '/' {
+		key = key[:last]
+	}
+	if branches[key] {
+		// already processed this branch
+		return
+	}
+	branches[key] = true
+
+	// errors are ignored, the token may be allowed to read but not to list the secrets
+	keys, _ := kv.list(ctx, key)
+	for _, k := range keys {
+		kv.walkTree(ctx, path.Join(key, "/", k), branches)
+	}
+}
+
+func (kv *vaultKV) branches(ctx context.Context, keys []string) []string {
+	branches := make(map[string]bool)
+	for _, key := range keys {
+		kv.walkTree(ctx, key, branches)
+	}
+	list := make([]string, 0, len(branches))
+	for b := range branches {
+		list = append(list, b)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// GetValues is used to lookup all keys with a prefix.
+// Several prefixes can be specified in the keys array.
+//
+// A secret with a single string "value" is returned as the value of its path,
+// all other secrets are flattened (every field is a key below the path of the secret).
+func (kv *vaultKV) GetValues(keys []string) (map[string]string, error) {
+	return kv.GetValuesContext(context.Background(), keys)
+}
+
+// GetValuesContext is GetValues, the requests are canceled with ctx.
+func (kv *vaultKV) GetValuesContext(ctx context.Context, keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, key := range kv.branches(ctx, keys) {
+		data, err := kv.read(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			continue
+		}
+
+		if val, ok := isVaultKV(data); ok {
+			vars[key] = val
+		} else {
+			flattenVaultData(key, data, vars)
+		}
+	}
+	return vars, nil
+}
+
+// isVaultKV checks if a given map has only one key of type string
+// if so, returns the value of that key
+func isVaultKV(data map[string]interface{}) (string, bool) {
+	if len(data) == 1 {
+		if value, ok := data["value"]; ok {
+			if text, ok := value.(string); ok {
+				return text, true
+			}
+		}
+	}
+	return "", false
+}
+
+// flattenVaultData recursively walks on all the values of a specific key and sets them in the variables map
+func flattenVaultData(key string, value interface{}, vars map[string]string) {
+	switch value := value.(type) {
+	case string:
+		vars[key] = value
+	case map[string]interface{}:
+		for innerKey, innerValue := range value {
+			flattenVaultData(path.Join(key, "/", innerKey), innerValue, vars)
+		}
+	}
+}
+
+// fingerprint returns a hash of the state of the secrets below keys.
+// The state of a KV v2 secret is its current version, the state of all other secrets is their data.
+func (kv *vaultKV) fingerprint(ctx context.Context, keys []string) (uint64, error) {
+	h := fnv.New64a()
+	for _, key := range kv.branches(ctx, keys) {
+		if m := kv.mountOf(ctx, key); m.version == 2 {
+			resp, err := vaultRequest(ctx, kv.api, m.kvPath("metadata", key), nil, false)
+			if err != nil {
+				return 0, err
+			}
+			if resp != nil && resp.Data != nil {
+				fmt.Fprintf(h, "%s=%v\n", key, resp.Data["current_version"])
+			}
+			continue
+		}
+
+		data, err := kv.read(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		if data != nil {
+			js, _ := json.Marshal(data)
+			fmt.Fprintf(h, "%s=%s\n", key, js)
+		}
+	}
+	// 0 is the wait index of the first watch
+	if sum := h.Sum64(); sum != 0 {
+		return sum, nil
+	}
+	return 1, nil
+}
+
+// WatchPrefix polls the secrets below the keys of the watch options every watchInterval and
+// returns when they changed. Vault has no native watch api, KV v2 secrets are compared by their
+// metadata.version, all other secrets by their data.
+func (kv *vaultKV) WatchPrefix(ctx context.Context, prefix string, opts ...easykv.WatchOption) (uint64, error) {
+	var options easykv.WatchOptions
+	for _, o := range opts {
+		o(&options)
+	}
+
+	last := options.WaitIndex
+	if last == 0 {
+		fp, err := kv.fingerprint(ctx, options.Keys)
+		if ctx.Err() != nil {
+			return options.WaitIndex, easykv.ErrWatchCanceled
+		}
+		if err != nil {
+			return 0, err
+		}
+		last = fp
+	}
+
+	ticker := time.NewTicker(kv.watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return options.WaitIndex, easykv.ErrWatchCanceled
+		case <-ticker.C:
+			fp, err := kv.fingerprint(ctx, options.Keys)
+			if ctx.Err() != nil {
+				return options.WaitIndex, easykv.ErrWatchCanceled
+			}
+			if err != nil {
+				return options.WaitIndex, err
+			}
+			if fp != last {
+				return fp, nil
+			}
+		}
+	}
+}
+
+// isVaultSubKey reports whether key is base or below base.
+func isVaultSubKey(base, key string) bool {
+	return key == base || strings.HasPrefix(key, base+"/")
+}
+
+// vaultKeysInclude reports whether base is one of the keys or below one of them.
+func vaultKeysInclude(keys []string, base string) bool {
+	for _, k := range keys {
+		k = path.Join("/", k)
+		if k == "/" || isVaultSubKey(k, base) || isVaultSubKey(base, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterVaultValues returns the values below the keys.
+func filterVaultValues(values map[string]string, keys []string) map[string]string {
+	filtered := make(map[string]string)
+	for k, v := range values {
+		for _, key := range keys {
+			key = path.Join("/", key)
+			if key == "/" || isVaultSubKey(key, k) {
+				filtered[k] = v
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// vaultClient reads the secrets (and the certificate of pki and the credentials of db, if set)
+// with the token of auth.
+type vaultClient struct {
+	*vaultKV
+	pki  *vaultPKI
+	db   *vaultDatabase
+	auth *vaultAuthClient
+}
+
+// kvKeys returns the keys that are neither keys of the certificate nor of the database credentials.
+func (c *vaultClient) kvKeys(keys []string) []string {
+	var kvKeys []string
+	for _, k := range keys {
+		key := path.Join("/", k)
+		if (c.pki != nil && c.pki.owns(key)) || (c.db != nil && c.db.owns(key)) {
+			continue
+		}
+		kvKeys = append(kvKeys, k)
+	}
+	return kvKeys
+}
+
+// GetValues is used to lookup all keys with a prefix.
+// Several prefixes can be specified in the keys array.
+func (c *vaultClient) GetValues(keys []string) (map[string]string, error) {
+	return c.GetValuesContext(context.Background(), keys)
+}
+
+// GetValuesContext is GetValues, the requests for the secrets are canceled with ctx.
+func (c *vaultClient) GetValuesContext(ctx context.Context, keys []string) (map[string]string, error) {
+	if c.pki == nil && c.db == nil {
+		return c.vaultKV.GetValuesContext(ctx, keys)
+	}
+
+	values := make(map[string]string)
+	if kvKeys := c.kvKeys(keys); len(kvKeys) > 0 {
+		var err error
+		if values, err = c.vaultKV.GetValuesContext(ctx, kvKeys); err != nil {
+			return nil, err
+		}
+	}
+	if c.pki != nil && c.pki.requested(keys) {
+		pkiValues, err := c.pki.GetValues(keys)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range pkiValues {
+			values[k] = v
+		}
+	}
+	if c.db != nil && c.db.requested(keys) {
+		for k, v := range c.db.GetValues(keys) {
+			values[k] = v
+		}
+	}
+	return values, nil
+}
+
+// WatchPrefix watches the secrets and returns as well if the keys include the certificate
+// and a new certificate has been issued after 2/3 of the lifetime of the current one,
+// or if the keys include the database credentials and new credentials have been read.
+func (c *vaultClient) WatchPrefix(ctx context.Context, prefix string, opts ...easykv.WatchOption) (uint64, error) {
+	var options easykv.WatchOptions
+	for _, o := range opts {
+		o(&options)
+	}
+	watchPKI := c.pki != nil && c.pki.requested(options.Keys)
+	watchDB := c.db != nil && c.db.requested(options.Keys)
+	if !watchPKI && !watchDB {
+		return c.vaultKV.WatchPrefix(ctx, prefix, opts...)
+	}
+
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		index uint64
+		err   error
+	}
+	watch := make(chan result, 1)
+	go func() {
+		index, err := c.vaultKV.WatchPrefix(wctx, prefix, easykv.WithKeys(c.kvKeys(options.Keys)), easykv.WithWaitIndex(options.WaitIndex))
+		watch <- result{index, err}
+	}()
+
+	var renewal <-chan time.Time
+	if watchPKI {
+		timer := time.NewTimer(c.pki.untilRenewal())
+		defer timer.Stop()
+		renewal = timer.C
+	}
+	var rotated <-chan struct{}
+	if watchDB {
+		rotated = c.db.rotated
+	}
+
+	select {
+	case r := <-watch:
+		return r.index, r.err
+	case <-renewal:
+		if err := c.pki.renew(); err != nil {
+			return options.WaitIndex, err
+		}
+		return options.WaitIndex, nil
+	case <-rotated:
+		return options.WaitIndex, nil
+	case <-ctx.Done():
+		return options.WaitIndex, easykv.ErrWatchCanceled
+	}
+}
+
+// Close stops the renewal of the token and of the lease of the database credentials.
+func (c *vaultClient) Close() {
+	if c.db != nil {
+		c.db.Close()
+	}
+	c.auth.Close()
+}
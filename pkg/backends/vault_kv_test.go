@@ -0,0 +1,305 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// fakeVaultKV serves a KV v2 engine at secret/ and a KV v1 engine at kv/.
+// If unprivileged is set, sys/mounts is denied.
+type fakeVaultKV struct {
+	mu           sync.Mutex
+	unprivileged bool
+	// v2 holds the versions of the KV v2 secrets, the latest version is the last one
+	v2 map[string][]map[string]interface{}
+	v1 map[string]map[string]interface{}
+	// versions holds the versions of the read requests of KV v2 secrets
+	versions []string
+}
+
+func newFakeVaultKV() *fakeVaultKV {
+	return &fakeVaultKV{
+		v2: map[string][]map[string]interface{}{
+			"app/db":     {{"user": "old"}, {"user": "remco", "password": "secret"}},
+			"app/banner": {{"value": "hello"}},
+		},
+		v1: map[string]map[string]interface{}{
+			"legacy/key": {"value": "v1"},
+		},
+	}
+}
+
+func (f *fakeVaultKV) setSecret(p string, data map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.v2[p] = append(f.v2[p], data)
+}
+
+func writeVaultData(w http.ResponseWriter, data interface{}) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+// listKeys returns the keys below dir in the vault list format.
+func listKeys(paths []string, dir string) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, p := range paths {
+		if !strings.HasPrefix(p, dir+"/") {
+			continue
+		}
+		k := strings.TrimPrefix(p, dir+"/")
+		if i := strings.Index(k, "/"); i >= 0 {
+			k = k[:i+1]
+		}
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (f *fakeVaultKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p := strings.TrimPrefix(r.URL.Path, "/v1/")
+	p = strings.TrimLeft(p, "/")
+	list := r.Method == "LIST" || r.URL.Query().Get("list") == "true"
+
+	notFound := func() {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"errors":[]}`)
+	}
+
+	switch {
+	case p == "sys/mounts":
+		if f.unprivileged {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors":["permission denied"]}`)
+			return
+		}
+		writeVaultData(w, map[string]interface{}{
+			"secret/":    map[string]interface{}{"type": "kv", "options": map[string]string{"version": "2"}},
+			"kv/":        map[string]interface{}{"type": "kv", "options": map[string]string{"version": "1"}},
+			"cubbyhole/": map[string]interface{}{"type": "cubbyhole"},
+		})
+	case strings.HasPrefix(p, "sys/internal/ui/mounts/secret"):
+		writeVaultData(w, map[string]interface{}{"path": "secret/", "type": "kv", "options": map[string]string{"version": "2"}})
+	case strings.HasPrefix(p, "sys/internal/ui/mounts/kv"):
+		writeVaultData(w, map[string]interface{}{"path": "kv/", "type": "kv", "options": nil})
+	case strings.HasPrefix(p, "secret/metadata/"):
+		name := strings.TrimSuffix(strings.TrimPrefix(p, "secret/metadata/"), "/")
+		if list {
+			var paths []string
+			for k := range f.v2 {
+				paths = append(paths, k)
+			}
+			keys := listKeys(paths, name)
+			if keys == nil {
+				notFound()
+				return
+			}
+			writeVaultData(w, map[string]interface{}{"keys": keys})
+			return
+		}
+		versions, ok := f.v2[name]
+		if !ok {
+			notFound()
+			return
+		}
+		writeVaultData(w, map[string]interface{}{"current_version": len(versions)})
+	case strings.HasPrefix(p, "secret/data/"):
+		versions, ok := f.v2[strings.TrimPrefix(p, "secret/data/")]
+		if !ok {
+			notFound()
+			return
+		}
+		version := len(versions)
+		if v := r.URL.Query().Get("version"); v != "" {
+			version, _ = strconv.Atoi(v)
+		}
+		f.versions = append(f.versions, r.URL.Query().Get("version"))
+		if version < 1 || version > len(versions) {
+			notFound()
+			return
+		}
+		writeVaultData(w, map[string]interface{}{
+			"data":     versions[version-1],
+			"metadata": map[string]interface{}{"version": version},
+		})
+	case strings.HasPrefix(p, "kv/"):
+		name := strings.TrimPrefix(p, "kv/")
+		if list {
+			var paths []string
+			for k := range f.v1 {
+				paths = append(paths, k)
+			}
+			keys := listKeys(paths, strings.TrimSuffix(name, "/"))
+			if keys == nil {
+				notFound()
+				return
+			}
+			writeVaultData(w, map[string]interface{}{"keys": keys})
+			return
+		}
+		data, ok := f.v1[name]
+		if !ok {
+			notFound()
+			return
+		}
+		writeVaultData(w, data)
+	default:
+		notFound()
+	}
+}
+
+func newTestVaultKV(t *testing.T, f *fakeVaultKV, version int) (*vaultKV, func()) {
+	server := httptest.NewServer(f)
+	conf := vaultapi.DefaultConfig()
+	conf.Address = server.URL
+	conf.MaxRetries = 0
+	api, err := vaultapi.NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.SetToken("token")
+	kv := newVaultKV("vault", api, version)
+	kv.watchInterval = 10 * time.Millisecond
+	return kv, server.Close
+}
+
+func TestVaultKVGetValuesContext(t *testing.T) {
+	// the server never answers
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	conf := vaultapi.DefaultConfig()
+	conf.Address = server.URL
+	conf.MaxRetries = 0
+	api, err := vaultapi.NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.SetToken("token")
+	kv := newVaultKV("vault", api, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := kv.GetValuesContext(ctx, []string{"/secret/app"}); err == nil {
+		t.Error("expected the canceled requests to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the requests weren't canceled: %s", elapsed)
+	}
+}
+
+func TestVaultKVGetValues(t *testing.T) {
+	for _, unprivileged := range []bool{false, true} {
+		f := newFakeVaultKV()
+		f.unprivileged = unprivileged
+		kv, stop := newTestVaultKV(t, f, 0)
+
+		values, err := kv.GetValues([]string{"/secret/app", "/kv/legacy"})
+		stop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := map[string]string{
+			"/secret/app/db/user":     "remco",
+			"/secret/app/db/password": "secret",
+			"/secret/app/banner":      "hello",
+			"/kv/legacy/key":          "v1",
+		}
+		if !reflect.DeepEqual(values, expected) {
+			t.Errorf("unprivileged=%v: expected %v, got %v", unprivileged, expected, values)
+		}
+	}
+}
+
+func TestVaultKVVersion(t *testing.T) {
+	f := newFakeVaultKV()
+	kv, stop := newTestVaultKV(t, f, 1)
+	defer stop()
+
+	values, err := kv.GetValues([]string{"/secret/app/db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, map[string]string{"/secret/app/db/user": "old"}) {
+		t.Errorf("expected the pinned version, got %v", values)
+	}
+	if !reflect.DeepEqual(f.versions, []string{"1"}) {
+		t.Errorf("unexpected versions %v", f.versions)
+	}
+}
+
+func TestVaultKVWatchPrefix(t *testing.T) {
+	f := newFakeVaultKV()
+	kv, stop := newTestVaultKV(t, f, 0)
+	defer stop()
+
+	keys := easykv.WithKeys([]string{"/secret/app", "/kv/legacy"})
+	result := make(chan uint64, 1)
+	go func() {
+		index, err := kv.WatchPrefix(context.Background(), "/", keys)
+		if err != nil {
+			t.Error(err)
+		}
+		result <- index
+	}()
+
+	select {
+	case <-result:
+		t.Fatal("the watch returned without a change")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	f.setSecret("app/banner", map[string]interface{}{"value": "hello again"})
+	var index uint64
+	select {
+	case index = <-result:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the new version wasn't detected")
+	}
+
+	// the watch continues from the returned index
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := kv.WatchPrefix(ctx, "/", keys, easykv.WithWaitIndex(index)); err != easykv.ErrWatchCanceled {
+		t.Errorf("expected the watch to be canceled, got %v", err)
+	}
+}
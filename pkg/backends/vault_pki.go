@@ -0,0 +1,177 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/log"
+	vaultapi "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// VaultPKIConfig configures the certificate that is issued by the PKI secrets engine.
+type VaultPKIConfig struct {
+	// The issue endpoint of the PKI secrets engine, e.g. pki/issue/my-role.
+	PKIPath string `toml:"pki_path"`
+
+	// The common name of the certificate.
+	CommonName string `toml:"common_name"`
+
+	// The requested TTL of the certificate, e.g. 72h. The default is the TTL of the role.
+	TTL string `toml:"ttl"`
+
+	// Additional DNS and email subject alternative names.
+	AltNames []string `toml:"alt_names"`
+
+	// Additional IP subject alternative names.
+	IPSans []string `toml:"ip_sans"`
+}
+
+// vaultPKI issues a certificate and issues a new one after 2/3 of its lifetime.
+//
+// The certificate, its private key and the CA chain are the values of the keys
+// <base>/certificate, <base>/private_key and <base>/ca_chain.
+type vaultPKI struct {
+	name   string
+	api    *vaultapi.Client
+	config VaultPKIConfig
+	base   string
+	now    func() time.Time
+
+	mu      sync.Mutex
+	values  map[string]string
+	renewAt time.Time
+}
+
+func newVaultPKI(name string, api *vaultapi.Client, config VaultPKIConfig, prefix string) (*vaultPKI, error) {
+	if config.PKIPath == "" || config.CommonName == "" {
+		return nil, fmt.Errorf("vault_pki needs a pki_path and a common_name")
+	}
+	return &vaultPKI{
+		name:   name,
+		api:    api,
+		config: config,
+		base:   path.Join("/", prefix, "pki"),
+		now:    time.Now,
+	}, nil
+}
+
+// owns reports whether the key is one of the keys of the certificate (or their base).
+func (p *vaultPKI) owns(key string) bool {
+	return isVaultSubKey(p.base, key)
+}
+
+// requested reports whether the certificate values are below one of the keys.
+func (p *vaultPKI) requested(keys []string) bool {
+	return vaultKeysInclude(keys, p.base)
+}
+
+// issue requests a new certificate.
+func (p *vaultPKI) issue() error {
+	data := map[string]interface{}{
+		"common_name": p.config.CommonName,
+	}
+	if p.config.TTL != "" {
+		data["ttl"] = p.config.TTL
+	}
+	if len(p.config.AltNames) > 0 {
+		data["alt_names"] = strings.Join(p.config.AltNames, ",")
+	}
+	if len(p.config.IPSans) > 0 {
+		data["ip_sans"] = strings.Join(p.config.IPSans, ",")
+	}
+
+	secret, err := p.api.Logical().Write(p.config.PKIPath, data)
+	if err != nil {
+		return errors.Wrap(err, "issuing the certificate failed")
+	}
+	if secret == nil || secret.Data == nil {
+		return fmt.Errorf("issuing the certificate failed: the response contains no certificate")
+	}
+
+	certificate, _ := secret.Data["certificate"].(string)
+	privateKey, _ := secret.Data["private_key"].(string)
+	block, _ := pem.Decode([]byte(certificate))
+	if block == nil || privateKey == "" {
+		return fmt.Errorf("issuing the certificate failed: the response contains no certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return errors.Wrap(err, "parsing the issued certificate failed")
+	}
+
+	var chain []string
+	if list, ok := secret.Data["ca_chain"].([]interface{}); ok {
+		for _, c := range list {
+			if c, ok := c.(string); ok {
+				chain = append(chain, c)
+			}
+		}
+	}
+	if ca, ok := secret.Data["issuing_ca"].(string); ok && len(chain) == 0 {
+		chain = append(chain, ca)
+	}
+
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	p.values = map[string]string{
+		p.base + "/certificate": certificate,
+		p.base + "/private_key": privateKey,
+		p.base + "/ca_chain":    strings.Join(chain, "\n"),
+	}
+	p.renewAt = cert.NotBefore.Add(lifetime * 2 / 3)
+
+	log.WithFields(logrus.Fields{
+		"backend":     p.name,
+		"common_name": p.config.CommonName,
+		"serial":      cert.SerialNumber.String(),
+		"expires":     cert.NotAfter.Format(time.RFC3339),
+	}).Info("issued a certificate")
+	return nil
+}
+
+// GetValues returns the values of the certificate below the keys.
+// A new certificate is issued if there is none or 2/3 of its lifetime have passed.
+func (p *vaultPKI) GetValues(keys []string) (map[string]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.values == nil || !p.now().Before(p.renewAt) {
+		if err := p.issue(); err != nil {
+			return nil, err
+		}
+	}
+	return filterVaultValues(p.values, keys), nil
+}
+
+// untilRenewal returns the time until a new certificate is due.
+func (p *vaultPKI) untilRenewal() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.values == nil {
+		return 0
+	}
+	return p.renewAt.Sub(p.now())
+}
+
+// renew issues a new certificate if it is due.
+func (p *vaultPKI) renew() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.values != nil && p.now().Before(p.renewAt) {
+		return nil
+	}
+	return p.issue()
+}
@@ -0,0 +1,224 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package backends
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// fakeVaultPKI issues self-signed certificates with a lifetime of one hour at pki/issue/web.
+type fakeVaultPKI struct {
+	mu       sync.Mutex
+	issued   int
+	requests []map[string]interface{}
+}
+
+func (f *fakeVaultPKI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/pki/issue/web" {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errors":[]}`))
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var req map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&req)
+	f.requests = append(f.requests, req)
+	f.issued++
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(f.issued)),
+		Subject:      pkix.Name{CommonName: req["common_name"].(string)},
+		NotBefore:    now.Add(-30 * time.Second),
+		NotAfter:     now.Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	cert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": map[string]interface{}{
+			"certificate": cert,
+			"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+			"ca_chain":    []string{"intermediate", "root"},
+			"issuing_ca":  "intermediate",
+		},
+	})
+}
+
+func (f *fakeVaultPKI) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.issued
+}
+
+func newTestVaultPKI(t *testing.T, f *fakeVaultPKI, prefix string) (*vaultPKI, *vaultapi.Client, func()) {
+	server := httptest.NewServer(f)
+	conf := vaultapi.DefaultConfig()
+	conf.Address = server.URL
+	conf.MaxRetries = 0
+	api, err := vaultapi.NewClient(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.SetToken("token")
+	p, err := newVaultPKI("vault", api, VaultPKIConfig{
+		PKIPath:    "pki/issue/web",
+		CommonName: "web.example.com",
+		TTL:        "1h",
+		AltNames:   []string{"www.example.com", "example.com"},
+		IPSans:     []string{"10.0.0.1"},
+	}, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, api, server.Close
+}
+
+func keysOf(values map[string]string) []string {
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestVaultPKIConfig(t *testing.T) {
+	if _, err := newVaultPKI("vault", nil, VaultPKIConfig{PKIPath: "pki/issue/web"}, ""); err == nil {
+		t.Error("expected an error without a common_name")
+	}
+}
+
+func TestVaultPKIGetValues(t *testing.T) {
+	f := &fakeVaultPKI{}
+	p, _, stop := newTestVaultPKI(t, f, "")
+	defer stop()
+
+	values, err := p.GetValues([]string{"/pki"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(keysOf(values), []string{"/pki/ca_chain", "/pki/certificate", "/pki/private_key"}) {
+		t.Errorf("unexpected keys %v", keysOf(values))
+	}
+	if values["/pki/ca_chain"] != "intermediate\nroot" {
+		t.Errorf("unexpected ca chain %q", values["/pki/ca_chain"])
+	}
+	expected := map[string]interface{}{
+		"common_name": "web.example.com",
+		"ttl":         "1h",
+		"alt_names":   "www.example.com,example.com",
+		"ip_sans":     "10.0.0.1",
+	}
+	if !reflect.DeepEqual(f.requests[0], expected) {
+		t.Errorf("unexpected request %v", f.requests[0])
+	}
+
+	// the certificate is reused until 2/3 of its lifetime have passed
+	certificate := values["/pki/certificate"]
+	values, err = p.GetValues([]string{"/pki/certificate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.count() != 1 || values["/pki/certificate"] != certificate || len(values) != 1 {
+		t.Errorf("expected the same certificate, issued %d certificates", f.count())
+	}
+	if d := p.untilRenewal(); d < 35*time.Minute || d > 40*time.Minute {
+		t.Errorf("expected the renewal after 2/3 of the lifetime, got %s", d)
+	}
+
+	p.now = func() time.Time { return time.Now().Add(41 * time.Minute) }
+	values, err = p.GetValues([]string{"/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.count() != 2 || values["/pki/certificate"] == certificate {
+		t.Errorf("expected a new certificate, issued %d certificates", f.count())
+	}
+}
+
+func TestVaultPKIKeys(t *testing.T) {
+	p, err := newVaultPKI("vault", nil, VaultPKIConfig{PKIPath: "pki/issue/web", CommonName: "web"}, "/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]bool{
+		"/":                    true,
+		"/app":                 true,
+		"/app/pki":             true,
+		"/app/pki/certificate": true,
+		"/app/pkix":            false,
+		"/other":               false,
+	} {
+		if p.requested([]string{key}) != expected {
+			t.Errorf("requested(%q) should be %v", key, expected)
+		}
+	}
+	c := &vaultClient{pki: p}
+	if other := c.kvKeys([]string{"/app", "/app/pki", "/app/pki/ca_chain"}); !reflect.DeepEqual(other, []string{"/app"}) {
+		t.Errorf("unexpected kv keys %v", other)
+	}
+}
+
+func TestVaultPKIWatchPrefix(t *testing.T) {
+	f := &fakeVaultPKI{}
+	p, api, stop := newTestVaultPKI(t, f, "")
+	defer stop()
+
+	kv := newVaultKV("vault", api, 0)
+	kv.watchInterval = 10 * time.Millisecond
+	c := &vaultClient{vaultKV: kv, pki: p}
+	if _, err := c.GetValues([]string{"/pki"}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := easykv.WithKeys([]string{"/pki"})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := c.WatchPrefix(ctx, "/", keys); err != easykv.ErrWatchCanceled {
+		t.Errorf("expected the watch to wait for the renewal, got %v", err)
+	}
+
+	p.now = func() time.Time { return time.Now().Add(41 * time.Minute) }
+	if _, err := c.WatchPrefix(context.Background(), "/", keys); err != nil {
+		t.Fatal(err)
+	}
+	if f.count() != 2 {
+		t.Errorf("expected a new certificate, issued %d certificates", f.count())
+	}
+}
@@ -9,11 +9,18 @@
 package backends
 
 import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
 	"github.com/HeavyHorst/easykv/zookeeper"
 	berr "github.com/HeavyHorst/remco/pkg/backends/error"
 	"github.com/HeavyHorst/remco/pkg/log"
 	"github.com/HeavyHorst/remco/pkg/template"
 	"github.com/sirupsen/logrus"
+	zk "github.com/tevino/go-zookeeper/zk"
 )
 
 // ZookeeperConfig represents the config for the consul backend.
@@ -32,7 +39,7 @@ func (c *ZookeeperConfig) Connect() (template.Backend, error) {
 		return template.Backend{}, berr.ErrNilConfig
 	}
 
-	c.Backend.Name = "zookeeper"
+	c.Backend.Name = c.BackendName()
 
 	// No nodes are set but a SRVRecord is provided
 	if len(c.Nodes) == 0 && c.SRVRecord != "" {
@@ -52,7 +59,67 @@ func (c *ZookeeperConfig) Connect() (template.Backend, error) {
 	if err != nil {
 		return c.Backend, err
 	}
+	conn, _, err := zk.Connect(c.Nodes, time.Second)
+	if err != nil {
+		client.Close()
+		return c.Backend, err
+	}
 
-	c.Backend.ReadWatcher = client
+	c.Backend.ReadWatcher = &zookeeperClient{ReadWatcher: client, conn: conn}
 	return c.Backend, nil
 }
+
+// zookeeperClient adds ListKeys to the easykv zookeeper client.
+type zookeeperClient struct {
+	easykv.ReadWatcher
+	conn *zk.Conn
+}
+
+// ListKeys returns the sorted keys below prefix. The keys are the nodes without children, as in GetValues;
+// the tree is walked with the stats of the nodes, their data isn't transferred.
+func (c *zookeeperClient) ListKeys(ctx context.Context, prefix string) ([]string, error) {
+	prefix = strings.Replace(prefix, "/*", "", -1)
+	if _, _, err := c.conn.Exists(prefix); err != nil {
+		return nil, err
+	}
+	if prefix == "/" {
+		prefix = ""
+	}
+	var keys []string
+	if err := c.walk(ctx, prefix, &keys); err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// walk adds the nodes without children below node to keys.
+func (c *zookeeperClient) walk(ctx context.Context, node string, keys *[]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	p := node
+	if p == "" {
+		p = "/"
+	}
+	children, stat, err := c.conn.Children(p)
+	if err != nil {
+		return err
+	}
+	if stat.NumChildren == 0 {
+		*keys = append(*keys, node)
+		return nil
+	}
+	for _, child := range children {
+		if err := c.walk(ctx, node+"/"+child, keys); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes both connections.
+func (c *zookeeperClient) Close() {
+	c.conn.Close()
+	c.ReadWatcher.Close()
+}
@@ -0,0 +1,205 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/log"
+	"github.com/HeavyHorst/remco/pkg/template"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultDependsOnTimeout is the maximum time a resource waits for its dependencies if depends_on_timeout is not set.
+const defaultDependsOnTimeout = 300 * time.Second
+
+// validateDependencies checks that every depends_on entry names exactly one other resource
+// and that the dependencies don't form a cycle.
+func validateDependencies(resources []ResourceConfig) error {
+	count := make(map[string]int)
+	for _, r := range resources {
+		count[r.Name]++
+	}
+
+	deps := make(map[string][]string)
+	for _, r := range resources {
+		if r.DependsOnTimeout < 0 {
+			return fmt.Errorf("resource %s: depends_on_timeout must not be negative", r.Name)
+		}
+		for _, d := range r.DependsOn {
+			switch {
+			case d == r.Name:
+				return fmt.Errorf("resource %s: depends on itself", r.Name)
+			case count[d] == 0:
+				return fmt.Errorf("resource %s: depends on unknown resource %q", r.Name, d)
+			case count[d] > 1:
+				return fmt.Errorf("resource %s: depends on %q, which is the name of %d resources", r.Name, d, count[d])
+			}
+		}
+		deps[r.Name] = append(deps[r.Name], r.DependsOn...)
+	}
+
+	// depth-first search, a resource that is reached again while it is still on the path closes a cycle
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			for i, n := range path {
+				if n == name {
+					return fmt.Errorf("dependency cycle: %s", strings.Join(append(path[i:], name), " -> "))
+				}
+			}
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		path = append(path, name)
+		for _, d := range deps[name] {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+	for _, r := range resources {
+		if err := visit(r.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A readinessGate publishes the readiness of a resource other resources depend on.
+type readinessGate struct {
+	ready       chan struct{}
+	readyOnce   sync.Once
+	healthy     chan struct{}
+	healthyOnce sync.Once
+	// stopped is closed when the resource stops, ready or not.
+	stopped chan struct{}
+}
+
+// newReadinessGates returns a gate for every resource that is a dependency of another resource.
+func newReadinessGates(resources []ResourceConfig) map[string]*readinessGate {
+	gates := make(map[string]*readinessGate)
+	for _, r := range resources {
+		for _, d := range r.DependsOn {
+			if _, ok := gates[d]; !ok {
+				gates[d] = &readinessGate{
+					ready:   make(chan struct{}),
+					healthy: make(chan struct{}),
+					stopped: make(chan struct{}),
+				}
+			}
+		}
+	}
+	return gates
+}
+
+func (g *readinessGate) openReady() {
+	g.readyOnce.Do(func() { close(g.ready) })
+}
+
+func (g *readinessGate) openHealthy() {
+	g.healthyOnce.Do(func() { close(g.healthy) })
+}
+
+// forward opens the gate as soon as res becomes ready (and healthy) or ctx is canceled.
+func (g *readinessGate) forward(ctx context.Context, res *template.Resource) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-res.Ready():
+		g.openReady()
+	}
+	select {
+	case <-ctx.Done():
+	case <-res.Healthy():
+		g.openHealthy()
+	}
+}
+
+// stop opens the gate as far as res got (res may be nil) and marks the resource as stopped.
+func (g *readinessGate) stop(res *template.Resource) {
+	if res != nil {
+		select {
+		case <-res.Ready():
+			g.openReady()
+		default:
+		}
+		select {
+		case <-res.Healthy():
+			g.openHealthy()
+		default:
+		}
+	}
+	close(g.stopped)
+}
+
+// waitForDependencies blocks until all dependencies of r are ready, or healthy if depends_on_healthy is set.
+// It returns an error if a dependency stops before it becomes ready, the depends_on_timeout expires or ctx is canceled.
+func waitForDependencies(ctx context.Context, r ResourceConfig, gates map[string]*readinessGate) error {
+	if len(r.DependsOn) == 0 {
+		return nil
+	}
+
+	timeout := defaultDependsOnTimeout
+	if r.DependsOnTimeout > 0 {
+		timeout = time.Duration(r.DependsOnTimeout) * time.Second
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	state := "ready"
+	if r.DependsOnHealthy {
+		state = "healthy"
+	}
+	logger := log.WithFields(logrus.Fields{"resource": r.Name})
+	for _, d := range r.DependsOn {
+		g := gates[d]
+		wait := g.ready
+		if r.DependsOnHealthy {
+			wait = g.healthy
+		}
+		select {
+		case <-wait:
+			continue
+		default:
+		}
+		logger.WithField("dependency", d).Info(fmt.Sprintf("waiting for the dependency to become %s", state))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wait:
+		case <-g.stopped:
+			// the gate may have been opened right before the dependency stopped
+			select {
+			case <-wait:
+			default:
+				return fmt.Errorf("dependency %q stopped before it became %s", d, state)
+			}
+		case <-timer.C:
+			return fmt.Errorf("dependency %q didn't become %s within depends_on_timeout (%s)", d, state, timeout)
+		}
+	}
+	return nil
+}
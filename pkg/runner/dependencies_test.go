@@ -0,0 +1,74 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package runner
+
+import (
+	"context"
+
+	. "gopkg.in/check.v1"
+)
+
+type DependenciesSuite struct{}
+
+// dependent returns the configuration of the resource name that depends on deps.
+func dependent(name string, deps ...string) ResourceConfig {
+	return named(name, ResourceConfig{DependsOn: deps})
+}
+
+var _ = Suite(&DependenciesSuite{})
+
+func (s *DependenciesSuite) TestValidateDependencies(t *C) {
+	t.Check(validateDependencies([]ResourceConfig{
+		dependent("dns"),
+		dependent("app", "dns", "db"),
+		dependent("db", "dns"),
+	}), IsNil)
+
+	t.Check(validateDependencies([]ResourceConfig{
+		dependent("app", "app"),
+	}), ErrorMatches, "resource app: depends on itself")
+
+	t.Check(validateDependencies([]ResourceConfig{
+		dependent("app", "dns"),
+	}), ErrorMatches, `resource app: depends on unknown resource "dns"`)
+
+	t.Check(validateDependencies([]ResourceConfig{
+		dependent("dns"),
+		dependent("dns"),
+		dependent("app", "dns"),
+	}), ErrorMatches, `resource app: depends on "dns", which is the name of 2 resources`)
+
+	t.Check(validateDependencies([]ResourceConfig{
+		dependent("a", "b"),
+		dependent("b", "c"),
+		dependent("c", "a"),
+	}), ErrorMatches, "dependency cycle: a -> b -> c -> a")
+}
+
+func (s *DependenciesSuite) TestWaitForDependencies(t *C) {
+	resources := []ResourceConfig{
+		dependent("dns"),
+		dependent("app", "dns"),
+	}
+	resources[1].DependsOnTimeout = 1
+
+	gates := newReadinessGates(resources)
+	gates["dns"].openReady()
+	t.Check(waitForDependencies(context.Background(), resources[1], gates), IsNil)
+
+	// the dependency is ready, but never becomes healthy
+	resources[1].DependsOnHealthy = true
+	t.Check(waitForDependencies(context.Background(), resources[1], gates), ErrorMatches,
+		`dependency "dns" didn't become healthy within depends_on_timeout \(1s\)`)
+
+	gates = newReadinessGates(resources)
+	gates["dns"].stop(nil)
+	t.Check(waitForDependencies(context.Background(), resources[1], gates), ErrorMatches,
+		`dependency "dns" stopped before it became healthy`)
+}
@@ -0,0 +1,85 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package runner
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Defaults of the restart backoff of a failed resource.
+const (
+	defaultRestartBackoffMin = 1 * time.Second
+	defaultRestartBackoffMax = 5 * time.Minute
+	defaultRestartResetAfter = 5 * time.Minute
+)
+
+// restartBackoff computes the wait before a failed resource is restarted.
+//
+// The wait of the n-th consecutive restart is min * 2^(n-1), capped at max, randomized between
+// half the wait and the wait. A run that lasted at least resetAfter resets the count.
+// The resource is abandoned after maxRestarts consecutive restarts (0 means unlimited).
+type restartBackoff struct {
+	min         time.Duration
+	max         time.Duration
+	resetAfter  time.Duration
+	maxRestarts int
+
+	restarts int
+}
+
+func secondsOr(seconds int, def time.Duration) time.Duration {
+	if seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return def
+}
+
+// validateRestartBackoff checks the restart settings of the resource.
+func (r ResourceConfig) validateRestartBackoff() error {
+	if r.RestartBackoffMin < 0 || r.RestartBackoffMax < 0 || r.RestartResetAfter < 0 || r.MaxRestarts < 0 {
+		return fmt.Errorf("resource %s: restart_backoff_min, restart_backoff_max, restart_reset_after and max_restarts must not be negative", r.Name)
+	}
+	if b := r.restartBackoff(); b.max < b.min {
+		return fmt.Errorf("resource %s: restart_backoff_max (%s) must not be smaller than restart_backoff_min (%s)", r.Name, b.max, b.min)
+	}
+	return nil
+}
+
+// restartBackoff returns the restart backoff of the resource with the defaults applied.
+func (r ResourceConfig) restartBackoff() *restartBackoff {
+	return &restartBackoff{
+		min:         secondsOr(r.RestartBackoffMin, defaultRestartBackoffMin),
+		max:         secondsOr(r.RestartBackoffMax, defaultRestartBackoffMax),
+		resetAfter:  secondsOr(r.RestartResetAfter, defaultRestartResetAfter),
+		maxRestarts: r.MaxRestarts,
+	}
+}
+
+// next counts the restart after a run that lasted ran and returns the wait before it.
+// It returns false if max_restarts is exceeded.
+func (b *restartBackoff) next(ran time.Duration) (time.Duration, bool) {
+	if ran >= b.resetAfter {
+		b.restarts = 0
+	}
+	b.restarts++
+	if b.maxRestarts > 0 && b.restarts > b.maxRestarts {
+		return 0, false
+	}
+
+	wait := b.min
+	for i := 1; i < b.restarts && wait < b.max; i++ {
+		wait *= 2
+	}
+	if wait > b.max {
+		wait = b.max
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1)), true
+}
@@ -0,0 +1,64 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package runner
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type RestartBackoffSuite struct{}
+
+// named returns r with the name set.
+func named(name string, r ResourceConfig) ResourceConfig {
+	r.Name = name
+	return r
+}
+
+var _ = Suite(&RestartBackoffSuite{})
+
+func (s *RestartBackoffSuite) TestDefaults(t *C) {
+	b := ResourceConfig{}.restartBackoff()
+	t.Check(b.min, Equals, defaultRestartBackoffMin)
+	t.Check(b.max, Equals, defaultRestartBackoffMax)
+	t.Check(b.resetAfter, Equals, defaultRestartResetAfter)
+	t.Check(b.maxRestarts, Equals, 0)
+}
+
+func (s *RestartBackoffSuite) TestValidate(t *C) {
+	t.Check(named("app", ResourceConfig{RestartBackoffMin: 10, RestartBackoffMax: 60}).validateRestartBackoff(), IsNil)
+	t.Check(named("app", ResourceConfig{MaxRestarts: -1}).validateRestartBackoff(), ErrorMatches, "resource app: .* must not be negative")
+	t.Check(named("app", ResourceConfig{RestartBackoffMin: 60, RestartBackoffMax: 10}).validateRestartBackoff(), ErrorMatches,
+		`resource app: restart_backoff_max \(10s\) must not be smaller than restart_backoff_min \(1m0s\)`)
+}
+
+func (s *RestartBackoffSuite) TestNext(t *C) {
+	b := ResourceConfig{RestartBackoffMin: 2, RestartBackoffMax: 10, RestartResetAfter: 60, MaxRestarts: 5}.restartBackoff()
+
+	// the wait doubles up to the cap and lies between half the wait and the wait
+	for _, max := range []time.Duration{2, 4, 8, 10} {
+		wait, ok := b.next(time.Second)
+		t.Check(ok, Equals, true)
+		t.Check(wait >= max*time.Second/2 && wait <= max*time.Second, Equals, true, Commentf("wait %s, max %ds", wait, max))
+	}
+
+	// a long run resets the count
+	wait, ok := b.next(time.Minute)
+	t.Check(ok, Equals, true)
+	t.Check(b.restarts, Equals, 1)
+	t.Check(wait <= 2*time.Second, Equals, true)
+
+	for i := 0; i < 4; i++ {
+		_, ok = b.next(time.Second)
+		t.Check(ok, Equals, true)
+	}
+	_, ok = b.next(time.Second)
+	t.Check(ok, Equals, false)
+}
@@ -0,0 +1,423 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+// Package runner runs template resources, it is the engine of the remco command.
+//
+// A Runner creates the resources from their configuration, starts them once their dependencies are ready
+// and restarts the failed resources with an exponential backoff. It can be embedded into other programs:
+//
+//	r, err := runner.New(runner.Config{Resources: resources})
+//	if err != nil {
+//		return err
+//	}
+//	go r.Run(ctx)
+//	...
+//	err = r.Trigger("nginx")
+//	err = r.Pause("nginx")
+//	status := r.Status()
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/log"
+	"github.com/HeavyHorst/remco/pkg/template"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// The states of a resource, see ResourceStatus.
+const (
+	// StatePending is the state of a resource that waits for its dependencies or hasn't been started yet.
+	StatePending = "pending"
+	// StateRunning is the state of a resource whose templates are processed.
+	StateRunning = "running"
+	// StateRestarting is the state of a failed resource that waits for its restart.
+	StateRestarting = "restarting"
+	// StateStopped is the state of a resource that stopped without an error (e.g. in onetime mode) or has been canceled.
+	StateStopped = "stopped"
+	// StateFailed is the state of a resource that couldn't be created or gave up.
+	StateFailed = "failed"
+)
+
+// ResourceConfig is the configuration of a resource run by a Runner.
+type ResourceConfig struct {
+	template.ResourceConfig
+
+	// DependsOn lists the names of the resources that must be ready before this resource is started.
+	DependsOn []string
+	// DependsOnTimeout is the maximum time in seconds the resource waits for its dependencies. The default is 300.
+	DependsOnTimeout int
+	// DependsOnHealthy waits until the dependencies are healthy instead of ready.
+	DependsOnHealthy bool
+
+	// A failed resource is restarted after an exponential backoff between RestartBackoffMin (default 1)
+	// and RestartBackoffMax (default 300) seconds. The backoff is reset after the resource ran for
+	// RestartResetAfter (default 300) seconds. It is abandoned after MaxRestarts consecutive restarts (0 means unlimited).
+	RestartBackoffMin int
+	RestartBackoffMax int
+	RestartResetAfter int
+	MaxRestarts       int
+}
+
+// Config is the configuration of a Runner.
+type Config struct {
+	Resources []ResourceConfig
+
+	// ReapLock is held while the resources execute their commands, so that a zombie reaper
+	// doesn't reap the commands before their exit status is collected. It may be nil.
+	ReapLock *sync.RWMutex
+}
+
+// Validate checks the dependencies and the restart settings of the resources.
+// The resources themselves are validated when they are created, see template.ResourceConfig.Validate.
+func (c Config) Validate() error {
+	for _, r := range c.Resources {
+		if err := r.validateRestartBackoff(); err != nil {
+			return err
+		}
+	}
+	return validateDependencies(c.Resources)
+}
+
+// ResourceResult is the outcome of the last run of a resource.
+type ResourceResult struct {
+	Name    string
+	Changed bool
+	// Err is the error of the resource, see template.Resource.Err.
+	Err error
+}
+
+// ResourceStatus is the state of a resource returned by Runner.Status.
+type ResourceStatus struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+	// Restarts is the number of consecutive restarts of the failed resource.
+	Restarts int `json:"restarts"`
+	// LastRender is the most recent attempt to process the templates, nil if there was none.
+	LastRender *template.RenderEvent `json:"last_render,omitempty"`
+	// LastError is the error of the last render, or the error the resource failed with.
+	LastError string `json:"last_error,omitempty"`
+	Ready     bool   `json:"ready"`
+	Healthy   bool   `json:"healthy"`
+	// ChildPID is the PID of the child process, 0 if no child process is running.
+	ChildPID int                      `json:"child_pid,omitempty"`
+	Backends []template.BackendStatus `json:"backends,omitempty"`
+	// Paused is true while the resource is paused, see Runner.Pause.
+	Paused bool `json:"paused"`
+}
+
+// Runner runs the resources of a Config.
+type Runner struct {
+	config Config
+
+	// mu protects the states
+	mu     sync.RWMutex
+	states []*resourceState
+}
+
+// resourceState is the state of a resource of the Runner.
+type resourceState struct {
+	name     string
+	state    string
+	restarts int
+	// paused is applied to the resource whenever it is (re)created.
+	paused bool
+	// res is set while the resource exists.
+	res    *template.Resource
+	result *ResourceResult
+}
+
+// New returns a Runner for the resources of config. It returns an error if config is invalid.
+func New(config Config) (*Runner, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	r := &Runner{config: config}
+	for _, res := range config.Resources {
+		r.states = append(r.states, &resourceState{name: res.Name, state: StatePending})
+	}
+	return r, nil
+}
+
+// Run runs all resources until they have stopped (e.g. all backends are configured with onetime)
+// or ctx is canceled. Failed resources are restarted with an exponential backoff.
+// Run must only be called once.
+func (r *Runner) Run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the resources other resources depend on publish their readiness
+	gates := newReadinessGates(r.config.Resources)
+
+	var wg sync.WaitGroup
+	for i, rc := range r.config.Resources {
+		wg.Add(1)
+		go func(s *resourceState, rc ResourceConfig) {
+			defer wg.Done()
+			r.runResource(ctx, s, rc, gates)
+		}(r.states[i], rc)
+	}
+	wg.Wait()
+}
+
+// runResource creates and monitors the resource until it stops or ctx is canceled.
+func (r *Runner) runResource(ctx context.Context, s *resourceState, rc ResourceConfig, gates map[string]*readinessGate) {
+	logger := log.WithFields(logrus.Fields{"resource": rc.Name})
+
+	var res *template.Resource
+	if g, ok := gates[rc.Name]; ok {
+		defer func() { g.stop(res) }()
+	}
+
+	if err := waitForDependencies(ctx, rc, gates); err != nil {
+		if ctx.Err() != nil {
+			r.update(s, StateStopped, nil)
+			return
+		}
+		logger.Error(err)
+		r.setResult(s, StateFailed, ResourceResult{Name: rc.Name, Err: err})
+		return
+	}
+
+	res, err := template.NewResourceFromResourceConfig(ctx, r.config.ReapLock, rc.ResourceConfig)
+	if err != nil {
+		if ctx.Err() != nil {
+			r.update(s, StateStopped, nil)
+			return
+		}
+		log.Error(err)
+		r.setResult(s, StateFailed, ResourceResult{Name: rc.Name, Err: err})
+		return
+	}
+	defer res.Close()
+	r.attach(s, res)
+
+	if g, ok := gates[rc.Name]; ok {
+		go g.forward(ctx, res)
+	}
+
+	backoff := rc.restartBackoff()
+	for {
+		r.update(s, StateRunning, res)
+		started := time.Now()
+		res.Monitor(ctx)
+		result := ResourceResult{Name: rc.Name, Changed: res.Changed, Err: res.Err}
+		// a onetime resource whose templates couldn't be processed isn't restarted
+		if ctx.Err() != nil || !res.Failed || (res.Err != nil && res.Onetime()) {
+			state := StateStopped
+			if res.Failed {
+				state = StateFailed
+			}
+			r.setResult(s, state, result)
+			return
+		}
+
+		wait, ok := backoff.next(time.Since(started))
+		if !ok {
+			err := fmt.Errorf("resource execution failed, giving up after %d restarts", backoff.maxRestarts)
+			if res.Err != nil {
+				err = errors.Wrap(res.Err, err.Error())
+			}
+			logger.Error(err)
+			result.Err = err
+			r.setResult(s, StateFailed, result)
+			return
+		}
+		r.setResult(s, StateRestarting, result)
+		r.setRestarts(s, backoff.restarts)
+		logger.WithFields(logrus.Fields{
+			"restart":      backoff.restarts,
+			"next_attempt": time.Now().Add(wait).Format(time.RFC3339),
+		}).Error(fmt.Sprintf("resource execution failed, restarting after %s", wait.Round(time.Millisecond)))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			r.update(s, StateStopped, res)
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// update sets the state and the resource of s.
+func (r *Runner) update(s *resourceState, state string, res *template.Resource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s.state = state
+	s.res = res
+}
+
+// attach sets the new resource of s and pauses it if s is paused.
+func (r *Runner) attach(s *resourceState, res *template.Resource) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s.res = res
+	if s.paused {
+		res.Pause()
+	}
+}
+
+// setResult sets the state and the result of s.
+func (r *Runner) setResult(s *resourceState, state string, result ResourceResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s.state = state
+	s.result = &result
+}
+
+func (r *Runner) setRestarts(s *resourceState, restarts int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s.restarts = restarts
+}
+
+// Status returns the state of every resource in the order of the configuration.
+// It is safe to call Status while Run is running.
+func (r *Runner) Status() []ResourceStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	status := make([]ResourceStatus, 0, len(r.states))
+	for _, s := range r.states {
+		rs := ResourceStatus{Name: s.name, State: s.state, Restarts: s.restarts, Paused: s.paused}
+		if s.res != nil {
+			st := s.res.Status()
+			if e, ok := st.LastRender(); ok {
+				rs.LastRender = &e
+				rs.LastError = e.Error
+			}
+			rs.Ready = st.Ready
+			rs.Healthy = st.Healthy
+			rs.ChildPID = st.ChildPID
+			rs.Backends = st.Backends
+		}
+		if s.state == StateFailed && s.result != nil && s.result.Err != nil {
+			rs.LastError = s.result.Err.Error()
+		}
+		status = append(status, rs)
+	}
+	return status
+}
+
+// Trigger processes the templates of the running resources with the given name with the values of all
+// their backends, without reconnecting the backends (see template.Resource.ForceRender).
+// It returns an error if no resource with this name is running.
+func (r *Runner) Trigger(name string) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var found bool
+	for _, s := range r.states {
+		if s.name == name && s.state == StateRunning && s.res != nil {
+			s.res.ForceRender()
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("resource %q is not running", name)
+	}
+	return nil
+}
+
+// Pause pauses the resources with the given name, see template.Resource.Pause.
+// The resources stay paused if they are restarted. It returns an error if there is no resource with this name.
+func (r *Runner) Pause(name string) error {
+	return r.setPaused(name, true)
+}
+
+// Resume resumes the paused resources with the given name, see template.Resource.Resume.
+// It returns an error if there is no resource with this name.
+func (r *Runner) Resume(name string) error {
+	return r.setPaused(name, false)
+}
+
+func (r *Runner) setPaused(name string, paused bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var found bool
+	for _, s := range r.states {
+		if s.name != name {
+			continue
+		}
+		found = true
+		s.paused = paused
+		if s.res == nil {
+			continue
+		}
+		if paused {
+			s.res.Pause()
+		} else {
+			s.res.Resume()
+		}
+	}
+	if !found {
+		return fmt.Errorf("unknown resource %q", name)
+	}
+	return nil
+}
+
+// ForceRender triggers all running resources, see Trigger.
+func (r *Runner) ForceRender() {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, s := range r.states {
+		if s.state == StateRunning && s.res != nil {
+			s.res.ForceRender()
+		}
+	}
+}
+
+// SendSignal forwards the signal to the child processes of all running (or restarting) resources.
+// It doesn't block if a resource can't receive the signal right now, the signal is dropped for this resource.
+func (r *Runner) SendSignal(sig os.Signal) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, s := range r.states {
+		if s.res == nil || (s.state != StateRunning && s.state != StateRestarting) {
+			continue
+		}
+		select {
+		case s.res.SignalChan <- sig:
+		default:
+		}
+	}
+}
+
+// Results returns the outcome of the last run of every resource that ran, sorted by name.
+func (r *Runner) Results() []ResourceResult {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var results []ResourceResult
+	for _, s := range r.states {
+		if s.result != nil {
+			results = append(results, *s.result)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Name < results[j].Name
+	})
+	return results
+}
+
+// Changed reports whether at least one template of any resource has been changed.
+func (r *Runner) Changed() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, s := range r.states {
+		if s.result != nil && s.result.Changed {
+			return true
+		}
+	}
+	return false
+}
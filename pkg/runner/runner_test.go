@@ -0,0 +1,199 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/backends"
+	"github.com/HeavyHorst/remco/pkg/template"
+
+	. "gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { TestingT(t) }
+
+type RunnerSuite struct {
+	dir string
+}
+
+var _ = Suite(&RunnerSuite{})
+
+func (s *RunnerSuite) SetUpTest(t *C) {
+	s.dir = t.MkDir()
+	err := ioutil.WriteFile(filepath.Join(s.dir, "src"), []byte(`{{ getv("/foo", "bar") }}`), 0644)
+	t.Assert(err, IsNil)
+}
+
+// resource returns the configuration of a resource with a mock backend.
+func (s *RunnerSuite) resource(name string, onetime bool, backendErr error) ResourceConfig {
+	mock := &backends.MockConfig{
+		Error:   backendErr,
+		Backend: template.Backend{Keys: []string{"/"}, Onetime: onetime, Interval: 60},
+	}
+	return named(name, ResourceConfig{ResourceConfig: template.ResourceConfig{
+		Template:   []*template.Renderer{{Src: filepath.Join(s.dir, "src"), Dst: filepath.Join(s.dir, name)}},
+		Connectors: []template.BackendConnector{mock},
+	}})
+}
+
+// eventually waits until cond returns true.
+func eventually(t *C, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (s *RunnerSuite) TestNewInvalid(t *C) {
+	_, err := New(Config{Resources: []ResourceConfig{dependent("app", "db")}})
+	t.Check(err, ErrorMatches, `resource app: depends on unknown resource "db"`)
+}
+
+func (s *RunnerSuite) TestRunOnetime(t *C) {
+	r, err := New(Config{Resources: []ResourceConfig{s.resource("app", true, nil), s.resource("db", true, nil)}})
+	t.Assert(err, IsNil)
+	for _, st := range r.Status() {
+		t.Check(st.State, Equals, StatePending)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r.Run(ctx)
+	t.Assert(ctx.Err(), IsNil)
+
+	t.Check(r.Changed(), Equals, true)
+	t.Check(r.Results(), DeepEquals, []ResourceResult{{Name: "app", Changed: true}, {Name: "db", Changed: true}})
+	status := r.Status()
+	t.Assert(status, HasLen, 2)
+	t.Check(status[0].Name, Equals, "app")
+	t.Check(status[0].State, Equals, StateStopped)
+	t.Check(status[0].Ready, Equals, true)
+	t.Assert(status[0].LastRender, NotNil)
+	t.Check(status[0].LastRender.Trigger, Equals, template.TriggerStartup)
+	t.Check(status[0].LastRender.Changed, Equals, true)
+	t.Assert(status[0].Backends, HasLen, 1)
+	t.Check(status[0].Backends[0].Name, Equals, "mock")
+	t.Check(status[0].Backends[0].LastFetch.IsZero(), Equals, false)
+}
+
+func (s *RunnerSuite) TestRunFailed(t *C) {
+	r, err := New(Config{Resources: []ResourceConfig{s.resource("app", true, fmt.Errorf("connection refused"))}})
+	t.Assert(err, IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	r.Run(ctx)
+	t.Assert(ctx.Err(), IsNil)
+
+	results := r.Results()
+	t.Assert(results, HasLen, 1)
+	t.Check(template.FailureOf(results[0].Err), Equals, template.FailureBackend)
+	status := r.Status()[0]
+	t.Check(status.State, Equals, StateFailed)
+	t.Check(status.LastError, Matches, ".*connection refused.*")
+	t.Assert(status.Backends, HasLen, 1)
+	t.Check(status.Backends[0].Error, Matches, ".*connection refused.*")
+	t.Check(status.Backends[0].LastFetch.IsZero(), Equals, true)
+}
+
+func (s *RunnerSuite) TestStatusAndTrigger(t *C) {
+	app := s.resource("app", false, nil)
+	app.Exec.Command = "sleep 60"
+	r, err := New(Config{Resources: []ResourceConfig{app}})
+	t.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+	eventually(t, func() bool {
+		st := r.Status()[0]
+		return st.State == StateRunning && st.Ready && st.ChildPID > 0
+	})
+
+	t.Check(r.Trigger("db"), ErrorMatches, `resource "db" is not running`)
+	t.Assert(r.Trigger("app"), IsNil)
+	eventually(t, func() bool {
+		e := r.Status()[0].LastRender
+		return e != nil && e.Trigger == template.TriggerForced
+	})
+
+	cancel()
+	<-done
+	status := r.Status()[0]
+	t.Check(status.State, Equals, StateStopped)
+	t.Check(status.ChildPID, Equals, 0)
+	t.Check(r.Trigger("app"), NotNil)
+	_, err = os.Stat(filepath.Join(s.dir, "app"))
+	t.Check(err, IsNil)
+}
+
+func (s *RunnerSuite) TestSendSignal(t *C) {
+	r, err := New(Config{Resources: []ResourceConfig{s.resource("app", false, nil)}})
+	t.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+	eventually(t, func() bool { return r.Status()[0].Ready })
+
+	// the signals don't block, even if the resource can't receive them right now
+	for i := 0; i < 3; i++ {
+		r.SendSignal(os.Interrupt)
+	}
+	cancel()
+	<-done
+	r.SendSignal(os.Interrupt)
+}
+
+func (s *RunnerSuite) TestPauseResume(t *C) {
+	r, err := New(Config{Resources: []ResourceConfig{s.resource("app", false, nil)}})
+	t.Assert(err, IsNil)
+	t.Check(r.Pause("db"), ErrorMatches, `unknown resource "db"`)
+	// the resource is paused before it is created
+	t.Assert(r.Pause("app"), IsNil)
+	t.Check(r.Status()[0].Paused, Equals, true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.Run(ctx)
+		close(done)
+	}()
+	eventually(t, func() bool { return r.Status()[0].State == StateRunning })
+	time.Sleep(100 * time.Millisecond)
+	t.Check(r.Status()[0].Ready, Equals, false)
+	_, err = os.Stat(filepath.Join(s.dir, "app"))
+	t.Check(os.IsNotExist(err), Equals, true)
+
+	t.Assert(r.Resume("app"), IsNil)
+	eventually(t, func() bool { return r.Status()[0].Ready })
+	t.Check(r.Status()[0].Paused, Equals, false)
+	_, err = os.Stat(filepath.Join(s.dir, "app"))
+	t.Check(err, IsNil)
+
+	cancel()
+	<-done
+}
@@ -10,6 +10,7 @@ package template
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/HeavyHorst/easykv"
@@ -29,6 +30,96 @@ type BackendConnector interface {
 	Connect() (Backend, error)
 }
 
+// A BackendDescriber is a BackendConnector that knows the type and the name of its backend without connecting to it.
+// All backends of remco implement it.
+type BackendDescriber interface {
+	// BackendType returns the type of the backend as in the configuration file, e.g. "etcd" or "consul".
+	BackendType() string
+	// BackendName returns the name the connected backend is logged with, e.g. "etcdv3".
+	BackendName() string
+}
+
+// BackendConnectors is a list of BackendConnectors. Unconfigured connectors (nil pointers) are skipped by its methods.
+type BackendConnectors []BackendConnector
+
+// Count returns the number of configured connectors.
+func (bc BackendConnectors) Count() int {
+	var n int
+	for _, c := range bc {
+		if configured(c) {
+			n++
+		}
+	}
+	return n
+}
+
+// Names returns the backend names of the configured connectors, without connecting to them.
+// It is "unknown" for connectors that don't implement BackendDescriber.
+func (bc BackendConnectors) Names() []string {
+	return bc.describe(BackendDescriber.BackendName)
+}
+
+// Types returns the backend types of the configured connectors, without connecting to them.
+// It is "unknown" for connectors that don't implement BackendDescriber.
+func (bc BackendConnectors) Types() []string {
+	return bc.describe(BackendDescriber.BackendType)
+}
+
+// Check connects to every configured backend once, reads its keys and closes the connection again.
+// Unlike NewResourceFromResourceConfig it doesn't retry, it returns the errors of all unreachable backends.
+func (bc BackendConnectors) Check(ctx context.Context) []error {
+	var errs []error
+	for _, c := range bc {
+		if !configured(c) {
+			continue
+		}
+		b, err := c.Connect()
+		if err == berr.ErrNilConfig {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, errors.Wrapf(err, "backend %s: connect failed", b.Name))
+			continue
+		}
+		if b.Timeout <= 0 {
+			b.Timeout = 30
+		}
+		if _, err := b.getValues(ctx, appendPrefix(b.Prefix, b.Keys)); err != nil {
+			errs = append(errs, errors.Wrapf(err, "backend %s: reading the keys failed", b.Name))
+		}
+		b.Close()
+	}
+	return errs
+}
+
+func (bc BackendConnectors) describe(f func(BackendDescriber) string) []string {
+	result := make([]string, 0, len(bc))
+	for _, c := range bc {
+		if !configured(c) {
+			continue
+		}
+		if d, ok := c.(BackendDescriber); ok {
+			result = append(result, f(d))
+		} else {
+			result = append(result, "unknown")
+		}
+	}
+	return result
+}
+
+// ContextReader is implemented by backend clients whose reads can be canceled.
+// GetValuesContext is used instead of GetValues if the ReadWatcher of a Backend implements it,
+// it must return once ctx is done.
+type ContextReader interface {
+	GetValuesContext(ctx context.Context, keys []string) (map[string]string, error)
+}
+
+// EndpointSwitcher is implemented by backend clients that switch between sets of endpoints, like etcd with failover_endpoints.
+// Active returns the name of the set in use, it is reported in the status of the backend.
+type EndpointSwitcher interface {
+	Active() string
+}
+
 // Backend is the representation of a template backend like etcd or consul
 type Backend struct {
 	easykv.ReadWatcher
@@ -49,50 +140,150 @@ type Backend struct {
 	// The backend polling interval. Can be used as a reconciliation loop for watch or standalone.
 	Interval int
 
+	// Schedule is a cron expression (see ParseSchedule) that triggers the backend polling as an alternative to Interval.
+	Schedule string
+
 	// The backend keys that the template requires to be rendered correctly.
 	Keys []string
 
+	// Timeout is the maximum amount of time in seconds a single GetValues call may take.
+	// It also bounds the reconnection attempts of a failed watch, see watch.
+	// The WatchPrefix calls themselves aren't bounded, they block until a change happens.
+	// The default is 30.
+	Timeout int `toml:"timeout" json:"timeout"`
+
+	// MaxKeys is the maximum number of keys a fetch may return, e.g. to guard against a too broad prefix.
+	// A fetch that returns more keys fails and the keys aren't loaded into the store. 0 means no limit.
+	MaxKeys int `toml:"max_keys" json:"max_keys"`
+
+	// Sensitive redacts the arguments of template functions in error messages.
+	// Backends that hold secrets (e.g. vault) enable it in Connect.
+	Sensitive bool
+
+	// OnChange is called for every key (without the prefix) that changed since the last fetch of the backend,
+	// after the key has been updated in the store of the backend and before the templates are rendered.
+	// oldValue is empty for an added key and newValue for a removed key, the first fetch reports all keys as added.
+	// It is called synchronously by the fetch (concurrently for different backends if parallel_backends is enabled),
+	// so it should return quickly. It can only be set if remco is used as a library.
+	OnChange func(key, oldValue, newValue string)
+
 	store *memkv.Store
+
+	// cron is the parsed Schedule.
+	cron *CronSchedule
+
+	// watched is set on the copies of the backend that watch sends to the Monitor loop.
+	watched bool
 }
 
-// connectAllBackends connects to all configured backends.
+// connectAllBackends connects to all configured backends concurrently.
 // This method blocks until a connection to every backend has been established or the context is canceled.
+// The backends are returned in the order of the connectors.
+// If the context is canceled all established connections are closed.
 func connectAllBackends(ctx context.Context, bc []BackendConnector) ([]Backend, error) {
+	type result struct {
+		backend Backend
+		ok      bool
+	}
+	results := make([]result, len(bc))
+
+	var wg sync.WaitGroup
+	for i, config := range bc {
+		wg.Add(1)
+		go func(i int, config BackendConnector) {
+			defer wg.Done()
+			b, ok := connectBackend(ctx, config)
+			results[i] = result{b, ok}
+		}(i, config)
+	}
+	wg.Wait()
+
 	var backendList []Backend
-	for _, config := range bc {
-	retryloop:
-		for {
-			select {
-			case <-ctx.Done():
-				for _, be := range backendList {
-					be.Close()
-				}
-				return backendList, ctx.Err()
-			default:
-				b, err := config.Connect()
-				if err == nil {
-					backendList = append(backendList, b)
-				} else if err != berr.ErrNilConfig {
-					log.WithFields(logrus.Fields{
-						"backend": b.Name,
-					}).Error(errors.Wrap(err, "connect failed"))
-
-					//try again after 2 seconds
-					time.Sleep(2 * time.Second)
-					continue retryloop
-				}
-				break retryloop
-			}
+	for _, r := range results {
+		if r.ok {
+			backendList = append(backendList, r.backend)
 		}
 	}
 
+	if err := ctx.Err(); err != nil {
+		for _, be := range backendList {
+			be.Close()
+		}
+		return nil, err
+	}
 	return backendList, nil
 }
 
+// connectBackend connects to a single backend and retries every 2 seconds until
+// the connection has been established or the context is canceled.
+// It returns false if the connector isn't configured or the context is canceled.
+func connectBackend(ctx context.Context, config BackendConnector) (Backend, bool) {
+	for {
+		b, err := config.Connect()
+		if err == nil {
+			return b, true
+		}
+		if err == berr.ErrNilConfig {
+			return b, false
+		}
+		log.WithFields(logrus.Fields{
+			"backend": b.Name,
+		}).Error(errors.Wrap(err, "connect failed"))
+
+		//try again after 2 seconds
+		select {
+		case <-ctx.Done():
+			return b, false
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+// getValues calls GetValuesContext (or GetValues) and returns a berr.TimeoutError if the call doesn't
+// return within the configured timeout. The call is canceled with ctx if the client is a ContextReader.
+func (s Backend) getValues(ctx context.Context, keys []string) (map[string]string, error) {
+	type result struct {
+		values map[string]string
+		err    error
+	}
+
+	timeout := time.Duration(s.Timeout) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// a GetValues call can't be canceled, it is abandoned once ctx is done
+	// the result channel is buffered so that the goroutine can always finish
+	c := make(chan result, 1)
+	go func() {
+		var values map[string]string
+		var err error
+		if r, ok := s.ReadWatcher.(ContextReader); ok {
+			values, err = r.GetValuesContext(ctx, keys)
+		} else {
+			values, err = s.GetValues(keys)
+		}
+		c <- result{values, err}
+	}()
+
+	select {
+	case r := <-c:
+		return r.values, r.err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, berr.TimeoutError{Backend: s.Name, Timeout: timeout}
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// watch calls WatchPrefix in a loop and sends the backend to processChan whenever it returns.
+// After a failed watch the backend is probed (within Timeout) every 2 seconds until it responds,
+// only then the values are re-fetched and the watch is resumed, so that a hung backend doesn't block the watch forever.
 func (s Backend) watch(ctx context.Context, processChan chan Backend, errChan chan berr.BackendError) {
 	if s.Onetime {
 		return
 	}
+	s.watched = true
 
 	var lastIndex uint64
 	keysPrefix := appendPrefix(s.Prefix, s.Keys)
@@ -105,6 +296,13 @@ func (s Backend) watch(ctx context.Context, processChan chan Backend, errChan ch
 			return
 		default:
 			if backendError {
+				if err := s.probe(ctx); err != nil {
+					if ctx.Err() == nil {
+						errChan <- berr.BackendError{Message: errors.Wrap(err, "reconnect failed").Error(), Backend: s.Name}
+						time.Sleep(2 * time.Second)
+					}
+					continue
+				}
 				processChan <- s
 				backendError = false
 			}
@@ -0,0 +1,83 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"fmt"
+	"strings"
+
+	berr "github.com/HeavyHorst/remco/pkg/backends/error"
+	"github.com/sirupsen/logrus"
+)
+
+// BackendErrors is returned by the processing of the templates if more than one backend failed.
+// Unless RequireAllBackends is set, the templates have been processed with the data of the other backends
+// and the previous data of the failed ones.
+type BackendErrors []error
+
+func (e BackendErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		if name := backendOf(err); name != "" {
+			msgs[i] = name + ": " + err.Error()
+		} else {
+			msgs[i] = err.Error()
+		}
+	}
+	return fmt.Sprintf("%d backends failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Backends returns the names of the failed backends.
+func (e BackendErrors) Backends() []string {
+	var names []string
+	for _, err := range e {
+		if name := backendOf(err); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// backendOf returns the name of the backend of a backend or timeout error.
+func backendOf(err error) string {
+	switch err := err.(type) {
+	case berr.BackendError:
+		return err.Backend
+	case berr.TimeoutError:
+		return err.Backend
+	}
+	return ""
+}
+
+// joinBackendErrors returns nil, the only error or BackendErrors.
+func joinBackendErrors(errs []error) error {
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return BackendErrors(errs)
+}
+
+// logProcessError logs the error of a processing of the templates with the names of the failed backends.
+func logProcessError(logger *logrus.Entry, err error) {
+	switch err := err.(type) {
+	case BackendErrors:
+		for _, e := range err {
+			logProcessError(logger, e)
+		}
+	case berr.BackendError:
+		logger.WithField("backend", err.Backend).Error(err)
+	case berr.TimeoutError:
+		logger.WithField("backend", err.Backend).Error(err)
+	default:
+		logger.Error(err)
+	}
+}
@@ -0,0 +1,230 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+	"github.com/HeavyHorst/easykv/mock"
+	berr "github.com/HeavyHorst/remco/pkg/backends/error"
+)
+
+// closeRecorder records Close calls of the mock client.
+type closeRecorder struct {
+	*mock.Client
+	closed *int32
+}
+
+func (c closeRecorder) Close() {
+	atomic.AddInt32(c.closed, 1)
+}
+
+// slowConnector blocks in Connect until all connectors of the barrier are connecting
+// or the delay has passed.
+type slowConnector struct {
+	name    string
+	delay   time.Duration
+	fail    bool
+	barrier *sync.WaitGroup
+	closed  *int32
+}
+
+func (c *slowConnector) Connect() (Backend, error) {
+	if c.barrier != nil {
+		c.barrier.Done()
+		done := make(chan struct{})
+		go func() {
+			c.barrier.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(c.delay):
+			return Backend{Name: c.name}, fmt.Errorf("connections are not concurrent")
+		}
+	} else {
+		time.Sleep(c.delay)
+	}
+	if c.fail {
+		return Backend{Name: c.name}, fmt.Errorf("connection refused")
+	}
+	client, _ := mock.New(nil, nil)
+	return Backend{Name: c.name, ReadWatcher: closeRecorder{client, c.closed}}, nil
+}
+
+func TestConnectAllBackendsConcurrently(t *testing.T) {
+	const n = 5
+	var barrier sync.WaitGroup
+	barrier.Add(n)
+
+	var closed int32
+	var connectors []BackendConnector
+	for i := 0; i < n; i++ {
+		connectors = append(connectors, &slowConnector{
+			name:    fmt.Sprintf("backend%d", i),
+			delay:   time.Second,
+			barrier: &barrier,
+			closed:  &closed,
+		})
+	}
+
+	// sequential connections fail and are retried until the context is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	backends, err := connectAllBackends(ctx, connectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backends) != n {
+		t.Fatalf("%d backends should be connected, got %d", n, len(backends))
+	}
+	for i, b := range backends {
+		if expected := fmt.Sprintf("backend%d", i); b.Name != expected {
+			t.Errorf("backend %d should be %s, got %s", i, expected, b.Name)
+		}
+	}
+}
+
+func TestConnectAllBackendsCanceled(t *testing.T) {
+	var closed int32
+	connectors := []BackendConnector{
+		&slowConnector{name: "ok1", closed: &closed},
+		&slowConnector{name: "ok2", closed: &closed},
+		&slowConnector{name: "failing", fail: true, closed: &closed},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	backends, err := connectAllBackends(ctx, connectors)
+	if err != context.DeadlineExceeded {
+		t.Errorf("connectAllBackends should return the context error, got %v", err)
+	}
+	if len(backends) != 0 {
+		t.Errorf("no backends should be returned, got %d", len(backends))
+	}
+	if c := atomic.LoadInt32(&closed); c != 2 {
+		t.Errorf("all established connections should be closed, got %d", c)
+	}
+}
+
+func BenchmarkConnectAllBackends(b *testing.B) {
+	var closed int32
+	var connectors []BackendConnector
+	for i := 0; i < 10; i++ {
+		connectors = append(connectors, &slowConnector{
+			name:   fmt.Sprintf("backend%d", i),
+			delay:  10 * time.Millisecond,
+			closed: &closed,
+		})
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := connectAllBackends(context.Background(), connectors); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// contextReader blocks in GetValuesContext until ctx is done.
+type contextReader struct {
+	*mock.Client
+	canceled chan struct{}
+}
+
+func (c contextReader) GetValuesContext(ctx context.Context, keys []string) (map[string]string, error) {
+	<-ctx.Done()
+	close(c.canceled)
+	return nil, ctx.Err()
+}
+
+func TestGetValuesContext(t *testing.T) {
+	client, _ := mock.New(nil, nil)
+	r := contextReader{client, make(chan struct{})}
+	b := Backend{Name: "blocking", ReadWatcher: r, Timeout: 30}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := b.getValues(ctx, []string{"/"}); err != context.Canceled {
+		t.Errorf("expected the call to be canceled, got %v", err)
+	}
+	select {
+	case <-r.canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the in-flight call wasn't canceled")
+	}
+}
+
+// hangingClient fails the first watch, its reads block until answer is closed.
+type hangingClient struct {
+	*mock.Client
+	answer  chan struct{}
+	watches int32
+}
+
+func (c *hangingClient) GetValues(keys []string) (map[string]string, error) {
+	<-c.answer
+	return c.Client.GetValues(keys)
+}
+
+func (c *hangingClient) WatchPrefix(ctx context.Context, prefix string, opts ...easykv.WatchOption) (uint64, error) {
+	if atomic.AddInt32(&c.watches, 1) == 1 {
+		return 0, fmt.Errorf("connection reset")
+	}
+	<-ctx.Done()
+	return 0, easykv.ErrWatchCanceled
+}
+
+func TestWatchReconnectTimeout(t *testing.T) {
+	client, _ := mock.New(nil, map[string]string{"/a": "1"})
+	c := &hangingClient{Client: client, answer: make(chan struct{})}
+	b := Backend{Name: "hanging", ReadWatcher: c, Keys: []string{"/a"}, Timeout: 1}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	processChan := make(chan Backend, 1)
+	errChan := make(chan berr.BackendError, 10)
+	go b.watch(ctx, processChan, errChan)
+
+	expectErr := func(substr string) {
+		t.Helper()
+		select {
+		case err := <-errChan:
+			if !strings.Contains(err.Message, substr) {
+				t.Errorf("expected an error containing %q, got %q", substr, err.Message)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected an error containing %q", substr)
+		}
+	}
+	expectErr("connection reset")
+	// the reconnection attempt times out, the watch isn't resumed
+	expectErr("reconnect failed")
+	select {
+	case <-processChan:
+		t.Fatal("the watch shouldn't be resumed before the backend responds")
+	default:
+	}
+
+	close(c.answer)
+	select {
+	case <-processChan:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watch should be resumed once the backend responds")
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/HeavyHorst/pongo2"
+	"github.com/pkg/errors"
+)
+
+// baseTemplateLoader loads templates from the local filesystem like pongo2.LocalFilesystemLoader,
+// but src is loaded as if it started with {% extends base %}.
+// A relative base is resolved against the directory of src, like the paths of extends and include tags.
+type baseTemplateLoader struct {
+	*pongo2.LocalFilesystemLoader
+	src  string
+	base string
+}
+
+// newTemplateLoader returns the loader for src, it extends base if base isn't empty.
+func newTemplateLoader(src, base string) pongo2.TemplateLoader {
+	loader := &pongo2.LocalFilesystemLoader{}
+	if base == "" {
+		return loader
+	}
+	return &baseTemplateLoader{
+		LocalFilesystemLoader: loader,
+		src:                   loader.Abs("", src),
+		base:                  base,
+	}
+}
+
+// Get returns the content of the template at path, the content of src is prefixed with the extends tag.
+// The tag doesn't end with a newline, so that the line numbers of src stay the same.
+func (l *baseTemplateLoader) Get(path string) (io.Reader, error) {
+	r, err := l.LocalFilesystemLoader.Get(path)
+	if err != nil || path != l.src {
+		return r, err
+	}
+	return io.MultiReader(strings.NewReader(fmt.Sprintf("{%% extends %q %%}", l.base)), r), nil
+}
+
+// basePath returns the path of the base template of the Renderer, resolved against the directory of src.
+func (s *Renderer) basePath(src string) string {
+	if s.BaseTemplate == "" || filepath.IsAbs(s.BaseTemplate) {
+		return s.BaseTemplate
+	}
+	return filepath.Join(filepath.Dir(src), s.BaseTemplate)
+}
+
+// baseTemplateError adds the paths of src and its base template to err if the Renderer has a base template.
+func (s *Renderer) baseTemplateError(src string, err error) error {
+	if s.BaseTemplate == "" {
+		return err
+	}
+	return errors.Wrapf(err, "%s (base template %s)", src, s.basePath(src))
+}
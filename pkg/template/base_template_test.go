@@ -0,0 +1,134 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/HeavyHorst/memkv"
+)
+
+// writeTemplates writes the templates (name -> content) to a new temporary directory.
+func writeTemplates(t *testing.T, templates map[string]string) string {
+	dir, err := ioutil.TempDir("", "remco-base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range templates {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestBaseTemplate(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"layout.tmpl": "# generated by remco\n{% block body %}{% endblock %}\n# end",
+		// the base template extends another template itself
+		"service.tmpl": `{% extends "layout.tmpl" %}{% block body %}[{{ name }}]
+{% block content %}default{% endblock %}{% endblock %}`,
+		"web.tmpl":   `{% block content %}port = {{ port }}{% endblock %}`,
+		"empty.tmpl": `no blocks`,
+	})
+	defer os.RemoveAll(dir)
+
+	funcMap := map[string]interface{}{"name": "web", "port": 8080}
+	tests := []struct {
+		src, base, expected string
+	}{
+		{"web.tmpl", "service.tmpl", "# generated by remco\n[web]\nport = 8080\n# end"},
+		{"web.tmpl", filepath.Join(dir, "service.tmpl"), "# generated by remco\n[web]\nport = 8080\n# end"},
+		// content outside of the blocks is ignored
+		{"empty.tmpl", "service.tmpl", "# generated by remco\n[web]\ndefault\n# end"},
+		{"web.tmpl", "", "port = 8080"},
+	}
+	for _, test := range tests {
+		r := &Renderer{Src: filepath.Join(dir, test.src), BaseTemplate: test.base, logger: newTestLogger()}
+		var buf bytes.Buffer
+		if err := r.render(r.Src, funcMap, &buf); err != nil {
+			t.Errorf("%s (base %s): %v", test.src, test.base, err)
+			continue
+		}
+		if strings.TrimSpace(buf.String()) != test.expected {
+			t.Errorf("%s (base %s): expected %q, got %q", test.src, test.base, test.expected, buf.String())
+		}
+	}
+}
+
+func TestBaseTemplateError(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"base.tmpl": "line1\n{% block content %}{% endblock %}\n{{ fail() }}",
+		"src.tmpl":  `{% block content %}ok{% endblock %}`,
+	})
+	defer os.RemoveAll(dir)
+
+	funcMap := map[string]interface{}{"fail": func() (string, error) { return "", os.ErrNotExist }}
+	r := &Renderer{Src: filepath.Join(dir, "src.tmpl"), Dst: filepath.Join(dir, "dst"), BaseTemplate: "base.tmpl", logger: newTestLogger()}
+	err := r.render(r.Src, funcMap, ioutil.Discard)
+	if err == nil {
+		t.Fatal("the render should fail")
+	}
+	msg := err.Error()
+	for _, expected := range []string{r.Src, filepath.Join(dir, "base.tmpl") + ":3:", "base template " + filepath.Join(dir, "base.tmpl")} {
+		if !strings.Contains(msg, expected) {
+			t.Errorf("the error should contain %q, got %q", expected, msg)
+		}
+	}
+	if FailureOf(r.createStageFile(context.Background(), funcMap)) != FailureRender {
+		t.Error("a failing base template is a render failure")
+	}
+
+	r.BaseTemplate = "missing.tmpl"
+	if err := r.render(r.Src, funcMap, ioutil.Discard); err == nil || !strings.Contains(err.Error(), "missing.tmpl") {
+		t.Errorf("a missing base template should fail, got %v", err)
+	}
+}
+
+func TestBaseTemplateValidate(t *testing.T) {
+	f := false
+	r := &Renderer{Src: "src", BaseTemplate: "base", Template: &f}
+	if err := r.validate(); err == nil {
+		t.Error("base_template can't be used with template = false")
+	}
+}
+
+func TestBaseTemplateFanOut(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"base.tmpl":    "[{% block content %}{% endblock %}]",
+		"service.tmpl": `{% block content %}{{ name }}:{{ values.port }}{% endblock %}`,
+	})
+	defer os.RemoveAll(dir)
+
+	store := memkv.New()
+	store.Set("/services/a/port", "80")
+	r := &Renderer{
+		Src:          filepath.Join(dir, "service.tmpl"),
+		Dst:          filepath.Join(dir, "{{.name}}.conf"),
+		Iterate:      "/services/*",
+		BaseTemplate: "base.tmpl",
+		logger:       newTestLogger(),
+	}
+	if _, err := r.fanOut(context.Background(), map[string]interface{}{}, store, false); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "a.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[a:80]" {
+		t.Errorf("expected [a:80], got %q", data)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+	"github.com/pkg/errors"
+)
+
+// BenchResult holds the durations measured by Bench.
+type BenchResult struct {
+	// Process holds the duration of every run over all templates.
+	Process []time.Duration
+
+	// Templates holds the durations of every run of the individual templates, in configuration order.
+	Templates []TemplateBenchResult
+}
+
+// TemplateBenchResult holds the durations of a single template.
+type TemplateBenchResult struct {
+	// Dst is the configured destination of the template.
+	Dst       string
+	Durations []time.Duration
+}
+
+// snapshotClient is a StoreClient that serves a fixed set of KV-Pairs.
+type snapshotClient map[string]string
+
+func (c snapshotClient) GetValues(keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for k, v := range c {
+		for _, prefix := range keys {
+			if strings.HasPrefix(k, prefix) {
+				vars[k] = v
+				break
+			}
+		}
+	}
+	return vars, nil
+}
+
+func (c snapshotClient) WatchPrefix(ctx context.Context, prefix string, opts ...easykv.WatchOption) (uint64, error) {
+	return 0, easykv.ErrWatchNotSupported
+}
+
+func (c snapshotClient) Close() {}
+
+// snapshotBackends fetches the KV-Pairs of all backends once
+// and replaces the backend clients with clients that serve this snapshot.
+func (t *Resource) snapshotBackends(ctx context.Context) error {
+	for i, b := range t.backends {
+		values, err := b.getValues(ctx, appendPrefix(b.Prefix, b.Keys))
+		if err != nil {
+			return errors.Wrapf(err, "fetching the snapshot of %s failed", b.Name)
+		}
+		b.Close()
+		t.backends[i].ReadWatcher = snapshotClient(values)
+	}
+	return nil
+}
+
+// benchClone returns a copy of the Renderer that renders to a file in dir and never runs any commands.
+func (s *Renderer) benchClone(dir string, i int) *Renderer {
+	c := *s
+	c.Dst = filepath.Join(dir, strconv.Itoa(i), filepath.Base(s.Dst))
+	c.MkDirs = true
+	c.CheckCmd = ""
+	c.ReloadCmd = ""
+	c.ReloadHTTP = nil
+	c.UID = os.Getuid()
+	c.GID = os.Getgid()
+	c.fanout = nil
+	return &c
+}
+
+// Bench renders the templates of the resource iterations times and measures the durations.
+// The backends are fetched only once at the start, every run is processed against this snapshot,
+// so only the rendering overhead (including the change detection) is measured.
+// The templates are rendered to a temporary directory, the destinations of the configuration
+// are never touched and no check, reload or exec commands are executed.
+func Bench(ctx context.Context, r ResourceConfig, iterations int) (BenchResult, error) {
+	var result BenchResult
+
+	dir, err := ioutil.TempDir("", "remco-bench")
+	if err != nil {
+		return result, errors.Wrap(err, "couldn't create the temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	clones := make([]*Renderer, len(r.Template))
+	index := make(map[*Renderer]int, len(r.Template))
+	for i, s := range r.Template {
+		clones[i] = s.benchClone(dir, i)
+		index[clones[i]] = i
+		result.Templates = append(result.Templates, TemplateBenchResult{Dst: s.Dst})
+	}
+	r.Template = clones
+	r.Exec = ExecConfig{}
+	r.StartCmd = ""
+	r.Reload = ReloadConfig{}
+
+	res, err := NewResourceFromResourceConfig(ctx, &sync.RWMutex{}, r)
+	if err != nil {
+		return result, err
+	}
+	defer res.Close()
+
+	if err := res.snapshotBackends(ctx); err != nil {
+		return result, err
+	}
+
+	res.renderObserver = func(s *Renderer, d time.Duration) {
+		i := index[s]
+		result.Templates[i].Durations = append(result.Templates[i].Durations, d)
+	}
+
+	for i := 0; i < iterations; i++ {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		start := time.Now()
+		if _, err := res.process(ctx, res.backends, false); err != nil {
+			return result, err
+		}
+		result.Process = append(result.Process, time.Since(start))
+	}
+	return result, nil
+}
@@ -0,0 +1,86 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// countingConnector connects to a snapshotClient and counts the GetValues calls.
+type countingConnector struct {
+	values map[string]string
+	calls  *int32
+}
+
+type countingClient struct {
+	snapshotClient
+	calls *int32
+}
+
+func (c countingClient) GetValues(keys []string) (map[string]string, error) {
+	atomic.AddInt32(c.calls, 1)
+	return c.snapshotClient.GetValues(keys)
+}
+
+func (c countingConnector) Connect() (Backend, error) {
+	return Backend{
+		Name:        "counting",
+		ReadWatcher: countingClient{snapshotClient(c.values), c.calls},
+		Keys:        []string{"/"},
+		Onetime:     true,
+	}, nil
+}
+
+func TestBench(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-bench-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "app.tmpl")
+	dst := filepath.Join(dir, "app.conf")
+	if err := ioutil.WriteFile(src, []byte(`port={{ getv("/port") }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int32
+	result, err := Bench(context.Background(), ResourceConfig{
+		Name: "bench",
+		Template: []*Renderer{{
+			Src:       src,
+			Dst:       dst,
+			ReloadCmd: "touch " + filepath.Join(dir, "reloaded"),
+		}},
+		Connectors: []BackendConnector{countingConnector{map[string]string{"/port": "8080"}, &calls}},
+	}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Process) != 10 {
+		t.Errorf("10 runs should be measured, got %d", len(result.Process))
+	}
+	if len(result.Templates) != 1 || result.Templates[0].Dst != dst || len(result.Templates[0].Durations) != 10 {
+		t.Errorf("10 runs of %s should be measured, got %+v", dst, result.Templates)
+	}
+	if calls != 1 {
+		t.Errorf("the backend should be fetched once, got %d calls", calls)
+	}
+	for _, f := range []string{dst, filepath.Join(dir, "reloaded")} {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("%s should not be created", f)
+		}
+	}
+}
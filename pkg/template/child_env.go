@@ -0,0 +1,151 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/HeavyHorst/pongo2"
+	"github.com/pkg/errors"
+)
+
+// Valid values of ExecConfig.EnvChange.
+const (
+	EnvChangeReloadSignal = "reload_signal"
+	EnvChangeRestart      = "restart"
+)
+
+// mergeEnv returns environ (a list of key=value pairs) with the values of env replaced or appended.
+func mergeEnv(environ []string, env map[string]string) []string {
+	merged := make([]string, 0, len(environ)+len(env))
+	for _, kv := range environ {
+		key := kv
+		for i := 0; i < len(kv); i++ {
+			if kv[i] == '=' {
+				key = kv[:i]
+				break
+			}
+		}
+		if _, ok := env[key]; !ok {
+			merged = append(merged, kv)
+		}
+	}
+
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		merged = append(merged, k+"="+env[k])
+	}
+	return merged
+}
+
+// writeEnvFile atomically writes the environment to path, the file is only readable by the current user.
+func writeEnvFile(path string, env map[string]string) error {
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".env")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// childEnv validates EnvChange and parses the templates of Env.
+func (c ExecConfig) childEnv() (map[string]*pongo2.Template, error) {
+	switch c.EnvChange {
+	case "", EnvChangeReloadSignal, EnvChangeRestart:
+	default:
+		return nil, fmt.Errorf("invalid env_change %q", c.EnvChange)
+	}
+
+	templates := make(map[string]*pongo2.Template, len(c.Env))
+	for k, v := range c.Env {
+		tmpl, err := pongo2.FromString(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing the env template of %s failed", k)
+		}
+		templates[k] = tmpl
+	}
+	return templates, nil
+}
+
+// renderChildEnv renders the env templates against the store.
+func (t *Resource) renderChildEnv() (map[string]string, error) {
+	env := make(map[string]string, len(t.childEnv))
+	for k, tmpl := range t.childEnv {
+		v, err := tmpl.Execute(t.funcMap)
+		if err != nil {
+			return nil, errors.Wrapf(err, "rendering the env template of %s failed", k)
+		}
+		env[k] = v
+	}
+	return env, nil
+}
+
+// updateChildEnv renders the environment of the child and hands it to the executor.
+// It returns true if the environment changed.
+func (t *Resource) updateChildEnv() (bool, error) {
+	if len(t.childEnv) == 0 {
+		return false, nil
+	}
+	env, err := t.renderChildEnv()
+	if err != nil {
+		return false, err
+	}
+	if reflect.DeepEqual(env, t.lastChildEnv) {
+		return false, nil
+	}
+	if err := t.exec.SetEnv(env); err != nil {
+		return false, err
+	}
+	t.lastChildEnv = env
+	return true, nil
+}
+
+// applyChanges reloads the child process and runs the reload commands after
+// the templates (changed) or the environment of the child (envChanged) have been changed.
+func (t *Resource) applyChanges(ctx context.Context, changed []string, envChanged bool) {
+	if envChanged && t.envChange == EnvChangeRestart {
+		t.logger.Info("the environment of the child process changed - restarting it")
+		if err := t.exec.RespawnChild(); err != nil {
+			t.logger.Error(err)
+		}
+		if len(changed) > 0 {
+			t.reload(ctx, changed, false)
+		}
+		return
+	}
+	if len(changed) > 0 {
+		t.reload(ctx, changed, true)
+	} else if envChanged {
+		if err := t.reloadChild(ctx, changed); err != nil {
+			t.logger.Error(err)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestMain runs the exec-child subcommand if the test binary is started as a child with an environment
+// and the executor test helper if it's started as a child of the executor tests.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == ExecChildSubcommand {
+		err := ExecChild(os.Args[2:])
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if len(os.Args) > 1 && os.Args[1] == executorTestHelper {
+		os.Exit(runExecutorTestHelper(os.Args[2:]))
+	}
+	os.Exit(m.Run())
+}
+
+func TestMergeEnv(t *testing.T) {
+	environ := []string{"PATH=/bin", "HOME=/root", "EMPTY="}
+	env := map[string]string{"HOME": "/home/remco", "B": "2", "A": "1=1"}
+
+	expected := []string{"PATH=/bin", "EMPTY=", "A=1=1", "B=2", "HOME=/home/remco"}
+	if merged := mergeEnv(environ, env); !reflect.DeepEqual(merged, expected) {
+		t.Errorf("expected %v, got %v", expected, merged)
+	}
+}
+
+func TestExecConfigChildEnv(t *testing.T) {
+	templates, err := ExecConfig{Env: map[string]string{"PORT": "{{ getv(\"/port\") }}"}}.childEnv()
+	if err != nil || len(templates) != 1 {
+		t.Errorf("expected a valid env template, got %v, %v", templates, err)
+	}
+
+	if _, err := (ExecConfig{Env: map[string]string{"PORT": "{{ getv("}}).childEnv(); err == nil {
+		t.Error("expected an error for an invalid template")
+	}
+
+	if _, err := (ExecConfig{EnvChange: "sometimes"}).childEnv(); err == nil {
+		t.Error("expected an error for an invalid env_change")
+	}
+}
+
+func TestExecutorEnv(t *testing.T) {
+	logger, buf := newJSONLogger()
+	exec := NewExecutor(`bash -c 'echo "$REMCO_TEST_ENV"; sleep 10'`, "", "", 1, 0, logger)
+	if err := exec.SetEnv(map[string]string{"REMCO_TEST_ENV": "first"}); err != nil {
+		t.Fatal(err)
+	}
+	envDir := exec.envDir
+	if err := exec.SpawnChild(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go exec.Wait(ctx)
+
+	output := func(expected string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			for _, e := range logEntries(t, buf) {
+				if e["child"] == true && e["msg"] == expected {
+					return
+				}
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		t.Errorf("the child didn't print %q", expected)
+	}
+	output("first")
+
+	if err := exec.SetEnv(map[string]string{"REMCO_TEST_ENV": "second"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.RespawnChild(); err != nil {
+		t.Fatal(err)
+	}
+	output("second")
+
+	exec.StopChild()
+	if _, err := os.Stat(envDir); !os.IsNotExist(err) {
+		t.Errorf("the environment directory wasn't removed: %v", err)
+	}
+}
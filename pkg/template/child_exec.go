@@ -0,0 +1,199 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ExecChildSubcommand is the remco subcommand that starts the child process with the environment
+// of ExecConfig.Env, the credentials of ExecConfig.User and ExecConfig.Group, the working directory and the umask.
+//
+// The child library of remco can set none of these, and the environment must never be passed on the
+// command line (the spawned command is logged), so the child is started as
+//   remco exec-child [-env <file>] [-uid <uid> -gid <gid> -groups <gids>] [-dir <dir>] [-umask <umask>] [-setpgid] -- <command> <args...>
+// instead. The subcommand reads the environment from the file and replaces itself with the command.
+// With -setpgid it first moves itself into its own process group, which the command (and its children) inherit.
+// If credentials are given, the command is started as a child of the subcommand with these credentials
+// and all signals are forwarded to it.
+const ExecChildSubcommand = "exec-child"
+
+// credentials are the resolved user and groups of the child process.
+type credentials struct {
+	uid    uint32
+	gid    uint32
+	groups []uint32
+}
+
+// args returns the arguments of ExecChildSubcommand for the credentials.
+func (c *credentials) args() []string {
+	groups := make([]string, len(c.groups))
+	for i, g := range c.groups {
+		groups[i] = strconv.FormatUint(uint64(g), 10)
+	}
+	return []string{
+		"-uid", strconv.FormatUint(uint64(c.uid), 10),
+		"-gid", strconv.FormatUint(uint64(c.gid), 10),
+		"-groups", strings.Join(groups, ","),
+	}
+}
+
+// lookupUser resolves a user by name or numeric ID.
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			return u, nil
+		}
+	}
+	return user.Lookup(name)
+}
+
+// lookupGroup resolves a group by name or numeric ID.
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		if g, err := user.LookupGroupId(name); err == nil {
+			return g, nil
+		}
+	}
+	return user.LookupGroup(name)
+}
+
+func parseID(id string) (uint32, error) {
+	v, err := strconv.ParseUint(id, 10, 32)
+	return uint32(v), err
+}
+
+// resolveCredentials resolves the user and group names (or numeric IDs) of the child.
+// The primary group of the user is used if group is empty, the supplementary groups
+// are the groups of the user. If only the group is given, the child keeps the user of remco.
+func resolveCredentials(userName, groupName string) (*credentials, error) {
+	if userName == "" && groupName == "" {
+		return nil, nil
+	}
+
+	c := &credentials{uid: uint32(os.Getuid()), gid: uint32(os.Getgid())}
+	if userName != "" {
+		u, err := lookupUser(userName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't resolve the user %q", userName)
+		}
+		if c.uid, err = parseID(u.Uid); err != nil {
+			return nil, errors.Wrapf(err, "the user %q has no numeric uid", userName)
+		}
+		if c.gid, err = parseID(u.Gid); err != nil {
+			return nil, errors.Wrapf(err, "the user %q has no numeric gid", userName)
+		}
+		gids, err := u.GroupIds()
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't resolve the groups of the user %q", userName)
+		}
+		for _, id := range gids {
+			gid, err := parseID(id)
+			if err != nil {
+				return nil, errors.Wrapf(err, "the user %q has a non-numeric group", userName)
+			}
+			c.groups = append(c.groups, gid)
+		}
+	}
+	if groupName != "" {
+		g, err := lookupGroup(groupName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "couldn't resolve the group %q", groupName)
+		}
+		if c.gid, err = parseID(g.Gid); err != nil {
+			return nil, errors.Wrapf(err, "the group %q has no numeric gid", groupName)
+		}
+	}
+
+	if os.Geteuid() != 0 && (c.uid != uint32(os.Geteuid()) || c.gid != uint32(os.Getegid())) {
+		return nil, fmt.Errorf("insufficient privileges: remco must run as root to start the child as user %q and group %q", userName, groupName)
+	}
+	return c, nil
+}
+
+// ExecChild implements ExecChildSubcommand, args are the arguments after the subcommand.
+// It only returns on errors.
+func ExecChild(args []string) error {
+	fs := flag.NewFlagSet(ExecChildSubcommand, flag.ContinueOnError)
+	envFile := fs.String("env", "", "file with the additional environment of the command")
+	uid := fs.Int("uid", -1, "uid of the command")
+	gid := fs.Int("gid", -1, "gid of the command")
+	groups := fs.String("groups", "", "comma separated supplementary gids of the command")
+	dir := fs.String("dir", "", "working directory of the command")
+	umask := fs.String("umask", "", "octal umask of the command")
+	setpgid := fs.Bool("setpgid", false, "start the command in its own process group")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: %s [flags] -- <command> [args...]", ExecChildSubcommand)
+	}
+
+	env := os.Environ()
+	if *envFile != "" {
+		data, err := ioutil.ReadFile(*envFile)
+		if err != nil {
+			return errors.Wrap(err, "couldn't read the environment")
+		}
+		var vars map[string]string
+		if err := json.Unmarshal(data, &vars); err != nil {
+			return errors.Wrap(err, "couldn't parse the environment")
+		}
+		env = mergeEnv(env, vars)
+	}
+
+	if *dir != "" {
+		if err := os.Chdir(*dir); err != nil {
+			return errors.Wrap(err, "couldn't change the working directory")
+		}
+	}
+	if *umask != "" {
+		m, err := strconv.ParseUint(*umask, 8, 32)
+		if err != nil {
+			return errors.Wrapf(err, "invalid umask %q", *umask)
+		}
+		setUmask(int(m))
+	}
+
+	if *setpgid {
+		if err := setOwnProcessGroup(); err != nil {
+			return errors.Wrap(err, "couldn't create the process group")
+		}
+	}
+
+	path, err := exec.LookPath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *uid < 0 {
+		return execProcess(path, fs.Args(), env)
+	}
+
+	c := &credentials{uid: uint32(*uid), gid: uint32(*gid)}
+	if *groups != "" {
+		for _, g := range strings.Split(*groups, ",") {
+			id, err := parseID(g)
+			if err != nil {
+				return errors.Wrapf(err, "invalid group %q", g)
+			}
+			c.groups = append(c.groups, id)
+		}
+	}
+	return runAs(path, fs.Args(), env, c)
+}
@@ -0,0 +1,162 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolveCredentials(t *testing.T) {
+	if c, err := resolveCredentials("", ""); c != nil || err != nil {
+		t.Errorf("expected no credentials, got %v, %v", c, err)
+	}
+
+	if _, err := resolveCredentials("remco-no-such-user", ""); err == nil || !strings.Contains(err.Error(), `couldn't resolve the user "remco-no-such-user"`) {
+		t.Errorf("expected an unknown user error, got %v", err)
+	}
+	if _, err := resolveCredentials("", "remco-no-such-group"); err == nil || !strings.Contains(err.Error(), `couldn't resolve the group "remco-no-such-group"`) {
+		t.Errorf("expected an unknown group error, got %v", err)
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		t.Skip("couldn't get the current user: ", err)
+	}
+	// by name and by numeric ID
+	for _, name := range []string{current.Username, current.Uid} {
+		c, err := resolveCredentials(name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.uid != uint32(os.Getuid()) || c.gid != uint32(os.Getgid()) {
+			t.Errorf("expected the current uid and gid, got %d, %d", c.uid, c.gid)
+		}
+	}
+
+	if os.Geteuid() != 0 {
+		if _, err := resolveCredentials("0", ""); err == nil || !strings.HasPrefix(err.Error(), "insufficient privileges") {
+			t.Errorf("expected an insufficient privileges error, got %v", err)
+		}
+	}
+}
+
+func TestCredentialsArgs(t *testing.T) {
+	c := &credentials{uid: 1, gid: 2, groups: []uint32{3, 4}}
+	expected := []string{"-uid", "1", "-gid", "2", "-groups", "3,4"}
+	if args := c.args(); !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected %v, got %v", expected, args)
+	}
+}
+
+func TestExecutorCredentials(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("only root can change the user of the child")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("the user nobody doesn't exist")
+	}
+
+	logger, buf := newJSONLogger()
+	exec := NewExecutor(`sh -c 'echo "$(id -u):$(id -g)"'`, "", "", 1, 0, logger)
+	exec.SetCredentials("nobody", "")
+	if err := exec.SpawnChild(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if !exec.Wait(ctx) {
+		t.Fatal("the child should have exited")
+	}
+
+	expected := nobody.Uid + ":" + nobody.Gid
+	var found bool
+	for _, e := range logEntries(t, buf) {
+		found = found || (e["child"] == true && e["msg"] == expected)
+	}
+	if !found {
+		t.Errorf("the child should run as %s", expected)
+	}
+}
+
+func TestExecutorUnknownUser(t *testing.T) {
+	exec := NewExecutor("true", "", "", 1, 0, newTestLogger())
+	exec.SetCredentials("remco-no-such-user", "")
+	if err := exec.SpawnChild(); err == nil || !strings.Contains(err.Error(), "couldn't resolve the user") {
+		t.Errorf("SpawnChild should fail, got %v", err)
+	}
+}
+
+func TestSetProcessAttributes(t *testing.T) {
+	exec := NewExecutor("true", "", "", 1, 0, newTestLogger())
+	for _, umask := range []string{"8", "1000", "rwx"} {
+		if err := exec.SetProcessAttributes("", umask, nil); err == nil {
+			t.Errorf("umask %q should be invalid", umask)
+		}
+	}
+	if err := exec.SetProcessAttributes("", "027", nil); err != nil || exec.umask != 027 {
+		t.Errorf("expected umask 027, got %o, %v", exec.umask, err)
+	}
+	if err := exec.SetProcessAttributes("", "", nil); err != nil || exec.umask != -1 {
+		t.Errorf("expected no umask, got %o, %v", exec.umask, err)
+	}
+}
+
+func TestExecutorInvalidWorkingDir(t *testing.T) {
+	exec := NewExecutor("true", "", "", 1, 0, newTestLogger())
+	exec.SetProcessAttributes("/remco/no/such/dir", "", nil)
+	if err := exec.SpawnChild(); err == nil || !strings.Contains(err.Error(), `invalid working_dir "/remco/no/such/dir"`) {
+		t.Errorf("SpawnChild should fail, got %v", err)
+	}
+}
+
+func TestExecutorProcessAttributes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-workdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger, buf := newJSONLogger()
+	exec := NewExecutor(`sh -c 'echo "$(pwd):$(umask):$STATIC:$OVERRIDDEN"'`, "", "", 1, 0, logger)
+	err = exec.SetProcessAttributes(dir, "027", map[string]string{"STATIC": "static", "OVERRIDDEN": "static"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.SetEnv(map[string]string{"OVERRIDDEN": "template"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.SpawnChild(); err != nil {
+		t.Fatal(err)
+	}
+	defer exec.StopChild()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if !exec.Wait(ctx) {
+		t.Fatal("the child should have exited")
+	}
+
+	expected := dir + ":0027:static:template"
+	var output []interface{}
+	for _, e := range logEntries(t, buf) {
+		if e["child"] == true {
+			output = append(output, e["msg"])
+		}
+	}
+	if len(output) != 1 || output[0] != expected {
+		t.Errorf("expected %q, got %v", expected, output)
+	}
+}
@@ -0,0 +1,54 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// coalesce collects the backends sent on processChan during the coalesce window that starts with first,
+// so that they are processed (and the child is reloaded) only once. Every backend is returned only once.
+// It returns early if ctx is canceled.
+func (t *Resource) coalesce(ctx context.Context, processChan <-chan Backend, first Backend) []Backend {
+	backends := []Backend{first}
+	timer := time.NewTimer(t.coalesceWindow)
+	defer timer.Stop()
+	for {
+		select {
+		case b := <-processChan:
+			if !containsBackend(backends, b.Name) {
+				backends = append(backends, b)
+			}
+		case <-timer.C:
+			return backends
+		case <-ctx.Done():
+			return backends
+		}
+	}
+}
+
+func containsBackend(backends []Backend, name string) bool {
+	for _, b := range backends {
+		if b.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// backendNames returns the comma-separated names of the backends.
+func backendNames(backends []Backend) string {
+	names := make([]string, len(backends))
+	for i, b := range backends {
+		names[i] = b.Name
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,54 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCoalesce(t *testing.T) {
+	res := &Resource{coalesceWindow: 200 * time.Millisecond}
+	processChan := make(chan Backend)
+	go func() {
+		for _, name := range []string{"b", "a", "b", "c"} {
+			processChan <- Backend{Name: name}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+
+	start := time.Now()
+	backends := res.coalesce(context.Background(), processChan, Backend{Name: "a"})
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("the backends should be collected for the whole window, took %s", elapsed)
+	}
+	if names := backendNames(backends); names != "a,b,c" {
+		t.Errorf("expected the backends a,b,c - got %s", names)
+	}
+
+}
+
+func TestCoalesceCanceled(t *testing.T) {
+	res := &Resource{coalesceWindow: time.Minute}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	backends := res.coalesce(ctx, make(chan Backend), Backend{Name: "a"})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("coalesce should return when the context is canceled, took %s", elapsed)
+	}
+	if len(backends) != 1 {
+		t.Errorf("expected one backend, got %d", len(backends))
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	"github.com/HeavyHorst/memkv"
+	"github.com/HeavyHorst/pongo2"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// keyExists reports whether key or any key below it exists in the store.
+func keyExists(store *memkv.Store, key string) bool {
+	key = path.Join("/", key)
+	if store.Exists(key) {
+		return true
+	}
+	for _, kv := range store.GetAllKVs() {
+		if strings.HasPrefix(kv.Key, key+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// shouldRender evaluates WhenExists and Condition.
+// It returns false if the template has to be skipped and an error if the condition couldn't be evaluated.
+func (s *Renderer) shouldRender(funcMap map[string]interface{}, store *memkv.Store) (bool, error) {
+	if s.WhenExists != "" && !keyExists(store, s.WhenExists) {
+		s.logger.WithFields(logrus.Fields{
+			"config":      s.Dst,
+			"when_exists": s.WhenExists,
+		}).Debug("key doesn't exist - skipping template")
+		return false, nil
+	}
+
+	if s.Condition == "" {
+		return true, nil
+	}
+	tmpl, err := pongo2.FromString(s.Condition)
+	if err != nil {
+		return false, errors.Wrap(err, "parsing condition failed")
+	}
+	result, err := tmpl.Execute(funcMap)
+	if err != nil {
+		return false, errors.Wrap(err, "condition execution failed")
+	}
+	if strings.TrimSpace(result) != "true" {
+		s.logger.WithFields(logrus.Fields{
+			"config":    s.Dst,
+			"condition": s.Condition,
+			"result":    result,
+		}).Debug("condition is not true - skipping template")
+		return false, nil
+	}
+	return true, nil
+}
+
+// skip handles a template whose condition is false.
+// Dst (or all generated files in fan-out mode) is removed if RemoveWhenSkipped is set.
+// It returns the removed files and an error if any.
+func (s *Renderer) skip() ([]string, error) {
+	if !s.RemoveWhenSkipped {
+		return nil, nil
+	}
+
+	if s.Iterate != "" {
+		var removed []string
+		for dst, item := range s.fanout {
+			if err := item.removeDst(); err != nil {
+				return removed, err
+			}
+			removed = append(removed, dst)
+			delete(s.fanout, dst)
+		}
+		return removed, nil
+	}
+
+	if _, err := os.Lstat(s.Dst); os.IsNotExist(err) {
+		return nil, nil
+	}
+	s.logger.WithFields(logrus.Fields{
+		"config": s.Dst,
+	}).Info("removing skipped target config")
+	if err := s.removeDst(); err != nil {
+		return nil, err
+	}
+	return []string{s.Dst}, nil
+}
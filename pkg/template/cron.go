@@ -0,0 +1,295 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxScheduleSearch bounds the search for the next activation of a cron schedule.
+// Schedules that never fire within it (e.g. "0 0 30 2 *") are rejected by ParseSchedule.
+const maxScheduleSearch = 5 * 366 * 24 * time.Hour
+
+var scheduleMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var weekdayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+// A CronSchedule is a parsed cron expression.
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// domAny and dowAny are set if the day of the month or the day of the week is unrestricted.
+	// If both are restricted, a day matches if either of them matches.
+	domAny, dowAny bool
+
+	loc *time.Location
+}
+
+// ParseSchedule parses a cron expression with the five fields minute, hour, day of month, month and day of week.
+// The fields support *, ranges (1-5), steps (*/15, 0-30/10), lists (1,15) and the names of the months and weekdays;
+// the macros @yearly, @annually, @monthly, @weekly, @daily, @midnight and @hourly are supported as well.
+// The expression is evaluated in the local timezone, a different one can be selected with a CRON_TZ=<zone> or TZ=<zone> prefix,
+// e.g. "CRON_TZ=Europe/Berlin 30 2 * * *".
+func ParseSchedule(expr string) (*CronSchedule, error) {
+	s := &CronSchedule{loc: time.Local}
+
+	fields := strings.Fields(expr)
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "CRON_TZ=") || strings.HasPrefix(fields[0], "TZ=")) {
+		name := fields[0][strings.Index(fields[0], "=")+1:]
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %v", name, err)
+		}
+		s.loc = loc
+		fields = fields[1:]
+	}
+	if len(fields) == 1 && strings.HasPrefix(fields[0], "@") {
+		m, ok := scheduleMacros[fields[0]]
+		if !ok {
+			return nil, fmt.Errorf("unknown macro %q", fields[0])
+		}
+		fields = strings.Fields(m)
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields (minute hour day-of-month month day-of-week), got %d", len(fields))
+	}
+
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %v", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %v", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %v", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("month: %v", err)
+	}
+	// 7 is an alias for sunday
+	if s.dow, err = parseCronField(fields[4], 0, 7, weekdayNames); err != nil {
+		return nil, fmt.Errorf("day of week: %v", err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	s.domAny = fields[2] == "*" || fields[2] == "?"
+	s.dowAny = fields[4] == "*" || fields[4] == "?"
+
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("the schedule %q never fires", expr)
+	}
+	return s, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps into a bitset.
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rng = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		var lo, hi int
+		switch {
+		case rng == "*" || rng == "?":
+			lo, hi = min, max
+		case strings.Contains(rng, "-"):
+			i := strings.Index(rng, "-")
+			var err error
+			if lo, err = parseCronValue(rng[:i], min, max, names); err != nil {
+				return 0, err
+			}
+			if hi, err = parseCronValue(rng[i+1:], min, max, names); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			v, err := parseCronValue(rng, min, max, names)
+			if err != nil {
+				return 0, err
+			}
+			lo, hi = v, v
+			// a step without a range (e.g. 5/15) runs until the end of the field
+			if rng != part {
+				hi = max
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// parseCronValue parses a single number or name of a field.
+func parseCronValue(s string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("%d is out of range [%d, %d]", v, min, max)
+	}
+	return v, nil
+}
+
+// Next returns the next activation of the schedule after t, or the zero time if there is none.
+//
+// The schedule is matched against the wall clock time of its timezone and every matching wall clock time fires exactly once:
+// times that are skipped by a daylight saving time transition fire at the end of the gap,
+// times that are repeated fire only at their first occurrence.
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.loc)
+
+	// the wall clock time is iterated as UTC to get rid of the transitions of the timezone
+	c := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC).Add(time.Minute)
+	end := c.Add(maxScheduleSearch)
+
+	for c.Before(end) {
+		if s.month&(1<<uint(c.Month())) == 0 {
+			c = time.Date(c.Year(), c.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.dayMatches(c) {
+			c = time.Date(c.Year(), c.Month(), c.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if s.hour&(1<<uint(c.Hour())) == 0 {
+			c = c.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if s.minute&(1<<uint(c.Minute())) == 0 {
+			c = c.Add(time.Minute)
+			continue
+		}
+
+		if next := s.instant(c); next.After(t) {
+			return next
+		}
+		c = c.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+// dayMatches reports whether the day of month and the day of week of the wall clock time c match.
+func (s *CronSchedule) dayMatches(c time.Time) bool {
+	dom := s.dom&(1<<uint(c.Day())) != 0
+	dow := s.dow&(1<<uint(c.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// instant returns the earliest instant of the wall clock time c (represented as UTC) in the timezone of the schedule.
+// If c doesn't exist because it is skipped by a transition, the end of the gap is returned.
+func (s *CronSchedule) instant(c time.Time) time.Time {
+	var first time.Time
+	for _, off := range s.offsetsAround(c) {
+		u := c.Add(-time.Duration(off) * time.Second)
+		if sameWallClock(u.In(s.loc), c) && (first.IsZero() || u.Before(first)) {
+			first = u
+		}
+	}
+	if !first.IsZero() {
+		return first
+	}
+
+	// the first wall clock time after the gap
+	for g := c.Add(time.Minute); g.Sub(c) <= 48*time.Hour; g = g.Add(time.Minute) {
+		for _, off := range s.offsetsAround(g) {
+			if u := g.Add(-time.Duration(off) * time.Second); sameWallClock(u.In(s.loc), g) {
+				return u
+			}
+		}
+	}
+	return time.Date(c.Year(), c.Month(), c.Day(), c.Hour(), c.Minute(), 0, 0, s.loc)
+}
+
+// offsetsAround returns the utc offsets of the timezone around the wall clock time c (represented as UTC).
+func (s *CronSchedule) offsetsAround(c time.Time) []int {
+	var offsets []int
+	for _, d := range []time.Duration{-24 * time.Hour, 0, 24 * time.Hour} {
+		_, off := c.Add(d).In(s.loc).Zone()
+		offsets = append(offsets, off)
+	}
+	return offsets
+}
+
+// sameWallClock reports whether the wall clock times of a and b are equal up to the minute.
+func sameWallClock(a, b time.Time) bool {
+	return a.Year() == b.Year() && a.YearDay() == b.YearDay() && a.Hour() == b.Hour() && a.Minute() == b.Minute()
+}
+
+// schedule sends the backend to processChan at every activation of its cron schedule.
+// The next activation is calculated after the send returns, so the activations that pass
+// while a render is in progress are collapsed into one.
+func (s Backend) schedule(ctx context.Context, processChan chan Backend) {
+	if s.Onetime || s.cron == nil {
+		return
+	}
+	var last time.Time
+	for {
+		from := time.Now()
+		if from.Before(last) {
+			from = last
+		}
+		next := s.cron.Next(from)
+		if next.IsZero() {
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		last = next
+
+		select {
+		case <-ctx.Done():
+			return
+		case processChan <- s:
+		}
+	}
+}
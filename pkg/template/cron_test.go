@@ -0,0 +1,167 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv/mock"
+)
+
+func mustParseSchedule(t *testing.T, expr string) *CronSchedule {
+	t.Helper()
+	s, err := ParseSchedule(expr)
+	if err != nil {
+		t.Fatalf("%q: %v", expr, err)
+	}
+	return s
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@every",
+		"CRON_TZ=Nowhere/Nothing * * * * *",
+		"0 0 30 2 *",
+	} {
+		if _, err := ParseSchedule(expr); err == nil {
+			t.Errorf("%q should be invalid", expr)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	utc := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		expr, from, want string
+	}{
+		{"TZ=UTC * * * * *", "2021-03-01 10:00", "2021-03-01 10:01"},
+		{"TZ=UTC */15 * * * *", "2021-03-01 10:07", "2021-03-01 10:15"},
+		{"TZ=UTC 5/20 * * * *", "2021-03-01 10:30", "2021-03-01 10:45"},
+		{"TZ=UTC 0 9-17/4 * * *", "2021-03-01 13:00", "2021-03-01 17:00"},
+		{"TZ=UTC 30 2 * * *", "2021-03-01 02:30", "2021-03-02 02:30"},
+		{"TZ=UTC 0 0 * * mon-fri", "2021-03-05 12:00", "2021-03-08 00:00"},
+		{"TZ=UTC 0 0 * * 7", "2021-03-01 00:00", "2021-03-07 00:00"},
+		{"TZ=UTC 0 0 1 jan,JUL *", "2021-03-01 00:00", "2021-07-01 00:00"},
+		{"TZ=UTC 0 0 29 2 *", "2021-03-01 00:00", "2024-02-29 00:00"},
+		// the day of month and the day of week are ORed if both are restricted
+		{"TZ=UTC 0 0 15 * sun", "2021-03-01 00:00", "2021-03-07 00:00"},
+		{"TZ=UTC 0 0 15 * sun", "2021-03-14 00:00", "2021-03-15 00:00"},
+		{"TZ=UTC @monthly", "2021-03-01 00:00", "2021-04-01 00:00"},
+		{"CRON_TZ=America/New_York 0 9 * * *", "2021-03-01 15:00", "2021-03-02 14:00"},
+	}
+	for _, test := range tests {
+		s := mustParseSchedule(t, test.expr)
+		if got := s.Next(utc(test.from)); !got.Equal(utc(test.want)) {
+			t.Errorf("%q after %s: expected %s, got %s", test.expr, test.from, test.want, got.UTC())
+		}
+	}
+}
+
+// activations returns the activations of the schedule in [from, to).
+func activations(s *CronSchedule, from, to time.Time) []time.Time {
+	var result []time.Time
+	for t := s.Next(from.Add(-time.Nanosecond)); !t.IsZero() && t.Before(to); t = s.Next(t) {
+		result = append(result, t)
+	}
+	return result
+}
+
+func TestScheduleDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	s := mustParseSchedule(t, "CRON_TZ=Europe/Berlin 30 2 * * *")
+
+	// 2021-03-28 02:00 CET jumps to 03:00 CEST: the skipped 02:30 fires at the end of the gap
+	got := activations(s, time.Date(2021, 3, 28, 0, 0, 0, 0, berlin), time.Date(2021, 3, 29, 0, 0, 0, 0, berlin))
+	want := time.Date(2021, 3, 28, 3, 0, 0, 0, berlin)
+	if len(got) != 1 || !got[0].Equal(want) {
+		t.Errorf("expected a single activation at %s on the spring forward day, got %v", want, got)
+	}
+
+	// 2021-10-31 03:00 CEST falls back to 02:00 CET: the repeated 02:30 fires only once
+	from := time.Date(2021, 10, 31, 0, 0, 0, 0, berlin)
+	got = activations(s, from, from.Add(25*time.Hour))
+	want = time.Date(2021, 10, 31, 0, 30, 0, 0, time.UTC)
+	if len(got) != 1 || !got[0].Equal(want) {
+		t.Errorf("expected a single activation at %s on the fall back day, got %v", want, got)
+	}
+	// not even if the next activation is calculated during the repeated hour
+	if next := s.Next(time.Date(2021, 10, 31, 1, 10, 0, 0, time.UTC)); next.Day() != 1 {
+		t.Errorf("the repeated 02:30 shouldn't fire again, got %s", next)
+	}
+
+	// the activations around the gap are not reordered or duplicated
+	s = mustParseSchedule(t, "CRON_TZ=Europe/Berlin 0,30 1-4 28 3 *")
+	got = activations(s, time.Date(2021, 3, 28, 0, 0, 0, 0, berlin), time.Date(2021, 3, 29, 0, 0, 0, 0, berlin))
+	var clock []string
+	for _, a := range got {
+		clock = append(clock, a.In(berlin).Format("15:04"))
+	}
+	if len(clock) != 6 || clock[0] != "01:00" || clock[1] != "01:30" || clock[2] != "03:00" || clock[3] != "03:30" || clock[5] != "04:30" {
+		t.Errorf("unexpected activations %v", clock)
+	}
+}
+
+func TestNewResourceSchedule(t *testing.T) {
+	newBackend := func(schedule string) Backend {
+		b := Backend{Name: "mock", Schedule: schedule}
+		b.ReadWatcher, _ = mock.New(nil, map[string]string{})
+		return b
+	}
+	exec := NewExecutor("", "", "", 0, 0, newTestLogger())
+
+	// a schedule replaces the default interval
+	res, err := NewResource([]Backend{newBackend("@hourly")}, nil, "test", exec, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := res.backends[0]; b.Interval != 0 || b.cron == nil {
+		t.Errorf("expected the schedule without an interval, got interval %d", b.Interval)
+	}
+
+	if _, err := NewResource([]Backend{newBackend("* * *")}, nil, "test", exec, "", ""); err == nil {
+		t.Error("the invalid schedule should be rejected")
+	}
+
+	// the schedule goroutine returns once the resource is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		res.backends[0].schedule(ctx, make(chan Backend))
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the schedule didn't stop")
+	}
+}
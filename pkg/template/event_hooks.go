@@ -0,0 +1,316 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The events of the event hooks.
+const (
+	// EventChange is sent to on_change after at least one file has been changed.
+	EventChange = "change"
+	// EventError is sent to on_error after the templates couldn't be processed.
+	EventError = "error"
+)
+
+// defaultEventHookTimeout is the timeout of the event hook commands if none is configured.
+const defaultEventHookTimeout = 30 * time.Second
+
+// EventHook is a command or a webhook that is notified about the render events of a resource.
+// Failures of the hook are logged, they don't affect the resource.
+type EventHook struct {
+	// Cmd is executed with the event in the environment variables REMCO_EVENT, REMCO_RESOURCE,
+	// REMCO_CHANGED_FILES (separated by newlines), REMCO_BACKEND, REMCO_TRIGGER and REMCO_ERROR.
+	Cmd string `json:"cmd"`
+
+	// Timeout is the maximum amount of time in seconds Cmd may take. The default is 30.
+	Timeout int `json:"timeout"`
+
+	// HTTP sends the event as a request. The body template is rendered with event, resource,
+	// changed (separated by newlines), backend, trigger, error and event_json (the event as JSON).
+	// The default body is the event as JSON.
+	HTTP *HTTPReload `toml:"http" json:"http"`
+}
+
+// validate reports whether the hook configured as name is valid.
+func (h *EventHook) validate(name string) error {
+	switch {
+	case h.Cmd == "" && h.HTTP == nil:
+		return fmt.Errorf("%s: cmd or http is required", name)
+	case h.Cmd != "" && h.HTTP != nil:
+		return fmt.Errorf("%s: cmd and http are mutually exclusive", name)
+	case h.Timeout < 0:
+		return fmt.Errorf("%s.timeout: must not be negative, got %d", name, h.Timeout)
+	case h.HTTP != nil:
+		return h.HTTP.validateAs(name + ".http")
+	}
+	return nil
+}
+
+// HookEvent is the event an EventHook is notified about.
+type HookEvent struct {
+	Event    string    `json:"event"`
+	Resource string    `json:"resource"`
+	Time     time.Time `json:"time"`
+	// Changed holds the changed files.
+	Changed []string `json:"changed"`
+	// Backend holds the names of the backends that triggered the render (separated by commas).
+	Backend string `json:"backend"`
+	Trigger string `json:"trigger"`
+	Error   string `json:"error,omitempty"`
+	// Count is the number of renders the event covers, it is larger than 1 if the events have been debounced.
+	Count int `json:"count"`
+}
+
+// merge adds the newer event e to the pending event.
+func (p *HookEvent) merge(e HookEvent) {
+	for _, c := range e.Changed {
+		if !containsString(p.Changed, c) {
+			p.Changed = append(p.Changed, c)
+		}
+	}
+	p.Time, p.Backend, p.Trigger, p.Error = e.Time, e.Backend, e.Trigger, e.Error
+	p.Count += e.Count
+}
+
+// environ returns the environment of the hook command.
+func (p HookEvent) environ() []string {
+	return append(os.Environ(),
+		"REMCO_EVENT="+p.Event,
+		"REMCO_RESOURCE="+p.Resource,
+		"REMCO_CHANGED_FILES="+strings.Join(p.Changed, "\n"),
+		"REMCO_BACKEND="+p.Backend,
+		"REMCO_TRIGGER="+p.Trigger,
+		"REMCO_ERROR="+p.Error,
+	)
+}
+
+// WebhookEvent is the body of the notify webhook request.
+type WebhookEvent struct {
+	Resource  string    `json:"resource"`
+	Timestamp time.Time `json:"timestamp"`
+	// TemplatesChanged holds the changed files.
+	TemplatesChanged []string `json:"templates_changed"`
+	// BackendsUpdated holds the names of the backends that triggered the render.
+	BackendsUpdated []string `json:"backends_updated"`
+}
+
+// webhookEvent returns the WebhookEvent of the change event e.
+func (p HookEvent) webhookEvent() WebhookEvent {
+	w := WebhookEvent{
+		Resource:         p.Resource,
+		Timestamp:        p.Time,
+		TemplatesChanged: p.Changed,
+		BackendsUpdated:  []string{},
+	}
+	if w.TemplatesChanged == nil {
+		w.TemplatesChanged = []string{}
+	}
+	if p.Backend != "" {
+		w.BackendsUpdated = strings.Split(p.Backend, ",")
+	}
+	return w
+}
+
+// notifyWebhook returns the hook that posts a WebhookEvent to url, nil if url is empty.
+func notifyWebhook(url string, headers map[string]string, timeout int) *EventHook {
+	if url == "" {
+		return nil
+	}
+	h := map[string]string{"Content-Type": "application/json"}
+	for k, v := range headers {
+		h[k] = v
+	}
+	return &EventHook{HTTP: &HTTPReload{
+		Method:  "POST",
+		URL:     url,
+		Headers: h,
+		Body:    "{{ webhook_json }}",
+		Timeout: timeout,
+	}}
+}
+
+// eventHook is the state of a configured EventHook.
+//
+// With reload_debounce the events are debounced like the reloads: the events of a burst are merged
+// into a single notification. The hooks run in the background, one notification at a time.
+type eventHook struct {
+	name      string
+	hook      *EventHook
+	debouncer *reloadDebouncer
+	pending   *HookEvent
+
+	// mu serializes the notifications
+	mu sync.Mutex
+	wg sync.WaitGroup
+}
+
+// newEventHook returns nil if hook is nil. The events are debounced for quiet (at most maxDelay) if quiet is set.
+func newEventHook(name string, hook *EventHook, quiet, maxDelay time.Duration) *eventHook {
+	if hook == nil {
+		return nil
+	}
+	if hook.HTTP != nil && hook.HTTP.Body == "" {
+		hook.HTTP.Body = "{{ event_json }}"
+		if _, ok := hook.HTTP.Headers["Content-Type"]; !ok {
+			headers := map[string]string{"Content-Type": "application/json"}
+			for k, v := range hook.HTTP.Headers {
+				headers[k] = v
+			}
+			hook.HTTP.Headers = headers
+		}
+	}
+	h := &eventHook{name: name, hook: hook}
+	if quiet > 0 {
+		h.debouncer = newReloadDebouncer(quiet, maxDelay, 0)
+	}
+	return h
+}
+
+// due returns the channel that receives a value when the debounced notification is due.
+// It is nil (blocks forever) if nothing is pending.
+func (h *eventHook) due() <-chan time.Time {
+	if h == nil || h.debouncer == nil {
+		return nil
+	}
+	return h.debouncer.C()
+}
+
+// notifyHooks passes the outcome of a render to the event hooks.
+func (t *Resource) notifyHooks(trigger, backend string, changed []string, err error) {
+	if backend == "" {
+		backend = backendNames(t.backends)
+	}
+	e := HookEvent{
+		Resource: t.name,
+		Time:     time.Now(),
+		Changed:  append([]string(nil), changed...),
+		Backend:  backend,
+		Trigger:  trigger,
+		Count:    1,
+	}
+	if err != nil {
+		e.Event = EventError
+		e.Error = err.Error()
+		t.addHookEvent(t.onError, e)
+	}
+	if len(changed) > 0 {
+		e.Event = EventChange
+		e.Error = ""
+		t.addHookEvent(t.onChange, e)
+		t.addHookEvent(t.notifyWebhook, e)
+	}
+}
+
+// addHookEvent notifies the hook about e, or adds e to the pending notification if the hook is debounced.
+func (t *Resource) addHookEvent(h *eventHook, e HookEvent) {
+	if h == nil {
+		return
+	}
+	if h.debouncer == nil {
+		t.runEventHook(h, e)
+		return
+	}
+	if h.pending == nil {
+		h.pending = &e
+	} else {
+		h.pending.merge(e)
+	}
+	// an error doesn't have to change a file, the event is pending anyway
+	h.debouncer.add(e.Changed, true)
+}
+
+// flushHook sends the pending notification of the debounced hook.
+func (t *Resource) flushHook(h *eventHook) {
+	if h == nil || h.debouncer == nil {
+		return
+	}
+	h.debouncer.take()
+	if h.pending != nil {
+		t.runEventHook(h, *h.pending)
+		h.pending = nil
+	}
+}
+
+// flushAndWaitHooks sends the pending notifications and waits until all hooks have been notified.
+func (t *Resource) flushAndWaitHooks() {
+	for _, h := range []*eventHook{t.onChange, t.onError, t.notifyWebhook} {
+		if h != nil {
+			t.flushHook(h)
+			h.wg.Wait()
+		}
+	}
+}
+
+// runEventHook notifies the hook about e in the background.
+func (t *Resource) runEventHook(h *eventHook, e HookEvent) {
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		logger := t.logger.WithFields(logrus.Fields{
+			"hook":    h.name,
+			"changed": e.Changed,
+		})
+		if err := t.callEventHook(h.hook, e); err != nil {
+			logger.Error(fmt.Sprintf("the %s hook failed: %v", h.name, err))
+			return
+		}
+		logger.Debugf("notified the %s hook", h.name)
+	}()
+}
+
+// callEventHook executes the command or sends the request of the hook.
+func (t *Resource) callEventHook(hook *EventHook, e HookEvent) error {
+	if hook.HTTP != nil {
+		eventJSON, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		webhookJSON, err := json.Marshal(e.webhookEvent())
+		if err != nil {
+			return err
+		}
+		return hook.HTTP.do(context.Background(), t.funcMap, map[string]string{
+			"event":      e.Event,
+			"resource":   e.Resource,
+			"changed":    strings.Join(e.Changed, "\n"),
+			"backend":    e.Backend,
+			"trigger":    e.Trigger,
+			"error":      e.Error,
+			"event_json": string(eventJSON),
+			// webhook_json is the body of the notify webhook
+			"webhook_json": string(webhookJSON),
+		})
+	}
+
+	timeout := secondsOrDefault(hook.Timeout, defaultEventHookTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	output, err := execCommandContext(ctx, hook.Cmd, nil, e.environ(), t.logger, nil)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return fmt.Errorf("timed out after %s", timeout)
+		}
+		return fmt.Errorf("%v - %q", err, string(output))
+	}
+	t.logger.Debug(fmt.Sprintf("%q", string(output)))
+	return nil
+}
@@ -0,0 +1,246 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv/mock"
+)
+
+func TestEventHookValidate(t *testing.T) {
+	valid := []*EventHook{
+		{Cmd: "true"},
+		{Cmd: "true", Timeout: 5},
+		{HTTP: &HTTPReload{URL: "http://localhost/events"}},
+	}
+	for _, h := range valid {
+		if err := h.validate("on_change"); err != nil {
+			t.Errorf("%+v should be valid: %v", h, err)
+		}
+	}
+
+	invalid := []*EventHook{
+		{},
+		{Cmd: "true", HTTP: &HTTPReload{URL: "http://localhost/events"}},
+		{Cmd: "true", Timeout: -1},
+		{HTTP: &HTTPReload{}},
+	}
+	for _, h := range invalid {
+		if err := h.validate("on_change"); err == nil || !strings.HasPrefix(err.Error(), "on_change") {
+			t.Errorf("%+v should be invalid, got %v", h, err)
+		}
+	}
+}
+
+// runWithHooks runs Monitor once with a onetime mock backend, the template and the hooks.
+func runWithHooks(t *testing.T, dir, template string, onChange, onError *EventHook) *Resource {
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte(template), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := Backend{Name: "mock", Onetime: true, Keys: []string{"/"}}
+	b.ReadWatcher, _ = mock.New(nil, map[string]string{"/key": "value"})
+	res, err := NewResource([]Backend{b}, []*Renderer{{Src: src, Dst: filepath.Join(dir, "dst")}}, "test", NewExecutor("", "", "", 0, 0, newTestLogger()), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.onChange = newEventHook("on_change", onChange, 0, 0)
+	res.onError = newEventHook("on_error", onError, 0, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res.Monitor(ctx)
+	if ctx.Err() != nil {
+		t.Fatal("Monitor should return in onetime mode")
+	}
+	return res
+}
+
+func TestEventHookCmd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-event-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	changeOut := filepath.Join(dir, "change")
+	errorOut := filepath.Join(dir, "error")
+
+	hook := func(out string) *EventHook {
+		return &EventHook{Cmd: "env | grep ^REMCO_ | sort > " + out}
+	}
+	res := runWithHooks(t, dir, `{{ getv("/key") }}`, hook(changeOut), hook(errorOut))
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	// Monitor waits for the hooks
+	data, err := ioutil.ReadFile(changeOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, env := range []string{"REMCO_EVENT=change", "REMCO_RESOURCE=test", "REMCO_CHANGED_FILES=" + filepath.Join(dir, "dst"), "REMCO_BACKEND=mock", "REMCO_TRIGGER=startup", "REMCO_ERROR=\n"} {
+		if !strings.Contains(string(data), env) {
+			t.Errorf("the environment of the hook should contain %q, got %q", env, data)
+		}
+	}
+	if _, err := os.Stat(errorOut); !os.IsNotExist(err) {
+		t.Error("on_error shouldn't be notified after a successful render")
+	}
+
+	os.Remove(filepath.Join(dir, "dst"))
+	res = runWithHooks(t, dir, `{{ getv("/missing") }}`, hook(changeOut+"2"), hook(errorOut))
+	if res.Err == nil {
+		t.Fatal("the render should fail")
+	}
+	data, err = ioutil.ReadFile(errorOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "REMCO_EVENT=error") || !strings.Contains(string(data), "/missing") {
+		t.Errorf("unexpected environment of the error hook %q", data)
+	}
+	if _, err := os.Stat(changeOut + "2"); !os.IsNotExist(err) {
+		t.Error("on_change shouldn't be notified if no file changed")
+	}
+}
+
+func TestEventHookFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-event-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// neither a failing nor a hanging hook affects the render
+	start := time.Now()
+	res := runWithHooks(t, dir, `{{ getv("/key") }}`, &EventHook{Cmd: "sleep 10", Timeout: 1}, nil)
+	if res.Err != nil || res.Failed || !res.Changed {
+		t.Errorf("the hook shouldn't affect the resource: %v", res.Err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the hook should time out, took %s", elapsed)
+	}
+	res = runWithHooks(t, dir, `{{ getv("/key") }}2`, &EventHook{Cmd: "exit 1"}, nil)
+	if res.Err != nil || res.Failed {
+		t.Errorf("the hook shouldn't affect the resource: %v", res.Err)
+	}
+}
+
+func TestEventHookWebhookDebounce(t *testing.T) {
+	var mu sync.Mutex
+	var events []HookEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e HookEvent
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	res := &Resource{name: "test", logger: newTestLogger()}
+	res.onChange = newEventHook("on_change", &EventHook{HTTP: &HTTPReload{URL: server.URL}}, 50*time.Millisecond, time.Second)
+	res.onError = newEventHook("on_error", &EventHook{HTTP: &HTTPReload{
+		URL:     server.URL,
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body:    `{"event": "{{ event }}", "error": "{{ error }}"}`,
+	}}, 50*time.Millisecond, time.Second)
+
+	// a burst of renders is a single notification
+	for i := 0; i < 3; i++ {
+		res.notifyHooks(TriggerBackend, "etcd", []string{fmt.Sprintf("/dst%d", i%2)}, nil)
+	}
+	res.notifyHooks(TriggerBackend, "etcd", nil, fmt.Errorf("failed"))
+	if res.onChange.due() == nil || res.onError.due() == nil {
+		t.Fatal("the events should be pending")
+	}
+	<-res.onChange.due()
+	res.flushHook(res.onChange)
+	res.flushAndWaitHooks()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 {
+		t.Fatalf("expected two notifications, got %+v", events)
+	}
+	change, failure := events[0], events[1]
+	if change.Event == EventError {
+		change, failure = failure, change
+	}
+	if change.Event != EventChange || change.Count != 3 || !reflect.DeepEqual(change.Changed, []string{"/dst0", "/dst1"}) || change.Backend != "etcd" {
+		t.Errorf("unexpected change event %+v", change)
+	}
+	if failure.Event != EventError || failure.Error != "failed" {
+		t.Errorf("unexpected error event %+v", failure)
+	}
+}
+
+func TestNotifyWebhook(t *testing.T) {
+	var mu sync.Mutex
+	var events []WebhookEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e WebhookEvent
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil || r.Method != http.MethodPost ||
+			r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	res := &Resource{name: "test", logger: newTestLogger()}
+	res.notifyWebhook = newEventHook("notify_webhook", notifyWebhook(server.URL, map[string]string{"Authorization": "Bearer token"}, 1), 0, 0)
+
+	// only renders that changed a file are sent, without debouncing
+	res.notifyHooks(TriggerBackend, "etcd,consul", []string{"/dst0"}, nil)
+	res.notifyHooks(TriggerBackend, "etcd", nil, nil)
+	res.notifyHooks(TriggerBackend, "etcd", nil, fmt.Errorf("failed"))
+	res.notifyHooks(TriggerBackend, "etcd", []string{"/dst1", "/dst2"}, nil)
+	res.flushAndWaitHooks()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 {
+		t.Fatalf("expected two notifications, got %+v", events)
+	}
+	// the notifications are sent in the background, their order isn't fixed
+	if len(events[0].TemplatesChanged) != 1 {
+		events[0], events[1] = events[1], events[0]
+	}
+	if e := events[0]; e.Resource != "test" || e.Timestamp.IsZero() ||
+		!reflect.DeepEqual(e.TemplatesChanged, []string{"/dst0"}) || !reflect.DeepEqual(e.BackendsUpdated, []string{"etcd", "consul"}) {
+		t.Errorf("unexpected event %+v", e)
+	}
+	if e := events[1]; !reflect.DeepEqual(e.TemplatesChanged, []string{"/dst1", "/dst2"}) || !reflect.DeepEqual(e.BackendsUpdated, []string{"etcd"}) {
+		t.Errorf("unexpected event %+v", e)
+	}
+
+	// a failing webhook is logged and doesn't block
+	server.Close()
+	res.notifyHooks(TriggerBackend, "etcd", []string{"/dst0"}, nil)
+	res.flushAndWaitHooks()
+}
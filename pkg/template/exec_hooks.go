@@ -0,0 +1,81 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultHookTimeout is the timeout of the pre start and post stop commands if none is configured.
+const defaultHookTimeout = 30 * time.Second
+
+// execHook is a command that runs before the child process is started or after it has been stopped.
+type execHook struct {
+	name    string
+	cmd     string
+	timeout time.Duration
+}
+
+func newExecHook(name, cmd string, timeout int) *execHook {
+	if cmd == "" {
+		return nil
+	}
+	return &execHook{
+		name:    name,
+		cmd:     cmd,
+		timeout: secondsOrDefault(timeout, defaultHookTimeout),
+	}
+}
+
+// destinations returns the destination paths of all templates, including the files generated in fan-out mode.
+func (t *Resource) destinations() []string {
+	var dsts []string
+	for _, s := range t.sources {
+		if s.Iterate == "" {
+			dsts = append(dsts, s.Dst)
+			continue
+		}
+		var generated []string
+		for dst := range s.fanout {
+			generated = append(generated, dst)
+		}
+		sort.Strings(generated)
+		dsts = append(dsts, generated...)
+	}
+	return dsts
+}
+
+// runHook runs the hook command with the timeout.
+// {{.dst}} in the command is replaced with the destination paths of all templates (separated by spaces).
+func (t *Resource) runHook(ctx context.Context, h *execHook) error {
+	cmd, err := renderTemplate(h.cmd, map[string]string{"dst": strings.Join(t.destinations(), " ")})
+	if err != nil {
+		return errors.Wrapf(err, "rendering the %s failed", h.name)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	output, err := execCommandContext(ctx, cmd, nil, nil, t.logger, nil)
+	logger := t.logger.WithField("command", cmd)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", h.timeout)
+		}
+		logger.Error(fmt.Sprintf("%q", string(output)))
+		return errors.Wrapf(err, "the %s failed", h.name)
+	}
+	logger.WithField("output", string(output)).Infof("executed the %s", h.name)
+	return nil
+}
@@ -0,0 +1,133 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv/mock"
+)
+
+func TestRunHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	res := &Resource{
+		logger:  newTestLogger(),
+		sources: []*Renderer{{Dst: "/etc/a.conf"}, {Dst: "/etc/b.conf"}},
+	}
+	if err := res.runHook(context.Background(), newExecHook("pre start cmd", "echo {{.dst}} > "+out, 0)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "/etc/a.conf /etc/b.conf\n" {
+		t.Errorf("unexpected destinations %q", string(data))
+	}
+
+	if err := res.runHook(context.Background(), newExecHook("pre start cmd", "exit 1", 0)); err == nil {
+		t.Error("expected the failing hook to fail")
+	}
+
+	h := newExecHook("post stop cmd", "sleep 5", 0)
+	h.timeout = 100 * time.Millisecond
+	err = res.runHook(context.Background(), h)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func newHookResource(t *testing.T, dir, preStart, postStop string) *Resource {
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte("{{ getv(\"/key\") }}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := Backend{Name: "mock", Onetime: true, Keys: []string{"/"}}
+	b.ReadWatcher, _ = mock.New(nil, map[string]string{"/key": "rendered"})
+
+	exec := NewExecutor("sleep 30", "", "", 1, 0, newTestLogger())
+	res, err := NewResource([]Backend{b}, []*Renderer{{Src: src, Dst: filepath.Join(dir, "dst")}}, "test", exec, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.preStart = newExecHook("pre start cmd", preStart, 0)
+	res.postStop = newExecHook("post stop cmd", postStop, 0)
+	return res
+}
+
+func TestMonitorHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	preOut := filepath.Join(dir, "pre")
+	postOut := filepath.Join(dir, "post")
+
+	// the pre start cmd sees the rendered destination
+	res := newHookResource(t, dir, "cat {{.dst}} > "+preOut, "echo stopped > "+postOut)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		res.Monitor(ctx)
+		close(done)
+	}()
+	waitForFile(t, preOut)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Monitor didn't return")
+	}
+	if res.Failed {
+		t.Error("the resource shouldn't have failed")
+	}
+	if data, _ := ioutil.ReadFile(preOut); string(data) != "rendered" {
+		t.Errorf("unexpected pre start output %q", string(data))
+	}
+	if data, _ := ioutil.ReadFile(postOut); string(data) != "stopped\n" {
+		t.Errorf("unexpected post stop output %q", string(data))
+	}
+
+	// a failing pre start cmd fails the resource, the child is never started and the post stop cmd never runs
+	os.Remove(postOut)
+	res = newHookResource(t, dir, "exit 1", "echo stopped > "+postOut)
+	res.Monitor(context.Background())
+	if !res.Failed {
+		t.Error("expected the resource to fail")
+	}
+	if _, err := os.Stat(postOut); !os.IsNotExist(err) {
+		t.Error("the post stop cmd shouldn't run without a child")
+	}
+}
+
+func waitForFile(t *testing.T, name string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if fi, err := os.Stat(name); err == nil && fi.Size() > 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s wasn't written", name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
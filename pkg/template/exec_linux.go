@@ -0,0 +1,16 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import "syscall"
+
+// setParentDeathSignal kills the command if its parent dies.
+func setParentDeathSignal(a *syscall.SysProcAttr) {
+	a.Pdeathsig = syscall.SIGKILL
+}
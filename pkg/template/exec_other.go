@@ -0,0 +1,16 @@
+// +build !linux,!windows
+
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import "syscall"
+
+// setParentDeathSignal is a no-op, parent death signals are only supported on linux.
+func setParentDeathSignal(a *syscall.SysProcAttr) {}
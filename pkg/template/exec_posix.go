@@ -0,0 +1,111 @@
+// +build !windows
+
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// execProcess replaces the current process with the command.
+func execProcess(path string, args, env []string) error {
+	return syscall.Exec(path, args, env)
+}
+
+// setProcessGroup starts the command in its own process group,
+// so that killProcessGroup also kills the children of the shell.
+func setProcessGroup(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills the started command and all processes in its process group.
+func killProcessGroup(c *exec.Cmd) {
+	syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+}
+
+// processGroupsSupported is true if the child can be started in its own process group.
+const processGroupsSupported = true
+
+// setOwnProcessGroup moves the current process into a new process group with its pid as the group ID.
+func setOwnProcessGroup() error {
+	return syscall.Setpgid(0, 0)
+}
+
+// signalProcessGroup sends the signal to all processes in the process group of the group leader pid.
+func signalProcessGroup(pid int, s os.Signal) error {
+	sig, ok := s.(syscall.Signal)
+	if !ok {
+		return fmt.Errorf("unsupported signal %v", s)
+	}
+	return syscall.Kill(-pid, sig)
+}
+
+// runAs runs the command with the credentials, forwards all signals to it and exits with its exit code.
+func runAs(path string, args, env []string, c *credentials) error {
+	cmd := exec.Command(path, args[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: c.uid, Gid: c.gid, Groups: c.groups},
+	}
+	// the command must not outlive this process if it is killed
+	setParentDeathSignal(cmd.SysProcAttr)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals)
+	if err := cmd.Start(); err != nil {
+		if os.IsPermission(err) {
+			return errors.Wrap(err, "insufficient privileges")
+		}
+		return err
+	}
+
+	go func() {
+		for s := range signals {
+			if s == syscall.SIGCHLD || s == syscall.SIGURG {
+				continue
+			}
+			cmd.Process.Signal(s)
+		}
+	}()
+
+	err := cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				os.Exit(128 + int(status.Signal()))
+			}
+			os.Exit(status.ExitStatus())
+		}
+	}
+	if err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
+
+// setUmask sets the umask of the current process.
+func setUmask(mask int) {
+	syscall.Umask(mask)
+}
+
+// processAlive reports whether the process with the pid is still running.
+func processAlive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
@@ -0,0 +1,71 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// execProcess runs the command and exits with its exit code, windows can't replace the current process.
+func execProcess(path string, args, env []string) error {
+	c := exec.Command(path, args[1:]...)
+	c.Env = env
+	c.Stdin = os.Stdin
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
+
+// setProcessGroup is a no-op, process groups are not supported on windows.
+func setProcessGroup(c *exec.Cmd) {}
+
+// killProcessGroup kills the started command.
+func killProcessGroup(c *exec.Cmd) {
+	c.Process.Kill()
+}
+
+// processGroupsSupported is false, process groups are not supported on windows.
+const processGroupsSupported = false
+
+// setOwnProcessGroup is a no-op, process groups are not supported on windows.
+func setOwnProcessGroup() error {
+	return nil
+}
+
+// signalProcessGroup is not supported on windows.
+func signalProcessGroup(pid int, s os.Signal) error {
+	return fmt.Errorf("process groups are not supported on windows")
+}
+
+// runAs is not supported on windows.
+func runAs(path string, args, env []string, c *credentials) error {
+	return fmt.Errorf("running the child as another user is not supported on windows")
+}
+
+// setUmask is a no-op, windows has no umask.
+func setUmask(mask int) {}
+
+// processAlive reports whether the process with the pid is still running.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
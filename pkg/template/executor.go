@@ -11,7 +11,14 @@ package template
 import (
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"syscall"
 	"time"
 
@@ -41,11 +48,215 @@ type ExecConfig struct {
 	// KillTimeout - the maximum amount of time in seconds to wait for the child process to gracefully terminate.
 	KillTimeout int `toml:"kill_timeout" json:"kill_timeout"`
 
-	// A random splay to wait before killing the command.
+	// KillSequence is a sequence of KillSignal and KillTimeout pairs to stop the child process,
+	// e.g. SIGTERM, then SIGQUIT after 20 seconds. The child is killed (SIGKILL) after the last stage.
+	// KillSequence can't be combined with KillSignal and KillTimeout.
+	KillSequence []KillStage `toml:"kill_sequence" json:"kill_sequence"`
+
+	// KillProcessGroup starts the child process in its own process group and sends the kill signals
+	// to the whole group, so that the processes started by the child don't outlive it.
+	// The default is true, it is ignored on windows.
+	KillProcessGroup *bool `toml:"kill_process_group" json:"kill_process_group"`
+
+	// Splay is the maximum random time in seconds to wait before the child process is reloaded with the ReloadSignal
+	// or, without a ReloadSignal, killed to be restarted. A new random delay between 0 and Splay is picked for every reload.
 	// May be useful in large clusters to prevent all child processes to reload at the same time when configuration changes occur.
+	// 0 (the default) reloads immediately.
 	Splay int `json:"splay"`
+
+	// StartupSplay is the maximum random time in seconds to wait before the child process is started for the first time,
+	// e.g. to prevent a thundering herd when remco manages many identical services across many hosts.
+	// 0 (the default) starts the child immediately.
+	StartupSplay int `toml:"startup_splay" json:"startup_splay"`
+
+	// ReloadHTTP reloads the child by sending a request to its admin endpoint
+	// instead of sending the ReloadSignal or restarting it.
+	ReloadHTTP *HTTPReload `toml:"reload_http" json:"reload_http"`
+
+	// Restart defines when the child process is restarted after it exited unexpectedly.
+	// Valid values are "never" (the default), "on-failure" (non-zero exit code) and "always".
+	// The backends are neither reconnected nor are the templates rendered again.
+	Restart string `json:"restart"`
+
+	// RestartMaxRetries is the maximum number of restarts before the resource is marked as failed.
+	// 0 means unlimited.
+	RestartMaxRetries int `toml:"restart_max_retries" json:"restart_max_retries"`
+
+	// RestartBackoff is the initial time in seconds to wait before the child is restarted.
+	// The backoff is doubled after every restart (up to 60 seconds). The default is 1 second.
+	RestartBackoff int `toml:"restart_backoff" json:"restart_backoff"`
+
+	// RestartHealthyAfter is the time in seconds the child needs to run until the restart count and backoff are reset.
+	// The default is 60 seconds.
+	RestartHealthyAfter int `toml:"restart_healthy_after" json:"restart_healthy_after"`
+
+	// Supervise turns remco into a process supervisor.
+	// The child is restarted forever after it exited, the resource is never torn down.
+	// The first restart is delayed by RestartBackoff or, if not set, by Splay.
+	// Supervise can't be combined with another restart policy or RestartMaxRetries.
+	Supervise bool `json:"supervise"`
+
+	// RawOutput writes the stdout and stderr of the child directly to the stdout and stderr of remco.
+	// The output is logged line by line otherwise, e.g. for children that produce binary or very chatty output.
+	RawOutput bool `toml:"raw_output" json:"raw_output"`
+
+	// Env holds additional environment variables of the child process.
+	// The values are templates that are rendered against the values of all backends when the child is started.
+	// The values are never logged.
+	Env map[string]string `json:"env"`
+
+	// EnvChange defines what happens if the environment of the child changed.
+	// "reload_signal" (the default) reloads the child like a changed template,
+	// the new environment is only used when the child is started again.
+	// "restart" stops the child and starts it again with the new environment.
+	EnvChange string `toml:"env_change" json:"env_change"`
+
+	// User is the name or the numeric ID of the user the child process runs as.
+	// The supplementary groups of the user are set as well.
+	// remco must run as root to change the user.
+	User string `json:"user"`
+
+	// Group is the name or the numeric ID of the group the child process runs as.
+	// The default is the primary group of User.
+	Group string `json:"group"`
+
+	// WorkingDir is the working directory of the child process.
+	// The default is the working directory of remco.
+	WorkingDir string `toml:"working_dir" json:"working_dir"`
+
+	// Umask is the octal file mode creation mask of the child process, e.g. "027".
+	// The umask of remco isn't changed.
+	Umask string `json:"umask"`
+
+	// ExtraEnv holds additional static environment variables of the child process.
+	// The values of Env take precedence.
+	ExtraEnv map[string]string `toml:"extra_env" json:"extra_env"`
+
+	// ReloadDebounce is the quiet period in seconds to wait after a change before the child is reloaded
+	// and the reload commands are run. The changes of all render cycles during the period are covered by a single reload.
+	// 0 (the default) reloads after every render cycle.
+	ReloadDebounce int `toml:"reload_debounce" json:"reload_debounce"`
+
+	// ReloadDebounceMax is the maximum time in seconds a reload is delayed by ReloadDebounce.
+	// The default is 10 times ReloadDebounce.
+	ReloadDebounceMax int `toml:"reload_debounce_max" json:"reload_debounce_max"`
+
+	// ReloadSplay is the maximum random time in seconds to wait before the child is reloaded
+	// and the reload commands are run, e.g. to prevent all instances of a cluster from reloading at the same time.
+	// Changes during the wait are covered by the same reload. 0 (the default) reloads immediately.
+	ReloadSplay int `toml:"reload_splay" json:"reload_splay"`
+
+	// HealthcheckCmd is a command that checks the health of the child process, it must exit with 0 if the child is healthy.
+	// Only one of HealthcheckCmd, HealthcheckTCP and HealthcheckHTTP can be set.
+	HealthcheckCmd string `toml:"healthcheck_cmd" json:"healthcheck_cmd"`
+
+	// HealthcheckTCP is an address (host:port) the child process must accept connections on.
+	HealthcheckTCP string `toml:"healthcheck_tcp" json:"healthcheck_tcp"`
+
+	// HealthcheckHTTP is a URL that must return a 2xx or 3xx status code.
+	HealthcheckHTTP string `toml:"healthcheck_http" json:"healthcheck_http"`
+
+	// HealthcheckInterval is the time in seconds between two health checks. The default is 10.
+	HealthcheckInterval int `toml:"healthcheck_interval" json:"healthcheck_interval"`
+
+	// HealthcheckTimeout is the maximum time in seconds a health check may take. The default is 5.
+	HealthcheckTimeout int `toml:"healthcheck_timeout" json:"healthcheck_timeout"`
+
+	// HealthcheckRetries is the number of consecutive failed health checks after which the child is recovered.
+	// The default is 3.
+	HealthcheckRetries int `toml:"healthcheck_retries" json:"healthcheck_retries"`
+
+	// HealthcheckAction is the recovery action of an unhealthy child:
+	// "restart" (the default) restarts the child, "reload_signal" reloads it like a changed template
+	// and "command" runs HealthcheckActionCmd.
+	HealthcheckAction string `toml:"healthcheck_action" json:"healthcheck_action"`
+
+	// HealthcheckActionCmd is the recovery command of HealthcheckAction "command".
+	HealthcheckActionCmd string `toml:"healthcheck_action_cmd" json:"healthcheck_action_cmd"`
+
+	// PreStartCmd is executed after the templates have been rendered for the first time, before the child is started.
+	// The resource fails if the command fails. {{.dst}} is replaced with the destination paths of all templates.
+	PreStartCmd string `toml:"pre_start_cmd" json:"pre_start_cmd"`
+
+	// PreStartTimeout is the maximum amount of time in seconds the PreStartCmd may take. The default is 30.
+	PreStartTimeout int `toml:"pre_start_timeout" json:"pre_start_timeout"`
+
+	// PostStopCmd is executed after the child has been stopped. Errors are logged.
+	// {{.dst}} is replaced with the destination paths of all templates.
+	PostStopCmd string `toml:"post_stop_cmd" json:"post_stop_cmd"`
+
+	// PostStopTimeout is the maximum amount of time in seconds the PostStopCmd may take. The default is 30.
+	PostStopTimeout int `toml:"post_stop_timeout" json:"post_stop_timeout"`
+
+	// SignalMap translates the signals remco receives before they are forwarded to the child,
+	// e.g. {SIGWINCH = "SIGHUP"}. Signals mapped to "ignore" aren't forwarded.
+	// Signals without an entry are forwarded unchanged.
+	SignalMap map[string]string `toml:"signal_map" json:"signal_map"`
+
+	// PIDFile is the path of a file the PID of the child process is written to.
+	// The file is rewritten whenever the child is restarted and removed when the child is stopped.
+	PIDFile string `toml:"pid_file" json:"pid_file"`
+
+	// PIDFileMode is the octal file mode of the PIDFile, e.g. "0600". The default is "0644".
+	PIDFileMode string `toml:"pid_file_mode" json:"pid_file_mode"`
+}
+
+// restartPolicy returns the restart parameters of the configuration.
+func (c ExecConfig) restartPolicy() (policy string, maxRetries, backoff int, err error) {
+	if !c.Supervise {
+		return c.Restart, c.RestartMaxRetries, c.RestartBackoff, nil
+	}
+	if (c.Restart != "" && c.Restart != RestartAlways) || c.RestartMaxRetries != 0 {
+		return "", 0, 0, fmt.Errorf("supervise can't be combined with restart %q and restart_max_retries %d", c.Restart, c.RestartMaxRetries)
+	}
+	backoff = c.RestartBackoff
+	if backoff == 0 {
+		backoff = c.Splay
+	}
+	return RestartAlways, 0, backoff, nil
 }
 
+// executor creates the Executor of the configuration.
+func (c ExecConfig) executor(logger *logrus.Entry) (Executor, error) {
+	exec := NewExecutor(c.Command, c.ReloadSignal, c.KillSignal, c.KillTimeout, c.Splay, logger)
+	if c.ReloadHTTP != nil {
+		if err := c.ReloadHTTP.validate(); err != nil {
+			return exec, err
+		}
+	}
+	policy, maxRetries, backoff, err := c.restartPolicy()
+	if err != nil {
+		return exec, err
+	}
+	if err := exec.SetRestartPolicy(policy, maxRetries, backoff, c.RestartHealthyAfter); err != nil {
+		return exec, err
+	}
+	exec.SetRawOutput(c.RawOutput)
+	exec.SetKillProcessGroup(c.KillProcessGroup == nil || *c.KillProcessGroup)
+	exec.SetStartupSplay(c.StartupSplay)
+	exec.SetCredentials(c.User, c.Group)
+	if err := exec.SetProcessAttributes(c.WorkingDir, c.Umask, c.ExtraEnv); err != nil {
+		return exec, err
+	}
+	if err := exec.SetPIDFile(c.PIDFile, c.PIDFileMode); err != nil {
+		return exec, err
+	}
+	stages, err := c.killSequence()
+	if err != nil {
+		return exec, err
+	}
+	return exec, exec.SetKillSequence(stages)
+}
+
+// Valid values of ExecConfig.Restart.
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+const maxRestartBackoff = 60 * time.Second
+
 type childSignal struct {
 	signal os.Signal
 	err    chan<- error
@@ -65,13 +276,50 @@ type Executor struct {
 	reloadSignal os.Signal
 	killSignal   os.Signal
 	killTimeout  time.Duration
+	killSequence []killStage
 	splay        time.Duration
 	logger       *logrus.Entry
 
-	stopChan   chan chan<- error
-	reloadChan chan chan<- error
-	signalChan chan childSignal
-	exitChan   chan chan exitC
+	// resource is the name of the resource the reload splays are recorded with.
+	resource string
+
+	// killProcessGroup is true if the child runs in its own process group
+	killProcessGroup bool
+
+	// startupSplay is the maximum random delay before the child is started, see waitStartupSplay.
+	startupSplay time.Duration
+
+	restart             string
+	restartMaxRetries   int
+	restartBackoff      time.Duration
+	restartHealthyAfter time.Duration
+
+	rawOutput bool
+	stdout    *logWriter
+	stderr    *logWriter
+
+	// envDir holds the file with the environment of the child.
+	envDir string
+
+	user  string
+	group string
+
+	workingDir string
+	// umask is -1 if the umask of remco is inherited
+	umask    int
+	extraEnv map[string]string
+
+	pidFile     string
+	pidFileMode os.FileMode
+	// childPID holds the PID of the running child, it is shared by the copies of the Executor.
+	childPID *int32
+
+	stopChan    chan chan<- error
+	reloadChan  chan chan<- error
+	restartChan chan chan<- error
+	respawnChan chan chan<- error
+	signalChan  chan childSignal
+	exitChan    chan chan exitC
 }
 
 // NewExecutor creates a new Executor.
@@ -105,55 +353,237 @@ func NewExecutor(execCommand, reloadSignal, killSignal string, killTimeout, spla
 		killTimeout = 10
 	}
 
+	// a negative splay is no splay
+	if splay < 0 {
+		splay = 0
+	}
+
 	return Executor{
-		execCommand:  execCommand,
-		reloadSignal: rs,
-		killSignal:   ks,
-		killTimeout:  time.Duration(killTimeout) * time.Second,
-		splay:        time.Duration(splay) * time.Second,
-		logger:       logger,
-		stopChan:     make(chan chan<- error),
-		reloadChan:   make(chan chan<- error),
-		signalChan:   make(chan childSignal),
-		exitChan:     make(chan chan exitC),
+		execCommand:      execCommand,
+		reloadSignal:     rs,
+		killSignal:       ks,
+		killTimeout:      time.Duration(killTimeout) * time.Second,
+		splay:            time.Duration(splay) * time.Second,
+		killProcessGroup: processGroupsSupported,
+		logger:           logger,
+		restart:          RestartNever,
+		umask:            -1,
+		stopChan:         make(chan chan<- error),
+		reloadChan:       make(chan chan<- error),
+		restartChan:      make(chan chan<- error),
+		respawnChan:      make(chan chan<- error),
+		signalChan:       make(chan childSignal),
+		exitChan:         make(chan chan exitC),
+		childPID:         new(int32),
+	}
+}
+
+// SetRestartPolicy configures if and how often the child process is restarted after it exited unexpectedly.
+// See ExecConfig for the meaning and the defaults of the parameters.
+func (e *Executor) SetRestartPolicy(policy string, maxRetries, backoff, healthyAfter int) error {
+	switch policy {
+	case "":
+		policy = RestartNever
+	case RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return fmt.Errorf("invalid restart policy %q", policy)
+	}
+
+	if backoff <= 0 {
+		backoff = 1
 	}
+	if healthyAfter <= 0 {
+		healthyAfter = 60
+	}
+
+	e.restart = policy
+	e.restartMaxRetries = maxRetries
+	e.restartBackoff = time.Duration(backoff) * time.Second
+	e.restartHealthyAfter = time.Duration(healthyAfter) * time.Second
+	return nil
+}
+
+// SetRawOutput configures whether the output of the child process is written directly
+// to the stdout and stderr of remco instead of the logger.
+func (e *Executor) SetRawOutput(raw bool) {
+	e.rawOutput = raw
+}
+
+// SetEnv sets the additional environment variables of the child process.
+// The environment is used the next time the child is started.
+// It must be called before SpawnChild if the child needs an environment at all.
+func (e *Executor) SetEnv(env map[string]string) error {
+	if e.envDir == "" {
+		dir, err := ioutil.TempDir("", "remco-env")
+		if err != nil {
+			return errors.Wrap(err, "couldn't create the environment directory")
+		}
+		e.envDir = dir
+	}
+	merged := make(map[string]string, len(e.extraEnv)+len(env))
+	for k, v := range e.extraEnv {
+		merged[k] = v
+	}
+	for k, v := range env {
+		merged[k] = v
+	}
+	if err := writeEnvFile(e.envFile(), merged); err != nil {
+		return errors.Wrap(err, "couldn't write the environment")
+	}
+
+	keys := make([]string, 0, len(merged))
+	for k := range merged {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	e.logger.WithField("env", keys).Debug("set the environment of the child process")
+	return nil
+}
+
+// SetCredentials sets the user and the group (names or numeric IDs) of the child process.
+// They are resolved when the child is started.
+func (e *Executor) SetCredentials(user, group string) {
+	e.user = user
+	e.group = group
+}
+
+// SetProcessAttributes sets the working directory, the octal umask (e.g. "027") and
+// the additional static environment variables of the child process.
+// Empty values keep the attributes of remco.
+func (e *Executor) SetProcessAttributes(workingDir, umask string, extraEnv map[string]string) error {
+	e.umask = -1
+	if umask != "" {
+		m, err := strconv.ParseUint(umask, 8, 32)
+		if err != nil || m > 0777 {
+			return fmt.Errorf("invalid umask %q, expected an octal value like 027", umask)
+		}
+		if runtime.GOOS == "windows" {
+			return fmt.Errorf("umask is not supported on windows")
+		}
+		e.umask = int(m)
+	}
+	e.workingDir = workingDir
+	e.extraEnv = extraEnv
+	return nil
+}
+
+func (e *Executor) envFile() string {
+	return filepath.Join(e.envDir, "env.json")
+}
+
+func (e *Executor) newChild() (*child.Child, error) {
+	p := shellwords.NewParser()
+	p.ParseBacktick = true
+	args, err := p.Parse(e.execCommand)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr io.Writer = os.Stdout, os.Stderr
+	if !e.rawOutput {
+		// the writers are shared by all restarts of the child
+		if e.stdout == nil {
+			logger := e.logger.WithFields(logrus.Fields{
+				"child":   true,
+				"command": filepath.Base(args[0]),
+			})
+			e.stdout = newLogWriter(logger.WithField("stream", "stdout"))
+			e.stderr = newLogWriter(logger.WithField("stream", "stderr"))
+		}
+		stdout, stderr = e.stdout, e.stderr
+	}
+
+	creds, err := resolveCredentials(e.user, e.group)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil && runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("running the child as another user is not supported on windows")
+	}
+
+	if e.workingDir != "" {
+		fi, err := os.Stat(e.workingDir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid working_dir %q", e.workingDir)
+		}
+		if !fi.IsDir() {
+			return nil, fmt.Errorf("invalid working_dir %q: not a directory", e.workingDir)
+		}
+	}
+
+	if e.envDir != "" || creds != nil || e.workingDir != "" || e.umask >= 0 || e.killProcessGroup {
+		// start the command via remco to set the process attributes, see ExecChildSubcommand.
+		// The launcher starts even if the command doesn't exist, so it is looked up here
+		// unless the child has another working directory or PATH.
+		if e.workingDir == "" && e.extraEnv["PATH"] == "" {
+			if _, err := exec.LookPath(args[0]); err != nil {
+				return nil, fmt.Errorf("error creating child: %s", err)
+			}
+		}
+		exe, err := os.Executable()
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't find the remco executable")
+		}
+		launcher := []string{exe, ExecChildSubcommand}
+		if e.envDir != "" {
+			launcher = append(launcher, "-env", e.envFile())
+		}
+		if creds != nil {
+			launcher = append(launcher, creds.args()...)
+		}
+		if e.workingDir != "" {
+			launcher = append(launcher, "-dir", e.workingDir)
+		}
+		if e.umask >= 0 {
+			launcher = append(launcher, "-umask", fmt.Sprintf("%03o", e.umask))
+		}
+		if e.killProcessGroup {
+			launcher = append(launcher, "-setpgid")
+		}
+		args = append(append(launcher, "--"), args...)
+	}
+
+	// the splay is waited (and recorded) by the executor before a reload, see waitReloadSplay
+	c, err := child.New(&child.NewInput{
+		Stdin:        os.Stdin,
+		Stdout:       stdout,
+		Stderr:       stderr,
+		Command:      args[0],
+		Args:         args[1:],
+		ReloadSignal: e.reloadSignal,
+		KillSignal:   e.killSignal,
+		KillTimeout:  e.killTimeout,
+		Logger:       e.logger,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating child: %s", err)
+	}
+	return c, nil
 }
 
 // SpawnChild parses e.execCommand and starts the child process accordingly.
 // Backtick parsing is supported:
-//   ./foo `echo $SHELL`
+//
+//	./foo `echo $SHELL`
 //
 // only call this once !
 func (e *Executor) SpawnChild() error {
 	var c *child.Child
 	if e.execCommand != "" {
-		p := shellwords.NewParser()
-		p.ParseBacktick = true
-		args, err := p.Parse(e.execCommand)
-		if err != nil {
-			return err
+		if len(e.extraEnv) > 0 && e.envDir == "" {
+			if err := e.SetEnv(nil); err != nil {
+				return err
+			}
 		}
-
-		c, err = child.New(&child.NewInput{
-			Stdin:        os.Stdin,
-			Stdout:       os.Stdout,
-			Stderr:       os.Stderr,
-			Command:      args[0],
-			Args:         args[1:],
-			ReloadSignal: e.reloadSignal,
-			KillSignal:   e.killSignal,
-			KillTimeout:  e.killTimeout,
-			Splay:        e.splay,
-			Logger:       e.logger,
-		})
-
+		var err error
+		c, err = e.newChild()
 		if err != nil {
-			return fmt.Errorf("error creating child: %s", err)
+			return err
 		}
-		//e.child = child
 		if err := c.Start(); err != nil {
 			return fmt.Errorf("error starting child: %s", err)
 		}
+		e.childStarted(c)
 	}
 
 	go func() {
@@ -161,14 +591,60 @@ func (e *Executor) SpawnChild() error {
 			select {
 			case errchan := <-e.stopChan:
 				if c != nil {
+					pid := c.Pid()
+					e.escalateKill(c)
 					c.Stop()
+					e.killOrphans(pid)
+				}
+				e.setChildPID(0)
+				e.removePIDFile()
+				e.flushOutput()
+				if e.envDir != "" {
+					os.RemoveAll(e.envDir)
 				}
 				errchan <- nil
 				return
 			case errchan := <-e.reloadChan:
 				var err error
 				if c != nil {
+					e.waitReloadSplay()
+					pid := c.Pid()
 					err = c.Reload()
+					if e.reloadSignal == nil {
+						e.killOrphans(pid)
+					}
+				}
+				if e.reloadSignal == nil {
+					// the child was restarted
+					e.flushOutput()
+					e.childStarted(c)
+				}
+				errchan <- err
+			case errchan := <-e.restartChan:
+				// the exited child is replaced by a new one
+				if c != nil {
+					e.killOrphans(c.Pid())
+				}
+				nc, err := e.newChild()
+				if err == nil {
+					err = nc.Start()
+				}
+				if err == nil {
+					c = nc
+					e.childStarted(c)
+				}
+				errchan <- err
+			case errchan := <-e.respawnChan:
+				// the child is restarted even if a reload signal is configured
+				var err error
+				if c != nil {
+					pid := c.Pid()
+					c.Kill()
+					e.killOrphans(pid)
+					err = c.Start()
+				}
+				if err == nil {
+					e.childStarted(c)
 				}
 				errchan <- err
 			case s := <-e.signalChan:
@@ -197,6 +673,14 @@ func (e *Executor) SpawnChild() error {
 	return nil
 }
 
+// flushOutput logs the incomplete last lines of the child output.
+func (e *Executor) flushOutput() {
+	if e.stdout != nil {
+		e.stdout.Flush()
+		e.stderr.Flush()
+	}
+}
+
 // SignalChild forwards the os.Signal to the child process.
 func (e *Executor) SignalChild(s os.Signal) error {
 	err := make(chan error)
@@ -233,6 +717,16 @@ func (e *Executor) Reload() error {
 	return nil
 }
 
+// RespawnChild stops the child process and starts it again.
+func (e *Executor) RespawnChild() error {
+	errchan := make(chan error)
+	e.respawnChan <- errchan
+	if err := <-errchan; err != nil {
+		return errors.Wrap(err, "restart failed")
+	}
+	return nil
+}
+
 func (e *Executor) getExitChan() (<-chan int, bool) {
 	ecc := make(chan exitC)
 	e.exitChan <- ecc
@@ -240,21 +734,53 @@ func (e *Executor) getExitChan() (<-chan int, bool) {
 	return exit.exitChan, exit.valid
 }
 
+// restartChild starts a new child process after the old one has exited.
+func (e *Executor) restartChild(ctx context.Context) error {
+	errchan := make(chan error)
+	select {
+	case e.restartChan <- errchan:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if err := <-errchan; err != nil {
+		return errors.Wrap(err, "restart failed")
+	}
+	return nil
+}
+
+// shouldRestart reports whether the child has to be restarted after it exited with the given code.
+func (e *Executor) shouldRestart(code int) bool {
+	switch e.restart {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return code != 0
+	}
+	return false
+}
+
 // Wait waits for the child to stop.
 // Returns true if the command stops unexpectedly and false if the context is canceled.
 //
 // Wait ignores reloads.
+// The child is restarted according to the restart policy,
+// true is only returned after the restart retries are exhausted.
 func (e *Executor) Wait(ctx context.Context) bool {
 	exitChan, valid := e.getExitChan()
 	if !valid {
 		return false
 	}
 
+	var restarts int
+	backoff := e.restartBackoff
+	started := time.Now()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return false
-		case <-exitChan:
+		case code := <-exitChan:
+			e.flushOutput()
 			// wait a little bit to give the process time to start
 			// in case of a reload
 			time.Sleep(1 * time.Second)
@@ -265,8 +791,54 @@ func (e *Executor) Wait(ctx context.Context) bool {
 				exitChan = nexitChan
 				continue
 			}
-			// the process exited - stop
-			return true
+			e.setChildPID(0)
+			if !e.shouldRestart(code) {
+				// the process exited - stop
+				return true
+			}
+
+			// the child ran long enough - start over
+			if time.Since(started) >= e.restartHealthyAfter {
+				restarts = 0
+				backoff = e.restartBackoff
+			}
+
+			for {
+				if e.restartMaxRetries > 0 && restarts >= e.restartMaxRetries {
+					e.logger.WithFields(logrus.Fields{
+						"exit_code":     code,
+						"restart_count": restarts,
+					}).Error("child process exited - no restart retries left")
+					return true
+				}
+				restarts++
+				e.logger.WithFields(logrus.Fields{
+					"exit_code":     code,
+					"restart_count": restarts,
+				}).Warning(fmt.Sprintf("child process exited - restarting in %s", backoff))
+
+				select {
+				case <-ctx.Done():
+					return false
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+				if backoff > maxRestartBackoff {
+					backoff = maxRestartBackoff
+				}
+
+				err := e.restartChild(ctx)
+				if err == nil {
+					break
+				}
+				if ctx.Err() != nil {
+					return false
+				}
+				e.logger.Error(err)
+			}
+
+			started = time.Now()
+			exitChan, _ = e.getExitChan()
 		}
 	}
 }
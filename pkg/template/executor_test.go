@@ -10,8 +10,13 @@ package template
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -107,6 +112,239 @@ func spawnTimeOutChild() (Executor, error) {
 	return spawnChild(command)
 }
 
+// executorTestHelper starts the test binary as a long-running child, see runExecutorTestHelper.
+const executorTestHelper = "executor-test-helper"
+
+// runExecutorTestHelper is the child of the executor tests.
+// It writes its PID to the file args[0] and appends the names of the signals it receives.
+// It exits with 0 on SIGTERM (unless args[1] is "ignore-term") and with 2 on SIGINT.
+func runExecutorTestHelper(args []string) int {
+	out := args[0]
+	ignoreTerm := len(args) > 1 && args[1] == "ignore-term"
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	if err := ioutil.WriteFile(out, []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+		return 1
+	}
+	for s := range signals {
+		f, err := os.OpenFile(out, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return 1
+		}
+		fmt.Fprintln(f, s.String())
+		f.Close()
+		switch {
+		case s == syscall.SIGINT:
+			return 2
+		case s == syscall.SIGTERM && !ignoreTerm:
+			return 0
+		}
+	}
+	return 0
+}
+
+// helperCommand returns the command that starts the test helper with the helper arguments
+// and the file the helper writes to. The caller removes the directory of the file.
+func helperCommand(t *testing.T, args ...string) (string, string) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "remco-executor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out")
+	return strings.Join(append([]string{exe, executorTestHelper, out}, args...), " "), out
+}
+
+// spawnHelperChild starts the test helper with the given reload signal, kill timeout and helper arguments.
+// It returns the PID of the helper and the file the helper writes to.
+func spawnHelperChild(t *testing.T, reloadSignal string, killTimeout int, args ...string) (*Executor, int, string) {
+	command, out := helperCommand(t, args...)
+	exec := NewExecutor(command, reloadSignal, "SIGTERM", killTimeout, 0, newTestLogger())
+	if err := exec.SpawnChild(); err != nil {
+		os.RemoveAll(filepath.Dir(out))
+		t.Fatal(err)
+	}
+	return &exec, helperPID(t, out), out
+}
+
+// helperPID waits for the test helper to start and returns its PID.
+func helperPID(t *testing.T, out string) int {
+	lines := waitForHelperOutput(t, out, 1)
+	pid, err := strconv.Atoi(lines[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pid
+}
+
+// waitForHelperOutput waits until the helper has written n lines to out.
+func waitForHelperOutput(t *testing.T, out string, n int) []string {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := ioutil.ReadFile(out)
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(data) > 0 && len(lines) >= n {
+			return lines
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d lines of helper output, got %q", n, string(data))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// processRunning reports whether the process with the PID is still running (and not a zombie).
+func processRunning(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	return err != nil || !strings.Contains(string(stat), ") Z ")
+}
+
+// processExited waits up to a second for the process with the PID to exit.
+func processExited(pid int) bool {
+	deadline := time.Now().Add(time.Second)
+	for processRunning(pid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+func TestSpawnChildCommandNotFound(t *testing.T) {
+	exec := NewExecutor("/nonexistent/remco-test-command", "", "", 0, 0, newTestLogger())
+	if err := exec.SpawnChild(); err == nil {
+		t.Error("SpawnChild should fail if the command doesn't exist")
+	}
+}
+
+func TestSpawnChild(t *testing.T) {
+	exec, pid, out := spawnHelperChild(t, "", 1)
+	defer os.RemoveAll(filepath.Dir(out))
+	defer exec.StopChild()
+
+	if !processRunning(pid) {
+		t.Errorf("the child %d should be running", pid)
+	}
+	if _, valid := exec.getExitChan(); !valid {
+		t.Error("we should have a valid exitChan")
+	}
+}
+
+func TestReloadSignal(t *testing.T) {
+	exec, pid, out := spawnHelperChild(t, "SIGHUP", 1)
+	defer os.RemoveAll(filepath.Dir(out))
+	defer exec.StopChild()
+
+	exitChan, _ := exec.getExitChan()
+	if err := exec.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	lines := waitForHelperOutput(t, out, 2)
+	if lines[1] != syscall.SIGHUP.String() {
+		t.Errorf("expected the reload signal %s, got %q", syscall.SIGHUP, lines[1])
+	}
+
+	// the child is signaled, not restarted
+	if nexitChan, _ := exec.getExitChan(); nexitChan != exitChan || !processRunning(pid) {
+		t.Error("the child shouldn't be restarted on a reload with a reload signal")
+	}
+}
+
+func TestWaitCancelHelper(t *testing.T) {
+	exec, pid, out := spawnHelperChild(t, "", 1)
+	defer os.RemoveAll(filepath.Dir(out))
+	defer exec.StopChild()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	failed := make(chan bool)
+	go func() {
+		failed <- exec.Wait(ctx)
+	}()
+	cancel()
+	if <-failed {
+		t.Error("Wait should return false when the context is canceled")
+	}
+	if !processRunning(pid) {
+		t.Error("canceling Wait shouldn't stop the child")
+	}
+}
+
+func TestWaitExitHelper(t *testing.T) {
+	exec, pid, out := spawnHelperChild(t, "", 1)
+	defer os.RemoveAll(filepath.Dir(out))
+	defer exec.StopChild()
+
+	failed := make(chan bool)
+	go func() {
+		failed <- exec.Wait(context.Background())
+	}()
+	syscall.Kill(pid, syscall.SIGKILL)
+
+	select {
+	case f := <-failed:
+		if !f {
+			t.Error("Wait should return true when the child exits unexpectedly")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Wait didn't return after the child exited")
+	}
+}
+
+func TestStopChild(t *testing.T) {
+	exec, pid, out := spawnHelperChild(t, "", 5)
+	defer os.RemoveAll(filepath.Dir(out))
+
+	start := time.Now()
+	exec.StopChild()
+	if !processExited(pid) {
+		t.Error("the child should be stopped")
+	}
+	if time.Since(start) > 4*time.Second {
+		t.Errorf("the child should stop on the kill signal, took %s", time.Since(start))
+	}
+	lines := waitForHelperOutput(t, out, 2)
+	if lines[1] != syscall.SIGTERM.String() {
+		t.Errorf("expected the kill signal %s, got %q", syscall.SIGTERM, lines[1])
+	}
+}
+
+func TestSignalChildHelper(t *testing.T) {
+	exec, _, out := spawnHelperChild(t, "", 1)
+	defer os.RemoveAll(filepath.Dir(out))
+	defer exec.StopChild()
+
+	if err := exec.SignalChild(syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	lines := waitForHelperOutput(t, out, 2)
+	if lines[1] != syscall.SIGINT.String() {
+		t.Errorf("expected the forwarded signal %s, got %q", syscall.SIGINT, lines[1])
+	}
+}
+
+func TestStopChildKillTimeout(t *testing.T) {
+	exec, pid, out := spawnHelperChild(t, "", 1, "ignore-term")
+	defer os.RemoveAll(filepath.Dir(out))
+
+	start := time.Now()
+	exec.StopChild()
+	elapsed := time.Since(start)
+	if !processExited(pid) {
+		t.Error("the child should be killed after the kill timeout")
+	}
+	if elapsed < time.Second || elapsed > 4*time.Second {
+		t.Errorf("the child should be killed after the kill timeout of 1s, took %s", elapsed)
+	}
+}
+
 func TestStopChildTimeOut(t *testing.T) {
 	exec, err := spawnTrapChild()
 	if err != nil {
@@ -251,3 +489,119 @@ func TestSignalChild(t *testing.T) {
 
 	exec.StopChild()
 }
+
+func TestSetRestartPolicy(t *testing.T) {
+	exec := NewExecutor("", "", "", 0, 0, &logrus.Entry{})
+	if exec.restart != RestartNever {
+		t.Errorf("default restart policy should be: %s", RestartNever)
+	}
+
+	if err := exec.SetRestartPolicy(RestartOnFailure, 3, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if exec.restartBackoff != time.Second {
+		t.Errorf("default restartBackoff should be: %v", time.Second)
+	}
+	if exec.restartHealthyAfter != time.Minute {
+		t.Errorf("default restartHealthyAfter should be: %v", time.Minute)
+	}
+
+	if err := exec.SetRestartPolicy("sometimes", 0, 0, 0); err == nil {
+		t.Error("an invalid restart policy should fail")
+	}
+}
+
+func waitRestartChild(t *testing.T, command string, config ExecConfig, timeout time.Duration) (int, bool) {
+	dir, err := ioutil.TempDir("", "remco-restart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	counter := dir + "/runs"
+
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	exec := NewExecutor(fmt.Sprintf(command, counter), "", "", 0, 0, logrus.NewEntry(logger))
+	policy, maxRetries, backoff, err := config.restartPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.SetRestartPolicy(policy, maxRetries, backoff, 60); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.SpawnChild(); err != nil {
+		t.Fatal(err)
+	}
+	defer exec.StopChild()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	failed := exec.Wait(ctx)
+
+	runs, err := ioutil.ReadFile(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(runs), failed
+}
+
+func TestWaitRestartOnFailure(t *testing.T) {
+	config := ExecConfig{Restart: RestartOnFailure, RestartMaxRetries: 2}
+	runs, failed := waitRestartChild(t, `bash -c "echo -n x >> %s; exit 1"`, config, 20*time.Second)
+	if !failed {
+		t.Error("Wait should return true after the restart retries are exhausted")
+	}
+	if runs != 3 {
+		t.Errorf("the child should run 3 times (1 start, 2 restarts), got %d", runs)
+	}
+}
+
+func TestWaitRestartOnFailureSuccess(t *testing.T) {
+	config := ExecConfig{Restart: RestartOnFailure, RestartMaxRetries: 2}
+	runs, failed := waitRestartChild(t, `bash -c "echo -n x >> %s; exit 0"`, config, 20*time.Second)
+	if !failed {
+		t.Error("Wait should return true if the child exited")
+	}
+	if runs != 1 {
+		t.Errorf("a successful child should not be restarted, got %d runs", runs)
+	}
+}
+
+func TestWaitSupervise(t *testing.T) {
+	config := ExecConfig{Supervise: true, RestartBackoff: 1}
+	runs, failed := waitRestartChild(t, `bash -c "echo -n x >> %s; exit 0"`, config, 4*time.Second)
+	if failed {
+		t.Error("Wait should never return true in supervise mode")
+	}
+	if runs < 2 {
+		t.Errorf("the child should be restarted, got %d runs", runs)
+	}
+}
+
+func TestExecConfigRestartPolicy(t *testing.T) {
+	tests := []struct {
+		config     ExecConfig
+		policy     string
+		maxRetries int
+		backoff    int
+		valid      bool
+	}{
+		{ExecConfig{Restart: RestartOnFailure, RestartMaxRetries: 3, RestartBackoff: 2}, RestartOnFailure, 3, 2, true},
+		{ExecConfig{Supervise: true, Splay: 5}, RestartAlways, 0, 5, true},
+		{ExecConfig{Supervise: true, Splay: 5, RestartBackoff: 2}, RestartAlways, 0, 2, true},
+		{ExecConfig{Supervise: true, Restart: RestartAlways}, RestartAlways, 0, 0, true},
+		{ExecConfig{Supervise: true, Restart: RestartOnFailure}, "", 0, 0, false},
+		{ExecConfig{Supervise: true, RestartMaxRetries: 3}, "", 0, 0, false},
+	}
+
+	for i, test := range tests {
+		policy, maxRetries, backoff, err := test.config.restartPolicy()
+		if (err == nil) != test.valid {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if policy != test.policy || maxRetries != test.maxRetries || backoff != test.backoff {
+			t.Errorf("test %d: expected (%s, %d, %d), got (%s, %d, %d)", i, test.policy, test.maxRetries, test.backoff, policy, maxRetries, backoff)
+		}
+	}
+}
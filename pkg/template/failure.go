@@ -0,0 +1,98 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	berr "github.com/HeavyHorst/remco/pkg/backends/error"
+)
+
+// A Failure classifies why the templates of a resource couldn't be processed.
+// More severe failures have higher values.
+type Failure int
+
+const (
+	// FailureNone means that all templates have been processed successfully.
+	FailureNone Failure = iota
+	// FailureSync means that a destination file couldn't be written, e.g. because of missing permissions.
+	FailureSync
+	// FailureCheck means that the check command rejected a rendered file.
+	FailureCheck
+	// FailureRender means that a template couldn't be rendered.
+	FailureRender
+	// FailureBackend means that a backend couldn't be connected or queried.
+	FailureBackend
+	// FailureConfig means that the resource configuration is invalid.
+	FailureConfig
+)
+
+func (f Failure) String() string {
+	switch f {
+	case FailureNone:
+		return "ok"
+	case FailureSync:
+		return "sync failed"
+	case FailureCheck:
+		return "check failed"
+	case FailureRender:
+		return "render failed"
+	case FailureBackend:
+		return "backend failed"
+	case FailureConfig:
+		return "invalid config"
+	}
+	return "unknown"
+}
+
+// failureError classifies the wrapped error.
+type failureError struct {
+	failure Failure
+	err     error
+}
+
+func (e *failureError) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns the wrapped error.
+func (e *failureError) Cause() error {
+	return e.err
+}
+
+// withFailure classifies err, it returns nil if err is nil.
+func withFailure(failure Failure, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &failureError{failure: failure, err: err}
+}
+
+// FailureOf classifies err by the outermost classified error in its chain of causes.
+// Backend and timeout errors (including a ProcessTimeoutError) are backend failures, errors of a resource configuration config failures.
+// All other errors are sync failures.
+func FailureOf(err error) Failure {
+	if err == nil {
+		return FailureNone
+	}
+	for e := err; e != nil; {
+		switch e := e.(type) {
+		case *failureError:
+			return e.failure
+		case berr.BackendError, berr.TimeoutError, ProcessTimeoutError, BackendErrors:
+			return FailureBackend
+		case ValidationError:
+			return FailureConfig
+		}
+		cause, ok := e.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		e = cause.Cause()
+	}
+	return FailureSync
+}
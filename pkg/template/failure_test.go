@@ -0,0 +1,104 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv/mock"
+	berr "github.com/HeavyHorst/remco/pkg/backends/error"
+	"github.com/pkg/errors"
+)
+
+func TestFailureOf(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected Failure
+	}{
+		{nil, FailureNone},
+		{fmt.Errorf("rename failed"), FailureSync},
+		{errors.Wrap(berr.TimeoutError{Backend: "etcd"}, "fetch failed"), FailureBackend},
+		{berr.BackendError{Backend: "etcd"}, FailureBackend},
+		{ValidationError{Resource: "test"}, FailureConfig},
+		{errors.Wrap(withFailure(FailureCheck, fmt.Errorf("exit status 1")), "sync files failed"), FailureCheck},
+		// the outermost classification wins
+		{withFailure(FailureRender, errors.Wrap(withFailure(FailureCheck, fmt.Errorf("exit status 1")), "fan-out failed")), FailureRender},
+	}
+	for _, test := range tests {
+		if f := FailureOf(test.err); f != test.expected {
+			t.Errorf("FailureOf(%v) = %s, expected %s", test.err, f, test.expected)
+		}
+	}
+	if withFailure(FailureSync, nil) != nil {
+		t.Error("withFailure should return nil for a nil error")
+	}
+}
+
+// runOnetime runs Monitor with a single onetime mock backend and the given template and check command.
+func runOnetime(t *testing.T, backendErr error, template, checkCmd string) *Resource {
+	dir, err := ioutil.TempDir("", "remco-failure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte(template), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := Backend{Name: "mock", Onetime: true, Keys: []string{"/"}}
+	b.ReadWatcher, _ = mock.New(backendErr, map[string]string{"/key": "value"})
+	exec := NewExecutor("", "", "", 0, 0, newTestLogger())
+	res, err := NewResource([]Backend{b}, []*Renderer{{Src: src, Dst: filepath.Join(dir, "dst"), CheckCmd: checkCmd}}, "test", exec, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.logger = newTestLogger()
+	for _, s := range res.sources {
+		s.logger = res.logger
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res.Monitor(ctx)
+	if ctx.Err() != nil {
+		t.Fatal("Monitor should return in onetime mode")
+	}
+	return res
+}
+
+func TestOnetimeFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		backendErr error
+		template   string
+		checkCmd   string
+		expected   Failure
+	}{
+		{"ok", nil, `{{ getv("/key") }}`, "", FailureNone},
+		{"backend", fmt.Errorf("unreachable"), `{{ getv("/key") }}`, "", FailureBackend},
+		{"render", nil, `{{ getv("/missing") }}`, "", FailureRender},
+		{"check", nil, `{{ getv("/key") }}`, "false", FailureCheck},
+	}
+	for _, test := range tests {
+		res := runOnetime(t, test.backendErr, test.template, test.checkCmd)
+		if f := FailureOf(res.Err); f != test.expected {
+			t.Errorf("%s: expected %s, got %s (%v)", test.name, test.expected, f, res.Err)
+		}
+		if res.Failed != (test.expected != FailureNone) {
+			t.Errorf("%s: Failed = %t", test.name, res.Failed)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/HeavyHorst/memkv"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// subtree is a part of the store matched by Renderer.Iterate.
+type subtree struct {
+	name   string
+	path   string
+	values map[string]string
+}
+
+// subtrees returns all subtrees of the store whose root matches the pattern, sorted by path.
+// The values of a subtree are keyed relative to its root without a leading slash,
+// so that they can be accessed like values.port in the template.
+func subtrees(pattern string, store *memkv.Store) []subtree {
+	pattern = path.Join("/", pattern)
+	depth := len(strings.Split(strings.Trim(pattern, "/"), "/"))
+
+	found := make(map[string]*subtree)
+	for _, kv := range store.GetAllKVs() {
+		segments := strings.Split(strings.Trim(kv.Key, "/"), "/")
+		if len(segments) < depth {
+			continue
+		}
+		root := "/" + strings.Join(segments[:depth], "/")
+		if ok, _ := path.Match(pattern, root); !ok {
+			continue
+		}
+		st, ok := found[root]
+		if !ok {
+			st = &subtree{name: path.Base(root), path: root, values: make(map[string]string)}
+			found[root] = st
+		}
+		st.values[strings.TrimPrefix(stripPrefix(root, kv.Key), "/")] = kv.Value
+	}
+
+	result := make([]subtree, 0, len(found))
+	for _, st := range found {
+		result = append(result, *st)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].path < result[j].path
+	})
+	return result
+}
+
+// fanOut renders Src once for every subtree matching Iterate.
+// The destination of every file is the Dst template rendered with the name and path of the subtree.
+// The check and reload commands are executed once per run for all changed files.
+// It returns the changed files and an error if any.
+func (s *Renderer) fanOut(ctx context.Context, funcMap map[string]interface{}, store *memkv.Store, runCommands bool) ([]string, error) {
+	if s.fanout == nil {
+		s.fanout = make(map[string]*Renderer)
+	}
+
+	current := make(map[string]*Renderer)
+	var staged []*Renderer
+	cleanup := func() {
+		for _, item := range staged {
+			os.Remove(item.stageFile.Name())
+		}
+	}
+
+	for _, st := range subtrees(s.Iterate, store) {
+		dst, err := renderTemplate(s.Dst, map[string]string{"name": st.name, "path": st.path})
+		if err != nil {
+			cleanup()
+			return nil, errors.Wrap(err, "rendering dst failed")
+		}
+		if _, ok := current[dst]; ok {
+			cleanup()
+			return nil, errors.Errorf("dst %q is generated by more than one subtree", dst)
+		}
+
+		item, ok := s.fanout[dst]
+		if !ok {
+			item = s.fanOutItem(dst)
+		}
+		current[dst] = item
+
+		tctx := make(map[string]interface{}, len(funcMap)+3)
+		for k, v := range funcMap {
+			tctx[k] = v
+		}
+		tctx["name"] = st.name
+		tctx["path"] = st.path
+		tctx["values"] = st.values
+
+		if err := item.createStageFileFromSrc(ctx, s.Src, tctx); err != nil {
+			cleanup()
+			return nil, errors.Wrapf(err, "create stage file for %s failed", dst)
+		}
+		staged = append(staged, item)
+	}
+
+	if runCommands && s.CheckCmd != "" {
+		var outOfSync []string
+		for _, item := range staged {
+			ok, err := item.sameFile(item.stageFile.Name())
+			if err != nil {
+				s.logger.Error(err.Error())
+			}
+			if !ok {
+				outOfSync = append(outOfSync, item.stageFile.Name())
+			}
+		}
+		if len(outOfSync) > 0 {
+			if err := s.check(strings.Join(outOfSync, " ")); err != nil {
+				cleanup()
+				return nil, errors.Wrap(err, "config check failed")
+			}
+		}
+	}
+
+	var changed []string
+	for i, item := range staged {
+		c, err := item.syncFiles(false)
+		if c {
+			changed = append(changed, item.Dst)
+		}
+		if err != nil {
+			for _, rest := range staged[i+1:] {
+				os.Remove(rest.stageFile.Name())
+			}
+			return changed, errors.Wrapf(err, "sync of %s failed", item.Dst)
+		}
+	}
+
+	for dst, item := range s.fanout {
+		if _, ok := current[dst]; ok || !s.RemoveStale {
+			continue
+		}
+		s.logger.WithFields(logrus.Fields{
+			"config": dst,
+		}).Info("removing stale target config")
+		if err := item.removeDst(); err != nil {
+			return changed, err
+		}
+		changed = append(changed, dst)
+	}
+	s.fanout = current
+
+	if runCommands && len(changed) > 0 {
+		if err := s.reloadWith(map[string]string{"dst": strings.Join(changed, " ")}); err != nil {
+			return changed, errors.Wrap(err, "reload command failed")
+		}
+	}
+	return changed, nil
+}
+
+// fanOutItem returns a Renderer for a single file generated by fanOut.
+// The item renders to dst and never runs any commands.
+func (s *Renderer) fanOutItem(dst string) *Renderer {
+	item := &Renderer{
+		Src:                   s.Src,
+		Dst:                   dst,
+		MkDirs:                s.MkDirs,
+		Mode:                  s.Mode,
+		UID:                   s.UID,
+		GID:                   s.GID,
+		OnExit:                s.OnExit,
+		LineEnding:            s.LineEnding,
+		Fsync:                 s.Fsync,
+		SyncMode:              s.SyncMode,
+		KeepVersions:          s.KeepVersions,
+		Template:              s.Template,
+		BaseTemplate:          s.BaseTemplate,
+		Compare:               s.Compare,
+		PreserveXattrs:        s.PreserveXattrs,
+		SELinuxLabel:          s.SELinuxLabel,
+		ReapLock:              s.ReapLock,
+		logger:                s.logger,
+		revision:              s.revision,
+		templates:             s.templates,
+		StripTrailingNewlines: s.StripTrailingNewlines,
+		EnsureTrailingNewline: s.EnsureTrailingNewline || s.EnsureFinalNewline,
+	}
+	return item
+}
+
+// removeDst removes Dst and, in symlink mode, its versioned files.
+func (s *Renderer) removeDst() error {
+	if err := os.Remove(s.Dst); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "couldn't remove target config")
+	}
+	if s.SyncMode == SyncModeSymlink {
+		if err := os.RemoveAll(s.versionDir()); err != nil {
+			return errors.Wrap(err, "couldn't remove versioned files")
+		}
+	}
+	return nil
+}
@@ -14,32 +14,12 @@
 package fileutil
 
 import (
-	hash "crypto/sha1"
-
-	"github.com/pkg/errors"
-
-	"fmt"
-	"io"
 	"os"
 	"syscall"
 )
 
-// stat return a fileInfo describing the named file.
-func stat(name string) (fi fileInfo, err error) {
-	if IsFileExist(name) {
-		f, err := os.Open(name)
-		if err != nil {
-			return fi, errors.Wrap(err, "open file failed")
-		}
-		defer f.Close()
-		stats, _ := f.Stat()
-		fi.Uid = stats.Sys().(*syscall.Stat_t).Uid
-		fi.Gid = stats.Sys().(*syscall.Stat_t).Gid
-		fi.Mode = stats.Mode()
-		h := hash.New()
-		io.Copy(h, f)
-		fi.Hash = fmt.Sprintf("%x", h.Sum(nil))
-		return fi, nil
-	}
-	return fi, fmt.Errorf("file not found")
+// owner returns the uid and gid of the file described by stats.
+func owner(stats os.FileInfo) (uint32, uint32) {
+	st := stats.Sys().(*syscall.Stat_t)
+	return st.Uid, st.Gid
 }
@@ -12,29 +12,11 @@
 package fileutil
 
 import (
-	hash "crypto/sha1"
-
-	"github.com/pkg/errors"
-
-	"fmt"
-	"io"
 	"os"
 )
 
-// stat return a fileInfo describing the named file.
-func stat(name string) (fi fileInfo, err error) {
-	if IsFileExist(name) {
-		f, err := os.Open(name)
-		defer f.Close()
-		if err != nil {
-			return fi, errors.Wrap(err, "open file failed")
-		}
-		stats, _ := f.Stat()
-		fi.Mode = stats.Mode()
-		h := hash.New()
-		io.Copy(h, f)
-		fi.Hash = fmt.Sprintf("%x", h.Sum(nil))
-		return fi, nil
-	}
-	return fi, fmt.Errorf("file not found")
+// owner returns the uid and gid of the file described by stats.
+// File ownership is not supported on windows, owner always returns 0, 0.
+func owner(stats os.FileInfo) (uint32, uint32) {
+	return 0, 0
 }
@@ -12,20 +12,98 @@
 package fileutil
 
 import (
-	"io/ioutil"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+// Valid strategies to detect changes between two files.
+const (
+	// CompareSHA1 compares the sha1 checksum of the file contents. This is the default.
+	CompareSHA1 = "sha1"
+	// CompareSHA256 compares the sha256 checksum of the file contents.
+	CompareSHA256 = "sha256"
+	// CompareMD5 compares the md5 checksum of the file contents.
+	CompareMD5 = "md5"
+	// CompareSizeMtime compares the size of a render and the modification times of its template and Dst with the last sync,
+	// it is implemented by the renderer. The file contents are not read. SameFileWith doesn't support it.
+	CompareSizeMtime = "size+mtime"
+	// CompareNone treats the files as always different.
+	CompareNone = "none"
+)
+
 // FileInfo describes a configuration file and is returned by filestat.
 type fileInfo struct {
-	Uid  uint32
-	Gid  uint32
-	Mode os.FileMode
-	Hash string
+	Uid     uint32
+	Gid     uint32
+	Mode    os.FileMode
+	Size    int64
+	ModTime time.Time
+	Hash    string
+}
+
+// ValidCompare reports whether strategy is a valid compare strategy.
+// The empty string is valid and means CompareSHA1.
+func ValidCompare(strategy string) bool {
+	switch strategy {
+	case "", CompareSHA1, CompareSHA256, CompareMD5, CompareSizeMtime, CompareNone:
+		return true
+	}
+	return false
+}
+
+func newHash(strategy string) hash.Hash {
+	switch strategy {
+	case "", CompareSHA1:
+		return sha1.New()
+	case CompareSHA256:
+		return sha256.New()
+	case CompareMD5:
+		return md5.New()
+	}
+	return nil
+}
+
+// stat return a fileInfo describing the named file.
+func stat(name string) (fileInfo, error) {
+	return statWith(name, CompareSHA1)
+}
+
+// statWith return a fileInfo describing the named file.
+// The file contents are only hashed if the compare strategy requires it.
+func statWith(name, strategy string) (fi fileInfo, err error) {
+	if !IsFileExist(name) {
+		return fi, fmt.Errorf("file not found")
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return fi, errors.Wrap(err, "open file failed")
+	}
+	defer f.Close()
+	stats, err := f.Stat()
+	if err != nil {
+		return fi, errors.Wrap(err, "stat file failed")
+	}
+	fi.Uid, fi.Gid = owner(stats)
+	fi.Mode = stats.Mode()
+	fi.Size = stats.Size()
+	fi.ModTime = stats.ModTime()
+	if h := newHash(strategy); h != nil {
+		if _, err := io.Copy(h, f); err != nil {
+			return fi, errors.Wrap(err, "hashing file failed")
+		}
+		fi.Hash = fmt.Sprintf("%x", h.Sum(nil))
+	}
+	return fi, nil
 }
 
 // IsFileExist reports whether path exits.
@@ -47,15 +125,8 @@ func ReplaceFile(src, dest string, mode os.FileMode, logger *logrus.Entry) error
 		if strings.Contains(err.Error(), "device or resource busy") {
 			logger.Debug("Rename failed - target is likely a mount. Trying to write instead")
 			// try to open the file and write to it
-			var contents []byte
-			var rerr error
-			contents, rerr = ioutil.ReadFile(src)
-			if rerr != nil {
-				return errors.Wrap(rerr, "couldn't read source file")
-			}
-			err := ioutil.WriteFile(dest, contents, mode)
-			if err != nil {
-				return errors.Wrap(rerr, "couldn't write destination file")
+			if err := CopyFile(src, dest, mode); err != nil {
+				return err
 			}
 		} else {
 			return errors.Wrap(err, "couldn't rename src -> dst")
@@ -64,22 +135,138 @@ func ReplaceFile(src, dest string, mode os.FileMode, logger *logrus.Entry) error
 	return nil
 }
 
+// CopyFile streams the contents of src to dest.
+// dest is created with the given mode if it doesn't exist and truncated otherwise.
+func CopyFile(src, dest string, mode os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "couldn't read source file")
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return errors.Wrap(err, "couldn't write destination file")
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return errors.Wrap(err, "couldn't write destination file")
+	}
+	return errors.Wrap(out.Close(), "couldn't write destination file")
+}
+
+// ErrXattrNotSupported is returned if the filesystem or the OS doesn't support extended attributes.
+var ErrXattrNotSupported = fmt.Errorf("extended attributes are not supported")
+
+// CopyXattrs copies all extended attributes of src for which match returns true to dest.
+func CopyXattrs(src, dest string, match func(attr string) bool) error {
+	attrs, err := ListXattrs(src)
+	if err != nil {
+		return err
+	}
+	for _, attr := range attrs {
+		if !match(attr) {
+			continue
+		}
+		value, err := GetXattr(src, attr)
+		if err != nil {
+			return err
+		}
+		if err := SetXattr(dest, attr, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checksum returns the checksum of the named file computed with the given compare strategy.
+// It returns an empty string if the strategy doesn't hash the file contents.
+func Checksum(name, strategy string) (string, error) {
+	fi, err := statWith(name, strategy)
+	if err != nil {
+		return "", err
+	}
+	return fi.Hash, nil
+}
+
+// SyncFile commits the contents of the named file to stable storage.
+func SyncFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return errors.Wrap(err, "open file failed")
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		return errors.Wrapf(err, "fsync %s failed", name)
+	}
+	return nil
+}
+
 // SameFile reports whether src and dest config files are equal.
 // Two config files are equal when they have the same file contents and
 // Unix permissions. The owner, group, and mode must match.
 // It return false in other cases.
 func SameFile(src, dest string, logger *logrus.Entry) (bool, error) {
+	return SameFileWith(src, dest, CompareSHA1, logger)
+}
+
+// SameFileWith is like SameFile but uses the given compare strategy
+// to decide whether the file contents are equal.
+// With CompareNone the files are never equal, CompareSizeMtime returns an error.
+func SameFileWith(src, dest, strategy string, logger *logrus.Entry) (bool, error) {
+	if strategy == "" {
+		strategy = CompareSHA1
+	}
+	if strategy == CompareSizeMtime {
+		return false, fmt.Errorf("%s compares a render with the last sync, not two files", CompareSizeMtime)
+	}
+	if strategy == CompareNone {
+		logger.WithFields(logrus.Fields{
+			"config": dest,
+		}).Debug("change detection disabled")
+		return false, nil
+	}
 	if !IsFileExist(dest) {
 		return false, nil
 	}
-	d, err := stat(dest)
+	d, err := statWith(dest, strategy)
+	if err != nil {
+		return false, err
+	}
+	s, err := statWith(src, strategy)
+	if err != nil {
+		return false, err
+	}
+	sameAttributes := sameOwnerAndMode(s, d, dest, logger)
+	contentEqual := true
+	if d.Hash != s.Hash {
+		contentEqual = false
+		logger.WithFields(logrus.Fields{
+			"config":    dest,
+			"algorithm": strategy,
+			"current":   d.Hash,
+			"new":       s.Hash,
+		}).Info("wrong hashsum")
+	}
+	return sameAttributes && contentEqual, nil
+}
+
+// SameAttributes reports whether src and dest have the same owner, group and mode.
+// The file contents are not compared.
+func SameAttributes(src, dest string, logger *logrus.Entry) (bool, error) {
+	d, err := statWith(dest, CompareSizeMtime)
 	if err != nil {
 		return false, err
 	}
-	s, err := stat(src)
+	s, err := statWith(src, CompareSizeMtime)
 	if err != nil {
 		return false, err
 	}
+	return sameOwnerAndMode(s, d, dest, logger), nil
+}
+
+// sameOwnerAndMode compares the owner, group and mode of s and d and logs the differences.
+func sameOwnerAndMode(s, d fileInfo, dest string, logger *logrus.Entry) bool {
 	if d.Uid != s.Uid {
 		logger.WithFields(logrus.Fields{
 			"config":  dest,
@@ -101,15 +288,5 @@ func SameFile(src, dest string, logger *logrus.Entry) (bool, error) {
 			"new":     s.Mode,
 		}).Info("wrong filemode")
 	}
-	if d.Hash != s.Hash {
-		logger.WithFields(logrus.Fields{
-			"config":  dest,
-			"current": d.Hash,
-			"new":     s.Hash,
-		}).Info("wrong hashsum")
-	}
-	if d.Uid != s.Uid || d.Gid != s.Gid || d.Mode != s.Mode || d.Hash != s.Hash {
-		return false, nil
-	}
-	return true, nil
+	return d.Uid == s.Uid && d.Gid == s.Gid && d.Mode == s.Mode
 }
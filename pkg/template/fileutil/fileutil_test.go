@@ -113,3 +113,40 @@ func (s *TestSuite) TestReplaceFile(t *C) {
 		t.Error(err.Error())
 	}
 }
+
+func (s *TestSuite) TestSameFileWith(t *C) {
+	logger := logrus.NewEntry(logrus.StandardLogger())
+	for _, strategy := range []string{CompareSHA1, CompareSHA256, CompareMD5} {
+		status, err := SameFileWith(s.file.Name(), s.sameFile.Name(), strategy, logger)
+		t.Check(err, IsNil)
+		t.Check(status, Equals, true)
+
+		status, err = SameFileWith(s.file.Name(), s.differentHash.Name(), strategy, logger)
+		t.Check(err, IsNil)
+		t.Check(status, Equals, false)
+	}
+
+	status, err := SameFileWith(s.file.Name(), s.sameFile.Name(), CompareNone, logger)
+	t.Check(err, IsNil)
+	t.Check(status, Equals, false)
+}
+
+func (s *TestSuite) TestSameFileWithSizeMtime(t *C) {
+	logger := logrus.NewEntry(logrus.StandardLogger())
+	status, err := SameFileWith(s.file.Name(), s.sameFile.Name(), CompareSizeMtime, logger)
+	t.Check(err, NotNil)
+	t.Check(status, Equals, false)
+}
+
+func (s *TestSuite) TestValidCompare(t *C) {
+	for _, strategy := range []string{"", CompareSHA1, CompareSHA256, CompareMD5, CompareSizeMtime, CompareNone} {
+		t.Check(ValidCompare(strategy), Equals, true)
+	}
+	t.Check(ValidCompare("crc32"), Equals, false)
+}
+
+func (s *TestSuite) TestSync(t *C) {
+	t.Check(SyncFile(s.file.Name()), IsNil)
+	t.Check(SyncDir(os.TempDir()), IsNil)
+	t.Check(SyncFile("/this/file/does/not/exist"), NotNil)
+}
@@ -0,0 +1,16 @@
+// +build !windows
+
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package fileutil
+
+// SyncDir commits the directory entries of the named directory to stable storage.
+func SyncDir(name string) error {
+	return SyncFile(name)
+}
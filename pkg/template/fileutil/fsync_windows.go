@@ -0,0 +1,15 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package fileutil
+
+// SyncDir commits the directory entries of the named directory to stable storage.
+// Directories can't be synced on windows, SyncDir is a no-op.
+func SyncDir(name string) error {
+	return nil
+}
@@ -0,0 +1,66 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package fileutil
+
+import (
+	"bytes"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// ListXattrs returns the names of all extended attributes of the named file.
+func ListXattrs(name string) ([]string, error) {
+	size, err := syscall.Listxattr(name, nil)
+	if err != nil {
+		return nil, xattrError(err)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Listxattr(name, buf)
+	if err != nil {
+		return nil, xattrError(err)
+	}
+
+	var names []string
+	for _, n := range bytes.Split(buf[:size], []byte{0}) {
+		if len(n) > 0 {
+			names = append(names, string(n))
+		}
+	}
+	return names, nil
+}
+
+// GetXattr returns the value of the extended attribute attr of the named file.
+func GetXattr(name, attr string) ([]byte, error) {
+	size, err := syscall.Getxattr(name, attr, nil)
+	if err != nil {
+		return nil, xattrError(err)
+	}
+	buf := make([]byte, size)
+	size, err = syscall.Getxattr(name, attr, buf)
+	if err != nil {
+		return nil, xattrError(err)
+	}
+	return buf[:size], nil
+}
+
+// SetXattr sets the extended attribute attr of the named file to value.
+func SetXattr(name, attr string, value []byte) error {
+	return xattrError(syscall.Setxattr(name, attr, value, 0))
+}
+
+func xattrError(err error) error {
+	if err == syscall.ENOTSUP {
+		return ErrXattrNotSupported
+	}
+	return errors.Wrap(err, "xattr syscall failed")
+}
@@ -0,0 +1,29 @@
+// +build !linux
+
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package fileutil
+
+// ListXattrs returns the names of all extended attributes of the named file.
+// Extended attributes are only supported on linux, ListXattrs always returns an empty list.
+func ListXattrs(name string) ([]string, error) {
+	return nil, nil
+}
+
+// GetXattr returns the value of the extended attribute attr of the named file.
+// Extended attributes are only supported on linux.
+func GetXattr(name, attr string) ([]byte, error) {
+	return nil, ErrXattrNotSupported
+}
+
+// SetXattr sets the extended attribute attr of the named file to value.
+// Extended attributes are only supported on linux.
+func SetXattr(name, attr string, value []byte) error {
+	return ErrXattrNotSupported
+}
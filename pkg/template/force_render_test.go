@@ -0,0 +1,76 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestForceRender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-force-render")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	res := newHookResource(t, dir, "", "")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		res.Monitor(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	select {
+	case <-res.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the resource didn't become ready")
+	}
+
+	// the onetime backend never triggers a render again, the forced render restores the file
+	dst := filepath.Join(dir, "dst")
+	if err := os.Remove(dst); err != nil {
+		t.Fatal(err)
+	}
+	res.ForceRender()
+	res.ForceRender()
+
+	var forced []RenderEvent
+	deadline := time.Now().Add(5 * time.Second)
+	for len(forced) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the templates haven't been processed again")
+		}
+		time.Sleep(10 * time.Millisecond)
+		for _, e := range res.Status().History {
+			if e.Trigger == TriggerForced {
+				forced = append(forced, e)
+			}
+		}
+	}
+	if !forced[0].Changed || forced[0].Backend != "mock" {
+		t.Errorf("expected a forced render of the backend mock that changed the file, got %+v", forced[0])
+	}
+	data, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "rendered" {
+		t.Errorf("unexpected content %q", data)
+	}
+}
@@ -0,0 +1,83 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// markFresh records the successful fetch of the backend.
+func (t *Resource) markFresh(name string) {
+	t.freshnessMutex.Lock()
+	defer t.freshnessMutex.Unlock()
+	t.freshness[name] = time.Now()
+	delete(t.fetchErrors, name)
+}
+
+// markFailed records the error of the last fetch of the backend.
+func (t *Resource) markFailed(name string, err error) {
+	t.freshnessMutex.Lock()
+	defer t.freshnessMutex.Unlock()
+	t.fetchErrors[name] = err.Error()
+}
+
+// backendStatus returns the state of every backend of the resource.
+func (t *Resource) backendStatus() []BackendStatus {
+	t.freshnessMutex.Lock()
+	defer t.freshnessMutex.Unlock()
+	status := make([]BackendStatus, 0, len(t.backends))
+	for _, b := range t.backends {
+		s := BackendStatus{
+			Name:      b.Name,
+			LastFetch: t.freshness[b.Name],
+			Error:     t.fetchErrors[b.Name],
+		}
+		if e, ok := b.ReadWatcher.(EndpointSwitcher); ok {
+			s.Endpoints = e.Active()
+		}
+		status = append(status, s)
+	}
+	return status
+}
+
+// staleAge returns the time since the last successful fetch of the backend,
+// false if the backend has never been fetched successfully.
+func (t *Resource) staleAge(name string) (time.Duration, bool) {
+	t.freshnessMutex.Lock()
+	defer t.freshnessMutex.Unlock()
+	last, ok := t.freshness[name]
+	if !ok {
+		return 0, false
+	}
+	return time.Since(last), true
+}
+
+// useStaleData decides whether the templates are rendered with the last good values of a failed backend,
+// which its store retains. It returns nil if the stale data is used and err otherwise:
+// if the backend has never been fetched successfully, ctx is done or the data is older than maxStaleAge.
+func (t *Resource) useStaleData(ctx context.Context, storeClient Backend, err error) error {
+	age, ok := t.staleAge(storeClient.Name)
+	if !ok || ctx.Err() != nil {
+		return err
+	}
+	logger := t.loggerFrom(ctx).WithFields(logrus.Fields{
+		"backend": storeClient.Name,
+		"age":     age.Round(time.Second).String(),
+	})
+	if t.maxStaleAge > 0 && age > t.maxStaleAge {
+		logger.Error(fmt.Sprintf("the data of the backend is older than max_stale_age (%s)", t.maxStaleAge))
+		return err
+	}
+	logger.Warning(fmt.Sprintf("using stale data: %v", err))
+	return nil
+}
@@ -0,0 +1,216 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Valid values of ExecConfig.HealthcheckAction.
+const (
+	HealthcheckActionRestart      = "restart"
+	HealthcheckActionReloadSignal = "reload_signal"
+	HealthcheckActionCommand      = "command"
+)
+
+// Defaults of the health check.
+const (
+	defaultHealthcheckInterval = 10 * time.Second
+	defaultHealthcheckTimeout  = 5 * time.Second
+	defaultHealthcheckRetries  = 3
+)
+
+// A healthChecker probes the child process periodically and recovers it
+// after a number of consecutive failures.
+type healthChecker struct {
+	probe     func(ctx context.Context, logger *logrus.Entry) error
+	interval  time.Duration
+	timeout   time.Duration
+	retries   int
+	action    string
+	actionCmd string
+}
+
+func secondsOrDefault(seconds int, def time.Duration) time.Duration {
+	if seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	return def
+}
+
+// healthChecker validates the health check options and returns the health checker.
+// It returns nil if no health check is configured.
+func (c ExecConfig) healthChecker() (*healthChecker, error) {
+	var probes int
+	for _, p := range []string{c.HealthcheckCmd, c.HealthcheckTCP, c.HealthcheckHTTP} {
+		if p != "" {
+			probes++
+		}
+	}
+	if probes == 0 {
+		return nil, nil
+	}
+	if probes > 1 {
+		return nil, fmt.Errorf("only one of healthcheck_cmd, healthcheck_tcp and healthcheck_http can be set")
+	}
+
+	h := &healthChecker{
+		interval:  secondsOrDefault(c.HealthcheckInterval, defaultHealthcheckInterval),
+		timeout:   secondsOrDefault(c.HealthcheckTimeout, defaultHealthcheckTimeout),
+		retries:   c.HealthcheckRetries,
+		action:    c.HealthcheckAction,
+		actionCmd: c.HealthcheckActionCmd,
+	}
+	if h.retries <= 0 {
+		h.retries = defaultHealthcheckRetries
+	}
+	switch h.action {
+	case "":
+		h.action = HealthcheckActionRestart
+	case HealthcheckActionRestart, HealthcheckActionReloadSignal:
+	case HealthcheckActionCommand:
+		if h.actionCmd == "" {
+			return nil, fmt.Errorf("healthcheck_action %q needs a healthcheck_action_cmd", h.action)
+		}
+	default:
+		return nil, fmt.Errorf("invalid healthcheck_action %q", h.action)
+	}
+
+	switch {
+	case c.HealthcheckCmd != "":
+		h.probe = commandProbe(c.HealthcheckCmd)
+	case c.HealthcheckTCP != "":
+		h.probe = tcpProbe(c.HealthcheckTCP)
+	default:
+		h.probe = httpProbe(c.HealthcheckHTTP)
+	}
+	return h, nil
+}
+
+// commandProbe succeeds if the command exits with 0.
+func commandProbe(cmd string) func(ctx context.Context, logger *logrus.Entry) error {
+	return func(ctx context.Context, logger *logrus.Entry) error {
+		output, err := execCommandContext(ctx, cmd, nil, nil, logger, nil)
+		if err != nil {
+			return fmt.Errorf("%v - %q", err, string(output))
+		}
+		return nil
+	}
+}
+
+// tcpProbe succeeds if a connection to the address can be established.
+func tcpProbe(address string) func(ctx context.Context, logger *logrus.Entry) error {
+	return func(ctx context.Context, logger *logrus.Entry) error {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+}
+
+// httpProbe succeeds if a GET request to the url returns a 2xx or 3xx status code.
+func httpProbe(url string) func(ctx context.Context, logger *logrus.Entry) error {
+	return func(ctx context.Context, logger *logrus.Entry) error {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+// check runs the probe with the timeout.
+func (h *healthChecker) check(ctx context.Context, logger *logrus.Entry) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	err := h.probe(ctx, logger)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", h.timeout)
+	}
+	return err
+}
+
+// recoverChild runs the recovery action of the health check.
+func (t *Resource) recoverChild(ctx context.Context, h *healthChecker) error {
+	switch h.action {
+	case HealthcheckActionReloadSignal:
+		return t.exec.Reload()
+	case HealthcheckActionCommand:
+		output, err := execCommandContext(ctx, h.actionCmd, nil, nil, t.logger, nil)
+		if err != nil {
+			return errors.Wrapf(err, "the recovery command failed - %q", string(output))
+		}
+		return nil
+	}
+	return t.exec.RespawnChild()
+}
+
+// runHealthCheck probes the child every interval until the context is canceled.
+// After retries consecutive failures the child is recovered and the failures are counted from 0 again.
+func (t *Resource) runHealthCheck(ctx context.Context, h *healthChecker) {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	var failures int
+	// unhealthy is reset by the first successful check, not by the recovery
+	var unhealthy bool
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := h.check(ctx, t.logger)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			if t.ready.isSet() {
+				t.healthy.set()
+			}
+			if unhealthy {
+				t.logger.Info("the child process is healthy again")
+			}
+			failures = 0
+			unhealthy = false
+			continue
+		}
+
+		failures++
+		unhealthy = true
+		t.logger.WithField("failures", fmt.Sprintf("%d/%d", failures, h.retries)).Warning(errors.Wrap(err, "health check failed"))
+		if failures < h.retries {
+			continue
+		}
+
+		t.logger.WithField("action", h.action).Error("the child process is unhealthy - recovering it")
+		if err := t.recoverChild(ctx, h); err != nil {
+			t.logger.Error(err)
+		}
+		failures = 0
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHealthCheckerConfig(t *testing.T) {
+	h, err := ExecConfig{}.healthChecker()
+	if h != nil || err != nil {
+		t.Errorf("expected no health check, got %v %v", h, err)
+	}
+
+	h, err = ExecConfig{HealthcheckTCP: "localhost:80"}.healthChecker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.interval != defaultHealthcheckInterval || h.timeout != defaultHealthcheckTimeout ||
+		h.retries != defaultHealthcheckRetries || h.action != HealthcheckActionRestart {
+		t.Errorf("unexpected defaults %+v", h)
+	}
+
+	invalid := []ExecConfig{
+		{HealthcheckCmd: "true", HealthcheckHTTP: "http://localhost"},
+		{HealthcheckCmd: "true", HealthcheckAction: "kill"},
+		{HealthcheckCmd: "true", HealthcheckAction: HealthcheckActionCommand},
+	}
+	for _, c := range invalid {
+		if _, err := c.healthChecker(); err == nil {
+			t.Errorf("expected an error for %+v", c)
+		}
+	}
+}
+
+func TestHealthCheckProbes(t *testing.T) {
+	ctx := context.Background()
+	logger := newTestLogger()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	if err := tcpProbe(addr)(ctx, logger); err != nil {
+		t.Errorf("tcp probe of a listening port failed: %v", err)
+	}
+	l.Close()
+	if err := tcpProbe(addr)(ctx, logger); err == nil {
+		t.Error("expected the tcp probe of a closed port to fail")
+	}
+
+	var status int32 = http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer server.Close()
+	if err := httpProbe(server.URL)(ctx, logger); err != nil {
+		t.Errorf("http probe failed: %v", err)
+	}
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	if err := httpProbe(server.URL)(ctx, logger); err == nil {
+		t.Error("expected the http probe to fail with 503")
+	}
+
+	if err := commandProbe("exit 0")(ctx, logger); err != nil {
+		t.Errorf("command probe failed: %v", err)
+	}
+	if err := commandProbe("exit 1")(ctx, logger); err == nil {
+		t.Error("expected the command probe to fail")
+	}
+
+	// probes are canceled after the timeout
+	h := &healthChecker{probe: commandProbe("sleep 5"), timeout: 100 * time.Millisecond}
+	start := time.Now()
+	err = h.check(ctx, logger)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Error("the probe wasn't canceled")
+	}
+}
+
+func TestHealthCheckRecovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-healthcheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "recovered")
+
+	var healthy int32
+	logger, buf := newJSONLogger()
+	res := &Resource{logger: logger}
+	h := &healthChecker{
+		probe: func(ctx context.Context, _ *logrus.Entry) error {
+			if atomic.LoadInt32(&healthy) == 1 {
+				return nil
+			}
+			return context.DeadlineExceeded
+		},
+		interval:  10 * time.Millisecond,
+		timeout:   time.Second,
+		retries:   3,
+		action:    HealthcheckActionCommand,
+		actionCmd: "echo recovered >> " + out,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		res.runHealthCheck(ctx, h)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := os.Stat(out); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the child wasn't recovered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(100 * time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the health check didn't stop with the context")
+	}
+
+	var failures []string
+	var recovered, healthyAgain bool
+	for _, e := range logEntries(t, buf) {
+		msg, _ := e["msg"].(string)
+		switch {
+		case strings.HasPrefix(msg, "health check failed"):
+			failures = append(failures, e["failures"].(string))
+		case msg == "the child process is unhealthy - recovering it":
+			recovered = true
+		case msg == "the child process is healthy again":
+			healthyAgain = true
+		}
+	}
+	if len(failures) < 3 || failures[0] != "1/3" || failures[2] != "3/3" {
+		t.Errorf("unexpected failures %v", failures)
+	}
+	if !recovered || !healthyAgain {
+		t.Errorf("missing state transitions: recovered=%v healthy again=%v", recovered, healthyAgain)
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HeavyHorst/pongo2"
+	"github.com/pkg/errors"
+)
+
+// HTTPReload reloads a service by sending a request to its admin endpoint.
+type HTTPReload struct {
+	// Method is the HTTP method. The default is "POST".
+	Method string `json:"method"`
+
+	// URL is the URL of the reload endpoint.
+	URL string `json:"url"`
+
+	// Headers are added to the request.
+	Headers map[string]string `json:"headers"`
+
+	// Body is a template that is rendered with the same functions as the src templates
+	// (and {{ dst }}, the changed files) and sent as the request body.
+	Body string `json:"body"`
+
+	// ExpectedStatus is the list of status codes that indicate a successful reload.
+	// Every 2xx status code is accepted if the list is empty.
+	ExpectedStatus []int `toml:"expected_status" json:"expected_status"`
+
+	// Timeout is the maximum amount of time in seconds the request may take.
+	// The default is 10.
+	Timeout int `json:"timeout"`
+
+	// The client cert file.
+	ClientCert string `toml:"client_cert" json:"client_cert"`
+
+	// The client key file.
+	ClientKey string `toml:"client_key" json:"client_key"`
+
+	// The client CA key file.
+	ClientCaKeys string `toml:"client_ca_keys" json:"client_ca_keys"`
+
+	// InsecureSkipVerify disables the verification of the server certificate,
+	// e.g. for admin ports on localhost with self-signed certificates.
+	InsecureSkipVerify bool `toml:"insecure_skip_verify" json:"insecure_skip_verify"`
+
+	clientOnce sync.Once
+	client     *http.Client
+	clientErr  error
+}
+
+// validate reports whether the HTTPReload configuration is valid.
+func (h *HTTPReload) validate() error {
+	return h.validateAs("reload_http")
+}
+
+// validateAs is validate for the request configured as name.
+func (h *HTTPReload) validateAs(name string) error {
+	if h.URL == "" {
+		return fmt.Errorf("%s requires an url", name)
+	}
+	if (h.ClientCert == "") != (h.ClientKey == "") {
+		return fmt.Errorf("%s requires both client_cert and client_key", name)
+	}
+	if h.Body != "" {
+		if _, err := pongo2.FromString(h.Body); err != nil {
+			return errors.Wrapf(err, "parsing the %s body failed", name)
+		}
+	}
+	return nil
+}
+
+func (h *HTTPReload) httpClient() (*http.Client, error) {
+	h.clientOnce.Do(func() {
+		tlsConfig := &tls.Config{InsecureSkipVerify: h.InsecureSkipVerify}
+		if h.ClientCert != "" {
+			cert, err := tls.LoadX509KeyPair(h.ClientCert, h.ClientKey)
+			if err != nil {
+				h.clientErr = errors.Wrap(err, "couldn't load the client certificate")
+				return
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		if h.ClientCaKeys != "" {
+			ca, err := ioutil.ReadFile(h.ClientCaKeys)
+			if err != nil {
+				h.clientErr = errors.Wrap(err, "couldn't read the CA file")
+				return
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				h.clientErr = fmt.Errorf("no valid certificate found in %s", h.ClientCaKeys)
+				return
+			}
+			tlsConfig.RootCAs = pool
+		}
+
+		// the timeouts of http.DefaultTransport, the reload itself is limited by the timeout of the request context
+		h.client = &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{
+					Timeout:   30 * time.Second,
+					KeepAlive: 30 * time.Second,
+				}).DialContext,
+				TLSClientConfig:       tlsConfig,
+				TLSHandshakeTimeout:   10 * time.Second,
+				IdleConnTimeout:       90 * time.Second,
+				MaxIdleConns:          100,
+				ExpectContinueTimeout: time.Second,
+			},
+		}
+	})
+	return h.client, h.clientErr
+}
+
+// expected reports whether the status code indicates a successful reload.
+func (h *HTTPReload) expected(code int) bool {
+	if len(h.ExpectedStatus) == 0 {
+		return code >= 200 && code < 300
+	}
+	for _, c := range h.ExpectedStatus {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// do sends the reload request. The body template is rendered with funcMap and data.
+// It returns an error if the request failed or the response has an unexpected status code.
+func (h *HTTPReload) do(ctx context.Context, funcMap map[string]interface{}, data map[string]string) error {
+	client, err := h.httpClient()
+	if err != nil {
+		return err
+	}
+
+	var body io.Reader
+	if h.Body != "" {
+		tmpl, err := pongo2.FromString(h.Body)
+		if err != nil {
+			return errors.Wrap(err, "parsing the body failed")
+		}
+		tctx := make(pongo2.Context, len(funcMap)+len(data))
+		for k, v := range funcMap {
+			tctx[k] = v
+		}
+		for k, v := range data {
+			tctx[k] = v
+		}
+		rendered, err := tmpl.Execute(tctx)
+		if err != nil {
+			return errors.Wrap(err, "rendering the body failed")
+		}
+		body = strings.NewReader(rendered)
+	}
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = 10
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	method := strings.ToUpper(h.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequest(method, h.URL, body)
+	if err != nil {
+		return errors.Wrap(err, "couldn't create the request")
+	}
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "request failed")
+	}
+	defer resp.Body.Close()
+	content, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if !h.expected(resp.StatusCode) {
+		return fmt.Errorf("unexpected status code %d: %q", resp.StatusCode, string(content))
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type recordedRequest struct {
+	method string
+	header http.Header
+	body   string
+}
+
+func newReloadServer(status int, tls bool) (*httptest.Server, chan recordedRequest) {
+	requests := make(chan recordedRequest, 1)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		select {
+		case requests <- recordedRequest{r.Method, r.Header, string(body)}:
+		default:
+		}
+		w.WriteHeader(status)
+	})
+	if tls {
+		return httptest.NewTLSServer(handler), requests
+	}
+	return httptest.NewServer(handler), requests
+}
+
+func TestHTTPReload(t *testing.T) {
+	server, requests := newReloadServer(http.StatusOK, false)
+	defer server.Close()
+
+	h := &HTTPReload{
+		Method:  "put",
+		URL:     server.URL,
+		Headers: map[string]string{"X-Token": "secret"},
+		Body:    `{"file": "{{ dst }}", "port": "{{ getv("/port") }}"}`,
+	}
+	funcMap := map[string]interface{}{
+		"getv": func(key string) string { return "8080" },
+	}
+	if err := h.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.do(context.Background(), funcMap, map[string]string{"dst": "/etc/app.conf"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := <-requests
+	if r.method != http.MethodPut {
+		t.Errorf("method should be PUT, got %s", r.method)
+	}
+	if r.header.Get("X-Token") != "secret" {
+		t.Errorf("the X-Token header should be set, got %v", r.header)
+	}
+	if expected := `{"file": "/etc/app.conf", "port": "8080"}`; r.body != expected {
+		t.Errorf("body should be %s, got %s", expected, r.body)
+	}
+}
+
+func TestHTTPReloadStatus(t *testing.T) {
+	server, _ := newReloadServer(http.StatusServiceUnavailable, false)
+	defer server.Close()
+
+	h := &HTTPReload{URL: server.URL}
+	if err := h.do(context.Background(), nil, nil); err == nil {
+		t.Error("an unexpected status code should fail the reload")
+	}
+
+	h = &HTTPReload{URL: server.URL, ExpectedStatus: []int{http.StatusServiceUnavailable}}
+	if err := h.do(context.Background(), nil, nil); err != nil {
+		t.Errorf("an expected status code should not fail the reload: %v", err)
+	}
+}
+
+func TestHTTPReloadInsecureSkipVerify(t *testing.T) {
+	server, _ := newReloadServer(http.StatusOK, true)
+	defer server.Close()
+
+	h := &HTTPReload{URL: server.URL}
+	if err := h.do(context.Background(), nil, nil); err == nil {
+		t.Error("an unknown certificate should fail the reload")
+	}
+
+	h = &HTTPReload{URL: server.URL, InsecureSkipVerify: true}
+	if err := h.do(context.Background(), nil, nil); err != nil {
+		t.Errorf("the certificate verification should be skipped: %v", err)
+	}
+}
+
+func TestHTTPReloadTransportTimeouts(t *testing.T) {
+	h := &HTTPReload{URL: "https://localhost/reload", InsecureSkipVerify: true}
+	client, err := h.httpClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := client.Transport.(*http.Transport)
+	if tr.DialContext == nil || tr.TLSHandshakeTimeout == 0 || tr.IdleConnTimeout == 0 {
+		t.Errorf("the transport should have the timeouts of the default transport, got %+v", tr)
+	}
+	if !tr.TLSClientConfig.InsecureSkipVerify {
+		t.Error("the tls config should be kept")
+	}
+}
+
+func TestHTTPReloadValidate(t *testing.T) {
+	invalid := []*HTTPReload{
+		{},
+		{URL: "http://localhost", ClientCert: "cert.pem"},
+		{URL: "http://localhost", Body: "{{ unclosed"},
+	}
+	for i, h := range invalid {
+		if err := h.validate(); err == nil {
+			t.Errorf("config %d should be invalid", i)
+		}
+	}
+}
+
+func TestReloadWithHTTP(t *testing.T) {
+	server, requests := newReloadServer(http.StatusOK, false)
+	defer server.Close()
+
+	s := &Renderer{
+		ReloadHTTP: &HTTPReload{URL: server.URL, Body: "{{ dst }}"},
+		logger:     newTestLogger(),
+	}
+	if err := s.reload("/etc/app.conf"); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-requests; r.body != "/etc/app.conf" {
+		t.Errorf("body should be /etc/app.conf, got %s", r.body)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/HeavyHorst/memkv"
+	"github.com/pkg/errors"
+)
+
+// VariablesSource is the source of the static variables in a StoreKey.
+const VariablesSource = "variables"
+
+// A StoreKey is a key of the resource store as the templates see it.
+type StoreKey struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+
+	// Source is the name of the backend (or VariablesSource) the value comes from.
+	Source string `json:"source"`
+
+	// Overridden holds the other sources of the key in the order of their precedence, Source wins the collision.
+	Overridden []string `json:"overridden,omitempty"`
+}
+
+// Keys connects to the backends of the resource once, reads their keys like a render does
+// (the prefix of a backend is stripped from its keys) and returns the keys of the merged store sorted by key.
+// The collisions are resolved with the merge strategy of the resource.
+// If backend is not empty, only the backend with this name or type is read. The static variables are always included.
+// No template is rendered and no command is executed.
+func Keys(ctx context.Context, r ResourceConfig, backend string) ([]StoreKey, error) {
+	strategy, err := NewMergeStrategy(r.Store.MergeStrategy)
+	if err != nil {
+		return nil, withFailure(FailureConfig, err)
+	}
+	connectors := r.Connectors
+	if backend != "" {
+		connectors = nil
+		for _, c := range r.Connectors {
+			if d, ok := c.(BackendDescriber); ok && configured(c) && (d.BackendName() == backend || d.BackendType() == backend) {
+				connectors = append(connectors, c)
+			}
+		}
+		if len(connectors) == 0 {
+			return nil, fmt.Errorf("resource %s has no backend %s", r.Name, backend)
+		}
+	}
+
+	backendList, err := connectAllBackends(ctx, connectors)
+	if err != nil {
+		return nil, withFailure(FailureBackend, errors.Wrap(err, "connectAllBackends failed"))
+	}
+	res, err := NewResource(backendList, nil, r.Name, Executor{}, "", "")
+	if err != nil {
+		for _, v := range backendList {
+			v.Close()
+		}
+		return nil, withFailure(FailureConfig, err)
+	}
+	defer res.Close()
+	res.variables = variablesStore(r.Store.VariablesPrefix, r.Store.Variables)
+
+	for _, b := range res.backends {
+		if err := res.fetchVars(ctx, b); err != nil {
+			return nil, withFailure(FailureBackend, errors.Wrapf(err, "backend %s", b.Name))
+		}
+	}
+
+	var sources []keySource
+	if res.variables != nil {
+		sources = append(sources, keySource{VariablesSource, res.variables})
+	}
+	for _, b := range res.backends {
+		sources = append(sources, keySource{b.Name, b.store})
+	}
+	keys, err := mergeKeys(sources, strategy)
+	if err != nil {
+		return nil, withFailure(FailureBackend, err)
+	}
+	return keys, nil
+}
+
+// A keySource is a store that is merged into the resource store.
+type keySource struct {
+	name  string
+	store *memkv.Store
+}
+
+// mergeKeys merges the stores like mergeStores does, the sources are in the order of their precedence.
+// The source of a key is the last source whose value has been used by the strategy.
+func mergeKeys(sources []keySource, strategy MergeStrategy) ([]StoreKey, error) {
+	index := make(map[string]int)
+	var keys []StoreKey
+	for _, s := range sources {
+		for _, kv := range s.store.GetAllKVs() {
+			i, ok := index[kv.Key]
+			if !ok {
+				index[kv.Key] = len(keys)
+				keys = append(keys, StoreKey{Key: kv.Key, Value: kv.Value, Source: s.name})
+				continue
+			}
+			value, used, err := strategy.Resolve(kv.Key, keys[i].Value, kv.Value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "%s and %s", keys[i].Source, s.name)
+			}
+			keys[i].Value = value
+			if used {
+				keys[i].Overridden = append(keys[i].Overridden, keys[i].Source)
+				keys[i].Source = s.name
+			} else {
+				keys[i].Overridden = append(keys[i].Overridden, s.name)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	return keys, nil
+}
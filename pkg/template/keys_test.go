@@ -0,0 +1,74 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// describedConnector connects to a snapshotClient under its name and prefix.
+type describedConnector struct {
+	name   string
+	prefix string
+	values map[string]string
+}
+
+func (c describedConnector) Connect() (Backend, error) {
+	return Backend{
+		Name:        c.name,
+		ReadWatcher: snapshotClient(c.values),
+		Prefix:      c.prefix,
+		Keys:        []string{"/"},
+		Onetime:     true,
+	}, nil
+}
+
+func (c describedConnector) BackendType() string { return c.name }
+func (c describedConnector) BackendName() string { return c.name }
+
+func TestKeys(t *testing.T) {
+	r := ResourceConfig{
+		Name: "keys",
+		Connectors: BackendConnectors{
+			describedConnector{"consul", "/app", map[string]string{"/app/port": "80", "/app/vars/dc": "us-east", "/other/port": "81"}},
+			describedConnector{"env", "", map[string]string{"/port": "8080"}},
+		},
+		Store: StoreConfig{Variables: map[string]string{"dc": "eu-west"}},
+	}
+
+	keys, err := Keys(context.Background(), r, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []StoreKey{
+		{Key: "/port", Value: "8080", Source: "env", Overridden: []string{"consul"}},
+		{Key: "/vars/dc", Value: "us-east", Source: "consul", Overridden: []string{VariablesSource}},
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %+v, got %+v", expected, keys)
+	}
+
+	keys, err = Keys(context.Background(), r, "consul")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []StoreKey{
+		{Key: "/port", Value: "80", Source: "consul"},
+		{Key: "/vars/dc", Value: "us-east", Source: "consul", Overridden: []string{VariablesSource}},
+	}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %+v, got %+v", expected, keys)
+	}
+
+	if _, err := Keys(context.Background(), r, "redis"); err == nil {
+		t.Error("expected an error for an unknown backend")
+	}
+}
@@ -0,0 +1,152 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/HeavyHorst/consul-template/child"
+	"github.com/hashicorp/consul-template/signals"
+	"github.com/sirupsen/logrus"
+)
+
+// KillStage is a stage of ExecConfig.KillSequence.
+type KillStage struct {
+	// Signal is the signal sent to the child process.
+	Signal string `toml:"signal" json:"signal"`
+
+	// Timeout is the time in seconds to wait for the child to exit before the next stage. The default is 10.
+	Timeout int `toml:"timeout" json:"timeout"`
+}
+
+type killStage struct {
+	signal  os.Signal
+	timeout time.Duration
+}
+
+// killSequence validates the KillSequence.
+func (c ExecConfig) killSequence() ([]KillStage, error) {
+	if len(c.KillSequence) > 0 && (c.KillSignal != "" || c.KillTimeout != 0) {
+		return nil, fmt.Errorf("kill_sequence can't be combined with kill_signal and kill_timeout")
+	}
+	return c.KillSequence, nil
+}
+
+// SetKillSequence configures the stages of stopping the child process.
+// The signal of every stage is sent in order until the child exits, the child is killed (SIGKILL) after the last stage.
+// The first stage replaces the kill signal and the kill timeout (e.g. when the child is restarted).
+func (e *Executor) SetKillSequence(stages []KillStage) error {
+	sequence := make([]killStage, 0, len(stages))
+	for i, stage := range stages {
+		s, err := signals.Parse(stage.Signal)
+		if err != nil {
+			return fmt.Errorf("invalid signal %q in stage %d of the kill_sequence", stage.Signal, i+1)
+		}
+		if s == os.Kill {
+			return fmt.Errorf("stage %d of the kill_sequence: SIGKILL is always sent after the last stage", i+1)
+		}
+		timeout := stage.Timeout
+		if timeout <= 0 {
+			timeout = 10
+		}
+		sequence = append(sequence, killStage{signal: s, timeout: time.Duration(timeout) * time.Second})
+	}
+	e.killSequence = sequence
+	if len(sequence) > 0 {
+		e.killSignal = sequence[0].signal
+		e.killTimeout = sequence[0].timeout
+	}
+	return nil
+}
+
+// SetKillProcessGroup configures whether the child is started in its own process group,
+// so that the kill signals are sent to all processes of the group. It is ignored on windows.
+func (e *Executor) SetKillProcessGroup(enabled bool) {
+	e.killProcessGroup = enabled && processGroupsSupported
+}
+
+// escalateKill runs the kill sequence until the child exits.
+// The child is killed if it's still running after the last stage.
+// If the child runs in its own process group, the signals are sent to the whole group
+// and the kill signal is handled like a sequence with a single stage.
+// The remaining processes of the group are killed by killOrphans once the child exited.
+func (e *Executor) escalateKill(c *child.Child) {
+	pid := c.Pid()
+	sequence := e.killSequence
+	if len(sequence) == 0 && e.killProcessGroup {
+		sequence = []killStage{{signal: e.killSignal, timeout: e.killTimeout}}
+	}
+	if pid == 0 || len(sequence) == 0 {
+		return
+	}
+
+	for i, stage := range sequence {
+		logger := e.logger.WithFields(logrus.Fields{
+			"stage":  i + 1,
+			"signal": stage.signal.String(),
+		})
+		if err := e.signalStage(c, pid, stage.signal); err != nil {
+			logger.Error(err)
+		}
+		if waitForExit(pid, stage.timeout) {
+			logger.Info("child process exited")
+			return
+		}
+	}
+
+	e.logger.WithFields(logrus.Fields{
+		"stage":  len(sequence) + 1,
+		"signal": os.Kill.String(),
+	}).Warning("child process didn't exit - killing it")
+	if !e.killProcessGroup || signalProcessGroup(pid, os.Kill) != nil {
+		if p, err := os.FindProcess(pid); err == nil {
+			p.Kill()
+		}
+	}
+	waitForExit(pid, time.Second)
+}
+
+// signalStage sends the signal of a kill stage to the child, or to its process group.
+// The child itself is signaled if its process group doesn't exist (yet), the exec-child launcher
+// creates it right after the start.
+func (e *Executor) signalStage(c *child.Child, pid int, s os.Signal) error {
+	if e.killProcessGroup {
+		if err := signalProcessGroup(pid, s); err == nil {
+			return nil
+		}
+	}
+	return c.Signal(s)
+}
+
+// killOrphans kills the processes that are left in the process group of the stopped (or restarted) child with the pid.
+func (e *Executor) killOrphans(pid int) {
+	if !e.killProcessGroup || pid == 0 {
+		return
+	}
+	if err := signalProcessGroup(pid, os.Kill); err == nil {
+		e.logger.WithFields(logrus.Fields{
+			"pgid": pid,
+		}).Debug("killed the remaining processes of the child's process group")
+	}
+}
+
+// waitForExit waits up to timeout for the process to exit.
+// It reports whether the process exited.
+func waitForExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestExecConfigKillSequence(t *testing.T) {
+	stages := []KillStage{{Signal: "SIGTERM", Timeout: 20}, {Signal: "SIGQUIT", Timeout: 40}}
+	if s, err := (ExecConfig{KillSequence: stages}).killSequence(); err != nil || !reflect.DeepEqual(s, stages) {
+		t.Errorf("unexpected kill sequence %v (%v)", s, err)
+	}
+	if _, err := (ExecConfig{KillSequence: stages, KillTimeout: 5}).killSequence(); err == nil {
+		t.Error("expected an error for a kill_sequence with a kill_timeout")
+	}
+
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	if err := exec.SetKillSequence(stages); err != nil {
+		t.Fatal(err)
+	}
+	if exec.killSignal != syscall.SIGTERM || exec.killTimeout != 20*time.Second || len(exec.killSequence) != 2 {
+		t.Errorf("unexpected kill configuration %v %v %v", exec.killSignal, exec.killTimeout, exec.killSequence)
+	}
+	for _, invalid := range [][]KillStage{{{Signal: "SIGFOO"}}, {{Signal: "SIGTERM"}, {Signal: "SIGKILL"}}} {
+		if err := exec.SetKillSequence(invalid); err == nil {
+			t.Errorf("expected an error for %v", invalid)
+		}
+	}
+}
+
+func spawnKillSequenceChild(t *testing.T, stages []KillStage, args ...string) (*Executor, int, string, *syncBuffer) {
+	command, out := helperCommand(t, args...)
+	logger, buf := newJSONLogger()
+	exec := NewExecutor(command, "", "", 0, 0, logger)
+	if err := exec.SetKillSequence(stages); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.SpawnChild(); err != nil {
+		os.RemoveAll(filepath.Dir(out))
+		t.Fatal(err)
+	}
+	return &exec, helperPID(t, out), out, buf
+}
+
+func killStageLogged(t *testing.T, buf *syncBuffer, stage float64, msg string) bool {
+	for _, e := range logEntries(t, buf) {
+		if e["stage"] == stage && e["msg"] == msg {
+			return true
+		}
+	}
+	return false
+}
+
+func TestStopChildKillSequence(t *testing.T) {
+	// the helper ignores SIGTERM and exits on SIGINT
+	stages := []KillStage{{Signal: "SIGTERM", Timeout: 1}, {Signal: "SIGINT", Timeout: 5}}
+	exec, pid, out, buf := spawnKillSequenceChild(t, stages, "ignore-term")
+	defer os.RemoveAll(filepath.Dir(out))
+
+	start := time.Now()
+	exec.StopChild()
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 4*time.Second {
+		t.Errorf("the child should exit in the second stage, took %s", elapsed)
+	}
+	if !processExited(pid) {
+		t.Error("the child should be stopped")
+	}
+	lines := waitForHelperOutput(t, out, 3)
+	if lines[1] != syscall.SIGTERM.String() || lines[2] != syscall.SIGINT.String() {
+		t.Errorf("unexpected signals %v", lines[1:])
+	}
+	if !killStageLogged(t, buf, 2, "child process exited") {
+		t.Error("the stage the child exited in should be logged")
+	}
+}
+
+func TestStopChildKillSequenceKill(t *testing.T) {
+	stages := []KillStage{{Signal: "SIGTERM", Timeout: 1}, {Signal: "SIGHUP", Timeout: 1}}
+	exec, pid, out, buf := spawnKillSequenceChild(t, stages, "ignore-term")
+	defer os.RemoveAll(filepath.Dir(out))
+
+	start := time.Now()
+	exec.StopChild()
+	if elapsed := time.Since(start); elapsed < 2*time.Second || elapsed > 5*time.Second {
+		t.Errorf("the child should be killed after both stages, took %s", elapsed)
+	}
+	if !processExited(pid) {
+		t.Error("the child should be killed")
+	}
+	lines := waitForHelperOutput(t, out, 3)
+	if lines[1] != syscall.SIGTERM.String() || lines[2] != syscall.SIGHUP.String() {
+		t.Errorf("unexpected signals %v", lines[1:])
+	}
+	if !killStageLogged(t, buf, 3, "child process didn't exit - killing it") {
+		t.Error("the kill stage should be logged")
+	}
+}
+
+func TestStopChildProcessGroup(t *testing.T) {
+	for _, group := range []bool{true, false} {
+		dir, err := ioutil.TempDir("", "remco-process-group")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		out := filepath.Join(dir, "out")
+
+		// the shell starts a grandchild and waits for it
+		exec := NewExecutor(fmt.Sprintf(`sh -c "sleep 60 & echo $! > %s; wait"`, out), "", "SIGTERM", 1, 0, newTestLogger())
+		exec.SetKillProcessGroup(group)
+		if err := exec.SpawnChild(); err != nil {
+			t.Fatal(err)
+		}
+		pid := helperPID(t, out)
+
+		exec.StopChild()
+		if group && !processExited(pid) {
+			t.Error("the grandchild should be stopped with the process group of the child")
+		}
+		if !group {
+			if processExited(pid) {
+				t.Error("the grandchild should outlive the child without a process group")
+			}
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+}
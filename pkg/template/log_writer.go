@@ -0,0 +1,79 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxLogLineLength is the maximum length of a logged line.
+// Longer lines are split, so that a child that never writes a newline can't exhaust the memory.
+const maxLogLineLength = 64 * 1024
+
+// A logWriter is an io.Writer that logs every written line.
+type logWriter struct {
+	mu     sync.Mutex
+	logger *logrus.Entry
+	buf    []byte
+}
+
+func newLogWriter(logger *logrus.Entry) *logWriter {
+	return &logWriter{logger: logger}
+}
+
+// Write logs all complete lines of p, incomplete lines are buffered until the next Write or Flush.
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			room := maxLogLineLength - len(w.buf)
+			if len(p) < room {
+				w.buf = append(w.buf, p...)
+				return n, nil
+			}
+			w.buf = append(w.buf, p[:room]...)
+			p = p[room:]
+			w.flush()
+			continue
+		}
+		if len(w.buf)+i > maxLogLineLength {
+			room := maxLogLineLength - len(w.buf)
+			w.buf = append(w.buf, p[:room]...)
+			p = p[room:]
+			w.flush()
+			continue
+		}
+		w.buf = append(w.buf, p[:i]...)
+		p = p[i+1:]
+		w.flush()
+	}
+	return n, nil
+}
+
+// Flush logs the buffered incomplete line.
+func (w *logWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.flush()
+	}
+}
+
+// flush logs the buffer and resets it. w.mu must be held.
+func (w *logWriter) flush() {
+	w.logger.Info(string(bytes.TrimSuffix(w.buf, []byte("\r"))))
+	w.buf = w.buf[:0]
+}
@@ -0,0 +1,148 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// syncBuffer is a bytes.Buffer that can be written while the test reads it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// logEntries returns (and consumes) the entries of a logger that writes JSON to buf.
+func logEntries(t *testing.T, buf *syncBuffer) []map[string]interface{} {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+
+	var entries []map[string]interface{}
+	s := bufio.NewScanner(&buf.buf)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		var e map[string]interface{}
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func newJSONLogger() (*logrus.Entry, *syncBuffer) {
+	buf := &syncBuffer{}
+	logger := logrus.New()
+	logger.Out = buf
+	logger.Formatter = &logrus.JSONFormatter{}
+	return logrus.NewEntry(logger), buf
+}
+
+func TestLogWriter(t *testing.T) {
+	logger, buf := newJSONLogger()
+	w := newLogWriter(logger.WithField("stream", "stdout"))
+
+	w.Write([]byte("first line\nsecond "))
+	w.Write([]byte("line\r\nthird"))
+	entries := logEntries(t, buf)
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(entries))
+	}
+	if entries[0]["msg"] != "first line" || entries[1]["msg"] != "second line" {
+		t.Errorf("unexpected lines: %v", entries)
+	}
+	if entries[0]["stream"] != "stdout" {
+		t.Errorf("the stream field is missing: %v", entries[0])
+	}
+
+	w.Flush()
+	entries = logEntries(t, buf)
+	if len(entries) != 1 || entries[0]["msg"] != "third" {
+		t.Errorf("the incomplete line wasn't flushed: %v", entries)
+	}
+
+	w.Flush()
+	if buf.String() != "" {
+		t.Errorf("nothing should be logged: %s", buf.String())
+	}
+}
+
+func TestLogWriterLongLines(t *testing.T) {
+	logger, buf := newJSONLogger()
+	w := newLogWriter(logger)
+
+	line := strings.Repeat("a", maxLogLineLength+10)
+	for i := 0; i < len(line); i += 1000 {
+		end := i + 1000
+		if end > len(line) {
+			end = len(line)
+		}
+		w.Write([]byte(line[i:end]))
+	}
+	if cap(w.buf) > 2*maxLogLineLength {
+		t.Errorf("the buffer grows unbounded: %d", cap(w.buf))
+	}
+	w.Write([]byte("\n"))
+
+	entries := logEntries(t, buf)
+	if len(entries) != 2 {
+		t.Fatalf("expected the line to be split in 2, got %d", len(entries))
+	}
+	if len(entries[0]["msg"].(string)) != maxLogLineLength || len(entries[1]["msg"].(string)) != 10 {
+		t.Errorf("unexpected split: %d, %d", len(entries[0]["msg"].(string)), len(entries[1]["msg"].(string)))
+	}
+}
+
+func TestExecutorCapturesOutput(t *testing.T) {
+	logger, buf := newJSONLogger()
+	exec := NewExecutor(`bash -c 'echo out; printf err >&2'`, "", "", 1, 0, logger)
+	if err := exec.SpawnChild(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if !exec.Wait(ctx) {
+		t.Fatal("the child should have exited")
+	}
+
+	streams := make(map[string]string)
+	for _, e := range logEntries(t, buf) {
+		if e["child"] != true {
+			continue
+		}
+		if e["command"] != "bash" {
+			t.Errorf("unexpected command field: %v", e["command"])
+		}
+		streams[e["stream"].(string)] = e["msg"].(string)
+	}
+	if streams["stdout"] != "out" || streams["stderr"] != "err" {
+		t.Errorf("unexpected child output: %v", streams)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/HeavyHorst/memkv"
+)
+
+// treeKVs returns all KV-Pairs below prefix (the prefix itself excluded), sorted by key.
+func treeKVs(store *memkv.Store, prefix string) memkv.KVPairs {
+	prefix = strings.TrimSuffix(path.Clean("/"+prefix), "/") + "/"
+	var kvs memkv.KVPairs
+	for _, kv := range store.GetAllKVs() {
+		if strings.HasPrefix(kv.Key, prefix) && len(kv.Key) > len(prefix) {
+			kvs = append(kvs, memkv.KVPair{Key: strings.TrimPrefix(kv.Key, prefix), Value: kv.Value})
+		}
+	}
+	sort.Sort(kvs)
+	return kvs
+}
+
+// getTree returns the values of all keys below prefix, keyed by their suffix,
+// e.g. getTree("/app") returns {"database/host": "db", "database/port": "5432"}.
+func getTree(store *memkv.Store, prefix string) map[string]string {
+	tree := make(map[string]string)
+	for _, kv := range treeKVs(store, prefix) {
+		tree[kv.Key] = kv.Value
+	}
+	return tree
+}
+
+// getTreeNested returns the values of all keys below prefix as nested maps,
+// one level per path segment of their suffix,
+// e.g. getTreeNested("/app") returns {"database": {"host": "db", "port": "5432"}}.
+// If a key is also the prefix of other keys, its value is dropped in favour of the nested map.
+func getTreeNested(store *memkv.Store, prefix string) map[string]interface{} {
+	tree := make(map[string]interface{})
+	for _, kv := range treeKVs(store, prefix) {
+		segments := strings.Split(kv.Key, "/")
+		node := tree
+		for _, segment := range segments[:len(segments)-1] {
+			child, ok := node[segment].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				node[segment] = child
+			}
+			node = child
+		}
+		leaf := segments[len(segments)-1]
+		if _, ok := node[leaf].(map[string]interface{}); !ok {
+			node[leaf] = kv.Value
+		}
+	}
+	return tree
+}
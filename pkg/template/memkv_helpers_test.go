@@ -0,0 +1,86 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"github.com/HeavyHorst/memkv"
+	"github.com/HeavyHorst/pongo2"
+	. "gopkg.in/check.v1"
+)
+
+func newTreeTestStore() *memkv.Store {
+	store := memkv.New()
+	for k, v := range map[string]string{
+		"/app/name":                      "shop",
+		"/app/database/host":             "db.local",
+		"/app/database/port":             "5432",
+		"/app/database/replicas/r1/host": "r1.local",
+		"/app/database/replicas/r2/host": "r2.local",
+		"/app/cache":                     "legacy",
+		"/app/cache/host":                "cache.local",
+		"/application/name":              "other",
+	} {
+		store.Set(k, v)
+	}
+	return store
+}
+
+func (s *FunctionTestSuite) TestGetTree(t *C) {
+	store := newTreeTestStore()
+
+	t.Check(getTree(store, "/app/database"), DeepEquals, map[string]string{
+		"host":             "db.local",
+		"port":             "5432",
+		"replicas/r1/host": "r1.local",
+		"replicas/r2/host": "r2.local",
+	})
+
+	// a trailing slash doesn't matter and /application isn't below /app
+	tree := getTree(store, "/app/")
+	t.Check(tree, HasLen, 7)
+	t.Check(tree["cache"], Equals, "legacy")
+	t.Check(tree["cache/host"], Equals, "cache.local")
+	_, ok := tree["name"]
+	t.Check(ok, Equals, true)
+
+	t.Check(getTree(store, "/app/database/host"), HasLen, 0)
+	t.Check(getTree(store, "/missing"), HasLen, 0)
+	t.Check(getTree(store, "/"), HasLen, 8)
+}
+
+func (s *FunctionTestSuite) TestGetTreeNested(t *C) {
+	store := newTreeTestStore()
+
+	t.Check(getTreeNested(store, "/app"), DeepEquals, map[string]interface{}{
+		"name": "shop",
+		"database": map[string]interface{}{
+			"host": "db.local",
+			"port": "5432",
+			"replicas": map[string]interface{}{
+				"r1": map[string]interface{}{"host": "r1.local"},
+				"r2": map[string]interface{}{"host": "r2.local"},
+			},
+		},
+		// the value of /app/cache is dropped in favour of its children
+		"cache": map[string]interface{}{"host": "cache.local"},
+	})
+
+	t.Check(getTreeNested(store, "/missing"), HasLen, 0)
+}
+
+func (s *FunctionTestSuite) TestGetTreeNestedTemplate(t *C) {
+	fm := newFuncMap()
+	addFuncs(fm, newStoreFuncMap(newTreeTestStore()))
+
+	tmpl, err := pongo2.FromString(`{% set db = getTreeNested("/app/database") %}{{ db.host }}:{{ db.port }} {{ db.replicas.r2.host }}`)
+	t.Assert(err, IsNil)
+	out, err := tmpl.Execute(pongo2.Context(fm))
+	t.Assert(err, IsNil)
+	t.Check(out, Equals, "db.local:5432 r2.local")
+}
@@ -0,0 +1,128 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Valid values of StoreConfig.MergeStrategy.
+const (
+	MergeLastWins         = "last_wins"
+	MergeFirstWins        = "first_wins"
+	MergeErrorOnConflict  = "error_on_conflict"
+	MergeLongestValueWins = "longest_value_wins"
+	MergeJSONDeepMerge    = "json_deep_merge"
+)
+
+// A MergeStrategy resolves the collisions of the keys of the static variables and the backends
+// when they are merged into the resource store. The sources are merged in the order of their precedence:
+// the static variables first, then the backends in the order of their configuration.
+type MergeStrategy interface {
+	// Resolve returns the value of key if the merged store holds existing and the next source holds incoming.
+	// The bool reports whether the incoming value has been used, the key is overridden then.
+	// An error fails the render.
+	Resolve(key, existing, incoming string) (string, bool, error)
+}
+
+// NewMergeStrategy returns the MergeStrategy with the name, the default is last_wins.
+func NewMergeStrategy(name string) (MergeStrategy, error) {
+	switch name {
+	case "", MergeLastWins:
+		return lastWins{}, nil
+	case MergeFirstWins:
+		return firstWins{}, nil
+	case MergeErrorOnConflict:
+		return errorOnConflict{}, nil
+	case MergeLongestValueWins:
+		return longestValueWins{}, nil
+	case MergeJSONDeepMerge:
+		return jsonDeepMerge{}, nil
+	}
+	return nil, fmt.Errorf("invalid merge_strategy %q", name)
+}
+
+// lastWins uses the value of the last source that holds the key.
+type lastWins struct{}
+
+func (lastWins) Resolve(key, existing, incoming string) (string, bool, error) {
+	return incoming, true, nil
+}
+
+// firstWins uses the value of the first source that holds the key.
+type firstWins struct{}
+
+func (firstWins) Resolve(key, existing, incoming string) (string, bool, error) {
+	return existing, false, nil
+}
+
+// errorOnConflict fails if the sources hold different values for the key. Equal values aren't a conflict.
+type errorOnConflict struct{}
+
+func (errorOnConflict) Resolve(key, existing, incoming string) (string, bool, error) {
+	if existing != incoming {
+		return existing, false, fmt.Errorf("conflicting values for key %s", key)
+	}
+	return existing, false, nil
+}
+
+// longestValueWins uses the longest value, the last source wins a tie.
+type longestValueWins struct{}
+
+func (longestValueWins) Resolve(key, existing, incoming string) (string, bool, error) {
+	if len(incoming) >= len(existing) {
+		return incoming, true, nil
+	}
+	return existing, false, nil
+}
+
+// jsonDeepMerge merges the values recursively if both are JSON objects:
+// the keys of the incoming object override those of the existing one, nested objects are merged the same way.
+// Every other value (including arrays) is replaced like with last_wins.
+type jsonDeepMerge struct{}
+
+func (jsonDeepMerge) Resolve(key, existing, incoming string) (string, bool, error) {
+	var a, b map[string]interface{}
+	if !isJSONObject(existing, &a) || !isJSONObject(incoming, &b) {
+		return incoming, true, nil
+	}
+	merged, err := json.Marshal(deepMerge(a, b))
+	if err != nil {
+		return existing, false, fmt.Errorf("merging the values of key %s failed: %v", key, err)
+	}
+	return string(merged), true, nil
+}
+
+// isJSONObject reports whether s is a JSON object and decodes it into v.
+// The numbers are kept as they are, large integers don't lose their precision.
+func isJSONObject(s string, v *map[string]interface{}) bool {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") || !json.Valid([]byte(s)) {
+		return false
+	}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	return dec.Decode(v) == nil
+}
+
+// deepMerge merges src into dst and returns dst.
+func deepMerge(dst, src map[string]interface{}) map[string]interface{} {
+	for k, v := range src {
+		if sm, ok := v.(map[string]interface{}); ok {
+			if dm, ok := dst[k].(map[string]interface{}); ok {
+				dst[k] = deepMerge(dm, sm)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+	return dst
+}
@@ -0,0 +1,172 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeStrategies(t *testing.T) {
+	tests := []struct {
+		strategy string
+		key      string
+		existing string
+		incoming string
+		value    string
+		used     bool
+		err      bool
+	}{
+		// the static variable /db/host is overridden by the consul backend
+		{MergeLastWins, "/db/host", "localhost", "db.service.consul", "db.service.consul", true, false},
+		{"", "/db/host", "localhost", "db.service.consul", "db.service.consul", true, false},
+		// a fallback backend configured after the primary one doesn't override it
+		{MergeFirstWins, "/db/host", "db.service.consul", "10.0.0.5", "db.service.consul", false, false},
+		// two backends that mirror each other agree
+		{MergeErrorOnConflict, "/db/port", "5432", "5432", "5432", false, false},
+		{MergeErrorOnConflict, "/db/port", "5432", "5433", "5432", false, true},
+		// the full certificate chain of vault wins over the leaf certificate of etcd
+		{MergeLongestValueWins, "/tls/chain", "leaf", "leaf\nintermediate", "leaf\nintermediate", true, false},
+		{MergeLongestValueWins, "/tls/chain", "leaf\nintermediate", "leaf", "leaf\nintermediate", false, false},
+		{MergeLongestValueWins, "/app/mode", "blue", "gold", "gold", true, false},
+		// the defaults of the application config are overridden per environment
+		{MergeJSONDeepMerge, "/app/config",
+			`{"db": {"host": "localhost", "pool": {"min": 1, "max": 10}}, "features": ["a"], "debug": false}`,
+			`{"db": {"pool": {"max": 50}}, "features": ["b"], "region": "eu-west-1"}`,
+			`{"db":{"host":"localhost","pool":{"max":50,"min":1}},"debug":false,"features":["b"],"region":"eu-west-1"}`,
+			true, false},
+		// large integers keep their precision
+		{MergeJSONDeepMerge, "/app/ids", `{"a": 9007199254740993}`, `{"b": 1}`, `{"a":9007199254740993,"b":1}`, true, false},
+		// values that aren't both objects are replaced
+		{MergeJSONDeepMerge, "/app/hosts", `["a", "b"]`, `["c"]`, `["c"]`, true, false},
+		{MergeJSONDeepMerge, "/app/config", `{"debug": true}`, `plain`, `plain`, true, false},
+		{MergeJSONDeepMerge, "/app/config", `{"debug": true}`, `{"debug": `, `{"debug": `, true, false},
+	}
+
+	for _, tt := range tests {
+		strategy, err := NewMergeStrategy(tt.strategy)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.strategy, err)
+		}
+		value, used, err := strategy.Resolve(tt.key, tt.existing, tt.incoming)
+		if (err != nil) != tt.err {
+			t.Errorf("%s %s: unexpected error %v", tt.strategy, tt.key, err)
+			continue
+		}
+		if value != tt.value || used != tt.used {
+			t.Errorf("%s %s: expected %q (used %v), got %q (used %v)", tt.strategy, tt.key, tt.value, tt.used, value, used)
+		}
+	}
+
+	if _, err := NewMergeStrategy("random"); err == nil {
+		t.Error("expected an error for an unknown strategy")
+	}
+}
+
+func TestMergeStoresWithStrategy(t *testing.T) {
+	for _, name := range []string{MergeLastWins, MergeFirstWins, MergeLongestValueWins, MergeJSONDeepMerge} {
+		strategy, _ := NewMergeStrategy(name)
+		incremental := newMergeTestResource("etcd", "consul")
+		full := newMergeTestResource("etcd", "consul")
+		incremental.mergeStrategy, full.mergeStrategy = strategy, strategy
+
+		updates := []struct {
+			backend int
+			kvs     map[string]string
+		}{
+			{0, map[string]string{"/host": "etcd.local", "/config": `{"a": 1, "b": {"c": 2}}`}},
+			{1, map[string]string{"/host": "consul.service.local", "/config": `{"b": {"d": 3}}`}},
+			{0, map[string]string{"/host": "etcd.service.local", "/config": `{"a": 2, "b": {"c": 2}}`}},
+			{1, map[string]string{"/config": `{"b": {"d": 3}}`}},
+		}
+		for i, u := range updates {
+			incremental.update(u.backend, u.kvs)
+			if err := incremental.mergeChanges(incremental.logger); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			full.update(u.backend, u.kvs)
+			if err := full.mergeStores(full.logger); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if got, expected := incremental.Snapshot(), full.Snapshot(); !reflect.DeepEqual(got, expected) {
+				t.Errorf("%s update %d: the incremental merge %v differs from the full merge %v", name, i, got, expected)
+			}
+		}
+	}
+
+	res := newMergeTestResource("etcd", "consul")
+	strategy, _ := NewMergeStrategy(MergeFirstWins)
+	res.mergeStrategy = strategy
+	res.update(0, map[string]string{"/host": "etcd.local"})
+	res.update(1, map[string]string{"/host": "consul.service.local", "/port": "80"})
+	res.mergeChanges(res.logger)
+	if got := res.Snapshot(); !reflect.DeepEqual(got, map[string]string{"/host": "etcd.local", "/port": "80"}) {
+		t.Errorf("the first backend should win, got %v", got)
+	}
+}
+
+func TestMergeStoresErrorOnConflict(t *testing.T) {
+	res := newMergeTestResource("etcd", "consul")
+	res.mergeStrategy = errorOnConflict{}
+	res.update(0, map[string]string{"/db/port": "5432", "/db/host": "db"})
+	res.update(1, map[string]string{"/db/port": "5432"})
+	if err := res.mergeChanges(res.logger); err != nil {
+		t.Fatalf("equal values aren't a conflict: %v", err)
+	}
+
+	res.update(0, map[string]string{"/db/port": "5432", "/db/host": "db.local"})
+	res.update(1, map[string]string{"/db/port": "5433"})
+	err := res.mergeChanges(res.logger)
+	if err == nil || FailureOf(err) != FailureBackend {
+		t.Fatalf("expected a backend failure, got %v", err)
+	}
+	// the store isn't changed partially
+	if got := res.Snapshot(); !reflect.DeepEqual(got, map[string]string{"/db/port": "5432", "/db/host": "db"}) {
+		t.Errorf("the failed merge changed the store: %v", got)
+	}
+	if err := res.mergeStores(res.logger); err == nil {
+		t.Fatal("expected a conflict")
+	}
+	if got := res.Snapshot(); !reflect.DeepEqual(got, map[string]string{"/db/port": "5432", "/db/host": "db"}) {
+		t.Errorf("the failed rebuild changed the store: %v", got)
+	}
+
+	// the next merge rebuilds the store once the conflict is resolved
+	res.update(1, map[string]string{"/db/port": "5432"})
+	if err := res.mergeChanges(res.logger); err != nil {
+		t.Fatal(err)
+	}
+	if _, full := res.diff.take(); full {
+		t.Error("the store should have been rebuilt")
+	}
+	if got := res.Snapshot(); !reflect.DeepEqual(got, map[string]string{"/db/port": "5432", "/db/host": "db.local"}) {
+		t.Errorf("unexpected store %v", got)
+	}
+}
+
+func TestMergeKeysWithStrategy(t *testing.T) {
+	etcd := newMergeTestResource("etcd").backends[0].store
+	consul := newMergeTestResource("consul").backends[0].store
+	etcd.Set("/host", "etcd.local")
+	consul.Set("/host", "consul.service.local")
+	sources := []keySource{{"etcd", etcd}, {"consul", consul}}
+
+	keys, err := mergeKeys(sources, firstWins{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []StoreKey{{Key: "/host", Value: "etcd.local", Source: "etcd", Overridden: []string{"consul"}}}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %+v, got %+v", expected, keys)
+	}
+
+	if _, err := mergeKeys(sources, errorOnConflict{}); err == nil {
+		t.Error("expected a conflict")
+	}
+}
@@ -0,0 +1,29 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"time"
+
+	"github.com/armon/go-metrics"
+)
+
+var (
+	// reloadSplayKey measures the random delay of the reloads of the child process and the debounced reloads.
+	reloadSplayKey = []string{"reload", "splay_seconds"}
+	// retryDelayKey measures the delay before the templates are processed again after a failed startup attempt.
+	retryDelayKey = []string{"retry", "delay_seconds"}
+	// processDurationKey measures the duration of the processing of the templates (fetching the backends and rendering).
+	processDurationKey = []string{"process", "duration_seconds"}
+)
+
+// observeSeconds adds d in seconds as a sample of the metric key, labeled with the resource.
+func observeSeconds(key []string, resource string, d time.Duration) {
+	metrics.AddSampleWithLabels(key, float32(d.Seconds()), []metrics.Label{{Name: "resource", Value: resource}})
+}
@@ -0,0 +1,67 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"sync/atomic"
+)
+
+// Pause stops the running Monitor from touching the files of the resource, e.g. during a maintenance window.
+// The changes of the backends are still consumed, but the templates aren't processed and
+// the child process and the reload commands aren't reloaded until the resource is resumed.
+// A pending reload is held back until then. Pausing a paused resource is a no-op.
+func (t *Resource) Pause() {
+	if atomic.CompareAndSwapInt32(&t.paused, 0, 1) {
+		t.logger.Info("resource paused")
+	}
+}
+
+// Resume resumes the paused resource. The running Monitor immediately processes the templates
+// with the values of all backends to catch up on the changes it missed. It doesn't block.
+func (t *Resource) Resume() {
+	if !atomic.CompareAndSwapInt32(&t.paused, 1, 0) {
+		return
+	}
+	t.logger.Info("resource resumed")
+	select {
+	case t.resume <- struct{}{}:
+	default:
+	}
+}
+
+// Paused reports whether the resource is paused.
+func (t *Resource) Paused() bool {
+	return atomic.LoadInt32(&t.paused) == 1
+}
+
+// A heldReload holds the changes of the reloads that were due while the resource was paused.
+// It is owned by the Monitor loop.
+type heldReload struct {
+	changed    []string
+	envChanged bool
+	pending    bool
+}
+
+// hold adds the changes of a reload to the held reload.
+func (h *heldReload) hold(changed []string, envChanged bool) {
+	for _, c := range changed {
+		if !containsString(h.changed, c) {
+			h.changed = append(h.changed, c)
+		}
+	}
+	h.envChanged = h.envChanged || envChanged
+	h.pending = true
+}
+
+// take returns and clears the held reload.
+func (h *heldReload) take() (changed []string, envChanged bool, pending bool) {
+	changed, envChanged, pending = h.changed, h.envChanged, h.pending
+	*h = heldReload{}
+	return changed, envChanged, pending
+}
@@ -0,0 +1,91 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// renderTriggers returns the triggers of the render history of the resource.
+func renderTriggers(res *Resource) []string {
+	var triggers []string
+	for _, e := range res.Status().History {
+		triggers = append(triggers, e.Trigger)
+	}
+	return triggers
+}
+
+func TestPauseResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-pause")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "dst")
+
+	// the initial render is postponed until the resource is resumed
+	res := newHookResource(t, dir, "", "")
+	res.Pause()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		res.Monitor(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Fatalf("the paused resource shouldn't render the templates: %v", err)
+	}
+	if !res.Status().Paused {
+		t.Error("the status should report the paused resource")
+	}
+	res.Resume()
+	select {
+	case <-res.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the resource didn't become ready after it was resumed")
+	}
+
+	// the forced render is ignored while the resource is paused
+	res.Pause()
+	if err := os.Remove(dst); err != nil {
+		t.Fatal(err)
+	}
+	res.ForceRender()
+	time.Sleep(200 * time.Millisecond)
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Fatalf("the paused resource shouldn't render the templates: %v", err)
+	}
+
+	// resuming catches up with a render of all backends
+	res.Resume()
+	res.Resume()
+	waitForFile(t, dst)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(renderTriggers(res)) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if triggers := renderTriggers(res); !reflect.DeepEqual(triggers, []string{TriggerStartup, TriggerResume}) {
+		t.Errorf("unexpected renders %v", triggers)
+	}
+	if res.Status().Paused {
+		t.Error("the resource should be resumed")
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/HeavyHorst/consul-template/child"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultPIDFileMode is the mode of the PID file if ExecConfig.PIDFileMode is not set.
+const defaultPIDFileMode os.FileMode = 0644
+
+// SetPIDFile configures the file the PID of the child process is written to.
+// mode is the octal file mode, e.g. "0600". An empty path disables the PID file.
+func (e *Executor) SetPIDFile(path, mode string) error {
+	e.pidFile = path
+	e.pidFileMode = defaultPIDFileMode
+	if mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || m > 0777 {
+			return fmt.Errorf("invalid pid_file_mode %q, expected an octal value like 0644", mode)
+		}
+		e.pidFileMode = os.FileMode(m)
+	}
+	return nil
+}
+
+// ChildPID returns the PID of the running child process, 0 if no child process is running.
+func (e *Executor) ChildPID() int {
+	if e.childPID == nil {
+		return 0
+	}
+	return int(atomic.LoadInt32(e.childPID))
+}
+
+// setChildPID records the PID returned by ChildPID.
+func (e *Executor) setChildPID(pid int) {
+	if e.childPID != nil {
+		atomic.StoreInt32(e.childPID, int32(pid))
+	}
+}
+
+// childStarted records the PID of the (re)started child and writes it to the PID file.
+func (e *Executor) childStarted(c *child.Child) {
+	if c != nil {
+		e.setChildPID(c.Pid())
+	}
+	e.updatePIDFile(c)
+}
+
+// updatePIDFile writes the PID of the (re)started child to the PID file.
+// A file left behind by a previous run is overwritten. Errors are logged.
+func (e *Executor) updatePIDFile(c *child.Child) {
+	if e.pidFile == "" || c == nil {
+		return
+	}
+	pid := c.Pid()
+	if err := writePIDFile(e.pidFile, pid, e.pidFileMode); err != nil {
+		e.logger.WithField("pid_file", e.pidFile).Error(errors.Wrap(err, "couldn't write the pid file"))
+		return
+	}
+	e.logger.WithFields(logrus.Fields{
+		"pid_file": e.pidFile,
+		"pid":      pid,
+	}).Debug("wrote the pid file")
+}
+
+// removePIDFile removes the PID file after the child has been stopped.
+func (e *Executor) removePIDFile() {
+	if e.pidFile == "" {
+		return
+	}
+	if err := os.Remove(e.pidFile); err != nil && !os.IsNotExist(err) {
+		e.logger.WithField("pid_file", e.pidFile).Error(errors.Wrap(err, "couldn't remove the pid file"))
+	}
+}
+
+// writePIDFile atomically writes pid to path.
+func writePIDFile(path string, pid int, mode os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".pid")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(strconv.Itoa(pid) + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
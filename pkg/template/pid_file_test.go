@@ -0,0 +1,138 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readPIDFile(t *testing.T, path string) int {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pid
+}
+
+func TestSetPIDFile(t *testing.T) {
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	if err := exec.SetPIDFile("/run/child.pid", ""); err != nil {
+		t.Fatal(err)
+	}
+	if exec.pidFileMode != defaultPIDFileMode {
+		t.Errorf("default mode should be %v, got %v", defaultPIDFileMode, exec.pidFileMode)
+	}
+	if err := exec.SetPIDFile("/run/child.pid", "0600"); err != nil || exec.pidFileMode != 0600 {
+		t.Errorf("unexpected mode %v (%v)", exec.pidFileMode, err)
+	}
+	for _, invalid := range []string{"rw", "0999", "1777"} {
+		if err := exec.SetPIDFile("/run/child.pid", invalid); err == nil {
+			t.Errorf("expected an error for mode %q", invalid)
+		}
+	}
+}
+
+func TestPIDFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "child.pid")
+
+	// a stale file of a previous run is overwritten
+	if err := ioutil.WriteFile(pidFile, []byte("999999\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	exec := NewExecutor("sleep 60", "", "SIGKILL", 1, 0, newTestLogger())
+	if err := exec.SetPIDFile(pidFile, "0600"); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.SpawnChild(); err != nil {
+		t.Fatal(err)
+	}
+	pid := readPIDFile(t, pidFile)
+	if pid == 999999 || pid <= 0 {
+		t.Fatalf("unexpected pid %d", pid)
+	}
+	if fi, err := os.Stat(pidFile); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("unexpected mode of the pid file %v (%v)", fi.Mode(), err)
+	}
+
+	// the file is rewritten when the child is restarted
+	if err := exec.RespawnChild(); err != nil {
+		t.Fatal(err)
+	}
+	if respawned := readPIDFile(t, pidFile); respawned == pid {
+		t.Errorf("expected a new pid after the restart, got %d", respawned)
+	}
+
+	exec.StopChild()
+	if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Errorf("the pid file should be removed, got %v", err)
+	}
+}
+
+func TestPIDFileSupervise(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidFile := filepath.Join(dir, "child.pid")
+
+	exec := NewExecutor("sh -c 'sleep 0.1'", "", "SIGKILL", 1, 0, newTestLogger())
+	policy, maxRetries, backoff, err := ExecConfig{Supervise: true}.restartPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.SetRestartPolicy(policy, maxRetries, backoff, 60); err != nil {
+		t.Fatal(err)
+	}
+	exec.restartBackoff = 10 * time.Millisecond
+	if err := exec.SetPIDFile(pidFile, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.SpawnChild(); err != nil {
+		t.Fatal(err)
+	}
+	defer exec.StopChild()
+	pid := readPIDFile(t, pidFile)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		exec.Wait(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for readPIDFile(t, pidFile) == pid {
+		if time.Now().After(deadline) {
+			t.Fatal("the pid file wasn't rewritten after the respawn")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
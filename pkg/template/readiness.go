@@ -0,0 +1,106 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// A readiness is a one-shot signal, its channel is closed the first time it is set.
+type readiness struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func newReadiness() *readiness {
+	return &readiness{ch: make(chan struct{})}
+}
+
+// set closes the channel, subsequent calls (and calls on a nil readiness) are no-ops.
+func (r *readiness) set() {
+	if r == nil {
+		return
+	}
+	r.once.Do(func() {
+		close(r.ch)
+	})
+}
+
+// isSet reports whether the signal has been set.
+func (r *readiness) isSet() bool {
+	if r == nil {
+		return false
+	}
+	select {
+	case <-r.ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// Ready returns a channel that is closed once the templates of the resource have been processed successfully for the first time.
+// It stays closed if Monitor is restarted.
+func (t *Resource) Ready() <-chan struct{} {
+	return t.ready.ch
+}
+
+// Healthy returns a channel that is closed once the resource is ready and its child process passed the first health check.
+// Without a child process or a health check it is closed as soon as the child has been started.
+func (t *Resource) Healthy() <-chan struct{} {
+	return t.healthy.ch
+}
+
+// createReadinessGate creates the empty readiness_gate_file after the first successful render of Monitor.
+// The file is created atomically with a rename, errors are logged.
+func (t *Resource) createReadinessGate() {
+	if t.readinessGateFile == "" {
+		return
+	}
+	if err := createFileAtomic(t.readinessGateFile); err != nil {
+		t.logger.WithField("readiness_gate_file", t.readinessGateFile).Error(errors.Wrap(err, "couldn't create the readiness gate file"))
+		return
+	}
+	t.logger.WithField("readiness_gate_file", t.readinessGateFile).Debug("created the readiness gate file")
+}
+
+// removeReadinessGate removes the readiness_gate_file when Monitor exits.
+func (t *Resource) removeReadinessGate() {
+	if t.readinessGateFile == "" {
+		return
+	}
+	if err := os.Remove(t.readinessGateFile); err != nil && !os.IsNotExist(err) {
+		t.logger.WithField("readiness_gate_file", t.readinessGateFile).Error(errors.Wrap(err, "couldn't remove the readiness gate file"))
+	}
+}
+
+// createFileAtomic creates an empty file at path (and its directory), a file that already exists is replaced.
+func createFileAtomic(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".ready")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
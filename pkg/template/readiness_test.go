@@ -0,0 +1,127 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/template/fileutil"
+)
+
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestReadyAfterFirstProcess(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-readiness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	res := newHookResource(t, dir, "", "")
+	if isClosed(res.Ready()) || isClosed(res.Healthy()) {
+		t.Fatal("the resource shouldn't be ready before Monitor")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		res.Monitor(ctx)
+		close(done)
+	}()
+	select {
+	case <-res.Healthy():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the resource didn't become healthy")
+	}
+	if !isClosed(res.Ready()) {
+		t.Error("a healthy resource must be ready")
+	}
+	cancel()
+	<-done
+}
+
+func TestNotReadyAfterFailedStartup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-readiness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	res := newFailingResource(t, dir, "")
+	res.startupMaxRetries = 1
+	monitorUntilDone(t, res, 5*time.Second)
+	if isClosed(res.Ready()) || isClosed(res.Healthy()) {
+		t.Error("the resource shouldn't be ready if the templates couldn't be processed")
+	}
+}
+
+func TestReadinessGateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-readiness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gate := filepath.Join(dir, "gate", "ready")
+	res := newHookResource(t, dir, "", "")
+	res.readinessGateFile = gate
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		res.Monitor(ctx)
+		close(done)
+	}()
+	select {
+	case <-res.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the resource didn't become ready")
+	}
+	if !fileutil.IsFileExist(gate) {
+		t.Error("the readiness gate file should exist once the resource is ready")
+	}
+	cancel()
+	<-done
+	if fileutil.IsFileExist(gate) {
+		t.Error("the readiness gate file should be removed when Monitor exits")
+	}
+	// no temporary files are left behind
+	if files, _ := ioutil.ReadDir(filepath.Dir(gate)); len(files) != 0 {
+		t.Errorf("unexpected files %v", files)
+	}
+}
+
+func TestNoReadinessGateFileAfterFailedStartup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-readiness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gate := filepath.Join(dir, "ready")
+	res := newFailingResource(t, dir, "")
+	res.readinessGateFile = gate
+	res.startupMaxRetries = 1
+	monitorUntilDone(t, res, 5*time.Second)
+	if fileutil.IsFileExist(gate) {
+		t.Error("the readiness gate file shouldn't be created if the templates couldn't be processed")
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ReloadCommands is a list of commands that are executed in order.
+// It can be unmarshaled from a single string or a list of strings.
+type ReloadCommands []string
+
+// UnmarshalTOML implements the toml.Unmarshaler interface.
+func (r *ReloadCommands) UnmarshalTOML(data interface{}) error {
+	switch v := data.(type) {
+	case string:
+		*r = nil
+		if v != "" {
+			*r = ReloadCommands{v}
+		}
+		return nil
+	case []interface{}:
+		cmds := make(ReloadCommands, 0, len(v))
+		for _, c := range v {
+			s, ok := c.(string)
+			if !ok {
+				return fmt.Errorf("reload commands must be strings, got %T", c)
+			}
+			cmds = append(cmds, s)
+		}
+		*r = cmds
+		return nil
+	default:
+		return fmt.Errorf("reload commands must be a string or a list of strings, got %T", data)
+	}
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (r *ReloadCommands) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	return r.UnmarshalTOML(v)
+}
+
+// runReloadCommands executes the reload commands in order.
+// The sequence is aborted if a command fails or exceeds the reloadTimeout,
+// reloadWait is waited between the commands.
+// It returns false if a command failed.
+func (t *Resource) runReloadCommands(ctx context.Context) bool {
+	for i, cmd := range t.reloadCmds {
+		if i > 0 && t.reloadWait > 0 {
+			select {
+			case <-ctx.Done():
+				return false
+			case <-time.After(time.Duration(t.reloadWait) * time.Second):
+			}
+		}
+
+		logger := t.logger.WithFields(logrus.Fields{
+			"step":    fmt.Sprintf("%d/%d", i+1, len(t.reloadCmds)),
+			"command": cmd,
+		})
+		output, err := t.runReloadCommand(ctx, cmd)
+		if err != nil {
+			logger.Error(fmt.Sprintf("failed to execute the resource reload cmd: %v - %q", err, string(output)))
+			return false
+		}
+		logger.Debug(fmt.Sprintf("%q", string(output)))
+	}
+	return true
+}
+
+func (t *Resource) runReloadCommand(ctx context.Context, cmd string) ([]byte, error) {
+	if t.reloadTimeout <= 0 {
+		return execCommandContext(ctx, cmd, nil, nil, t.logger, nil)
+	}
+	timeout := time.Duration(t.reloadTimeout) * time.Second
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	output, err := execCommandContext(ctx, cmd, nil, nil, t.logger, nil)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	return output, err
+}
@@ -0,0 +1,109 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"time"
+)
+
+// reloadDebounceMaxFactor is the default of ExecConfig.ReloadDebounceMax as a multiple of ExecConfig.ReloadDebounce.
+const reloadDebounceMaxFactor = 10
+
+// A reloadDebouncer coalesces the changes of several render cycles into a single reload.
+//
+// The reload is due when no change has been added for the quiet period,
+// but at the latest after maxDelay since the first pending change.
+// The due reload is delayed by a random splay (up to splay, picked once for every pending reload),
+// changes during the splay are covered by the same reload.
+// The debouncer isn't safe for concurrent use, it is owned by the Monitor loop.
+type reloadDebouncer struct {
+	quiet    time.Duration
+	maxDelay time.Duration
+	splay    time.Duration
+
+	timer      *time.Timer
+	deadline   time.Time
+	splayDelay time.Duration
+	changed    []string
+	envChanged bool
+	pending    bool
+}
+
+func newReloadDebouncer(quiet, maxDelay, splay time.Duration) *reloadDebouncer {
+	if maxDelay <= 0 {
+		maxDelay = reloadDebounceMaxFactor * quiet
+	}
+	if maxDelay < quiet {
+		maxDelay = quiet
+	}
+	return &reloadDebouncer{quiet: quiet, maxDelay: maxDelay, splay: splay}
+}
+
+// add adds the changes of a render cycle and (re)starts the quiet period.
+// The splay isn't restarted. It returns the splay picked for a new pending reload, 0 otherwise.
+func (d *reloadDebouncer) add(changed []string, envChanged bool) time.Duration {
+	if len(changed) == 0 && !envChanged {
+		return 0
+	}
+
+	now := time.Now()
+	var splay time.Duration
+	if !d.pending {
+		d.pending = true
+		d.deadline = now.Add(d.maxDelay)
+		d.splayDelay = randomSplay(d.splay)
+		splay = d.splayDelay
+	}
+	for _, c := range changed {
+		if !containsString(d.changed, c) {
+			d.changed = append(d.changed, c)
+		}
+	}
+	d.envChanged = d.envChanged || envChanged
+
+	wait := d.quiet
+	if remaining := d.deadline.Sub(now); remaining < wait {
+		wait = remaining
+	}
+	wait += d.splayDelay
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.timer = time.NewTimer(wait)
+	return splay
+}
+
+// C returns the channel that receives a value when the pending reload is due.
+// It is nil (blocks forever) if no reload is pending.
+func (d *reloadDebouncer) C() <-chan time.Time {
+	if !d.pending {
+		return nil
+	}
+	return d.timer.C
+}
+
+// take returns and clears the accumulated changes.
+func (d *reloadDebouncer) take() (changed []string, envChanged bool, pending bool) {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	changed, envChanged, pending = d.changed, d.envChanged, d.pending
+	d.changed, d.envChanged, d.pending = nil, false, false
+	return changed, envChanged, pending
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
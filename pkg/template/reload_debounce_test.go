@@ -0,0 +1,185 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func TestReloadDebouncerCoalesces(t *testing.T) {
+	d := newReloadDebouncer(50*time.Millisecond, time.Second, 0)
+	if d.C() != nil {
+		t.Fatal("expected no pending reload")
+	}
+
+	d.add(nil, false)
+	if d.C() != nil {
+		t.Fatal("expected no pending reload without changes")
+	}
+
+	start := time.Now()
+	for _, c := range []string{"/a", "/b", "/a"} {
+		d.add([]string{c}, false)
+		time.Sleep(20 * time.Millisecond)
+	}
+	d.add(nil, true)
+	<-d.C()
+	if elapsed := time.Since(start); elapsed < 110*time.Millisecond {
+		t.Errorf("the reload fired before the quiet period after the last change: %s", elapsed)
+	}
+
+	changed, envChanged, pending := d.take()
+	if !reflect.DeepEqual(changed, []string{"/a", "/b"}) || !envChanged || !pending {
+		t.Errorf("unexpected pending reload %v %v %v", changed, envChanged, pending)
+	}
+	if d.C() != nil {
+		t.Error("expected no pending reload after take")
+	}
+}
+
+func TestReloadDebouncerMaxDelay(t *testing.T) {
+	d := newReloadDebouncer(100*time.Millisecond, 200*time.Millisecond, 0)
+
+	start := time.Now()
+	d.add([]string{"/a"}, false)
+	for {
+		select {
+		case <-d.C():
+			if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+				t.Errorf("the reload was delayed beyond the max delay: %s", elapsed)
+			}
+			return
+		case <-time.After(30 * time.Millisecond):
+			if time.Since(start) > time.Second {
+				t.Fatal("the reload never fired")
+			}
+			d.add([]string{"/a"}, false)
+		}
+	}
+}
+
+func TestReloadDebouncerDefaultMaxDelay(t *testing.T) {
+	d := newReloadDebouncer(time.Second, 0, 0)
+	if d.maxDelay != reloadDebounceMaxFactor*time.Second {
+		t.Errorf("unexpected default max delay %s", d.maxDelay)
+	}
+	d = newReloadDebouncer(time.Second, time.Millisecond, 0)
+	if d.maxDelay != time.Second {
+		t.Errorf("expected the max delay to be at least the quiet period, got %s", d.maxDelay)
+	}
+}
+
+func TestReloadDebouncerSplay(t *testing.T) {
+	d := newReloadDebouncer(0, 0, 200*time.Millisecond)
+
+	start := time.Now()
+	splay := d.add([]string{"/a"}, false)
+	if splay < 0 || splay >= 200*time.Millisecond || splay != d.splayDelay {
+		t.Fatalf("unexpected splay %s", splay)
+	}
+	// changes during the splay neither restart it nor queue another reload
+	time.Sleep(splay / 2)
+	if picked := d.add([]string{"/b"}, false); picked != 0 || d.splayDelay != splay {
+		t.Errorf("the splay was picked again")
+	}
+	<-d.C()
+	if elapsed := time.Since(start); elapsed < splay || elapsed > splay+150*time.Millisecond {
+		t.Errorf("expected the reload after the splay of %s, got %s", splay, elapsed)
+	}
+	changed, _, _ := d.take()
+	if !reflect.DeepEqual(changed, []string{"/a", "/b"}) {
+		t.Errorf("unexpected changes %v", changed)
+	}
+	if d.C() != nil {
+		t.Error("expected no pending reload after take")
+	}
+}
+
+func TestReloadDebouncerSplayAfterQuietPeriod(t *testing.T) {
+	d := newReloadDebouncer(50*time.Millisecond, time.Second, 100*time.Millisecond)
+
+	start := time.Now()
+	d.add([]string{"/a"}, false)
+	<-d.C()
+	if elapsed, min := time.Since(start), 50*time.Millisecond+d.splayDelay; elapsed < min {
+		t.Errorf("the reload fired before the quiet period and the splay (%s): %s", min, elapsed)
+	}
+}
+
+func (s *ResourceSuite) newDebouncedResource(t *C, dir string) (*Resource, string) {
+	out := filepath.Join(dir, "reloads")
+	r := &Renderer{
+		Src: s.templateFile,
+		Dst: filepath.Join(dir, "dst"),
+	}
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource([]Backend{s.backend}, []*Renderer{r}, "test", exec, "", "")
+	t.Assert(err, IsNil)
+	res.reloadCmds = []string{"echo reload >> " + out}
+	res.reloadDebouncer = newReloadDebouncer(50*time.Millisecond, time.Second, 0)
+	return res, out
+}
+
+func (s *ResourceSuite) TestDebouncedReload(t *C) {
+	dir, err := ioutil.TempDir("", "remco-debounce")
+	t.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	res, out := s.newDebouncedResource(t, dir)
+	ctx := context.Background()
+	res.scheduleReload(ctx, []string{"/a"}, false)
+	res.scheduleReload(ctx, []string{"/b"}, false)
+	_, err = os.Stat(out)
+	t.Check(os.IsNotExist(err), Equals, true)
+
+	<-res.reloadDue()
+	changed, _, _ := res.takePendingReload()
+	t.Check(changed, DeepEquals, []string{"/a", "/b"})
+	// there is no child process to reload
+	res.reload(ctx, changed, false)
+
+	data, err := ioutil.ReadFile(out)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "reload\n")
+}
+
+func (s *ResourceSuite) TestDebouncedReloadOnShutdown(t *C) {
+	dir, err := ioutil.TempDir("", "remco-debounce")
+	t.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// the pending reload is dropped
+	res, out := s.newDebouncedResource(t, dir)
+	res.scheduleReload(ctx, []string{"/a"}, false)
+	res.Monitor(ctx)
+	t.Check(res.reloadDue(), IsNil)
+	_, err = os.Stat(out)
+	t.Check(os.IsNotExist(err), Equals, true)
+
+	// the pending reload is merged into the final reload
+	res, out = s.newDebouncedResource(t, dir)
+	res.renderOnShutdown = true
+	res.scheduleReload(ctx, []string{"/a"}, false)
+	res.Monitor(ctx)
+	t.Check(res.reloadDue(), IsNil)
+	data, err := ioutil.ReadFile(out)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "reload\n")
+}
@@ -0,0 +1,74 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import "context"
+
+// renderContextKey is the context key of the RenderContext of a process call.
+type renderContextKey struct{}
+
+// RenderContext holds the request-scoped data of a process call that isn't part of the KV store.
+// The values are exposed to the templates with the renderContextValue function.
+type RenderContext struct {
+	// Resource is the name of the resource.
+	Resource string
+	// SpanID is the span_id of the log entries of the process call.
+	SpanID string
+	// Trigger is the trigger of the render, e.g. TriggerBackend.
+	Trigger string
+	// Backend is the backend whose change triggered the render (comma-separated if several changes were coalesced),
+	// it is empty if all backends are fetched.
+	Backend string
+}
+
+// Value returns the value of key ("resource", "span_id", "trigger" or "backend"), it returns "" for unknown keys.
+func (rc RenderContext) Value(key string) string {
+	switch key {
+	case "resource":
+		return rc.Resource
+	case "span_id":
+		return rc.SpanID
+	case "trigger":
+		return rc.Trigger
+	case "backend":
+		return rc.Backend
+	}
+	return ""
+}
+
+// withRenderContext returns a copy of ctx that carries rc.
+func withRenderContext(ctx context.Context, rc RenderContext) context.Context {
+	return context.WithValue(ctx, renderContextKey{}, rc)
+}
+
+// renderContextFrom returns the RenderContext of ctx or the zero value if ctx doesn't carry one.
+func renderContextFrom(ctx context.Context) RenderContext {
+	rc, _ := ctx.Value(renderContextKey{}).(RenderContext)
+	return rc
+}
+
+// renderContextValue is the renderContextValue template function outside of a process call.
+func renderContextValue(key string) string {
+	return ""
+}
+
+// withRenderContextFuncs returns a copy of funcMap whose renderContextValue function returns the values of the RenderContext of ctx.
+// funcMap is returned as is if ctx doesn't carry a RenderContext.
+func withRenderContextFuncs(ctx context.Context, funcMap map[string]interface{}) map[string]interface{} {
+	rc, ok := ctx.Value(renderContextKey{}).(RenderContext)
+	if !ok {
+		return funcMap
+	}
+	m := make(map[string]interface{}, len(funcMap)+1)
+	for k, v := range funcMap {
+		m[k] = v
+	}
+	m["renderContextValue"] = rc.Value
+	return m
+}
@@ -0,0 +1,66 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/HeavyHorst/easykv/mock"
+)
+
+func TestRenderContextValue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-render-context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src.tmpl")
+	tmpl := `{{ renderContextValue("resource") }} {{ renderContextValue("trigger") }} {{ renderContextValue("backend") }} {{ renderContextValue("span_id") }}{{ renderContextValue("unknown") }}`
+	if err := ioutil.WriteFile(src, []byte(tmpl), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := Backend{Name: "mock", Keys: []string{"/"}}
+	b.ReadWatcher, _ = mock.New(nil, map[string]string{})
+	renderer := &Renderer{Src: src, Dst: filepath.Join(dir, "dst")}
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource([]Backend{b}, []*Renderer{renderer}, "test", exec, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := withRenderContext(context.Background(), RenderContext{Trigger: TriggerBackend, Backend: "mock"})
+	if _, err := res.process(ctx, res.backends, false); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(renderer.Dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "test backend mock " + res.spanID; string(data) != expected {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+
+	// the values are empty outside of a process call
+	if err := renderer.createStageFile(context.Background(), res.funcMap); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(renderer.stageFile.Name())
+	data, err = ioutil.ReadFile(renderer.stageFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "   " {
+		t.Errorf("expected empty values, got %q", data)
+	}
+}
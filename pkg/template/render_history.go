@@ -0,0 +1,150 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultHistorySize is the default number of render events kept per resource.
+const defaultHistorySize = 100
+
+// The triggers of a render.
+const (
+	// TriggerStartup is the first attempt to process the templates.
+	TriggerStartup = "startup"
+	// TriggerRetry is a retry of the initial processing of the templates.
+	TriggerRetry = "retry"
+	// TriggerBackend is a change (or the interval) of a backend.
+	TriggerBackend = "backend"
+	// TriggerShutdown is the last render before shutdown (render_on_shutdown).
+	TriggerShutdown = "shutdown"
+	// TriggerForced is a render of all backends requested with ForceRender (SIGHUP).
+	TriggerForced = "forced"
+	// TriggerResume is the render of all backends after the resource was resumed, see Resume.
+	TriggerResume = "resume"
+)
+
+// RenderEvent records an attempt to process the templates of a resource.
+type RenderEvent struct {
+	Time    time.Time `json:"time"`
+	Trigger string    `json:"trigger"`
+	// SpanID is the span_id of the log entries of the render.
+	SpanID string `json:"span_id"`
+	// Backend is the backend whose change triggered the render (comma-separated if several changes were coalesced),
+	// it is empty if all backends were fetched.
+	Backend string `json:"backend,omitempty"`
+	// Changed is true if at least one template has been changed.
+	Changed bool   `json:"changed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// renderHistory is a ring buffer of the most recent render events.
+type renderHistory struct {
+	mutex  sync.Mutex
+	events []RenderEvent
+	next   int
+	full   bool
+}
+
+func newRenderHistory(size int) *renderHistory {
+	if size <= 0 {
+		size = defaultHistorySize
+	}
+	return &renderHistory{events: make([]RenderEvent, size)}
+}
+
+// add records e, the oldest event is dropped if the buffer is full.
+func (h *renderHistory) add(e RenderEvent) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.events[h.next] = e
+	h.next = (h.next + 1) % len(h.events)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// list returns a copy of the recorded events, the oldest first.
+func (h *renderHistory) list() []RenderEvent {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if !h.full {
+		return append([]RenderEvent(nil), h.events[:h.next]...)
+	}
+	events := make([]RenderEvent, 0, len(h.events))
+	events = append(events, h.events[h.next:]...)
+	return append(events, h.events[:h.next]...)
+}
+
+// ResourceStatus is the state of a resource returned by Resource.Status.
+type ResourceStatus struct {
+	Name string `json:"name"`
+	// History holds the most recent render events, the oldest first.
+	History []RenderEvent `json:"history"`
+	// Ready is true once the templates have been processed successfully, Healthy once the child is healthy as well.
+	Ready   bool `json:"ready"`
+	Healthy bool `json:"healthy"`
+	// ChildPID is the PID of the child process, 0 if no child process is running.
+	ChildPID int             `json:"child_pid,omitempty"`
+	Backends []BackendStatus `json:"backends"`
+	// Paused is true while the resource is paused, see Resource.Pause.
+	Paused bool `json:"paused"`
+}
+
+// BackendStatus is the state of a backend of a resource.
+type BackendStatus struct {
+	Name string `json:"name"`
+	// LastFetch is the time of the last successful fetch, zero if the backend has never been fetched successfully.
+	LastFetch time.Time `json:"last_fetch"`
+	// Error is the error of the last fetch, it is empty if the last fetch succeeded.
+	Error string `json:"error,omitempty"`
+	// Endpoints is the active set of endpoints if the client of the backend implements EndpointSwitcher, e.g. "failover".
+	Endpoints string `json:"endpoints,omitempty"`
+}
+
+// Status returns the render history, the readiness, the child process and the backends of the resource.
+// It is safe to call Status while Monitor is running.
+func (t *Resource) Status() ResourceStatus {
+	return ResourceStatus{
+		Name:     t.name,
+		History:  t.history.list(),
+		Ready:    t.ready.isSet(),
+		Healthy:  t.healthy.isSet(),
+		ChildPID: t.exec.ChildPID(),
+		Backends: t.backendStatus(),
+		Paused:   t.Paused(),
+	}
+}
+
+// LastRender returns the most recent render event, false if the templates haven't been processed yet.
+func (s ResourceStatus) LastRender() (RenderEvent, bool) {
+	if len(s.History) == 0 {
+		return RenderEvent{}, false
+	}
+	return s.History[len(s.History)-1], true
+}
+
+// recordRender adds the render event of the last process call to the history of the resource
+// and notifies the event hooks.
+func (t *Resource) recordRender(trigger, backend string, changed []string, err error) {
+	e := RenderEvent{
+		Time:    time.Now(),
+		Trigger: trigger,
+		SpanID:  t.spanID,
+		Backend: backend,
+		Changed: len(changed) > 0,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	t.history.add(e)
+	t.notifyHooks(trigger, backend, changed, err)
+}
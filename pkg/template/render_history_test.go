@@ -0,0 +1,94 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/HeavyHorst/easykv/mock"
+)
+
+func TestRenderHistory(t *testing.T) {
+	h := newRenderHistory(3)
+	if events := h.list(); len(events) != 0 {
+		t.Fatalf("expected an empty history, got %v", events)
+	}
+
+	for i := 0; i < 5; i++ {
+		h.add(RenderEvent{Backend: fmt.Sprint(i)})
+		if i == 1 {
+			if events := h.list(); len(events) != 2 || events[0].Backend != "0" || events[1].Backend != "1" {
+				t.Errorf("unexpected history before wrapping: %v", events)
+			}
+		}
+	}
+
+	// the oldest events are dropped
+	events := h.list()
+	var backends []string
+	for _, e := range events {
+		backends = append(backends, e.Backend)
+	}
+	if strings.Join(backends, ",") != "2,3,4" {
+		t.Errorf("expected the events 2,3,4 - got %v", backends)
+	}
+
+	if len(newRenderHistory(0).events) != defaultHistorySize {
+		t.Errorf("the default size should be %d", defaultHistorySize)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	res := runOnetime(t, nil, `{{ getv("/missing") }}`, "")
+	status := res.Status()
+	if status.Name != "test" {
+		t.Errorf("expected the name test, got %q", status.Name)
+	}
+	if len(status.History) != 1 {
+		t.Fatalf("expected one render event, got %v", status.History)
+	}
+	e := status.History[0]
+	if e.Trigger != TriggerStartup || e.Changed || !strings.Contains(e.Error, "/missing") || e.Time.IsZero() {
+		t.Errorf("unexpected render event %+v", e)
+	}
+
+	b, err := json.Marshal(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`"name":"test"`, `"trigger":"startup"`, `"changed":false`, `"error":`} {
+		if !strings.Contains(string(b), field) {
+			t.Errorf("%s should contain %s", b, field)
+		}
+	}
+}
+
+// switchingClient reports the failover endpoints as active.
+type switchingClient struct {
+	*mock.Client
+}
+
+func (switchingClient) Active() string {
+	return "failover"
+}
+
+func TestStatusEndpoints(t *testing.T) {
+	client, _ := mock.New(nil, nil)
+	res := &Resource{backends: []Backend{
+		{Name: "etcd", ReadWatcher: switchingClient{client}},
+		{Name: "mock", ReadWatcher: client},
+	}}
+	status := res.backendStatus()
+	if status[0].Endpoints != "failover" || status[1].Endpoints != "" {
+		t.Errorf("expected the failover endpoints of the first backend only, got %+v", status)
+	}
+}
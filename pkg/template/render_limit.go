@@ -0,0 +1,81 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"time"
+)
+
+// A renderLimiter defers the watch-triggered renders until the minimum interval since the last render has elapsed.
+//
+// All triggers during the interval are collapsed into a single deferred render of their backends.
+// Renders triggered by an interval aren't limited.
+// The limiter isn't safe for concurrent use, it is owned by the Monitor loop.
+type renderLimiter struct {
+	interval time.Duration
+
+	lastRender time.Time
+	timer      *time.Timer
+	pending    []Backend
+	suppressed int
+}
+
+func newRenderLimiter(interval time.Duration) *renderLimiter {
+	return &renderLimiter{interval: interval}
+}
+
+// rendered records the end of a render.
+func (l *renderLimiter) rendered() {
+	l.lastRender = time.Now()
+}
+
+// filter returns the backends that can be processed immediately.
+// The watched backends are deferred if the last render ended less than the interval ago.
+func (l *renderLimiter) filter(backends []Backend) []Backend {
+	wait := l.interval - time.Since(l.lastRender)
+	if wait <= 0 {
+		return backends
+	}
+
+	var now []Backend
+	for _, b := range backends {
+		if !b.watched {
+			now = append(now, b)
+			continue
+		}
+		l.suppressed++
+		if !containsBackend(l.pending, b.Name) {
+			l.pending = append(l.pending, b)
+		}
+		if l.timer == nil {
+			l.timer = time.NewTimer(wait)
+		}
+	}
+	return now
+}
+
+// C returns the channel that receives a value when the deferred render is due.
+// It is nil (blocks forever) if no render is deferred.
+func (l *renderLimiter) C() <-chan time.Time {
+	if l.timer == nil {
+		return nil
+	}
+	return l.timer.C
+}
+
+// take returns and clears the deferred backends and the number of suppressed triggers.
+func (l *renderLimiter) take() ([]Backend, int) {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	pending, suppressed := l.pending, l.suppressed
+	l.pending, l.suppressed = nil, 0
+	return pending, suppressed
+}
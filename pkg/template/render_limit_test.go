@@ -0,0 +1,61 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenderLimiter(t *testing.T) {
+	l := newRenderLimiter(100 * time.Millisecond)
+	watched := Backend{Name: "etcd", watched: true}
+	polled := Backend{Name: "file"}
+
+	// nothing has been rendered yet
+	if now := l.filter([]Backend{watched}); len(now) != 1 {
+		t.Errorf("the first render shouldn't be deferred, got %v", now)
+	}
+	if l.C() != nil {
+		t.Error("no render should be deferred")
+	}
+
+	l.rendered()
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if now := l.filter([]Backend{watched}); len(now) != 0 {
+			t.Errorf("the watched backend should be deferred, got %v", now)
+		}
+	}
+	// interval-triggered renders aren't limited
+	if now := l.filter([]Backend{watched, polled}); len(now) != 1 || now[0].Name != "file" {
+		t.Errorf("only the polled backend should be processed, got %v", now)
+	}
+
+	select {
+	case <-l.C():
+	case <-time.After(time.Second):
+		t.Fatal("the deferred render wasn't due")
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("the render should be deferred for the interval, was due after %s", elapsed)
+	}
+	pending, suppressed := l.take()
+	if len(pending) != 1 || pending[0].Name != "etcd" || suppressed != 6 {
+		t.Errorf("expected the pending backend etcd and 6 suppressed triggers, got %v and %d", pending, suppressed)
+	}
+	if l.C() != nil {
+		t.Error("take should clear the deferred render")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if now := l.filter([]Backend{watched}); len(now) != 1 {
+		t.Errorf("the render shouldn't be deferred after the interval, got %v", now)
+	}
+}
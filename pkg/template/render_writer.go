@@ -0,0 +1,82 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// RenderToWriter renders the template of the resource whose src is src to w,
+// using the current values of the store. Nothing is written if the template fails.
+// The destination file, the check and the reload commands are not touched.
+// It is safe to call RenderToWriter concurrently and while Monitor is running.
+func (t *Resource) RenderToWriter(w io.Writer, src string) error {
+	var renderer *Renderer
+	for _, s := range t.sources {
+		if s.Src == src {
+			renderer = s
+			break
+		}
+	}
+	if renderer == nil {
+		return errors.Errorf("the resource has no template %s", src)
+	}
+	if renderer.Iterate != "" {
+		return errors.Errorf("the fan-out template %s can't be rendered to a writer", src)
+	}
+
+	// the store isn't rebuilt while the template is rendered
+	t.storeMutex.RLock()
+	defer t.storeMutex.RUnlock()
+
+	if !renderer.isTemplate() {
+		return renderer.copy(src, w)
+	}
+	return withFailure(FailureRender, renderer.render(src, t.funcMap, w))
+}
+
+// Render renders the template src once to w with the values of the backends of connectors and the literal values,
+// which override the values of the backends. The keys of values are the keys in the templates, i.e. without the prefix.
+// The backends are connected (until ctx is canceled) and fetched once.
+// No destination file is written and no check, reload or exec commands are executed.
+// Nothing is written to w if the template fails.
+func Render(ctx context.Context, src string, connectors BackendConnectors, values map[string]string, w io.Writer) error {
+	backends, err := connectAllBackends(ctx, connectors)
+	if err != nil {
+		return withFailure(FailureBackend, errors.Wrap(err, "connectAllBackends failed"))
+	}
+	// a template without backends is rendered with the literal values only (which may be empty)
+	if len(values) > 0 || len(backends) == 0 {
+		backends = append(backends, Backend{Name: "values", ReadWatcher: snapshotClient(values), Keys: []string{"/"}})
+	}
+	for i := range backends {
+		backends[i].Onetime = true
+	}
+
+	res, err := NewResource(backends, []*Renderer{{Src: src, Dst: src}}, "render", NewExecutor("", "", "", 0, 0, nil), "", "")
+	if err != nil {
+		for _, b := range backends {
+			b.Close()
+		}
+		return err
+	}
+	defer res.Close()
+
+	err = res.fetchBackends(ctx, res.backends)
+	if mergeErr := res.mergeChanges(res.logger); err == nil {
+		err = mergeErr
+	}
+	if err != nil {
+		return err
+	}
+	return res.RenderToWriter(w, src)
+}
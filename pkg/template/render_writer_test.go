@@ -0,0 +1,162 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/HeavyHorst/easykv/mock"
+)
+
+// newWriterTestResource returns a processed resource with the template "{{ getv("/host") }}:{{ getv("/port") }}".
+func newWriterTestResource(t *testing.T, dir string) (*Resource, string) {
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte(`{{ getv("/host") }}:{{ getv("/port") }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := Backend{Name: "mock", Onetime: true, Keys: []string{"/"}}
+	b.ReadWatcher, _ = mock.New(nil, map[string]string{"/host": "db.local", "/port": "5432"})
+	exec := NewExecutor("", "", "", 0, 0, newTestLogger())
+	res, err := NewResource([]Backend{b}, []*Renderer{{Src: src, Dst: filepath.Join(dir, "dst")}}, "test", exec, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.logger = newTestLogger()
+	for _, s := range res.sources {
+		s.logger = res.logger
+	}
+	if _, err := res.process(context.Background(), res.backends, false); err != nil {
+		t.Fatal(err)
+	}
+	return res, src
+}
+
+// renderHandler is an example of an HTTP handler that serves the rendered template src of res.
+// The template is rendered to a buffer first, so that errors can still be reported with a status code.
+func renderHandler(res *Resource, src string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if err := res.RenderToWriter(&buf, src); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		buf.WriteTo(w)
+	})
+}
+
+func TestRenderToWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	res, src := newWriterTestResource(t, dir)
+
+	var buf bytes.Buffer
+	if err := res.RenderToWriter(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "db.local:5432" {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+
+	if err := res.RenderToWriter(&buf, filepath.Join(dir, "unknown")); err == nil {
+		t.Error("rendering an unknown template should fail")
+	}
+
+	// concurrently with a store rebuild
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			var buf bytes.Buffer
+			if err := res.RenderToWriter(&buf, src); err != nil || buf.String() != "db.local:5432" {
+				t.Errorf("unexpected output %q: %v", buf.String(), err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			res.mergeStores(res.logger)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRenderToWriterHTTP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	res, src := newWriterTestResource(t, dir)
+
+	server := httptest.NewServer(renderHandler(res, src))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "db.local:5432" {
+		t.Errorf("unexpected response %d: %q", resp.StatusCode, body)
+	}
+
+	server = httptest.NewServer(renderHandler(res, "missing"))
+	defer server.Close()
+	resp, err = http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status %d, got %d", http.StatusInternalServerError, resp.StatusCode)
+	}
+}
+
+func TestRender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-render")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte(`{{ getv("/host") }}:{{ getv("/port") }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the literal values override the backends
+	var calls int32
+	connectors := BackendConnectors{countingConnector{values: map[string]string{"/host": "db.local", "/port": "5432"}, calls: &calls}}
+	var buf bytes.Buffer
+	if err := Render(context.Background(), src, connectors, map[string]string{"/port": "6432"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "db.local:6432" || calls != 1 {
+		t.Errorf("unexpected result %q after %d calls", buf.String(), calls)
+	}
+
+	// a template can be rendered without any backend, nothing is written if it fails
+	buf.Reset()
+	err = Render(context.Background(), src, nil, map[string]string{"/host": "localhost"}, &buf)
+	if FailureOf(err) != FailureRender || buf.Len() != 0 {
+		t.Errorf("expected a render failure without output, got %v (%q)", err, buf.String())
+	}
+}
@@ -12,16 +12,21 @@ package template
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"sync"
 	"text/template"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/HeavyHorst/pongo2"
 	"github.com/HeavyHorst/remco/pkg/template/fileutil"
@@ -34,6 +39,13 @@ func init() {
 	pongo2.SetAutoescape(false)
 }
 
+// Valid values for Renderer.OnExit.
+const (
+	OnExitKeep          = "keep"
+	OnExitRemove        = "remove"
+	OnExitWriteTemplate = "write_template"
+)
+
 // Renderer contains all data needed for the template processing
 type Renderer struct {
 	Src       string `json:"src"`
@@ -44,32 +56,136 @@ type Renderer struct {
 	GID       int    `json:"gid"`
 	ReloadCmd string `toml:"reload_cmd" json:"reload_cmd"`
 	CheckCmd  string `toml:"check_cmd" json:"check_cmd"`
+
+	// ReloadHTTP reloads the service by sending a request to its admin endpoint.
+	// It is used in addition to ReloadCmd, a failed request is handled like a failed reload command.
+	ReloadHTTP *HTTPReload `toml:"reload_http" json:"reload_http"`
+
+	// OnExit defines what happens to Dst when the resource is shut down.
+	// Valid values are "keep", "remove" and "write_template".
+	// The default is "keep".
+	OnExit string `toml:"on_exit" json:"on_exit"`
+
+	// OnExitSrc is the template that is rendered to Dst on shutdown if OnExit is "write_template".
+	OnExitSrc string `toml:"on_exit_src" json:"on_exit_src"`
+
+	// EnsureTrailingNewline appends a newline to the rendered content if it doesn't end with one.
+	EnsureTrailingNewline bool `toml:"ensure_trailing_newline" json:"ensure_trailing_newline"`
+
+	// EnsureFinalNewline is an alias for EnsureTrailingNewline.
+	EnsureFinalNewline bool `toml:"ensure_final_newline" json:"ensure_final_newline"`
+
+	// StripTrailingNewlines removes all trailing whitespace and newlines from the rendered content.
+	StripTrailingNewlines bool `toml:"strip_trailing_newlines" json:"strip_trailing_newlines"`
+
+	// LineEnding converts the line endings of the rendered content.
+	// Valid values are "lf", "crlf" and "native".
+	// The line endings are left untouched if LineEnding is empty or the content isn't text.
+	LineEnding string `toml:"line_ending" json:"line_ending"`
+
+	// Fsync commits the stage file to stable storage before it replaces Dst
+	// and the parent directory of Dst afterwards.
+	// This makes the replacement crash-safe but is expensive.
+	Fsync bool `json:"fsync"`
+
+	// SyncMode defines how Dst is replaced.
+	// "replace" (the default) renames the stage file to Dst.
+	// "symlink" moves the stage file to a versioned file in the directory Dst + ".d"
+	// and atomically repoints the symlink Dst to it.
+	SyncMode string `toml:"sync_mode" json:"sync_mode"`
+
+	// KeepVersions is the number of versioned files (including the current one) to keep if SyncMode is "symlink".
+	// The default is 5.
+	KeepVersions int `toml:"keep_versions" json:"keep_versions"`
+
+	// WhenExists is a key that must exist in the store for the template to be rendered.
+	// Keys below WhenExists satisfy the condition as well.
+	WhenExists string `toml:"when_exists" json:"when_exists"`
+
+	// Condition is a template that must evaluate to "true" for the template to be rendered.
+	// It is rendered with the same functions as the src template.
+	Condition string `json:"condition"`
+
+	// RemoveWhenSkipped removes Dst if the template is skipped because of WhenExists or Condition.
+	RemoveWhenSkipped bool `toml:"remove_when_skipped" json:"remove_when_skipped"`
+
+	// Iterate is a key pattern (e.g. "/services/*") that enables the fan-out mode.
+	// Src is rendered once for every subtree of the store matching the pattern.
+	// Dst is a template that is rendered with {{.name}} (the last path segment of the subtree)
+	// and {{.path}} (the root of the subtree) to compute the destination of each file.
+	// The check and reload commands are executed once per run for all changed files.
+	Iterate string `json:"iterate"`
+
+	// RemoveStale removes files generated in fan-out mode whose subtree disappeared.
+	RemoveStale bool `toml:"remove_stale" json:"remove_stale"`
+
+	// BaseTemplate is a template that Src extends, as if Src started with {% extends BaseTemplate %}.
+	// The base template defines blocks ({% block content %}{% endblock %}) that Src fills,
+	// it may extend another template itself. A relative path is resolved against the directory of Src.
+	BaseTemplate string `toml:"base_template" json:"base_template"`
+
+	// Template defines whether Src is a template.
+	// If set to false Src is copied verbatim to Dst without any template processing or post-processing,
+	// this is useful for binary or pre-rendered files.
+	// The default is true.
+	Template *bool `json:"template"`
+
+	// PreserveXattrs copies the user.* extended attributes and the POSIX ACL of the existing Dst
+	// to the new file. The SELinux label of Dst is always preserved.
+	PreserveXattrs bool `toml:"preserve_xattrs" json:"preserve_xattrs"`
+
+	// SELinuxLabel is the SELinux label applied to Dst if it doesn't exist yet.
+	// "auto" looks up the default label with matchpathcon.
+	SELinuxLabel string `toml:"selinux_label" json:"selinux_label"`
+
+	// Compare is the strategy used to detect if the destination file has changed.
+	// Valid values are "sha1", "sha256", "md5", "size+mtime" and "none".
+	// "size+mtime" is cheap for huge files but misses changes that don't alter the file size.
+	// "none" syncs the file on every run.
+	// The default is "sha1".
+	Compare string `json:"compare"`
+
 	stageFile *os.File
 	logger    *logrus.Entry
 	ReapLock  *sync.RWMutex
+
+	// stagedSrcModTime is the modification time of the src of the stage file.
+	stagedSrcModTime time.Time
+	// stagedRevision is the revision of the resource store the stage file was rendered with.
+	stagedRevision uint64
+	// revision returns the revision of the resource store, it is nil if the renderer doesn't belong to a resource.
+	revision func() uint64
+	// synced is the render that was synced last, the "size+mtime" compare strategy compares the stage file with it.
+	synced syncStamp
+
+	// xattrWarned is set once unsupported extended attributes have been reported.
+	xattrWarned bool
+	// xattrErr is the last reported error of applyXattrs, it is reset once the extended attributes were applied.
+	xattrErr string
+
+	// fanout holds the renderers of the files generated in fan-out mode, keyed by destination.
+	fanout map[string]*Renderer
+
+	// funcMap holds the template functions of the resource, it is used to render the ReloadHTTP body.
+	funcMap map[string]interface{}
+
+	// templates caches the parsed templates, the templates are parsed on every render if it is nil.
+	templates *templateCache
 }
 
 // createStageFile stages the src configuration file by processing the src
-// template and setting the desired owner, group, and mode. It also sets the
+// template (or copying it if Template is false) and setting the desired owner, group, and mode. It also sets the
 // StageFile for the template resource.
+// The template functions can access the RenderContext of ctx.
 // It returns an error if any.
-func (s *Renderer) createStageFile(funcMap map[string]interface{}) error {
-	if !fileutil.IsFileExist(s.Src) {
-		return fmt.Errorf("missing template: %s", s.Src)
-	}
-
-	s.logger.WithFields(logrus.Fields{
-		"template": s.Src,
-	}).Debug("compiling source template")
+func (s *Renderer) createStageFile(ctx context.Context, funcMap map[string]interface{}) error {
+	return s.createStageFileFromSrc(ctx, s.Src, funcMap)
+}
 
-	set := pongo2.NewSet("local", &pongo2.LocalFilesystemLoader{})
-	set.Options = &pongo2.Options{
-		TrimBlocks:   true,
-		LStripBlocks: true,
-	}
-	tmpl, err := set.FromFile(s.Src)
-	if err != nil {
-		return errors.Wrapf(err, "set.FromFile(%s) failed", s.Src)
+// createStageFileFromSrc is like createStageFile but stages the given src.
+func (s *Renderer) createStageFileFromSrc(ctx context.Context, src string, funcMap map[string]interface{}) error {
+	if !fileutil.IsFileExist(src) {
+		return withFailure(FailureRender, fmt.Errorf("missing template: %s", src))
 	}
 
 	// create TempFile in Dest directory to avoid cross-filesystem issues
@@ -83,15 +199,16 @@ func (s *Renderer) createStageFile(funcMap map[string]interface{}) error {
 		return errors.Wrap(err, "couldn't create tempfile")
 	}
 
-	executionStartTime := time.Now()
-	if err = tmpl.ExecuteWriter(funcMap, temp); err != nil {
-		temp.Close()
-		os.Remove(temp.Name())
-		return errors.Wrap(err, "template execution failed")
+	if s.isTemplate() {
+		err = withFailure(FailureRender, s.render(src, withRenderContextFuncs(ctx, funcMap), temp))
+	} else {
+		err = s.copy(src, temp)
 	}
-	metrics.MeasureSince([]string{"files", "template_execution_duration"}, executionStartTime)
-
 	temp.Close()
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
 
 	fileMode, err := s.getFileMode()
 	if err != nil {
@@ -102,17 +219,183 @@ func (s *Renderer) createStageFile(funcMap map[string]interface{}) error {
 	// compare against the destination configuration file later.
 	os.Chmod(temp.Name(), fileMode)
 	os.Chown(temp.Name(), s.UID, s.GID)
+
+	if fi, err := os.Stat(src); err == nil {
+		s.stagedSrcModTime = fi.ModTime()
+	}
+	if s.revision != nil {
+		s.stagedRevision = s.revision()
+	}
 	s.stageFile = temp
 
+	s.logger.WithFields(logrus.Fields{
+		"template": src,
+		"staged":   temp.Name(),
+	}).Debug("stage file created")
+
+	return nil
+}
+
+// isTemplate reports whether Src is processed as a template.
+func (s *Renderer) isTemplate() bool {
+	return s.Template == nil || *s.Template
+}
+
+// render executes the src template and writes the post-processed result to w.
+func (s *Renderer) render(src string, funcMap map[string]interface{}, w io.Writer) error {
+	tmpl, err := s.parse(src)
+	if err != nil {
+		return s.baseTemplateError(src, errors.Wrapf(templateError(src, err), "set.FromFile(%s) failed", src))
+	}
+
+	executionStartTime := time.Now()
+	var rendered bytes.Buffer
+	if err = executeTemplate(tmpl, funcMap, &rendered); err != nil {
+		return s.baseTemplateError(src, errors.Wrap(templateError(src, err), "template execution failed"))
+	}
+	metrics.MeasureSince([]string{"files", "template_execution_duration"}, executionStartTime)
+
+	if _, err = w.Write(s.postProcess(rendered.Bytes())); err != nil {
+		return errors.Wrap(err, "couldn't write stage file")
+	}
 	return nil
 }
 
+// parse returns the parsed src template.
+// The cached template is used unless src, its base templates or its included templates changed since it has been parsed.
+// Templates with the cycle or ifchanged tags are parsed on every render, these tags keep their state in the template.
+func (s *Renderer) parse(src string) (*pongo2.Template, error) {
+	if tmpl, ok := s.templates.lookup(src); ok {
+		return tmpl, nil
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"template": src,
+	}).Debug("compiling source template")
+
+	parseStartTime := time.Now()
+	loader := newRecordingLoader(newTemplateLoader(src, s.BaseTemplate))
+	set := pongo2.NewSet("local", loader)
+	set.Options = &pongo2.Options{
+		TrimBlocks:   true,
+		LStripBlocks: true,
+	}
+	tmpl, err := set.FromFile(src)
+	if err != nil {
+		return nil, err
+	}
+	if loader.cacheable() {
+		s.templates.store(src, tmpl, loader.modTimes())
+	}
+	s.logger.WithFields(logrus.Fields{
+		"template":   src,
+		"parse_time": time.Since(parseStartTime),
+	}).Debug("source template compiled")
+	return tmpl, nil
+}
+
+// executeTemplate executes tmpl and returns an error if a template function panics.
+func executeTemplate(tmpl *pongo2.Template, funcMap map[string]interface{}, w io.Writer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("template execution panicked: %v", r)
+		}
+	}()
+	return tmpl.ExecuteWriter(funcMap, w)
+}
+
+// copy streams the contents of src to w without any processing.
+func (s *Renderer) copy(src string, w io.Writer) error {
+	s.logger.WithFields(logrus.Fields{
+		"source": src,
+	}).Debug("copying source file")
+
+	f, err := os.Open(src)
+	if err != nil {
+		return errors.Wrap(err, "couldn't open source file")
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return errors.Wrap(err, "couldn't write stage file")
+	}
+	return nil
+}
+
+// A syncStamp describes the last synced render of a renderer.
+type syncStamp struct {
+	// srcModTime is the modification time of the template (or of the copied file if Template is false).
+	srcModTime time.Time
+	// revision is the revision of the resource store the file was rendered with.
+	revision uint64
+	// dstModTime is the modification time of Dst after the sync.
+	dstModTime time.Time
+	// size is the size of the rendered file.
+	size int64
+}
+
+// sameFile reports whether the staged file and Dst are equal according to the compare strategy.
+// The "size+mtime" strategy doesn't compare the files with each other: the stage file always has a new mtime.
+// The staged render is equal to the last synced one if its src has the same mtime, the resource store has the same revision
+// and it has the same size, and Dst is unchanged if it still has the mtime and the size of the last sync.
+func (s *Renderer) sameFile(staged string) (bool, error) {
+	if s.Compare != fileutil.CompareSizeMtime {
+		return fileutil.SameFileWith(staged, s.Dst, s.Compare, s.logger)
+	}
+	if s.synced.dstModTime.IsZero() || !fileutil.IsFileExist(s.Dst) {
+		return false, nil
+	}
+	sfi, err := os.Stat(staged)
+	if err != nil {
+		return false, errors.Wrap(err, "stat stage file failed")
+	}
+	dfi, err := os.Stat(s.Dst)
+	if err != nil {
+		return false, errors.Wrap(err, "stat target config failed")
+	}
+	switch {
+	case !s.stagedSrcModTime.Equal(s.synced.srcModTime):
+		s.logger.WithFields(logrus.Fields{
+			"config":  s.Dst,
+			"current": s.synced.srcModTime,
+			"new":     s.stagedSrcModTime,
+		}).Info("wrong template mtime")
+		return false, nil
+	case s.stagedRevision != s.synced.revision:
+		s.logger.WithFields(logrus.Fields{
+			"config":  s.Dst,
+			"current": s.synced.revision,
+			"new":     s.stagedRevision,
+		}).Info("store changed since the last sync")
+		return false, nil
+	case sfi.Size() != s.synced.size:
+		s.logger.WithFields(logrus.Fields{
+			"config":  s.Dst,
+			"current": s.synced.size,
+			"new":     sfi.Size(),
+		}).Info("wrong size")
+		return false, nil
+	case dfi.Size() != s.synced.size || !dfi.ModTime().Equal(s.synced.dstModTime):
+		s.logger.WithFields(logrus.Fields{
+			"config":  s.Dst,
+			"synced":  s.synced.dstModTime,
+			"current": dfi.ModTime(),
+		}).Info("target config modified since the last sync")
+		return false, nil
+	}
+	return fileutil.SameAttributes(staged, s.Dst, s.logger)
+}
+
 // syncFiles compares the staged and dest config files and attempts to sync them
 // if they differ. syncFiles will run a config check command if set before
 // overwriting the target config file. Finally, syncFile will run a reload command
 // if set to have the application or service pick up the changes.
 // It returns a boolean indicating if the file has changed and an error if any.
 func (s *Renderer) syncFiles(runCommands bool) (bool, error) {
+	if s.SyncMode == SyncModeSymlink {
+		return s.syncSymlink(runCommands)
+	}
+
 	var changed bool
 	staged := s.stageFile.Name()
 	defer os.Remove(staged)
@@ -122,11 +405,34 @@ func (s *Renderer) syncFiles(runCommands bool) (bool, error) {
 		"dest":   s.Dst,
 	}).Debug("comparing staged and dest config files")
 
-	ok, err := fileutil.SameFile(staged, s.Dst, s.logger)
+	var checksumBefore string
+	debug := s.logger.Logger.IsLevelEnabled(logrus.DebugLevel)
+	if debug && fileutil.IsFileExist(s.Dst) {
+		checksumBefore, _ = fileutil.Checksum(s.Dst, s.Compare)
+	}
+
+	ok, err := s.sameFile(staged)
 	if err != nil {
 		s.logger.Error(err.Error())
 	}
 
+	if debug {
+		defer func() {
+			fields := logrus.Fields{
+				"config":          s.Dst,
+				"checksum_before": checksumBefore,
+				"changed":         changed,
+			}
+			if fi, err := os.Stat(s.Dst); err == nil {
+				fields["size"] = fi.Size()
+			}
+			if checksumAfter, err := fileutil.Checksum(s.Dst, s.Compare); err == nil {
+				fields["checksum_after"] = checksumAfter
+			}
+			s.logger.WithFields(fields).Debug("sync finished")
+		}()
+	}
+
 	if !ok {
 		s.logger.WithFields(logrus.Fields{
 			"config": s.Dst,
@@ -146,14 +452,36 @@ func (s *Renderer) syncFiles(runCommands bool) (bool, error) {
 		if err != nil {
 			return changed, errors.Wrap(err, "getFileMode failed")
 		}
+		s.applyXattrs(staged)
+		if s.Fsync {
+			if err := fileutil.SyncFile(staged); err != nil {
+				return changed, errors.Wrap(err, "fsync stage file failed")
+			}
+		}
 		if err := fileutil.ReplaceFile(staged, s.Dst, fileMode, s.logger); err != nil {
 			return changed, errors.Wrap(err, "replace file failed")
 		}
+		if s.Fsync {
+			// ReplaceFile may have written to Dst instead of renaming the stage file
+			if err := fileutil.SyncFile(s.Dst); err != nil {
+				return changed, errors.Wrap(err, "fsync target config failed")
+			}
+			if err := fileutil.SyncDir(filepath.Dir(s.Dst)); err != nil {
+				return changed, errors.Wrap(err, "fsync target directory failed")
+			}
+		}
 
 		// make sure owner and group match the temp file, in case the file was created with WriteFile
 		os.Chown(s.Dst, s.UID, s.GID)
 		changed = true
 
+		if s.Compare == fileutil.CompareSizeMtime {
+			s.synced = syncStamp{}
+			if fi, err := os.Stat(s.Dst); err == nil {
+				s.synced = syncStamp{srcModTime: s.stagedSrcModTime, revision: s.stagedRevision, dstModTime: fi.ModTime(), size: fi.Size()}
+			}
+		}
+
 		if runCommands {
 			if err := s.reload(s.Dst); err != nil {
 				return changed, errors.Wrap(err, "reload command failed")
@@ -173,6 +501,146 @@ func (s *Renderer) syncFiles(runCommands bool) (bool, error) {
 	return changed, nil
 }
 
+// Valid values for Renderer.LineEnding.
+const (
+	LineEndingLF     = "lf"
+	LineEndingCRLF   = "crlf"
+	LineEndingNative = "native"
+)
+
+// newline returns the newline sequence for the configured line ending.
+func (s *Renderer) newline() []byte {
+	switch s.LineEnding {
+	case LineEndingCRLF:
+		return []byte("\r\n")
+	case LineEndingNative:
+		if runtime.GOOS == "windows" {
+			return []byte("\r\n")
+		}
+	}
+	return []byte("\n")
+}
+
+// isText reports whether content looks like text.
+// Content with NUL bytes or invalid utf-8 sequences is treated as binary.
+func isText(content []byte) bool {
+	return utf8.Valid(content) && bytes.IndexByte(content, 0) == -1
+}
+
+// postProcess applies the line ending and trailing newline options to the rendered content.
+// Binary content is returned unmodified.
+func (s *Renderer) postProcess(content []byte) []byte {
+	if s.LineEnding == "" && !s.StripTrailingNewlines && !s.EnsureTrailingNewline && !s.EnsureFinalNewline {
+		return content
+	}
+	if !isText(content) {
+		s.logger.WithFields(logrus.Fields{
+			"template": s.Src,
+		}).Debug("rendered content is not text, skipping normalization")
+		return content
+	}
+
+	newline := s.newline()
+	if s.LineEnding != "" {
+		content = bytes.Replace(content, []byte("\r\n"), []byte("\n"), -1)
+		if !bytes.Equal(newline, []byte("\n")) {
+			content = bytes.Replace(content, []byte("\n"), newline, -1)
+		}
+	}
+
+	if s.StripTrailingNewlines {
+		return bytes.TrimRightFunc(content, unicode.IsSpace)
+	}
+	if (s.EnsureTrailingNewline || s.EnsureFinalNewline) && !bytes.HasSuffix(content, []byte("\n")) {
+		return append(content, newline...)
+	}
+	return content
+}
+
+// validate reports whether the Renderer configuration is valid.
+func (s *Renderer) validate() error {
+	if s.Src == "" {
+		return ErrEmptySrc
+	}
+	if (s.EnsureTrailingNewline || s.EnsureFinalNewline) && s.StripTrailingNewlines {
+		return ErrConflictingNewlineOptions
+	}
+	if !s.isTemplate() && s.BaseTemplate != "" {
+		return fmt.Errorf("template = false copies src verbatim and can't be combined with base_template")
+	}
+	if !s.isTemplate() && (s.EnsureTrailingNewline || s.EnsureFinalNewline || s.StripTrailingNewlines || s.LineEnding != "") {
+		return fmt.Errorf("template = false copies src verbatim and can't be combined with newline or line_ending options")
+	}
+	switch s.LineEnding {
+	case "", LineEndingLF, LineEndingCRLF, LineEndingNative:
+	default:
+		return fmt.Errorf("invalid line_ending value: %q", s.LineEnding)
+	}
+	switch s.SyncMode {
+	case "", SyncModeReplace, SyncModeSymlink:
+	default:
+		return fmt.Errorf("invalid sync_mode value: %q", s.SyncMode)
+	}
+	if s.ReloadHTTP != nil {
+		if err := s.ReloadHTTP.validate(); err != nil {
+			return err
+		}
+	}
+	if !fileutil.ValidCompare(s.Compare) {
+		return fmt.Errorf("invalid compare value: %q", s.Compare)
+	}
+	if s.Iterate != "" {
+		if _, err := path.Match(s.Iterate, ""); err != nil {
+			return fmt.Errorf("invalid iterate pattern: %q", s.Iterate)
+		}
+		if s.OnExit == OnExitWriteTemplate {
+			return fmt.Errorf("on_exit = %q can't be used with iterate", s.OnExit)
+		}
+	}
+	switch s.OnExit {
+	case "", OnExitKeep, OnExitRemove:
+		return nil
+	case OnExitWriteTemplate:
+		if s.OnExitSrc == "" {
+			return fmt.Errorf("on_exit = %q requires an on_exit_src template", s.OnExit)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid on_exit value: %q", s.OnExit)
+	}
+}
+
+// onExit cleans up the destination file according to s.OnExit.
+// No check or reload commands are executed.
+// It returns an error if any.
+func (s *Renderer) onExit(funcMap map[string]interface{}) error {
+	if s.Iterate != "" {
+		for _, item := range s.fanout {
+			if err := item.onExit(funcMap); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	switch s.OnExit {
+	case OnExitRemove:
+		s.logger.WithFields(logrus.Fields{
+			"config": s.Dst,
+		}).Info("removing target config")
+		if err := os.Remove(s.Dst); err != nil && !os.IsNotExist(err) {
+			return errors.Wrap(err, "couldn't remove target config")
+		}
+	case OnExitWriteTemplate:
+		if err := s.createStageFileFromSrc(context.Background(), s.OnExitSrc, funcMap); err != nil {
+			return errors.Wrap(err, "create shutdown stage file failed")
+		}
+		if _, err := s.syncFiles(false); err != nil {
+			return errors.Wrap(err, "sync shutdown files failed")
+		}
+	}
+	return nil
+}
+
 func (s *Renderer) getFileMode() (os.FileMode, error) {
 	if s.Mode == "" {
 		if !fileutil.IsFileExist(s.Dst) {
@@ -204,12 +672,12 @@ func (s *Renderer) check(stageFile string) error {
 	defer metrics.MeasureSince([]string{"files", "check_command_duration"}, time.Now())
 	cmd, err := renderTemplate(s.CheckCmd, map[string]string{"src": stageFile})
 	if err != nil {
-		return errors.Wrap(err, "rendering check command failed")
+		return withFailure(FailureCheck, errors.Wrap(err, "rendering check command failed"))
 	}
 	output, err := execCommand(cmd, s.logger, s.ReapLock)
 	if err != nil {
 		s.logger.Error(fmt.Sprintf("%q", string(output)))
-		return errors.Wrap(err, "the check command failed")
+		return withFailure(FailureCheck, errors.Wrap(err, "the check command failed"))
 	}
 	s.logger.Debug(fmt.Sprintf("%q", string(output)))
 	return nil
@@ -218,11 +686,22 @@ func (s *Renderer) check(stageFile string) error {
 // reload executes the reload command.
 // It returns nil if the reload command returns 0 and an error otherwise.
 func (s *Renderer) reload(renderedFile string) error {
+	return s.reloadWith(map[string]string{"dst": renderedFile})
+}
+
+// reloadWith sends the reload request and executes the reload command rendered with the given data.
+// It returns nil if the request succeeds and the reload command returns 0 and an error otherwise.
+func (s *Renderer) reloadWith(data map[string]string) error {
+	if s.ReloadHTTP != nil {
+		if err := s.ReloadHTTP.do(context.Background(), s.funcMap, data); err != nil {
+			return errors.Wrap(err, "the reload request failed")
+		}
+	}
 	if s.ReloadCmd == "" {
 		return nil
 	}
 	defer metrics.MeasureSince([]string{"files", "reload_command_duration"}, time.Now())
-	cmd, err := renderTemplate(s.ReloadCmd, map[string]string{"dst": renderedFile})
+	cmd, err := renderTemplate(s.ReloadCmd, data)
 	if err != nil {
 		return errors.Wrap(err, "rendering reload command failed")
 	}
@@ -248,13 +727,42 @@ func renderTemplate(unparsed string, data interface{}) (string, error) {
 }
 
 func execCommand(cmd string, logger *logrus.Entry, rl *sync.RWMutex) ([]byte, error) {
+	return execCommandContext(context.Background(), cmd, nil, nil, logger, rl)
+}
+
+// execCommandContext runs cmd with the given positional arguments and environment.
+// The command and all processes it started are killed if the context is done before the command completes.
+// The current environment is used if env is nil.
+func execCommandContext(ctx context.Context, cmd string, args, env []string, logger *logrus.Entry, rl *sync.RWMutex) ([]byte, error) {
 	logger.Debugf("Running %q", cmd)
-	c := exec.Command("/bin/sh", "-c", cmd)
+	c := exec.Command("/bin/sh", append([]string{"-c", cmd, "sh"}, args...)...)
+	c.Env = env
+	var output bytes.Buffer
+	c.Stdout = &output
+	c.Stderr = &output
+	setProcessGroup(c)
 
 	if rl != nil {
 		rl.RLock()
 		defer rl.RUnlock()
 	}
 
-	return c.CombinedOutput()
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return output.Bytes(), err
+	case <-ctx.Done():
+		// killing only the shell would leave its children running,
+		// which keep the output open and block Wait
+		killProcessGroup(c)
+		<-done
+		return output.Bytes(), ctx.Err()
+	}
 }
@@ -0,0 +1,552 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/pongo2"
+	"github.com/HeavyHorst/remco/pkg/template/fileutil"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestLogger() *logrus.Entry {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	return logrus.NewEntry(logger)
+}
+
+func TestPostProcessDefault(t *testing.T) {
+	r := &Renderer{}
+	for _, in := range []string{"foo", "foo\n", "foo\n\n  "} {
+		if out := string(r.postProcess([]byte(in))); out != in {
+			t.Errorf("postProcess(%q) should be %q, got %q", in, in, out)
+		}
+	}
+}
+
+func TestPostProcessEnsureTrailingNewline(t *testing.T) {
+	r := &Renderer{EnsureTrailingNewline: true}
+	tests := map[string]string{
+		"":         "\n",
+		"foo":      "foo\n",
+		"foo\n":    "foo\n",
+		"foo\n\n":  "foo\n\n",
+		"foo\nbar": "foo\nbar\n",
+	}
+	for in, expected := range tests {
+		if out := string(r.postProcess([]byte(in))); out != expected {
+			t.Errorf("postProcess(%q) should be %q, got %q", in, expected, out)
+		}
+	}
+}
+
+func TestPostProcessStripTrailingNewlines(t *testing.T) {
+	r := &Renderer{StripTrailingNewlines: true}
+	tests := map[string]string{
+		"":              "",
+		"foo":           "foo",
+		"foo\n":         "foo",
+		"foo\n\n \t\n":  "foo",
+		"\nfoo\nbar\n ": "\nfoo\nbar",
+	}
+	for in, expected := range tests {
+		if out := string(r.postProcess([]byte(in))); out != expected {
+			t.Errorf("postProcess(%q) should be %q, got %q", in, expected, out)
+		}
+	}
+}
+
+func TestValidateConflictingNewlineOptions(t *testing.T) {
+	r := &Renderer{Src: "foo", EnsureTrailingNewline: true, StripTrailingNewlines: true}
+	if err := r.validate(); err != ErrConflictingNewlineOptions {
+		t.Errorf("validate should return ErrConflictingNewlineOptions, got %v", err)
+	}
+
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	_, err := NewResource([]Backend{{Name: "mock"}}, []*Renderer{r}, "test", exec, "", "")
+	if err != ErrConflictingNewlineOptions {
+		t.Errorf("NewResource should return ErrConflictingNewlineOptions, got %v", err)
+	}
+}
+
+func TestPostProcessLineEnding(t *testing.T) {
+	tests := []struct {
+		lineEnding   string
+		ensure       bool
+		in, expected string
+	}{
+		{LineEndingLF, false, "a\r\nb\nc", "a\nb\nc"},
+		{LineEndingCRLF, false, "a\r\nb\nc", "a\r\nb\r\nc"},
+		{LineEndingCRLF, true, "a\nb", "a\r\nb\r\n"},
+		{LineEndingCRLF, true, "a\nb\r\n", "a\r\nb\r\n"},
+		{LineEndingLF, true, "a\r\nb", "a\nb\n"},
+	}
+	for _, tt := range tests {
+		r := &Renderer{LineEnding: tt.lineEnding, EnsureFinalNewline: tt.ensure, logger: newTestLogger()}
+		if out := string(r.postProcess([]byte(tt.in))); out != tt.expected {
+			t.Errorf("postProcess(%q) with line_ending %q should be %q, got %q", tt.in, tt.lineEnding, tt.expected, out)
+		}
+	}
+}
+
+func TestPostProcessBinary(t *testing.T) {
+	r := &Renderer{LineEnding: LineEndingCRLF, EnsureFinalNewline: true, logger: newTestLogger()}
+	in := []byte{'a', '\n', 0, 0xff, '\n'}
+	if out := r.postProcess(in); string(out) != string(in) {
+		t.Errorf("binary content should not be modified, got %q", out)
+	}
+}
+
+func TestValidateLineEnding(t *testing.T) {
+	r := &Renderer{Src: "foo", LineEnding: "cr"}
+	if err := r.validate(); err == nil {
+		t.Error("validate should fail for an invalid line_ending")
+	}
+	r.LineEnding = LineEndingNative
+	if err := r.validate(); err != nil {
+		t.Errorf("validate should not fail for line_ending %q: %v", r.LineEnding, err)
+	}
+}
+
+func TestCreateStageFileCopy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// not a valid template and not valid utf-8
+	content := []byte("{{ foo \x00\xff{% bar\r\n")
+	src := filepath.Join(dir, "blob.bin")
+	if err := ioutil.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := false
+	r := &Renderer{
+		Src:      src,
+		Dst:      filepath.Join(dir, "out.bin"),
+		Template: &tmpl,
+		logger:   newTestLogger(),
+	}
+	if err := r.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.createStageFile(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := r.syncFiles(false)
+	if err != nil || !changed {
+		t.Fatalf("sync should change dst, got changed=%v err=%v", changed, err)
+	}
+
+	data, err := ioutil.ReadFile(r.Dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("dst should be a verbatim copy of src, got %q", data)
+	}
+
+	if err := r.createStageFile(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := r.syncFiles(false); err != nil || changed {
+		t.Errorf("sync of an unchanged file should be a no-op, got changed=%v err=%v", changed, err)
+	}
+}
+
+func TestSyncFilesSizeMtime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-size-mtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "allowlist.src")
+	if err := ioutil.WriteFile(src, []byte("10.0.0.0/8\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl := false
+	r := &Renderer{
+		Src:      src,
+		Dst:      filepath.Join(dir, "allowlist"),
+		Template: &tmpl,
+		Compare:  fileutil.CompareSizeMtime,
+		logger:   newTestLogger(),
+	}
+	var revision uint64
+	r.revision = func() uint64 { return revision }
+	if err := r.validate(); err != nil {
+		t.Fatal(err)
+	}
+	sync := func() bool {
+		t.Helper()
+		if err := r.createStageFile(context.Background(), nil); err != nil {
+			t.Fatal(err)
+		}
+		changed, err := r.syncFiles(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return changed
+	}
+
+	if !sync() {
+		t.Fatal("the first sync should change dst")
+	}
+	if sync() {
+		t.Error("an unchanged render shouldn't be synced")
+	}
+
+	// the source changed but kept its size
+	mtime := time.Now().Add(time.Hour)
+	if err := ioutil.WriteFile(src, []byte("10.1.0.0/8\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if !sync() {
+		t.Error("a render of a modified source should be synced")
+	}
+	if sync() {
+		t.Error("an unchanged render shouldn't be synced")
+	}
+
+	// the store changed, the render kept its size
+	revision++
+	if !sync() {
+		t.Error("a render of a changed store should be synced")
+	}
+	if sync() {
+		t.Error("an unchanged render shouldn't be synced")
+	}
+
+	// the source changed its size but kept its mtime
+	if err := ioutil.WriteFile(src, []byte("10.1.0.0/16\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(src, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if !sync() {
+		t.Error("a render with another size should be synced")
+	}
+
+	// dst was modified since the last sync
+	if err := ioutil.WriteFile(r.Dst, []byte("10.9.0.0/16\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dstTime := time.Now().Add(2 * time.Hour)
+	if err := os.Chtimes(r.Dst, dstTime, dstTime); err != nil {
+		t.Fatal(err)
+	}
+	if !sync() {
+		t.Error("a modified dst should be synced")
+	}
+	if data, _ := ioutil.ReadFile(r.Dst); string(data) != "10.1.0.0/16\n" {
+		t.Errorf("unexpected dst %q", data)
+	}
+}
+
+func TestValidateCopy(t *testing.T) {
+	tmpl := false
+	r := &Renderer{Src: "src", Template: &tmpl, LineEnding: LineEndingCRLF}
+	if err := r.validate(); err == nil {
+		t.Error("template = false should not be combined with line_ending")
+	}
+}
+
+func TestCreateStageFileTemplateError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src.tmpl")
+	if err := ioutil.WriteFile(src, []byte("line1\nline2\nvalue: {{ getv(\"/missing\") }}\nline4\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	funcMap := map[string]interface{}{
+		"getv": func(key string, v ...string) (string, error) {
+			return "", fmt.Errorf("key does not exist")
+		},
+	}
+
+	for _, redact := range []bool{false, true} {
+		fm := make(map[string]interface{})
+		addFuncs(fm, funcMap)
+		annotateFuncs(fm, redact)
+
+		r := &Renderer{Src: src, Dst: filepath.Join(dir, "dst"), logger: newTestLogger()}
+		err = r.createStageFile(context.Background(), fm)
+		if err == nil {
+			t.Fatal("createStageFile should fail")
+		}
+
+		msg := err.Error()
+		for _, expected := range []string{src + ":3:", "    2 | line2", "    3 | value:", "    4 | line4", "^"} {
+			if !strings.Contains(msg, expected) {
+				t.Errorf("error should contain %q, got %q", expected, msg)
+			}
+		}
+		if redact {
+			if !strings.Contains(msg, `getv(<redacted>): key does not exist`) || strings.Contains(msg, `getv("/missing"):`) {
+				t.Errorf("function arguments should be redacted, got %q", msg)
+			}
+		} else if !strings.Contains(msg, `getv("/missing"): key does not exist`) {
+			t.Errorf("error should contain the function name and arguments, got %q", msg)
+		}
+	}
+}
+
+// newErrorTestRenderer writes the template to a new temporary directory
+// and returns a Renderer that renders it to dst in the same directory.
+func newErrorTestRenderer(t *testing.T, template string) (*Renderer, string) {
+	dir, err := ioutil.TempDir("", "remco-stage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(dir, "src.tmpl")
+	if err := ioutil.WriteFile(src, []byte(template), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return &Renderer{Src: src, Dst: filepath.Join(dir, "dst"), logger: newTestLogger()}, dir
+}
+
+// assertNoStageFiles fails if a stage file of dst was left behind in dir.
+func assertNoStageFiles(t *testing.T, dir string) {
+	matches, err := filepath.Glob(filepath.Join(dir, ".dst*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) > 0 {
+		t.Errorf("the stage files weren't removed: %v", matches)
+	}
+}
+
+func TestCreateStageFileMissingTemplate(t *testing.T) {
+	r, dir := newErrorTestRenderer(t, "")
+	defer os.RemoveAll(dir)
+	r.Src = filepath.Join(dir, "missing.tmpl")
+
+	err := r.createStageFile(context.Background(), nil)
+	if err == nil || err.Error() != "missing template: "+r.Src {
+		t.Errorf("expected a missing template error, got %v", err)
+	}
+	assertNoStageFiles(t, dir)
+}
+
+func TestCreateStageFileSyntaxError(t *testing.T) {
+	r, dir := newErrorTestRenderer(t, "line1\n{% if %}\nline3\n")
+	defer os.RemoveAll(dir)
+
+	err := r.createStageFile(context.Background(), nil)
+	if err == nil {
+		t.Fatal("createStageFile should fail")
+	}
+	if _, ok := errors.Cause(err).(*pongo2.Error); !ok {
+		t.Errorf("the cause should be a pongo2 error, got %T", errors.Cause(err))
+	}
+	msg := err.Error()
+	for _, expected := range []string{"set.FromFile(" + r.Src + ") failed", r.Src + ":2:", "    2 | {% if %}"} {
+		if !strings.Contains(msg, expected) {
+			t.Errorf("error should contain %q, got %q", expected, msg)
+		}
+	}
+	assertNoStageFiles(t, dir)
+}
+
+func TestCreateStageFileUndefinedFunction(t *testing.T) {
+	// an unknown filter is an error
+	r, dir := newErrorTestRenderer(t, "{{ \"value\" | nofilter }}")
+	defer os.RemoveAll(dir)
+
+	err := r.createStageFile(context.Background(), nil)
+	if err == nil {
+		t.Fatal("createStageFile should fail")
+	}
+	if _, ok := errors.Cause(err).(*pongo2.Error); !ok {
+		t.Errorf("the cause should be a pongo2 error, got %T", errors.Cause(err))
+	}
+	if !strings.Contains(err.Error(), "Filter 'nofilter' does not exist") {
+		t.Errorf("error should name the filter, got %q", err)
+	}
+	assertNoStageFiles(t, dir)
+
+	// pongo2 treats an unknown function as nil, the call renders nothing
+	r, dir = newErrorTestRenderer(t, "value: {{ nofunc() }}")
+	defer os.RemoveAll(dir)
+	if err := r.createStageFile(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(r.stageFile.Name())
+	data, err := ioutil.ReadFile(r.stageFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "value: " {
+		t.Errorf("an unknown function should render nothing, got %q", data)
+	}
+}
+
+func TestCreateStageFilePanic(t *testing.T) {
+	r, dir := newErrorTestRenderer(t, "{{ boom() }}")
+	defer os.RemoveAll(dir)
+	funcMap := map[string]interface{}{
+		"boom": func() string { panic("kaboom") },
+	}
+
+	err := r.createStageFile(context.Background(), funcMap)
+	if err == nil {
+		t.Fatal("createStageFile should fail")
+	}
+	if !strings.Contains(err.Error(), "template execution failed: template execution panicked: kaboom") {
+		t.Errorf("error should contain the panic, got %q", err)
+	}
+	assertNoStageFiles(t, dir)
+}
+
+func TestCreateStageFileStageDirError(t *testing.T) {
+	r, dir := newErrorTestRenderer(t, "value")
+	defer os.RemoveAll(dir)
+
+	// the directory of dst doesn't exist
+	r.Dst = filepath.Join(dir, "missing", "dst")
+	err := r.createStageFile(context.Background(), nil)
+	if err == nil || !strings.HasPrefix(err.Error(), "couldn't create tempfile") {
+		t.Errorf("expected a tempfile error, got %v", err)
+	}
+	if !os.IsNotExist(errors.Cause(err)) {
+		t.Errorf("the cause should be a not exist error, got %v", errors.Cause(err))
+	}
+
+	// the directory of dst can't be created, a file has the same name (this fails even for root)
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	r.Dst = filepath.Join(file, "dst")
+	r.MkDirs = true
+	err = r.createStageFile(context.Background(), nil)
+	if err == nil || !strings.HasPrefix(err.Error(), "MkdirAll failed") {
+		t.Errorf("expected a MkdirAll error, got %v", err)
+	}
+	if _, ok := errors.Cause(err).(*os.PathError); !ok {
+		t.Errorf("the cause should be a path error, got %T", errors.Cause(err))
+	}
+
+	// the directory of dst isn't writable
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to read-only directories")
+	}
+	readOnly := filepath.Join(dir, "readonly")
+	if err := os.Mkdir(readOnly, 0555); err != nil {
+		t.Fatal(err)
+	}
+	r.Dst = filepath.Join(readOnly, "dst")
+	err = r.createStageFile(context.Background(), nil)
+	if err == nil || !os.IsPermission(errors.Cause(err)) {
+		t.Errorf("expected a permission error, got %v", err)
+	}
+}
+
+// cappedWriter fails with ENOSPC after max bytes, like a full disk.
+type cappedWriter struct {
+	max     int
+	written int
+}
+
+func (w *cappedWriter) Write(p []byte) (int, error) {
+	if w.written+len(p) > w.max {
+		n := w.max - w.written
+		w.written = w.max
+		return n, &os.PathError{Op: "write", Path: "stage", Err: syscall.ENOSPC}
+	}
+	w.written += len(p)
+	return len(p), nil
+}
+
+func TestRenderDiskFull(t *testing.T) {
+	r, dir := newErrorTestRenderer(t, strings.Repeat("line\n", 100))
+	defer os.RemoveAll(dir)
+
+	err := r.render(r.Src, nil, &cappedWriter{max: 10})
+	if err == nil || !strings.HasPrefix(err.Error(), "couldn't write stage file") {
+		t.Fatalf("expected a write error, got %v", err)
+	}
+	perr, ok := errors.Cause(err).(*os.PathError)
+	if !ok || perr.Err != syscall.ENOSPC {
+		t.Errorf("the cause should be ENOSPC, got %v", errors.Cause(err))
+	}
+}
+
+func TestReportXattrError(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &buf
+	r := &Renderer{Dst: "/etc/dst", logger: logrus.NewEntry(logger)}
+
+	reported := func() int {
+		return strings.Count(buf.String(), "couldn't preserve extended attributes")
+	}
+	eperm := errors.New("operation not permitted")
+	r.reportXattrError(eperm)
+	r.reportXattrError(eperm)
+	if n := reported(); n != 1 {
+		t.Errorf("a repeated error should be reported once, got %d reports", n)
+	}
+
+	r.reportXattrError(nil)
+	r.reportXattrError(eperm)
+	if n := reported(); n != 2 {
+		t.Errorf("the error should be reported again after a success, got %d reports", n)
+	}
+}
+
+func TestSyncFilesPreserveXattrs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-xattr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dst := filepath.Join(dir, "dst")
+	if err := ioutil.WriteFile(dst, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fileutil.SetXattr(dst, "user.remco", []byte("test")); err != nil {
+		t.Skipf("user xattrs are not supported: %v", err)
+	}
+
+	r := &Renderer{Dst: dst, PreserveXattrs: true, logger: newTestLogger()}
+	stageContent(t, r, "new")
+	if _, err := r.syncFiles(false); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := fileutil.GetXattr(dst, "user.remco")
+	if err != nil || string(value) != "test" {
+		t.Errorf("the xattr should be preserved, got %q (%v)", value, err)
+	}
+}
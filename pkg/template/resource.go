@@ -25,14 +25,14 @@ package template
 import (
 	"context"
 	"fmt"
-	"math/rand"
 	"os"
-	"path"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/HeavyHorst/memkv"
+	"github.com/HeavyHorst/pongo2"
 	berr "github.com/HeavyHorst/remco/pkg/backends/error"
 	"github.com/HeavyHorst/remco/pkg/log"
 	"github.com/armon/go-metrics"
@@ -42,66 +42,441 @@ import (
 
 // Resource is the representation of a parsed template resource.
 type Resource struct {
+	name     string
 	backends []Backend
 	funcMap  map[string]interface{}
 	store    *memkv.Store
 	sources  []*Renderer
 	logger   *logrus.Entry
 
-	exec      Executor
-	startCmd  string
-	reloadCmd string
+	// variables holds the static variables, it is nil if there are none.
+	variables *memkv.Store
+
+	// storeMutex protects the rebuild of store in mergeStores and the updates in mergeChanges.
+	storeMutex sync.RWMutex
+	// revision counts the changes of store, it is protected by storeMutex.
+	revision uint64
+
+	// diff collects the keys that changed in the backend stores since the last merge.
+	diff *storeDiff
+
+	// mergeStrategy resolves the key collisions of the variables and the backend stores.
+	mergeStrategy MergeStrategy
+
+	exec       Executor
+	startCmd   string
+	reloadCmds []string
+
+	// reloadTimeout is the maximum amount of time in seconds every reload command may take, 0 means no limit.
+	reloadTimeout int
+	// reloadWait is the time in seconds to wait between the reload commands.
+	reloadWait int
+
+	// reloadHTTP replaces the reload of the child process if set.
+	reloadHTTP *HTTPReload
+
+	postSyncCmd     string
+	postSyncTimeout int
+
+	parallelBackends bool
+	// requireAllBackends aborts the processing of the templates on the first failed backend.
+	requireAllBackends bool
+	// maxConcurrentBackends bounds the number of concurrent fetches if parallelBackends is true, 0 means no limit.
+	maxConcurrentBackends int
+
+	// parallelTemplates renders the templates concurrently, they are still synced one after another.
+	parallelTemplates bool
+
+	// freshness holds the time of the last successful fetch of every backend (by name).
+	// The templates are rendered with the stale data of a failed backend up to maxStaleAge (0 means no limit).
+	freshnessMutex sync.Mutex
+	freshness      map[string]time.Time
+	maxStaleAge    time.Duration
+	// fetchErrors holds the error of the last fetch of every failed backend (by name), see Status.
+	fetchErrors map[string]string
+
+	// retry is the backoff between the attempts to process the templates for the first time.
+	retry retryBackoff
+
+	// the initial processing of the templates gives up after startupMaxRetries or startupTimeout (0 means unlimited)
+	// and either fails the resource or, if startChildOnFailure is set, starts the child anyway.
+	startupMaxRetries   int
+	startupTimeout      time.Duration
+	startChildOnFailure bool
+
+	// processTimeout bounds a single processing of the templates (0 means unlimited).
+	processTimeout time.Duration
+
+	// waitForBackendsTimeout is the deadline of the probes of the backends before the first attempt
+	// to process the templates (0 disables the probes), they are probed every waitInterval.
+	waitForBackendsTimeout time.Duration
+	waitInterval           time.Duration
+
+	// childEnv holds the env templates of the child process.
+	childEnv     map[string]*pongo2.Template
+	lastChildEnv map[string]string
+	envChange    string
+
+	// healthChecker checks the health of the child process if set.
+	healthChecker *healthChecker
+
+	// reloadDebouncer coalesces (and splays) the reloads of several render cycles if set.
+	reloadDebouncer *reloadDebouncer
+
+	// preStart runs before the child process is started, postStop after it has been stopped.
+	preStart *execHook
+	postStop *execHook
+
+	// renderOnShutdown processes the templates a last time before the child process is stopped.
+	renderOnShutdown bool
+
+	// coalesceWindow is the time the backend changes are collected before they are processed together, 0 disables it.
+	coalesceWindow time.Duration
+
+	// renderLimiter defers the watch-triggered renders to at most one per min_render_interval if set.
+	renderLimiter *renderLimiter
+
+	// spanID is the span ID of the last process call.
+	spanID string
+
+	// history records the most recent attempts to process the templates.
+	history *renderHistory
+
+	// ready is set after the first successful processing of the templates,
+	// healthy once the child process has been started and passed the first health check.
+	ready   *readiness
+	healthy *readiness
+
+	// readinessGateFile is created after the first successful render of Monitor and removed when Monitor exits.
+	readinessGateFile string
+
+	// onChange and onError are notified about the changed files and the failed renders if set.
+	onChange *eventHook
+	onError  *eventHook
+
+	// notifyWebhook receives a WebhookEvent after every render that changed a file if set, it isn't debounced.
+	notifyWebhook *eventHook
+
+	// renderObserver is called with the duration of every template run if set.
+	renderObserver func(s *Renderer, d time.Duration)
+
 	// SignalChan is a channel to send os.Signal's to all child processes.
 	SignalChan chan os.Signal
 
+	// forceRender receives a value when all templates should be processed again, see ForceRender.
+	forceRender chan struct{}
+
+	// paused is 1 while the resource is paused, resume receives a value when it is resumed, see Pause.
+	paused int32
+	resume chan struct{}
+	// held holds the reloads that were due while the resource was paused.
+	held heldReload
+
+	// signalMap translates (or, if mapped to nil, drops) the signals of SignalChan.
+	signalMap map[os.Signal]os.Signal
+
 	// Failed is true if we run Monitor() in exec mode and the child process exits unexpectedly.
 	// If the monitor context is canceled as usual Failed is false.
 	// Failed is used to restart the Resource on failure.
 	Failed bool
+
+	// Changed is true if at least one template has been changed by Monitor.
+	Changed bool
+
+	// Err is the error of the last failed attempt to process the templates for the first time
+	// and nil if they have been processed successfully. FailureOf(Err) classifies it.
+	Err error
 }
 
 // ResourceConfig is a configuration struct to create a new resource.
 type ResourceConfig struct {
-	Exec      ExecConfig
-	StartCmd  string
-	ReloadCmd string
+	Exec     ExecConfig
+	StartCmd string
+
+	// Reload configures the commands that are executed after the templates have been changed.
+	Reload ReloadConfig
 
 	// Template is the configuration for all template options.
 	// You can configure as much template-destination pairs as you like.
 	Template []*Renderer
 
+	// Store configures how the values of the backends are fetched and merged into the store.
+	Store StoreConfig
+
+	// Render configures how and when the templates are processed.
+	Render RenderConfig
+
+	// Retry configures the wait between two attempts to process the templates after a failure.
+	Retry RetryConfig
+
+	// Startup configures the initial processing of the templates.
+	Startup StartupConfig
+
+	// Notify configures the event hooks and the notify webhook.
+	Notify NotifyConfig
+
 	// Name gives the Resource a name.
 	// This name is added to the logs to distinguish between different resources.
 	Name string
 
+	// ConfigFile is the file the resource has been loaded from, it is added to the logs as field config_file if set.
+	ConfigFile string
+
 	// Connectors is a list of BackendConnectors.
 	// The Resource will establish a connection to all of these.
-	Connectors []BackendConnector
+	Connectors BackendConnectors
+}
+
+// ReloadConfig configures the commands that are executed after the templates have been changed.
+type ReloadConfig struct {
+	// Cmd is a list of commands that are executed in order after the templates have been changed.
+	// The sequence is aborted if a command fails.
+	Cmd ReloadCommands
+
+	// Timeout is the maximum amount of time in seconds every reload command may take.
+	// 0 means no limit.
+	Timeout int
+
+	// Wait is the time in seconds to wait between the reload commands.
+	Wait int
+
+	// PostSyncCmd is executed every time at least one template has been changed.
+	// The paths of the changed files are passed as arguments and
+	// in the REMCO_CHANGED_FILES environment variable.
+	PostSyncCmd string
+
+	// PostSyncTimeout is the maximum amount of time in seconds the PostSyncCmd may take.
+	// The default is 30.
+	PostSyncTimeout int
+}
+
+// StoreConfig configures how the values of the backends are fetched and merged into the store.
+type StoreConfig struct {
+	// ParallelBackends enables fetching the values of all backends concurrently.
+	ParallelBackends bool
+
+	// MaxConcurrentBackends is the maximum number of backends fetched concurrently if ParallelBackends is true.
+	// 0 means no limit.
+	MaxConcurrentBackends int
+
+	// RequireAllBackends aborts the processing of the templates if a backend fails.
+	// By default the templates are processed with the data of the other backends and the previous data of the failed ones.
+	RequireAllBackends bool
+
+	// MaxStaleAge is the maximum age in seconds of the data of a failed backend the templates are rendered with.
+	// If a backend fails, the templates are rendered with its last good values and the data of the other backends.
+	// 0 means no limit.
+	MaxStaleAge int
+
+	// Variables are static KV-Pairs that are merged into the store under VariablesPrefix (DefaultVariablesPrefix if empty).
+	// The keys of the backends override them.
+	Variables       map[string]string
+	VariablesPrefix string
+
+	// MergeStrategy resolves the collisions of the keys of the variables and the backends:
+	// last_wins (the default), first_wins, error_on_conflict, longest_value_wins or json_deep_merge, see MergeStrategy.
+	MergeStrategy string
+}
+
+// RenderConfig configures how and when the templates are processed.
+type RenderConfig struct {
+	// ParallelTemplates renders all templates concurrently.
+	// The rendered files are still synced (and the check and reload commands executed) one after another in configuration order.
+	ParallelTemplates bool
+
+	// ProcessTimeout is the maximum time in seconds a single processing of the templates may take.
+	// The processing is canceled with a ProcessTimeoutError after it. 0 means unlimited.
+	ProcessTimeout int
+
+	// CoalesceWindowMs is the time in milliseconds the changes of the backends are collected
+	// before they are processed together (followed by at most one reload). 0 disables it.
+	CoalesceWindowMs int
+
+	// MinRenderInterval is the minimum time in seconds between the end of a render and a watch-triggered render.
+	// The changes during the interval are processed together once it has elapsed. 0 disables it.
+	MinRenderInterval int
+
+	// HistorySize is the number of render events kept for Status. The default is 100.
+	HistorySize int
+
+	// RenderOnShutdown processes the templates a last time when Monitor is canceled,
+	// before the child process is stopped.
+	RenderOnShutdown bool
+
+	// ReadinessGateFile is an empty file that is created after the first successful processing of the templates
+	// (in every run of Monitor) and removed when Monitor exits, e.g. for a kubernetes readiness probe.
+	ReadinessGateFile string
+}
+
+// RetryConfig configures the wait between two attempts to process the templates after a failure.
+type RetryConfig struct {
+	// Min is the wait in seconds before the templates are processed again after the first failure.
+	// The default is 30 (or Max if it is smaller).
+	Min int
+
+	// Max is the maximum wait in seconds between two attempts. The default is Min, but at least 30.
+	Max int
+
+	// Factor is the factor the wait grows by after every failed attempt. The default is 1 (no growth).
+	Factor float64
+
+	// Jitter randomizes the wait: "full" (the default) waits between 0 and the wait,
+	// "equal" between half the wait and the wait and "none" exactly the wait.
+	Jitter string
+}
+
+// StartupConfig configures the initial processing of the templates.
+type StartupConfig struct {
+	// MaxRetries is the maximum number of retries of the initial processing of the templates.
+	// 0 means unlimited.
+	MaxRetries int
+
+	// Timeout is the maximum time in seconds the initial processing of the templates may take (including the retries).
+	// 0 means unlimited.
+	Timeout int
+
+	// StartChildOnFailure starts the child process with the templates rendered so far
+	// if MaxRetries or Timeout is exceeded. The resource fails otherwise.
+	StartChildOnFailure bool
+
+	// WaitForBackends is the maximum time in seconds remco waits for the backends to respond before
+	// the templates are processed for the first time. The backends are probed every second.
+	// After the deadline the templates are processed (and retried) anyway. 0 disables the wait.
+	WaitForBackends int
+}
+
+// NotifyConfig configures the event hooks and the notify webhook.
+type NotifyConfig struct {
+	// OnChange is notified after at least one file has been changed.
+	OnChange *EventHook
+
+	// OnError is notified after the templates couldn't be processed.
+	OnError *EventHook
+
+	// WebhookURL receives a POST request with a WebhookEvent after a render has changed at least one file.
+	WebhookURL string
+
+	// WebhookHeaders are added to the requests of the notify webhook.
+	WebhookHeaders map[string]string
+
+	// WebhookTimeout is the maximum amount of time in seconds the notify webhook may take. The default is 10.
+	WebhookTimeout int
 }
 
 // ErrEmptySrc is returned if an emty src template is passed to NewResource
 var ErrEmptySrc = fmt.Errorf("empty src template")
 
+// ErrConflictingNewlineOptions is returned if a template sets both ensure_trailing_newline and strip_trailing_newlines
+var ErrConflictingNewlineOptions = fmt.Errorf("ensure_trailing_newline and strip_trailing_newlines are mutually exclusive")
+
 // NewResourceFromResourceConfig creates a new resource from the given ResourceConfig.
+// The configuration is checked before the backends are connected.
 func NewResourceFromResourceConfig(ctx context.Context, reapLock *sync.RWMutex, r ResourceConfig) (*Resource, error) {
+	if errs := r.Validate(); len(errs) > 0 {
+		return nil, ValidationError{Resource: r.Name, Errors: errs}
+	}
+
+	fields := logrus.Fields{"resource": r.Name}
+	if r.ConfigFile != "" {
+		fields["config_file"] = r.ConfigFile
+	}
+	logger := log.WithFields(fields)
+
+	exec, err := r.Exec.executor(logger)
+	if err != nil {
+		return nil, withFailure(FailureConfig, err)
+	}
+	childEnv, err := r.Exec.childEnv()
+	if err != nil {
+		return nil, withFailure(FailureConfig, err)
+	}
+	healthChecker, err := r.Exec.healthChecker()
+	if err != nil {
+		return nil, withFailure(FailureConfig, err)
+	}
+	signalMap, err := r.Exec.signalMap()
+	if err != nil {
+		return nil, withFailure(FailureConfig, err)
+	}
+	retry, err := r.Retry.backoff()
+	if err != nil {
+		return nil, withFailure(FailureConfig, err)
+	}
+	mergeStrategy, err := NewMergeStrategy(r.Store.MergeStrategy)
+	if err != nil {
+		return nil, withFailure(FailureConfig, err)
+	}
+
 	backendList, err := connectAllBackends(ctx, r.Connectors)
 	if err != nil {
-		return nil, errors.Wrap(err, "connectAllBackends failed")
+		return nil, withFailure(FailureBackend, errors.Wrap(err, "connectAllBackends failed"))
 	}
 
 	for _, p := range r.Template {
 		p.ReapLock = reapLock
 	}
-
-	logger := log.WithFields(logrus.Fields{"resource": r.Name})
-	exec := NewExecutor(r.Exec.Command, r.Exec.ReloadSignal, r.Exec.KillSignal, r.Exec.KillTimeout, r.Exec.Splay, logger)
-	res, err := NewResource(backendList, r.Template, r.Name, exec, r.StartCmd, r.ReloadCmd)
+	res, err := NewResource(backendList, r.Template, r.Name, exec, r.StartCmd, "")
 	if err != nil {
 		for _, v := range backendList {
 			v.Close()
 		}
+		return nil, withFailure(FailureConfig, err)
+	}
+	res.logger = logger
+	for _, v := range res.sources {
+		v.logger = logger
 	}
-	return res, err
+	res.childEnv = childEnv
+	res.healthChecker = healthChecker
+	res.signalMap = signalMap
+	res.retry = retry
+	res.mergeStrategy = mergeStrategy
+	res.preStart = newExecHook("pre start cmd", r.Exec.PreStartCmd, r.Exec.PreStartTimeout)
+	res.postStop = newExecHook("post stop cmd", r.Exec.PostStopCmd, r.Exec.PostStopTimeout)
+	res.envChange = r.Exec.EnvChange
+	res.reloadHTTP = r.Exec.ReloadHTTP
+	if r.Exec.ReloadDebounce > 0 || r.Exec.ReloadSplay > 0 {
+		res.reloadDebouncer = newReloadDebouncer(
+			time.Duration(r.Exec.ReloadDebounce)*time.Second,
+			time.Duration(r.Exec.ReloadDebounceMax)*time.Second,
+			time.Duration(r.Exec.ReloadSplay)*time.Second,
+		)
+	}
+
+	res.reloadCmds = r.Reload.Cmd
+	res.reloadTimeout = r.Reload.Timeout
+	res.reloadWait = r.Reload.Wait
+	res.postSyncCmd = r.Reload.PostSyncCmd
+	res.postSyncTimeout = r.Reload.PostSyncTimeout
+
+	res.parallelBackends = r.Store.ParallelBackends
+	res.maxConcurrentBackends = r.Store.MaxConcurrentBackends
+	res.requireAllBackends = r.Store.RequireAllBackends
+	res.maxStaleAge = time.Duration(r.Store.MaxStaleAge) * time.Second
+	res.variables = variablesStore(r.Store.VariablesPrefix, r.Store.Variables)
+
+	res.parallelTemplates = r.Render.ParallelTemplates
+	res.processTimeout = time.Duration(r.Render.ProcessTimeout) * time.Second
+	res.coalesceWindow = time.Duration(r.Render.CoalesceWindowMs) * time.Millisecond
+	if r.Render.MinRenderInterval > 0 {
+		res.renderLimiter = newRenderLimiter(time.Duration(r.Render.MinRenderInterval) * time.Second)
+	}
+	res.history = newRenderHistory(r.Render.HistorySize)
+	res.renderOnShutdown = r.Render.RenderOnShutdown
+	res.readinessGateFile = r.Render.ReadinessGateFile
+
+	res.startupMaxRetries = r.Startup.MaxRetries
+	res.startupTimeout = time.Duration(r.Startup.Timeout) * time.Second
+	res.startChildOnFailure = r.Startup.StartChildOnFailure
+	res.waitForBackendsTimeout = time.Duration(r.Startup.WaitForBackends) * time.Second
+
+	// the events are debounced like the reloads
+	quiet := time.Duration(r.Exec.ReloadDebounce) * time.Second
+	maxDelay := time.Duration(r.Exec.ReloadDebounceMax) * time.Second
+	res.onChange = newEventHook("on_change", r.Notify.OnChange, quiet, maxDelay)
+	res.onError = newEventHook("on_error", r.Notify.OnError, quiet, maxDelay)
+	res.notifyWebhook = newEventHook("notify_webhook", notifyWebhook(r.Notify.WebhookURL, r.Notify.WebhookHeaders, r.Notify.WebhookTimeout), 0, 0)
+	return res, nil
 }
 
 // NewResource creates a Resource.
@@ -113,22 +488,39 @@ func NewResource(backends []Backend, sources []*Renderer, name string, exec Exec
 	logger := log.WithFields(logrus.Fields{"resource": name})
 
 	for _, v := range sources {
-		if v.Src == "" {
-			return nil, ErrEmptySrc
+		if err := v.validate(); err != nil {
+			return nil, err
 		}
 		v.logger = logger
 	}
 
 	tr := &Resource{
-		backends:   backends,
-		store:      memkv.New(),
-		funcMap:    newFuncMap(),
-		sources:    sources,
-		logger:     logger,
-		SignalChan: make(chan os.Signal, 1),
-		exec:       exec,
-		startCmd:   startCmd,
-		reloadCmd:  reloadCmd,
+		name:          name,
+		backends:      backends,
+		store:         memkv.New(),
+		diff:          newStoreDiff(),
+		mergeStrategy: lastWins{},
+		funcMap:       newFuncMap(),
+		sources:       sources,
+		logger:        logger,
+		SignalChan:    make(chan os.Signal, 1),
+		forceRender:   make(chan struct{}, 1),
+		resume:        make(chan struct{}, 1),
+		exec:          exec,
+		startCmd:      startCmd,
+		freshness:     make(map[string]time.Time),
+		fetchErrors:   make(map[string]string),
+		retry:         defaultRetryBackoff(),
+		waitInterval:  defaultWaitForBackendsInterval,
+		history:       newRenderHistory(defaultHistorySize),
+		ready:         newReadiness(),
+		healthy:       newReadiness(),
+	}
+
+	tr.exec.resource = name
+
+	if reloadCmd != "" {
+		tr.reloadCmds = []string{reloadCmd}
 	}
 
 	// initialize the inidividual backend memkv Stores
@@ -136,13 +528,42 @@ func NewResource(backends []Backend, sources []*Renderer, name string, exec Exec
 		store := memkv.New()
 		tr.backends[i].store = store
 
-		if tr.backends[i].Interval <= 0 && !tr.backends[i].Onetime && !tr.backends[i].Watch {
+		if tr.backends[i].Schedule != "" {
+			sched, err := ParseSchedule(tr.backends[i].Schedule)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid schedule of backend %s", tr.backends[i].Name)
+			}
+			tr.backends[i].cron = sched
+		}
+
+		if tr.backends[i].Interval <= 0 && tr.backends[i].cron == nil && !tr.backends[i].Onetime && !tr.backends[i].Watch {
 			logger.Warning("interval needs to be > 0: setting interval to 60")
 			tr.backends[i].Interval = 60
 		}
+
+		if tr.backends[i].Timeout <= 0 {
+			tr.backends[i].Timeout = 30
+		}
 	}
 
 	addFuncs(tr.funcMap, tr.store.FuncMap)
+	addFuncs(tr.funcMap, newStoreFuncMap(tr.store))
+
+	var sensitive bool
+	for _, b := range tr.backends {
+		sensitive = sensitive || b.Sensitive
+	}
+	annotateFuncs(tr.funcMap, sensitive)
+
+	for _, v := range sources {
+		v.funcMap = tr.funcMap
+		v.revision = tr.storeRevision
+		v.templates = newTemplateCache()
+		// the templates are parsed eagerly, a parse error is reported by the first render
+		if v.isTemplate() {
+			v.parse(v.Src)
+		}
+	}
 
 	return tr, nil
 }
@@ -159,95 +580,656 @@ func (t *Resource) Close() {
 
 // setVars reads all KV-Pairs for the backend
 // and writes these pairs to the individual (per backend) memkv store.
-// After that, the instance wide memkv store gets purged and is recreated with all individual
-// memkv KV-Pairs.
-// Key collisions are logged.
+// After that, the changed KV-Pairs are merged into the instance wide memkv store.
+// Key collisions are logged and resolved with the merge strategy.
 // It returns an error if any.
-func (t *Resource) setVars(storeClient Backend) error {
-	var err error
+func (t *Resource) setVars(ctx context.Context, storeClient Backend) error {
+	if err := t.fetchVars(ctx, storeClient); err != nil {
+		return err
+	}
+	return t.mergeChanges(t.loggerFrom(ctx))
+}
 
-	t.logger.WithFields(logrus.Fields{
+// fetchVars reads all KV-Pairs for the backend
+// and updates the individual (per backend) memkv store with these pairs.
+// It fails without updating the store if the backend returned more than MaxKeys keys.
+// The changed keys are recorded for the next mergeChanges.
+// It returns an error if any.
+func (t *Resource) fetchVars(ctx context.Context, storeClient Backend) error {
+	logger := t.loggerFrom(ctx)
+	logger.WithFields(logrus.Fields{
 		"backend":    storeClient.Name,
 		"key_prefix": storeClient.Prefix,
 	}).Debug("retrieving keys")
 
-	result, err := storeClient.GetValues(appendPrefix(storeClient.Prefix, storeClient.Keys))
+	result, err := storeClient.getValues(ctx, appendPrefix(storeClient.Prefix, storeClient.Keys))
 	if err != nil {
 		return errors.Wrap(err, "getValues failed")
 	}
 
-	storeClient.store.Purge()
+	logger.WithFields(logrus.Fields{
+		"backend":    storeClient.Name,
+		"key_prefix": storeClient.Prefix,
+		"key_count":  len(result),
+	}).Debug("keys retrieved")
+	if storeClient.MaxKeys > 0 && len(result) > storeClient.MaxKeys {
+		return fmt.Errorf("backend %s returned %d keys, more than max_keys (%d)", storeClient.Name, len(result), storeClient.MaxKeys)
+	}
 
+	kvs := make(map[string]string, len(result))
 	for key, value := range result {
-		storeClient.store.Set(path.Join("/", strings.TrimPrefix(key, storeClient.Prefix)), value)
+		kvs[stripPrefix(storeClient.Prefix, key)] = value
+	}
+	var old map[string]string
+	if storeClient.OnChange != nil {
+		old = storeValues(storeClient.store)
 	}
+	changed := updateStore(storeClient.store, kvs)
+	t.diff.add(changed)
+	metrics.SetGaugeWithLabels([]string{"backend", "key_count"}, float32(len(kvs)), []metrics.Label{
+		{Name: "resource", Value: t.name},
+		{Name: "backend", Value: storeClient.Name},
+	})
+	if storeClient.OnChange != nil {
+		sort.Strings(changed)
+		for _, key := range changed {
+			storeClient.OnChange(key, old[key], kvs[key])
+		}
+	}
+	t.markFresh(storeClient.Name)
 
-	//merge all stores
-	t.store.Purge()
-	for _, v := range t.backends {
-		for _, kv := range v.store.GetAllKVs() {
-			if t.store.Exists(kv.Key) {
-				t.logger.Warning("key collision - " + kv.Key)
+	return nil
+}
+
+// mergeStores purges the instance wide memkv store and recreates it
+// with the static variables and the KV-Pairs of all individual backend stores.
+// Key collisions are logged to logger and resolved with the merge strategy of the resource.
+// It returns an error if the merge strategy rejects a collision, the store is left unchanged
+// and the next merge rebuilds it then.
+func (t *Resource) mergeStores(logger *logrus.Entry) error {
+	t.storeMutex.Lock()
+	defer t.storeMutex.Unlock()
+
+	// the store is only replaced if all collisions are resolved
+	result := make(map[string]string)
+	var merged int
+	for _, store := range t.sourceStores() {
+		for _, kv := range store.GetAllKVs() {
+			cur, ok := result[kv.Key]
+			if !ok {
+				result[kv.Key] = kv.Value
+				merged++
+				continue
+			}
+			value, err := t.resolveCollision(logger, kv.Key, cur, kv.Value)
+			if err != nil {
+				t.diff.reset()
+				return err
 			}
-			t.store.Set(kv.Key, kv.Value)
+			result[kv.Key] = value
+		}
+	}
+
+	if !equalValues(storeValues(t.store), result) {
+		t.store.Purge()
+		for k, v := range result {
+			t.store.Set(k, v)
 		}
+		t.revision++
 	}
 
+	logger.WithFields(logrus.Fields{
+		"key_count": merged,
+	}).Debug("backend stores merged")
 	return nil
 }
 
-func (t *Resource) createStageFileAndSync(runCommands bool) (bool, error) {
-	var changed bool
+// storeRevision returns the revision of the resource store, it changes whenever a merge changes the store.
+func (t *Resource) storeRevision() uint64 {
+	t.storeMutex.RLock()
+	defer t.storeMutex.RUnlock()
+	return t.revision
+}
+
+// Snapshot returns a copy of all KV-Pairs currently in the resource store.
+// The copy is taken under the lock that protects the store rebuild, so it never contains
+// a partially merged state. Map iteration order is random, callers that need a
+// deterministic output have to sort the keys.
+func (t *Resource) Snapshot() map[string]string {
+	t.storeMutex.RLock()
+	defer t.storeMutex.RUnlock()
+
+	kvs := t.store.GetAllKVs()
+	snapshot := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		snapshot[kv.Key] = kv.Value
+	}
+	return snapshot
+}
+
+// fetchBackend calls fetchVars for the backend and records the backend metrics.
+// It returns a berr.TimeoutError or a berr.BackendError on failure,
+// unless the stale data of the backend is used (see useStaleData).
+func (t *Resource) fetchBackend(ctx context.Context, storeClient Backend) error {
+	labels := []metrics.Label{{Name: "name", Value: storeClient.Name}}
+	if err := t.fetchVars(ctx, storeClient); err != nil {
+		metrics.IncrCounterWithLabels([]string{"backends", "sync_errors_total"}, 1, labels)
+		t.markFailed(storeClient.Name, err)
+		if terr, ok := errors.Cause(err).(berr.TimeoutError); ok {
+			return t.useStaleData(ctx, storeClient, terr)
+		}
+		return t.useStaleData(ctx, storeClient, berr.BackendError{
+			Message: errors.Wrap(err, "setVars failed").Error(),
+			Backend: storeClient.Name,
+		})
+	}
+	metrics.IncrCounterWithLabels([]string{"backends", "synced_total"}, 1, labels)
+	return nil
+}
+
+// fetchBackends fetches the KV-Pairs of all given backends.
+// The backends are fetched concurrently (at most maxConcurrentBackends at a time) if parallelBackends is true.
+// All backends are fetched even if some of them fail and the errors of all failed backends are returned (see joinBackendErrors).
+// If requireAllBackends is set, the remaining fetches are canceled on the first error and only the first error is returned.
+func (t *Resource) fetchBackends(ctx context.Context, storeClients []Backend) error {
+	if !t.parallelBackends || len(storeClients) < 2 {
+		var errs []error
+		for _, storeClient := range storeClients {
+			if err := t.fetchBackend(ctx, storeClient); err != nil {
+				if t.requireAllBackends || ctx.Err() != nil {
+					return err
+				}
+				errs = append(errs, err)
+			}
+		}
+		return joinBackendErrors(errs)
+	}
+
+	parentCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var firstErr error
+	var errOnce sync.Once
+	errs := make([]error, len(storeClients))
+	var sem chan struct{}
+	if t.maxConcurrentBackends > 0 {
+		sem = make(chan struct{}, t.maxConcurrentBackends)
+	}
+	var skipped bool
+	wg := sync.WaitGroup{}
+fetch:
+	for i, storeClient := range storeClients {
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				// the remaining backends aren't fetched after the first error
+				skipped = true
+				break fetch
+			}
+		}
+		wg.Add(1)
+		go func(i int, s Backend) {
+			defer wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			if err := t.fetchBackend(ctx, s); err != nil {
+				errs[i] = err
+				if t.requireAllBackends {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}(i, storeClient)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	if parentCtx.Err() != nil || skipped {
+		// the parent context was canceled
+		return ctx.Err()
+	}
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return joinBackendErrors(failed)
+}
+
+// createStageFileAndSync renders and syncs all templates.
+// The template functions can access the RenderContext of ctx.
+// It returns the destination paths of all changed templates and an error if any.
+func (t *Resource) createStageFileAndSync(ctx context.Context, runCommands bool) ([]string, error) {
+	if t.parallelTemplates && len(t.sources) > 1 {
+		return t.createStageFilesParallel(ctx, runCommands)
+	}
+	var changed []string
 	for _, s := range t.sources {
-		err := s.createStageFile(t.funcMap)
+		start := time.Now()
+		c, err := t.syncSource(ctx, s, runCommands)
+		if t.renderObserver != nil {
+			t.renderObserver(s, time.Since(start))
+		}
+		changed = append(changed, c...)
+		if err != nil {
+			return changed, err
+		}
+	}
+	return changed, nil
+}
+
+// createStageFilesParallel renders all templates concurrently and syncs them one after another in configuration order,
+// so that the check and reload commands never run concurrently.
+// Like in the sequential mode, the templates after a failed one aren't synced, their stage files are removed.
+// It returns the destination paths of all changed templates and an error if any.
+func (t *Resource) createStageFilesParallel(ctx context.Context, runCommands bool) ([]string, error) {
+	type staged struct {
+		render   bool
+		err      error
+		duration time.Duration
+	}
+	results := make([]staged, len(t.sources))
+
+	var wg sync.WaitGroup
+	for i, s := range t.sources {
+		wg.Add(1)
+		go func(i int, s *Renderer) {
+			defer wg.Done()
+			start := time.Now()
+			render, err := t.stageSource(ctx, s)
+			results[i] = staged{render, err, time.Since(start)}
+		}(i, s)
+	}
+	wg.Wait()
+
+	var changed []string
+	for i, s := range t.sources {
+		start := time.Now()
+		var c []string
+		err := results[i].err
+		if err == nil {
+			c, err = t.syncStaged(ctx, s, results[i].render, runCommands)
+		}
+		if t.renderObserver != nil {
+			t.renderObserver(s, results[i].duration+time.Since(start))
+		}
+		changed = append(changed, c...)
 		if err != nil {
-			metrics.IncrCounter([]string{"files", "stage_errors_total"}, 1)
-			return changed, errors.Wrap(err, "create stage file failed")
+			for j, rest := range t.sources[i+1:] {
+				if r := results[i+1+j]; r.err == nil && r.render && rest.Iterate == "" {
+					os.Remove(rest.stageFile.Name())
+				}
+			}
+			return changed, err
 		}
-		metrics.IncrCounter([]string{"files", "staged_total"}, 1)
-		c, err := s.syncFiles(runCommands)
-		changed = changed || c
+	}
+	return changed, nil
+}
+
+// syncSource renders and syncs a single template.
+// It returns the destination paths of the changed files and an error if any.
+func (t *Resource) syncSource(ctx context.Context, s *Renderer, runCommands bool) ([]string, error) {
+	render, err := t.stageSource(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	return t.syncStaged(ctx, s, render, runCommands)
+}
+
+// stageSource evaluates the condition of a template and creates its stage file.
+// It returns false if the template is skipped. Fan-out templates are rendered by syncStaged.
+func (t *Resource) stageSource(ctx context.Context, s *Renderer) (bool, error) {
+	ok, err := s.shouldRender(withRenderContextFuncs(ctx, t.funcMap), t.store)
+	if err != nil {
+		return false, withFailure(FailureRender, errors.Wrapf(err, "evaluating the condition for %s failed", s.Dst))
+	}
+	if !ok || s.Iterate != "" {
+		return ok, nil
+	}
+	if err := s.createStageFile(ctx, t.funcMap); err != nil {
+		metrics.IncrCounter([]string{"files", "stage_errors_total"}, 1)
+		return false, errors.Wrapf(err, "create stage file for %s failed", s.Dst)
+	}
+	metrics.IncrCounter([]string{"files", "staged_total"}, 1)
+	return true, nil
+}
+
+// syncStaged syncs a template staged by stageSource, a skipped template (render is false) is removed if configured.
+// It returns the destination paths of the changed files and an error if any.
+func (t *Resource) syncStaged(ctx context.Context, s *Renderer, render bool, runCommands bool) ([]string, error) {
+	if !render {
+		removed, err := s.skip()
+		if err != nil {
+			return removed, errors.Wrap(err, "removing skipped template failed")
+		}
+		return removed, nil
+	}
+
+	if s.Iterate != "" {
+		c, err := s.fanOut(ctx, t.funcMap, t.store, runCommands)
 		if err != nil {
 			metrics.IncrCounter([]string{"files", "sync_errors_total"}, 1)
-			return changed, errors.Wrap(err, "sync files failed")
+			return c, errors.Wrap(err, "fan-out failed")
 		}
 		metrics.IncrCounter([]string{"files", "synced_total"}, 1)
+		return c, nil
 	}
+
+	var changed []string
+	c, err := s.syncFiles(runCommands)
+	if c {
+		changed = append(changed, s.Dst)
+	}
+	if err != nil {
+		metrics.IncrCounter([]string{"files", "sync_errors_total"}, 1)
+		return changed, errors.Wrap(err, "sync files failed")
+	}
+	metrics.IncrCounter([]string{"files", "synced_total"}, 1)
 	return changed, nil
 }
 
+// onExit runs the on_exit action of all templates.
+// Errors are logged but don't abort the cleanup of the remaining templates.
+func (t *Resource) onExit() {
+	for _, s := range t.sources {
+		if err := s.onExit(t.funcMap); err != nil {
+			t.logger.WithFields(logrus.Fields{
+				"config": s.Dst,
+			}).Error(errors.Wrap(err, "on_exit failed"))
+		}
+	}
+}
+
 // Process is a convenience function that wraps calls to the three main tasks
 // required to keep local configuration files in sync. First we gather vars
 // from the store, then we stage a candidate configuration file, and finally sync
 // things up.
-// It returns an error if any.
-func (t *Resource) process(storeClients []Backend, runCommands bool) (bool, error) {
-	var changed bool
-	var err error
-	for _, storeClient := range storeClients {
-		labels := []metrics.Label{{Name: "name", Value: storeClient.Name}}
-		if err = t.setVars(storeClient); err != nil {
-			metrics.IncrCounterWithLabels([]string{"backends", "sync_errors_total"}, 1, labels)
-			return changed, berr.BackendError{
-				Message: errors.Wrap(err, "setVars failed").Error(),
-				Backend: storeClient.Name,
-			}
+// All log entries of a call carry a new span ID (span_id), which is also kept in spanID.
+// The span ID and the name of the resource are added to the RenderContext of ctx.
+// It returns the destination paths of all changed templates and an error if any.
+func (t *Resource) process(ctx context.Context, storeClients []Backend, runCommands bool) ([]string, error) {
+	t.spanID = newSpanID()
+	logger := t.logger.WithField("span_id", t.spanID)
+	ctx = withSpanLogger(ctx, logger)
+	rc := renderContextFrom(ctx)
+	rc.Resource = t.name
+	rc.SpanID = t.spanID
+	ctx = withRenderContext(ctx, rc)
+	t.setRendererLogger(logger)
+	defer t.setRendererLogger(t.logger)
+
+	var changed []string
+	fetchErr := t.fetchBackends(ctx, storeClients)
+	// merge the stores even on failure, the successfully fetched backends hold new data
+	mergeErr := t.mergeChanges(logger)
+	if fetchErr != nil && (t.requireAllBackends || ctx.Err() != nil) {
+		return changed, fetchErr
+	}
+	if mergeErr != nil {
+		return changed, mergeErr
+	}
+	// the templates are processed with the data of the other backends and the previous data of the failed ones
+	changed, err := t.createStageFileAndSync(ctx, runCommands)
+	if runCommands && len(changed) > 0 {
+		t.postSync(logger, changed)
+	}
+	if fetchErr != nil {
+		if err != nil {
+			logger.Error(errors.Wrap(err, "createStageFileAndSync failed"))
 		}
-		metrics.IncrCounterWithLabels([]string{"backends", "synced_total"}, 1, labels)
+		return changed, fetchErr
 	}
-	if changed, err = t.createStageFileAndSync(runCommands); err != nil {
+	if err != nil {
 		return changed, errors.Wrap(err, "createStageFileAndSync failed")
 	}
 	return changed, nil
 }
 
+// ProcessTimeoutError is returned if the processing of the templates of a resource takes longer than its process timeout.
+type ProcessTimeoutError struct {
+	Resource string
+	Timeout  time.Duration
+}
+
+func (e ProcessTimeoutError) Error() string {
+	return fmt.Sprintf("processing the templates of %s timed out after %s", e.Resource, e.Timeout)
+}
+
+// processWithTimeout calls process with a context that is canceled after the process timeout,
+// so that a slow backend can't block the resource indefinitely.
+// It returns a ProcessTimeoutError if the timeout is exceeded.
+func (t *Resource) processWithTimeout(ctx context.Context, storeClients []Backend, runCommands bool) ([]string, error) {
+	defer func(start time.Time) {
+		observeSeconds(processDurationKey, t.name, time.Since(start))
+	}(time.Now())
+	if t.processTimeout <= 0 {
+		return t.process(ctx, storeClients, runCommands)
+	}
+	pctx, cancel := context.WithTimeout(ctx, t.processTimeout)
+	defer cancel()
+	changed, err := t.process(pctx, storeClients, runCommands)
+	if err != nil && ctx.Err() == nil && pctx.Err() == context.DeadlineExceeded {
+		return changed, ProcessTimeoutError{Resource: t.name, Timeout: t.processTimeout}
+	}
+	return changed, err
+}
+
+// postSync executes the post sync command with the paths of the changed files.
+// The paths are passed as arguments and in the REMCO_CHANGED_FILES environment variable (separated by newlines).
+// Errors are logged to logger but don't affect the resource.
+func (t *Resource) postSync(logger *logrus.Entry, changed []string) {
+	if t.postSyncCmd == "" {
+		return
+	}
+
+	timeout := time.Duration(t.postSyncTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	env := append(os.Environ(), "REMCO_CHANGED_FILES="+strings.Join(changed, "\n"))
+	output, err := execCommandContext(ctx, t.postSyncCmd, changed, env, logger, nil)
+	logger = logger.WithFields(logrus.Fields{
+		"command": t.postSyncCmd,
+		"changed": changed,
+	})
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		logger.Error(fmt.Sprintf("failed to execute the post sync cmd: %v - %q", err, string(output)))
+		return
+	}
+	logger.Debug(fmt.Sprintf("%q", string(output)))
+}
+
+// reloadChild reloads the child process with the reload request if configured
+// and with the reload signal (or by restarting it) otherwise.
+func (t *Resource) reloadChild(ctx context.Context, changed []string) error {
+	if t.reloadHTTP == nil {
+		return t.exec.Reload()
+	}
+	if err := t.reloadHTTP.do(ctx, t.funcMap, map[string]string{"dst": strings.Join(changed, " ")}); err != nil {
+		return errors.Wrap(err, "the reload request failed")
+	}
+	return nil
+}
+
+// reload reloads the child process (if reloadChild is true) and runs the resource reload commands
+// after the templates have been changed.
+func (t *Resource) reload(ctx context.Context, changed []string, reloadChild bool) {
+	if reloadChild {
+		if err := t.reloadChild(ctx, changed); err != nil {
+			t.logger.Error(err)
+		}
+	}
+	t.runReloadCommands(ctx)
+}
+
+// scheduleReload applies the changes of a render cycle immediately or, if the reload is debounced,
+// adds them to the pending reload.
+func (t *Resource) scheduleReload(ctx context.Context, changed []string, envChanged bool) {
+	if t.reloadDebouncer == nil {
+		t.applyChanges(ctx, changed, envChanged)
+		return
+	}
+	if splay := t.reloadDebouncer.add(changed, envChanged); splay > 0 {
+		t.logger.Debug(fmt.Sprintf("delaying the reload by a random splay of %s", splay.Round(time.Millisecond)))
+		observeSeconds(reloadSplayKey, t.name, splay)
+	}
+}
+
+// reloadDue returns the channel that receives a value when the pending debounced reload is due.
+func (t *Resource) reloadDue() <-chan time.Time {
+	if t.reloadDebouncer == nil {
+		return nil
+	}
+	return t.reloadDebouncer.C()
+}
+
+// takePendingReload returns and clears the changes of the pending debounced reload.
+func (t *Resource) takePendingReload() (changed []string, envChanged bool, pending bool) {
+	if t.reloadDebouncer == nil {
+		return nil, false, false
+	}
+	return t.reloadDebouncer.take()
+}
+
+// renderFinal processes the templates a last time on shutdown,
+// so that the child process is stopped with the most recent configuration.
+// A pending debounced reload is merged into the final reload.
+func (t *Resource) renderFinal(childSpawned bool) {
+	t.logger.Info("rendering the templates before shutdown")
+	// the monitor context is already canceled
+	ctx := context.Background()
+	changed, err := t.processWithTimeout(withRenderContext(ctx, RenderContext{Trigger: TriggerShutdown}), t.backends, true)
+	t.Changed = t.Changed || len(changed) > 0
+	t.recordRender(TriggerShutdown, "", changed, err)
+	if err != nil {
+		t.logger.Error(errors.Wrap(err, "rendering the templates before shutdown failed"))
+	}
+	pending, _, _ := t.takePendingReload()
+	for _, c := range pending {
+		if !containsString(changed, c) {
+			changed = append(changed, c)
+		}
+	}
+	if len(changed) > 0 {
+		t.reload(ctx, changed, childSpawned)
+	}
+}
+
+// ForceRender makes the running Monitor process the templates with the values of all backends,
+// as if all of them had changed. The child process and the reload commands are only reloaded
+// if a file changed. It doesn't block, a render that is already requested isn't requested twice.
+func (t *Resource) ForceRender() {
+	select {
+	case t.forceRender <- struct{}{}:
+	default:
+	}
+}
+
+// processChanges processes the templates after the given backends changed
+// and schedules the reload of the child process and the reload commands.
+func (t *Resource) processChanges(ctx context.Context, trigger string, backends []Backend) {
+	rctx := withRenderContext(ctx, RenderContext{Trigger: trigger, Backend: backendNames(backends)})
+	changed, err := t.processWithTimeout(rctx, backends, true)
+	if t.renderLimiter != nil {
+		t.renderLimiter.rendered()
+	}
+	t.Changed = t.Changed || len(changed) > 0
+	t.recordRender(trigger, backendNames(backends), changed, err)
+	if err != nil {
+		logProcessError(t.logger.WithField("span_id", t.spanID), err)
+		// the templates have been processed without the failed backends, the changed files are reloaded anyway
+		if t.requireAllBackends || FailureOf(err) != FailureBackend || len(changed) == 0 {
+			return
+		}
+	}
+	envChanged, err := t.updateChildEnv()
+	if err != nil {
+		t.logger.Error(err)
+	}
+	t.scheduleReload(ctx, changed, envChanged)
+}
+
+// renderDue returns the channel of the deferred render, it is nil (blocks forever) if renders aren't limited.
+func (t *Resource) renderDue() <-chan time.Time {
+	if t.renderLimiter == nil {
+		return nil
+	}
+	return t.renderLimiter.C()
+}
+
+// Onetime reports whether all backends of the resource are configured with onetime,
+// i.e. whether the templates are processed only once.
+func (t *Resource) Onetime() bool {
+	for _, b := range t.backends {
+		if !b.Onetime {
+			return false
+		}
+	}
+	return true
+}
+
+// giveUpStartup gives up the initial processing of the templates after the given number of failed attempts.
+// It returns true if the child is started anyway (startChildOnFailure), the resource is marked as failed otherwise.
+func (t *Resource) giveUpStartup(reason string, attempts int) bool {
+	logger := t.logger.WithField("attempts", attempts)
+	if t.startChildOnFailure {
+		logger.Warning(reason + " - starting the child with the templates rendered so far")
+		return true
+	}
+	logger.Error(reason + " - the resource failed")
+	t.Failed = true
+	return false
+}
+
 // Monitor will start to monitor all given Backends for changes.
 // It accepts a ctx.Context for cancelation.
 // It will process all given tamplates on changes.
 func (t *Resource) Monitor(ctx context.Context) {
 	t.Failed = false
+	t.Err = nil
 	wg := &sync.WaitGroup{}
 
+	parentCtx := ctx
+	var childSpawned bool
+	defer func() {
+		t.removeReadinessGate()
+		// clean up the destination files if remco is shutting down (the parent context was canceled).
+		// this happens before the child process is stopped.
+		if parentCtx.Err() != nil && !t.Failed {
+			if t.renderOnShutdown && !t.Paused() {
+				t.renderFinal(childSpawned)
+			}
+			t.onExit()
+		}
+		// a pending debounced reload is dropped, the child is stopped anyway
+		if _, _, pending := t.takePendingReload(); pending {
+			t.logger.Info("dropping the pending reload")
+		}
+		if childSpawned {
+			t.exec.StopChild()
+			if t.postStop != nil {
+				if err := t.runHook(context.Background(), t.postStop); err != nil {
+					t.logger.Error(err)
+				}
+			}
+		}
+		// the pending events are sent right away
+		t.flushAndWaitHooks()
+	}()
+
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -255,43 +1237,109 @@ func (t *Resource) Monitor(ctx context.Context) {
 	defer close(processChan)
 	errChan := make(chan berr.BackendError, 10)
 
+	// wait until the backends respond, the retries below take over after the deadline
+	t.waitForBackends(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+
 	// try to process the template resource with all given backends
-	// we wait a random amount of time (between 0 - 30 seconds)
+	// we wait according to the retry backoff (by default a random amount of time between 0 - 30 seconds)
 	// to prevent ddossing our backends and try again (with all backends - no stale data)
 	retryChan := make(chan struct{}, 1)
 	retryChan <- struct{}{}
+	var attempt int
+	// postponed is set if an attempt was due while the resource was paused
+	var postponed bool
+	var startupDeadline <-chan time.Time
+	// startupCtx cancels an attempt that is still running when the startup timeout is exceeded
+	startupCtx := ctx
+	if t.startupTimeout > 0 {
+		timer := time.NewTimer(t.startupTimeout)
+		defer timer.Stop()
+		startupDeadline = timer.C
+		var cancelStartup context.CancelFunc
+		startupCtx, cancelStartup = context.WithTimeout(ctx, t.startupTimeout)
+		defer cancelStartup()
+	}
 retryloop:
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-startupDeadline:
+			if !t.giveUpStartup(fmt.Sprintf("startup_timeout (%s) exceeded", t.startupTimeout), attempt) {
+				return
+			}
+			break retryloop
+		case <-t.onChange.due():
+			t.flushHook(t.onChange)
+		case <-t.onError.due():
+			t.flushHook(t.onError)
+		case <-t.resume:
+			if postponed {
+				postponed = false
+				retryChan <- struct{}{}
+			}
 		case <-retryChan:
-			if _, err := t.process(t.backends, t.startCmd == ""); err != nil {
-				switch err := err.(type) {
-				case berr.BackendError:
-					t.logger.WithFields(logrus.Fields{
-						"backend": err.Backend,
-					}).Error(err)
-				default:
-					t.logger.Error(err)
+			if t.Paused() {
+				t.logger.Info("the resource is paused, postponing the render until it is resumed")
+				postponed = true
+				continue retryloop
+			}
+			trigger := TriggerStartup
+			if attempt > 0 {
+				trigger = TriggerRetry
+			}
+			rctx := withRenderContext(startupCtx, RenderContext{Trigger: trigger})
+			changed, err := t.processWithTimeout(rctx, t.backends, t.startCmd == "")
+			t.Changed = t.Changed || len(changed) > 0
+			t.Err = err
+			t.recordRender(trigger, "", changed, err)
+			if err != nil {
+				logProcessError(t.logger.WithField("span_id", t.spanID), err)
+				attempt++
+				// in onetime mode the templates are only retried if the retries are bounded
+				if t.Onetime() && t.startupMaxRetries == 0 && t.startupTimeout == 0 {
+					if !t.giveUpStartup("processing the templates failed in onetime mode", attempt) {
+						return
+					}
+					break retryloop
+				}
+				if t.startupMaxRetries > 0 && attempt > t.startupMaxRetries {
+					if !t.giveUpStartup(fmt.Sprintf("startup_max_retries (%d) exceeded", t.startupMaxRetries), attempt) {
+						return
+					}
+					break retryloop
 				}
+				wait := t.retry.wait(attempt)
+				observeSeconds(retryDelayKey, t.name, wait)
+				t.logger.WithField("attempt", attempt).Error(fmt.Sprintf("not all templates could be rendered, trying again after %s", wait.Round(time.Millisecond)))
 				go func() {
-					rn := rand.Int63n(30)
-					t.logger.Error(fmt.Sprintf("not all templates could be rendered, trying again after %d seconds", rn))
-					time.Sleep(time.Duration(rn) * time.Second)
+					timer := time.NewTimer(wait)
+					defer timer.Stop()
 					select {
 					case <-ctx.Done():
 						return
-					default:
+					case <-timer.C:
 						retryChan <- struct{}{}
 					}
 				}()
 				continue retryloop
 			}
+			// the gate file exists once Ready is closed
+			t.createReadinessGate()
+			t.ready.set()
 			break retryloop
 		}
 	}
 
+	if t.renderLimiter != nil {
+		// a deferred render of the previous run is covered by the initial render
+		t.renderLimiter.take()
+		t.renderLimiter.rendered()
+	}
+
 	if t.startCmd != "" {
 		output, err := execCommand(t.startCmd, t.logger, nil)
 		if err != nil {
@@ -303,30 +1351,58 @@ retryloop:
 		}
 	}
 
-	err := t.exec.SpawnChild()
+	// the start of the child is delayed by the startup splay
+	if !t.Failed && !t.exec.waitStartupSplay(ctx) {
+		return
+	}
+
+	var err error
+	if t.preStart != nil && !t.Failed {
+		err = t.runHook(ctx, t.preStart)
+	}
+	if err == nil {
+		_, err = t.updateChildEnv()
+	}
+	if err == nil {
+		err = t.exec.SpawnChild()
+	}
 	if err != nil {
 		t.logger.Error(err)
 		t.Failed = true
 		cancel()
 	} else {
-		defer t.exec.StopChild()
+		childSpawned = true
+		if t.ready.isSet() && (t.healthChecker == nil || t.exec.execCommand == "") {
+			t.healthy.set()
+		}
 	}
 
 	done := make(chan struct{})
-	wg.Add(1)
-	go func() {
-		// Wait for the child process to quit.
-		// If the process terminates unexpectedly (the context was NOT canceled), we set t.Failed to true
-		// and cancel the resource context. Remco will try to restart the resource if t.Failed is true.
-		defer wg.Done()
-		failed := t.exec.Wait(ctx)
-		if failed {
-			t.Failed = true
-			cancel()
-		}
-	}()
+	if childSpawned {
+		wg.Add(1)
+		go func() {
+			// Wait for the child process to quit.
+			// The child is restarted according to its restart policy (forever in supervise mode).
+			// If the process terminates unexpectedly (the context was NOT canceled), we set t.Failed to true
+			// and cancel the resource context. Remco will try to restart the resource if t.Failed is true.
+			defer wg.Done()
+			failed := t.exec.Wait(ctx)
+			if failed {
+				t.Failed = true
+				cancel()
+			}
+		}()
+	}
+
+	if childSpawned && t.healthChecker != nil && t.exec.execCommand != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t.runHealthCheck(ctx, t.healthChecker)
+		}()
+	}
 
-	// start the watch and interval processors so that we get notfied on changes
+	// start the watch, interval and schedule processors so that we get notfied on changes
 	for _, sc := range t.backends {
 		if sc.Watch {
 			wg.Add(1)
@@ -343,6 +1419,14 @@ retryloop:
 				s.interval(ctx, processChan)
 			}(sc)
 		}
+
+		if sc.cron != nil {
+			wg.Add(1)
+			go func(s Backend) {
+				defer wg.Done()
+				s.schedule(ctx, processChan)
+			}(sc)
+		}
 	}
 
 	go func() {
@@ -354,30 +1438,69 @@ retryloop:
 	for {
 		select {
 		case storeClient := <-processChan:
-			changed, err := t.process([]Backend{storeClient}, true)
-			if err != nil {
-				switch err.(type) {
-				case berr.BackendError:
-					t.logger.WithField("backend", storeClient.Name).Error(err)
-				default:
-					t.logger.Error(err)
-				}
-			} else if changed {
-				if err := t.exec.Reload(); err != nil {
-					t.logger.Error(err)
+			if t.Paused() {
+				t.logger.WithField("backend", storeClient.Name).Debug("the resource is paused, skipping the render")
+				continue
+			}
+			backends := []Backend{storeClient}
+			if t.coalesceWindow > 0 {
+				// the watchers block until the next receive, so no change gets lost while processing
+				backends = t.coalesce(ctx, processChan, storeClient)
+				if ctx.Err() != nil {
+					continue
 				}
-
-				if t.reloadCmd != "" {
-					output, err := execCommand(t.reloadCmd, t.logger, nil)
-					if err != nil {
-						t.logger.Error(fmt.Sprintf("failed to execute the resource reload cmd - %q", string(output)))
-					}
+			}
+			if t.renderLimiter != nil {
+				if backends = t.renderLimiter.filter(backends); len(backends) == 0 {
+					t.logger.WithFields(logrus.Fields{
+						"backend":    backendNames(t.renderLimiter.pending),
+						"suppressed": t.renderLimiter.suppressed,
+					}).Debug(fmt.Sprintf("deferring the render, min_render_interval is %s", t.renderLimiter.interval))
+					continue
 				}
 			}
+			t.processChanges(ctx, TriggerBackend, backends)
+		case <-t.renderDue():
+			backends, suppressed := t.renderLimiter.take()
+			if t.Paused() {
+				t.logger.Debug("the resource is paused, skipping the deferred render")
+				continue
+			}
+			t.logger.WithFields(logrus.Fields{
+				"backend":    backendNames(backends),
+				"suppressed": suppressed,
+			}).Debug("running the deferred render")
+			t.processChanges(ctx, TriggerBackend, backends)
+		case <-t.forceRender:
+			if t.Paused() {
+				t.logger.Info("the resource is paused, ignoring the forced render")
+				continue
+			}
+			t.logger.Info("forced render of all templates")
+			t.processChanges(ctx, TriggerForced, t.backends)
+		case <-t.resume:
+			t.logger.Info("rendering all templates after the resource was resumed")
+			t.processChanges(ctx, TriggerResume, t.backends)
+			if changed, envChanged, pending := t.held.take(); pending {
+				t.scheduleReload(ctx, changed, envChanged)
+			}
+		case <-t.reloadDue():
+			changed, envChanged, _ := t.takePendingReload()
+			if t.Paused() {
+				t.logger.Info("the resource is paused, holding back the reload")
+				t.held.hold(changed, envChanged)
+				continue
+			}
+			t.applyChanges(ctx, changed, envChanged)
+		case <-t.onChange.due():
+			t.flushHook(t.onChange)
+		case <-t.onError.due():
+			t.flushHook(t.onError)
 		case s := <-t.SignalChan:
-			err := t.exec.SignalChild(s)
-			if err != nil {
-				t.logger.Error(err)
+			if s, ok := t.mapSignal(s); ok {
+				if err := t.exec.SignalChild(s); err != nil {
+					t.logger.Error(err)
+				}
 			}
 		case err := <-errChan:
 			t.logger.WithField("backend", err.Backend).Error(err.Message)
@@ -10,12 +10,19 @@ package template
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"testing"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/HeavyHorst/easykv/mock"
+	berr "github.com/HeavyHorst/remco/pkg/backends/error"
+	"github.com/HeavyHorst/remco/pkg/template/fileutil"
+	"github.com/sirupsen/logrus"
 
 	. "gopkg.in/check.v1"
 )
@@ -86,6 +93,7 @@ func (s *ResourceSuite) TestNewResource(t *C) {
 
 	fm := newFuncMap()
 	addFuncs(fm, s.resource.store.FuncMap)
+	addFuncs(fm, newStoreFuncMap(s.resource.store))
 	t.Check(s.resource.funcMap, HasLen, len(fm))
 	t.Check(s.resource.sources, DeepEquals, []*Renderer{s.renderer})
 	t.Check(s.resource.SignalChan, NotNil)
@@ -96,19 +104,96 @@ func (s *ResourceSuite) TestClose(t *C) {
 }
 
 func (s *ResourceSuite) TestSetVars(t *C) {
-	err := s.resource.setVars(s.resource.backends[0])
+	err := s.resource.setVars(context.Background(), s.resource.backends[0])
 	t.Check(err, IsNil)
 	// the backend trie and the global tree should hold the same values
 	t.Check(s.resource.store.GetAllKVs(), DeepEquals, s.resource.backends[0].store.GetAllKVs())
 }
 
+func (s *ResourceSuite) TestSnapshot(t *C) {
+	err := s.resource.setVars(context.Background(), s.resource.backends[0])
+	t.Assert(err, IsNil)
+
+	snapshot := s.resource.Snapshot()
+	t.Check(snapshot, DeepEquals, map[string]string{"/some/path/data": "someData"})
+
+	// the snapshot is a copy
+	snapshot["/foo"] = "bar"
+	t.Check(s.resource.store.Exists("/foo"), Equals, false)
+}
+
+func (s *ResourceSuite) TestAppendPrefix(t *C) {
+	tests := []struct {
+		prefix   string
+		keys     []string
+		expected []string
+	}{
+		{"", []string{"/foo", "bar"}, []string{"/foo", "/bar"}},
+		{"/", []string{"/foo", "bar"}, []string{"/foo", "/bar"}},
+		{"/", []string{"/"}, []string{"/"}},
+		{"app", []string{"/foo", "bar"}, []string{"/app/foo", "/app/bar"}},
+		{"/app/", []string{"/foo", "bar/"}, []string{"/app/foo", "/app/bar"}},
+		{"/app", []string{"/", ""}, []string{"/app", "/app"}},
+		{"/app", []string{"//foo//bar"}, []string{"/app/foo/bar"}},
+		{"/app", []string{}, []string{}},
+		{"/app", nil, []string{}},
+	}
+
+	for _, tc := range tests {
+		t.Check(appendPrefix(tc.prefix, tc.keys), DeepEquals, tc.expected, Commentf("prefix %q, keys %q", tc.prefix, tc.keys))
+	}
+}
+
+func (s *ResourceSuite) TestSetVarsStripsPrefix(t *C) {
+	data := map[string]string{
+		"/app/foo":     "1",
+		"/app/bar/baz": "2",
+		"/app":         "3",
+		"/application": "4",
+		"/other/foo":   "5",
+	}
+
+	tests := []struct {
+		prefix   string
+		expected map[string]string
+	}{
+		{"", data},
+		{"/", data},
+		{"/app", map[string]string{"/foo": "1", "/bar/baz": "2", "/": "3", "/application": "4", "/other/foo": "5"}},
+		{"/app/", map[string]string{"/foo": "1", "/bar/baz": "2", "/": "3", "/application": "4", "/other/foo": "5"}},
+		{"app", map[string]string{"/foo": "1", "/bar/baz": "2", "/": "3", "/application": "4", "/other/foo": "5"}},
+	}
+
+	for _, tc := range tests {
+		b := Backend{
+			Name:   "mock",
+			Prefix: tc.prefix,
+			Keys:   []string{"/"},
+		}
+		b.ReadWatcher, _ = mock.New(nil, data)
+
+		exec := NewExecutor("", "", "", 0, 0, nil)
+		res, err := NewResource([]Backend{b}, []*Renderer{s.renderer}, "test", exec, "", "")
+		t.Assert(err, IsNil)
+
+		err = res.setVars(context.Background(), res.backends[0])
+		t.Assert(err, IsNil)
+
+		got := make(map[string]string)
+		for _, kv := range res.store.GetAllKVs() {
+			got[kv.Key] = kv.Value
+		}
+		t.Check(got, DeepEquals, tc.expected, Commentf("prefix %q", tc.prefix))
+	}
+}
+
 func (s *ResourceSuite) TestCreateStageFileAndSync(t *C) {
-	_, err := s.resource.createStageFileAndSync(true)
+	_, err := s.resource.createStageFileAndSync(context.Background(), true)
 	t.Check(err, IsNil)
 }
 
 func (s *ResourceSuite) TestProcess(t *C) {
-	_, err := s.resource.process(s.resource.backends, true)
+	_, err := s.resource.process(context.Background(), s.resource.backends, true)
 	t.Check(err, IsNil)
 
 	data, err := ioutil.ReadFile("/tmp/remco-basic-test.conf")
@@ -143,3 +228,552 @@ func (s *ResourceSuite) TestMonitorWithBackendError(t *C) {
 	t.Check(s.resource.Failed, Equals, false)
 	s.resource.backends[0].ReadWatcher.(*mock.Client).Err = nil
 }
+
+func (s *ResourceSuite) TestOnExit(t *C) {
+	dst, err := ioutil.TempFile("", "remco-onexit")
+	t.Assert(err, IsNil)
+	dst.Close()
+	defer os.Remove(dst.Name())
+
+	r := &Renderer{
+		Src:    s.templateFile,
+		Dst:    dst.Name(),
+		OnExit: OnExitRemove,
+	}
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource([]Backend{s.backend}, []*Renderer{r}, "test", exec, "", "")
+	t.Assert(err, IsNil)
+
+	res.onExit()
+	_, err = os.Stat(dst.Name())
+	t.Check(os.IsNotExist(err), Equals, true)
+
+	r.OnExit = OnExitWriteTemplate
+	r.OnExitSrc = s.templateFile
+	res.onExit()
+	data, err := ioutil.ReadFile(dst.Name())
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "[]")
+}
+
+func (s *ResourceSuite) TestRenderOnShutdown(t *C) {
+	dir, err := ioutil.TempDir("", "remco-shutdown")
+	t.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	r := &Renderer{
+		Src: s.templateFile,
+		Dst: filepath.Join(dir, "dst"),
+	}
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource([]Backend{s.backend}, []*Renderer{r}, "test", exec, "", "")
+	t.Assert(err, IsNil)
+	res.renderOnShutdown = true
+
+	// the template is never rendered by Monitor itself, the context is already canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res.Monitor(ctx)
+
+	data, err := ioutil.ReadFile(r.Dst)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, tmplFile)
+	t.Check(res.Changed, Equals, true)
+}
+
+func (s *ResourceSuite) TestInvalidOnExit(t *C) {
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	r := &Renderer{Src: s.templateFile, Dst: "/tmp/remco-basic-test.conf", OnExit: "truncate"}
+	_, err := NewResource([]Backend{s.backend}, []*Renderer{r}, "test", exec, "", "")
+	t.Check(err, NotNil)
+
+	r = &Renderer{Src: s.templateFile, Dst: "/tmp/remco-basic-test.conf", OnExit: OnExitWriteTemplate}
+	_, err = NewResource([]Backend{s.backend}, []*Renderer{r}, "test", exec, "", "")
+	t.Check(err, NotNil)
+}
+
+type slowClient struct {
+	mock.Client
+	delay time.Duration
+}
+
+func (c *slowClient) GetValues(keys []string) (map[string]string, error) {
+	time.Sleep(c.delay)
+	return c.Client.GetValues(keys)
+}
+
+func (s *ResourceSuite) TestSetVarsTimeout(t *C) {
+	b := Backend{
+		Name:    "slow",
+		Keys:    []string{"/"},
+		Timeout: 1,
+	}
+	b.ReadWatcher = &slowClient{Client: mock.Client{Data: map[string]string{"/foo": "bar"}}, delay: 2 * time.Second}
+
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource([]Backend{b}, []*Renderer{s.renderer}, "test", exec, "", "")
+	t.Assert(err, IsNil)
+
+	_, err = res.process(context.Background(), res.backends, false)
+	t.Check(err, FitsTypeOf, berr.TimeoutError{})
+}
+
+func (s *ResourceSuite) TestProcessWithTimeout(t *C) {
+	b := Backend{Name: "slow", Keys: []string{"/"}}
+	b.ReadWatcher = &slowClient{Client: mock.Client{Data: map[string]string{"/foo": "bar"}}, delay: 2 * time.Second}
+
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource([]Backend{b}, []*Renderer{s.renderer}, "test", exec, "", "")
+	t.Assert(err, IsNil)
+	res.processTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, err = res.processWithTimeout(context.Background(), res.backends, false)
+	t.Check(time.Since(start) < time.Second, Equals, true)
+	t.Check(err, FitsTypeOf, ProcessTimeoutError{})
+	t.Check(FailureOf(err), Equals, FailureBackend)
+
+	// the cancelation of the parent context isn't a timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = res.processWithTimeout(ctx, res.backends, false)
+	t.Check(err, NotNil)
+	_, ok := err.(ProcessTimeoutError)
+	t.Check(ok, Equals, false)
+}
+
+func (s *ResourceSuite) TestProcessStaleData(t *C) {
+	good := Backend{Name: "good", Keys: []string{"/"}}
+	goodClient, _ := mock.New(nil, map[string]string{"/good": "1"})
+	good.ReadWatcher = goodClient
+	flaky := Backend{Name: "flaky", Keys: []string{"/"}}
+	flakyClient, _ := mock.New(nil, map[string]string{"/flaky": "1"})
+	flaky.ReadWatcher = flakyClient
+	never := Backend{Name: "never", Keys: []string{"/"}}
+	never.ReadWatcher, _ = mock.New(fmt.Errorf("unavailable"), nil)
+
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource([]Backend{flaky, good}, []*Renderer{s.renderer}, "test", exec, "", "")
+	t.Assert(err, IsNil)
+
+	_, err = res.process(context.Background(), res.backends, false)
+	t.Assert(err, IsNil)
+
+	// the failed backend keeps its last good values, the other backends are updated
+	flakyClient.Err = fmt.Errorf("unavailable")
+	goodClient.Data = map[string]string{"/good": "2"}
+	_, err = res.process(context.Background(), res.backends, false)
+	t.Assert(err, IsNil)
+	t.Check(res.store.GetAllKVs(), HasLen, 2)
+	v, _ := res.store.GetValue("/flaky")
+	t.Check(v, Equals, "1")
+	v, _ = res.store.GetValue("/good")
+	t.Check(v, Equals, "2")
+
+	// the stale data is too old
+	res.maxStaleAge = time.Second
+	res.freshness["flaky"] = time.Now().Add(-2 * time.Second)
+	_, err = res.process(context.Background(), res.backends, false)
+	t.Check(err, FitsTypeOf, berr.BackendError{})
+
+	// a backend without any fresh data fails
+	res, err = NewResource([]Backend{never}, []*Renderer{s.renderer}, "test", exec, "", "")
+	t.Assert(err, IsNil)
+	_, err = res.process(context.Background(), res.backends, false)
+	t.Check(err, FitsTypeOf, berr.BackendError{})
+}
+
+func (s *ResourceSuite) TestPostSync(t *C) {
+	out, err := ioutil.TempFile("", "remco-postsync")
+	t.Assert(err, IsNil)
+	out.Close()
+	defer os.Remove(out.Name())
+
+	s.resource.postSyncCmd = "echo \"$@\" > " + out.Name() + "; echo \"$REMCO_CHANGED_FILES\" >> " + out.Name()
+	defer func() { s.resource.postSyncCmd = "" }()
+	s.resource.postSync(s.resource.logger, []string{"/tmp/a", "/tmp/b"})
+
+	data, err := ioutil.ReadFile(out.Name())
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "/tmp/a /tmp/b\n/tmp/a\n/tmp/b\n")
+}
+
+func (s *ResourceSuite) TestRunReloadCommands(t *C) {
+	out, err := ioutil.TempFile("", "remco-reload")
+	t.Assert(err, IsNil)
+	out.Close()
+	defer os.Remove(out.Name())
+
+	defer func() {
+		s.resource.reloadCmds = nil
+		s.resource.reloadTimeout = 0
+	}()
+
+	s.resource.reloadCmds = []string{"echo 1 >> " + out.Name(), "echo 2 >> " + out.Name()}
+	t.Check(s.resource.runReloadCommands(context.Background()), Equals, true)
+
+	// the sequence is aborted after the failing step
+	s.resource.reloadCmds = []string{"echo 3 >> " + out.Name(), "exit 1", "echo 4 >> " + out.Name()}
+	t.Check(s.resource.runReloadCommands(context.Background()), Equals, false)
+
+	// a step that exceeds the timeout fails
+	s.resource.reloadTimeout = 1
+	s.resource.reloadCmds = []string{"sleep 5", "echo 5 >> " + out.Name()}
+	start := time.Now()
+	t.Check(s.resource.runReloadCommands(context.Background()), Equals, false)
+	t.Check(time.Since(start) < 4*time.Second, Equals, true)
+
+	data, err := ioutil.ReadFile(out.Name())
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "1\n2\n3\n")
+}
+
+func (s *ResourceSuite) TestReloadCommandsUnmarshal(t *C) {
+	var config struct {
+		Single ReloadCommands `toml:"single"`
+		List   ReloadCommands `toml:"list"`
+		Empty  ReloadCommands `toml:"empty"`
+	}
+	_, err := toml.Decode(`
+single = "systemctl reload app"
+list = ["systemctl reload app", "systemctl reload lb"]
+empty = ""
+`, &config)
+	t.Assert(err, IsNil)
+	t.Check(config.Single, DeepEquals, ReloadCommands{"systemctl reload app"})
+	t.Check(config.List, DeepEquals, ReloadCommands{"systemctl reload app", "systemctl reload lb"})
+	t.Check(config.Empty, HasLen, 0)
+
+	var list ReloadCommands
+	t.Assert(json.Unmarshal([]byte(`["a", "b"]`), &list), IsNil)
+	t.Check(list, DeepEquals, ReloadCommands{"a", "b"})
+	t.Check(json.Unmarshal([]byte(`[1]`), &list), NotNil)
+}
+
+func newSlowBackends(n int, delay time.Duration) []Backend {
+	var backends []Backend
+	for i := 0; i < n; i++ {
+		b := Backend{
+			Name: fmt.Sprintf("slow%d", i),
+			Keys: []string{"/"},
+		}
+		b.ReadWatcher = &slowClient{
+			Client: mock.Client{Data: map[string]string{fmt.Sprintf("/key%d", i): "value"}},
+			delay:  delay,
+		}
+		backends = append(backends, b)
+	}
+	return backends
+}
+
+func (s *ResourceSuite) TestProcessParallelBackends(t *C) {
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource(newSlowBackends(5, 100*time.Millisecond), []*Renderer{s.renderer}, "test", exec, "", "")
+	t.Assert(err, IsNil)
+	res.parallelBackends = true
+
+	start := time.Now()
+	_, err = res.process(context.Background(), res.backends, false)
+	t.Assert(err, IsNil)
+	t.Check(time.Since(start) < 400*time.Millisecond, Equals, true)
+	t.Check(res.store.GetAllKVs(), HasLen, 5)
+}
+
+func (s *ResourceSuite) TestProcessMaxConcurrentBackends(t *C) {
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource(newSlowBackends(4, 100*time.Millisecond), []*Renderer{s.renderer}, "test", exec, "", "")
+	t.Assert(err, IsNil)
+	res.parallelBackends = true
+	res.maxConcurrentBackends = 2
+
+	// two rounds of two concurrent fetches
+	start := time.Now()
+	_, err = res.process(context.Background(), res.backends, false)
+	t.Assert(err, IsNil)
+	elapsed := time.Since(start)
+	t.Check(elapsed >= 200*time.Millisecond, Equals, true, Commentf("took %s", elapsed))
+	t.Check(elapsed < 400*time.Millisecond, Equals, true, Commentf("took %s", elapsed))
+	t.Check(res.store.GetAllKVs(), HasLen, 4)
+}
+
+func (s *ResourceSuite) TestProcessParallelBackendsError(t *C) {
+	backends := newSlowBackends(3, 100*time.Millisecond)
+	failing := Backend{Name: "failing", Keys: []string{"/"}}
+	failing.ReadWatcher, _ = mock.New(fmt.Errorf("unreachable"), nil)
+	backends = append([]Backend{failing}, backends...)
+
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource(backends, []*Renderer{s.renderer}, "test", exec, "", "")
+	t.Assert(err, IsNil)
+	res.parallelBackends = true
+	res.maxConcurrentBackends = 1
+	res.requireAllBackends = true
+
+	_, err = res.process(context.Background(), res.backends, false)
+	backendErr, ok := err.(berr.BackendError)
+	t.Assert(ok, Equals, true, Commentf("unexpected error %v", err))
+	t.Check(backendErr.Backend, Equals, "failing")
+	// the remaining backends aren't fetched
+	t.Check(res.store.GetAllKVs(), HasLen, 0)
+}
+
+func (s *ResourceSuite) TestProcessPartialFailure(t *C) {
+	for _, parallel := range []bool{false, true} {
+		backends := newSlowBackends(2, 10*time.Millisecond)
+		for _, name := range []string{"down", "unreachable"} {
+			failing := Backend{Name: name, Keys: []string{"/"}}
+			failing.ReadWatcher, _ = mock.New(fmt.Errorf("%s", name), nil)
+			backends = append(backends, failing)
+		}
+
+		exec := NewExecutor("", "", "", 0, 0, nil)
+		res, err := NewResource(backends, []*Renderer{s.renderer}, "test", exec, "", "")
+		t.Assert(err, IsNil)
+		res.parallelBackends = parallel
+
+		// all backends are fetched and the templates are processed with the healthy ones
+		_, err = res.process(context.Background(), res.backends, false)
+		backendErrs, ok := err.(BackendErrors)
+		t.Assert(ok, Equals, true, Commentf("unexpected error %v", err))
+		t.Check(backendErrs.Backends(), DeepEquals, []string{"down", "unreachable"})
+		t.Check(FailureOf(err), Equals, FailureBackend)
+		t.Check(res.store.GetAllKVs(), HasLen, 2)
+	}
+}
+
+func benchmarkProcess(b *testing.B, parallel bool) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	f, err := ioutil.TempFile("", "template")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(tmplString)
+	f.Close()
+
+	renderer := &Renderer{Src: f.Name(), Dst: f.Name() + ".out"}
+	defer os.Remove(renderer.Dst)
+
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource(newSlowBackends(5, 100*time.Millisecond), []*Renderer{renderer}, "bench", exec, "", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	res.logger = logrus.NewEntry(logger)
+	renderer.logger = res.logger
+	res.parallelBackends = parallel
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := res.process(context.Background(), res.backends, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProcessSequentialBackends(b *testing.B) { benchmarkProcess(b, false) }
+func BenchmarkProcessParallelBackends(b *testing.B)   { benchmarkProcess(b, true) }
+
+func (s *ResourceSuite) TestProcessFanOut(t *C) {
+	dir, err := ioutil.TempDir("", "remco-fanout")
+	t.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "service.tmpl")
+	err = ioutil.WriteFile(src, []byte(`{{ name }}:{{ values.port }}`), 0644)
+	t.Assert(err, IsNil)
+	reloads := filepath.Join(dir, "reloads")
+
+	client, _ := mock.New(nil, map[string]string{
+		"/services/a/port": "80",
+		"/services/b/port": "81",
+		"/other/c/port":    "82",
+	})
+	b := Backend{Name: "mock", Keys: []string{"/"}, ReadWatcher: client}
+	r := &Renderer{
+		Src:         src,
+		Dst:         filepath.Join(dir, "{{.name}}.conf"),
+		Iterate:     "/services/*",
+		RemoveStale: true,
+		CheckCmd:    "cat {{.src}}",
+		ReloadCmd:   "echo {{.dst}} >> " + reloads,
+	}
+
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource([]Backend{b}, []*Renderer{r}, "test", exec, "", "")
+	t.Assert(err, IsNil)
+
+	changed, err := res.process(context.Background(), res.backends, true)
+	t.Assert(err, IsNil)
+	t.Check(changed, DeepEquals, []string{filepath.Join(dir, "a.conf"), filepath.Join(dir, "b.conf")})
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "a.conf"))
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "a:80")
+	data, err = ioutil.ReadFile(filepath.Join(dir, "b.conf"))
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "b:81")
+	t.Check(fileutil.IsFileExist(filepath.Join(dir, "c.conf")), Equals, false)
+
+	// remove a service, the unchanged file stays untouched
+	delete(client.Data, "/services/b/port")
+	changed, err = res.process(context.Background(), res.backends, true)
+	t.Assert(err, IsNil)
+	t.Check(changed, DeepEquals, []string{filepath.Join(dir, "b.conf")})
+	t.Check(fileutil.IsFileExist(filepath.Join(dir, "b.conf")), Equals, false)
+
+	// the reload command runs once per run
+	data, err = ioutil.ReadFile(reloads)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, filepath.Join(dir, "a.conf")+" "+filepath.Join(dir, "b.conf")+"\n"+filepath.Join(dir, "b.conf")+"\n")
+}
+
+func (s *ResourceSuite) TestProcessCondition(t *C) {
+	dir, err := ioutil.TempDir("", "remco-condition")
+	t.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	client, _ := mock.New(nil, map[string]string{"/features/tls/enabled": "true"})
+	b := Backend{Name: "mock", Keys: []string{"/"}, ReadWatcher: client}
+
+	exists := &Renderer{Src: s.templateFile, Dst: filepath.Join(dir, "exists.conf"), WhenExists: "/features/tls"}
+	missing := &Renderer{Src: s.templateFile, Dst: filepath.Join(dir, "missing.conf"), WhenExists: "/features/http2"}
+	condition := &Renderer{
+		Src:               s.templateFile,
+		Dst:               filepath.Join(dir, "condition.conf"),
+		Condition:         `{{ getv("/features/tls/enabled", "false") }}`,
+		RemoveWhenSkipped: true,
+	}
+
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource([]Backend{b}, []*Renderer{exists, missing, condition}, "test", exec, "", "")
+	t.Assert(err, IsNil)
+
+	changed, err := res.process(context.Background(), res.backends, false)
+	t.Assert(err, IsNil)
+	t.Check(changed, DeepEquals, []string{exists.Dst, condition.Dst})
+	t.Check(fileutil.IsFileExist(missing.Dst), Equals, false)
+
+	// the condition becomes false, the destination is removed
+	client.Data["/features/tls/enabled"] = "false"
+	changed, err = res.process(context.Background(), res.backends, false)
+	t.Assert(err, IsNil)
+	t.Check(changed, DeepEquals, []string{exists.Dst, condition.Dst})
+	t.Check(fileutil.IsFileExist(condition.Dst), Equals, false)
+
+	// nothing to remove anymore
+	changed, err = res.process(context.Background(), res.backends, false)
+	t.Assert(err, IsNil)
+	t.Check(changed, HasLen, 0)
+}
+
+func (s *ResourceSuite) TestProcessParallelTemplates(t *C) {
+	dir, err := ioutil.TempDir("", "remco-parallel-templates")
+	t.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	syncs := filepath.Join(dir, "syncs")
+	var renderers []*Renderer
+	for _, name := range []string{"a", "b", "c"} {
+		renderers = append(renderers, &Renderer{
+			Src:       s.templateFile,
+			Dst:       filepath.Join(dir, name+".conf"),
+			ReloadCmd: "echo " + name + " >> " + syncs,
+		})
+	}
+
+	client, _ := mock.New(nil, map[string]string{"/some/path/data": "someData"})
+	b := Backend{Name: "mock", Keys: []string{"/"}, ReadWatcher: client}
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource([]Backend{b}, renderers, "test", exec, "", "")
+	t.Assert(err, IsNil)
+	res.parallelTemplates = true
+
+	changed, err := res.process(context.Background(), res.backends, true)
+	t.Assert(err, IsNil)
+	t.Check(changed, DeepEquals, []string{renderers[0].Dst, renderers[1].Dst, renderers[2].Dst})
+
+	// the templates are synced in configuration order
+	data, err := ioutil.ReadFile(syncs)
+	t.Assert(err, IsNil)
+	t.Check(string(data), Equals, "a\nb\nc\n")
+
+	changed, err = res.process(context.Background(), res.backends, true)
+	t.Assert(err, IsNil)
+	t.Check(changed, HasLen, 0)
+
+	// a failed check stops the sync, the stage files of the following templates are removed
+	client.Data["/some/path/data"] = "otherData"
+	renderers[1].CheckCmd = "exit 1"
+	changed, err = res.process(context.Background(), res.backends, true)
+	t.Check(err, NotNil)
+	t.Check(changed, DeepEquals, []string{renderers[0].Dst})
+
+	files, err := ioutil.ReadDir(dir)
+	t.Assert(err, IsNil)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	t.Check(names, DeepEquals, []string{"a.conf", "b.conf", "c.conf", "syncs"})
+}
+
+func benchmarkCreateStageFiles(b *testing.B, parallel bool) {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	dir, err := ioutil.TempDir("", "remco-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src.tmpl")
+	// lookup simulates a template function that waits for the network, like lookupIP
+	tmpl := "{{ lookup() }}\n{% for kv in gets(\"/*\") %}{{ kv.Key|upper }}={{ kv.Value|base64 }}\n{% endfor %}"
+	if err := ioutil.WriteFile(src, []byte(tmpl), 0644); err != nil {
+		b.Fatal(err)
+	}
+
+	data := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		data[fmt.Sprintf("/key%d", i)] = fmt.Sprintf("value%d", i)
+	}
+	client, _ := mock.New(nil, data)
+	backend := Backend{Name: "mock", Keys: []string{"/"}, ReadWatcher: client}
+
+	var renderers []*Renderer
+	for i := 0; i < 20; i++ {
+		renderers = append(renderers, &Renderer{Src: src, Dst: filepath.Join(dir, fmt.Sprintf("%d.conf", i))})
+	}
+
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource([]Backend{backend}, renderers, "bench", exec, "", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	res.logger = logrus.NewEntry(logger)
+	for _, r := range renderers {
+		r.logger = res.logger
+	}
+	res.funcMap["lookup"] = func() string {
+		time.Sleep(5 * time.Millisecond)
+		return "127.0.0.1"
+	}
+	res.parallelTemplates = parallel
+	if _, err := res.process(context.Background(), res.backends, false); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := res.createStageFileAndSync(context.Background(), false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCreateStageFilesSequential(b *testing.B) { benchmarkCreateStageFiles(b, false) }
+func BenchmarkCreateStageFilesParallel(b *testing.B)   { benchmarkCreateStageFiles(b, true) }
@@ -0,0 +1,240 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/consul-template/signals"
+	"github.com/mattn/go-shellwords"
+)
+
+// validResourceName matches the valid resource names.
+// Dots and underscores are allowed as well, the names of included resources default to their file names.
+var validResourceName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Validate checks the configuration of the resource without connecting to the backends.
+// It returns all problems, not just the first one.
+func (r ResourceConfig) Validate() []error {
+	var errs []error
+	addErr := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	switch {
+	case r.Name == "":
+		addErr("name: the resource needs a name")
+	case !validResourceName.MatchString(r.Name):
+		addErr("name %q: only letters, digits, '-', '_' and '.' are allowed", r.Name)
+	}
+
+	if r.Connectors.Count() == 0 {
+		addErr("backend: at least one backend is required")
+	}
+
+	if len(r.Template) == 0 {
+		addErr("template: at least one template is required")
+	}
+	for i, s := range r.Template {
+		if s.Src == "" {
+			addErr("template[%d]: src is required", i)
+		} else if f, err := os.Open(s.Src); err != nil {
+			addErr("template[%d]: src %q is not readable: %v", i, s.Src, err)
+		} else {
+			f.Close()
+		}
+		if base := s.basePath(s.Src); base != "" {
+			if f, err := os.Open(base); err != nil {
+				addErr("template[%d]: base_template %q is not readable: %v", i, base, err)
+			} else {
+				f.Close()
+			}
+		}
+		if s.Dst == "" {
+			addErr("template[%d]: dst is required", i)
+		} else if !strings.Contains(s.Dst, "{{") {
+			if err := checkWritableDir(filepath.Dir(s.Dst)); err != nil {
+				addErr("template[%d]: the directory of dst %q is not writable: %v", i, s.Dst, err)
+			}
+		}
+	}
+
+	if r.Startup.MaxRetries < 0 {
+		addErr("startup_max_retries: must not be negative, got %d", r.Startup.MaxRetries)
+	}
+	if r.Startup.Timeout < 0 {
+		addErr("startup_timeout: must not be negative, got %d", r.Startup.Timeout)
+	}
+	if r.Render.ProcessTimeout < 0 {
+		addErr("process_timeout: must not be negative, got %d", r.Render.ProcessTimeout)
+	}
+	if _, err := NewMergeStrategy(r.Store.MergeStrategy); err != nil {
+		addErr("merge_strategy: %q is not one of last_wins, first_wins, error_on_conflict, longest_value_wins and json_deep_merge", r.Store.MergeStrategy)
+	}
+	for k := range r.Store.Variables {
+		if strings.Trim(k, "/") == "" {
+			addErr("variables: the key %q is empty", k)
+		}
+	}
+	if r.Startup.WaitForBackends < 0 {
+		addErr("wait_for_backends: must not be negative, got %d", r.Startup.WaitForBackends)
+	}
+	if r.Render.ReadinessGateFile != "" {
+		if err := checkWritableDir(filepath.Dir(r.Render.ReadinessGateFile)); err != nil {
+			addErr("readiness_gate_file: the directory of %q is not writable: %v", r.Render.ReadinessGateFile, err)
+		}
+	}
+	if r.Store.MaxConcurrentBackends < 0 {
+		addErr("max_concurrent_backends: must not be negative, got %d", r.Store.MaxConcurrentBackends)
+	}
+	if r.Render.CoalesceWindowMs < 0 {
+		addErr("coalesce_window_ms: must not be negative, got %d", r.Render.CoalesceWindowMs)
+	}
+	if r.Render.MinRenderInterval < 0 {
+		addErr("min_render_interval: must not be negative, got %d", r.Render.MinRenderInterval)
+	}
+	if r.Render.HistorySize < 0 {
+		addErr("history_size: must not be negative, got %d", r.Render.HistorySize)
+	}
+
+	if r.Notify.OnChange != nil {
+		if err := r.Notify.OnChange.validate("on_change"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if r.Notify.OnError != nil {
+		if err := r.Notify.OnError.validate("on_error"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if r.Notify.WebhookTimeout < 0 {
+		addErr("notify_webhook_timeout: must not be negative, got %d", r.Notify.WebhookTimeout)
+	}
+	if r.Notify.WebhookURL != "" {
+		if u, err := url.Parse(r.Notify.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			addErr("notify_webhook_url: %q is not an http or https url", r.Notify.WebhookURL)
+		}
+	} else if len(r.Notify.WebhookHeaders) > 0 {
+		addErr("notify_webhook_headers: requires notify_webhook_url")
+	}
+
+	if r.Exec.Command != "" {
+		if err := checkExecutable(r.Exec.Command); err != nil {
+			addErr("exec.command %q: %v", r.Exec.Command, err)
+		}
+	}
+	if r.Exec.KillTimeout < 0 {
+		addErr("exec.kill_timeout: must be positive, got %d", r.Exec.KillTimeout)
+	}
+	if r.Exec.Splay < 0 {
+		addErr("exec.splay: must not be negative, got %d", r.Exec.Splay)
+	}
+	if r.Exec.StartupSplay < 0 {
+		addErr("exec.startup_splay: must not be negative, got %d", r.Exec.StartupSplay)
+	}
+	for i, stage := range r.Exec.KillSequence {
+		if stage.Timeout < 0 {
+			addErr("exec.kill_sequence[%d].timeout: must be positive, got %d", i, stage.Timeout)
+		}
+		if _, err := signals.Parse(stage.Signal); err != nil {
+			addErr("exec.kill_sequence[%d].signal %q: %v", i, stage.Signal, err)
+		}
+	}
+	if r.Exec.ReloadSignal != "" {
+		if _, err := signals.Parse(r.Exec.ReloadSignal); err != nil {
+			addErr("exec.reload_signal %q: %v", r.Exec.ReloadSignal, err)
+		}
+	}
+	if r.Exec.KillSignal != "" {
+		if _, err := signals.Parse(r.Exec.KillSignal); err != nil {
+			addErr("exec.kill_signal %q: %v", r.Exec.KillSignal, err)
+		}
+	}
+	if _, err := r.Exec.signalMap(); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// configured reports whether the connector is set, the connectors of unconfigured backends are nil pointers.
+func configured(c BackendConnector) bool {
+	if c == nil {
+		return false
+	}
+	v := reflect.ValueOf(c)
+	return v.Kind() != reflect.Ptr || !v.IsNil()
+}
+
+// checkWritableDir checks that a file can be created in dir.
+// If dir doesn't exist yet, its nearest existing parent must be writable.
+func checkWritableDir(dir string) error {
+	for {
+		fi, err := os.Stat(dir)
+		if err == nil {
+			if !fi.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return err
+		}
+		dir = parent
+	}
+	f, err := ioutil.TempFile(dir, ".remco-validate")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkExecutable checks that the program of the command exists and is executable.
+// Backticks aren't evaluated.
+func checkExecutable(command string) error {
+	args, err := shellwords.Parse(command)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("no program")
+	}
+	if strings.HasPrefix(args[0], "`") {
+		// the program is only known at runtime
+		return nil
+	}
+	_, err = exec.LookPath(args[0])
+	return err
+}
+
+// ValidationError is returned by NewResourceFromResourceConfig if the ResourceConfig is invalid.
+type ValidationError struct {
+	Resource string
+	Errors   []error
+}
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("invalid resource %q: %s", e.Resource, strings.Join(msgs, "; "))
+}
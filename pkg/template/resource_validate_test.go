@@ -0,0 +1,146 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type nilConnector struct{}
+
+func (c *nilConnector) Connect() (Backend, error) { return Backend{}, nil }
+
+func TestBackendConnectorsWithoutDescriber(t *testing.T) {
+	bc := BackendConnectors{(*nilConnector)(nil), &nilConnector{}, nil}
+	if n := bc.Count(); n != 1 {
+		t.Errorf("expected 1 configured backend, got %d", n)
+	}
+	if names := bc.Names(); len(names) != 1 || names[0] != "unknown" {
+		t.Errorf("unexpected names %v", names)
+	}
+}
+
+func TestResourceConfigValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src.tmpl")
+	if err := ioutil.WriteFile(src, []byte("static"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	valid := ResourceConfig{
+		Name:       "haproxy.toml",
+		Template:   []*Renderer{{Src: src, Dst: filepath.Join(dir, "conf.d", "haproxy.cfg")}},
+		Connectors: []BackendConnector{(*nilConnector)(nil), &nilConnector{}},
+		Exec:       ExecConfig{Command: "sh -c 'sleep 1'", KillTimeout: 5},
+
+		Notify: NotifyConfig{
+			WebhookURL:     "http://localhost:8080/hooks/remco",
+			WebhookHeaders: map[string]string{"Authorization": "Bearer token"},
+		},
+	}
+	if errs := valid.Validate(); len(errs) != 0 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+
+	invalid := ResourceConfig{
+		Name: "ha proxy",
+		Template: []*Renderer{
+			{Src: filepath.Join(dir, "missing.tmpl"), Dst: filepath.Join(src, "haproxy.cfg")},
+			{Dst: filepath.Join(dir, "{{.name}}.cfg")},
+		},
+		Connectors: []BackendConnector{(*nilConnector)(nil)},
+		Render:     RenderConfig{ProcessTimeout: -1, ReadinessGateFile: filepath.Join(src, "ready")},
+		Store:      StoreConfig{MergeStrategy: "random"},
+		Notify:     NotifyConfig{WebhookURL: "localhost:8080", WebhookTimeout: -1},
+		Exec:       ExecConfig{Command: "/nonexistent/haproxy -f cfg", KillTimeout: -1, Splay: -1, StartupSplay: -5, ReloadSignal: "SIGFOO"},
+	}
+	errs := invalid.Validate()
+	expected := []string{
+		`name "ha proxy"`,
+		"backend: at least one backend is required",
+		`template[0]: src "` + filepath.Join(dir, "missing.tmpl") + `" is not readable`,
+		`template[0]: the directory of dst`,
+		"template[1]: src is required",
+		"process_timeout: must not be negative, got -1",
+		`merge_strategy: "random" is not one of`,
+		`readiness_gate_file: the directory of "` + filepath.Join(src, "ready") + `" is not writable`,
+		"notify_webhook_timeout: must not be negative, got -1",
+		`notify_webhook_url: "localhost:8080" is not an http or https url`,
+		`exec.command "/nonexistent/haproxy -f cfg"`,
+		"exec.kill_timeout: must be positive",
+		"exec.splay: must not be negative, got -1",
+		"exec.startup_splay: must not be negative, got -5",
+		`exec.reload_signal "SIGFOO"`,
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for i, e := range expected {
+		if !strings.HasPrefix(errs[i].Error(), e) {
+			t.Errorf("expected an error starting with %q, got %q", e, errs[i])
+		}
+	}
+
+	_, err = NewResourceFromResourceConfig(context.Background(), &sync.RWMutex{}, invalid)
+	verr, ok := err.(ValidationError)
+	if !ok || len(verr.Errors) != len(expected) {
+		t.Errorf("expected a ValidationError, got %v", err)
+	}
+}
+
+func TestResourceConfigCheckTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-check")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	valid := write("valid.tmpl", "{% for kv in getAllKVs() %}{{ kv.Key }}{% endfor %}")
+	broken := write("broken.tmpl", "line 1\n{% for kv in getAllKVs() %}\n")
+	copied := write("copied.bin", "{% not a template")
+	noTemplate := false
+
+	rc := ResourceConfig{Template: []*Renderer{
+		{Src: valid, Dst: filepath.Join(dir, "{{.name}}.cfg"), CheckCmd: "test -f {{.src}}", ReloadCmd: "kill -HUP $(cat pid)"},
+		{Src: copied, Template: &noTemplate},
+		{Src: broken, Condition: "{% if %}", Dst: filepath.Join(dir, "{{.name}.cfg"), CheckCmd: "if true; then", ReloadCmd: "echo {{.dst"},
+	}}
+	errs := rc.CheckTemplates()
+	expected := []string{
+		"template[2]: " + broken + ":2:",
+		"template[2].condition: ",
+		"template[2].dst: ",
+		"template[2].check_cmd: ",
+		"template[2].reload_cmd: ",
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("expected %d errors, got %v", len(expected), errs)
+	}
+	for i, e := range expected {
+		if !strings.HasPrefix(errs[i].Error(), e) {
+			t.Errorf("expected an error starting with %q, got %q", e, errs[i])
+		}
+	}
+}
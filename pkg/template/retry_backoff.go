@@ -0,0 +1,102 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Valid values of RetryConfig.Jitter.
+const (
+	RetryJitterFull  = "full"
+	RetryJitterEqual = "equal"
+	RetryJitterNone  = "none"
+)
+
+// defaultRetryMax is the default of RetryConfig.Min and the minimum default of RetryConfig.Max.
+// Together with the default factor and jitter, the retries wait between 0 and 30 seconds.
+const defaultRetryMax = 30 * time.Second
+
+// retryBackoff computes the wait before the templates are processed again after a failure.
+//
+// The wait of an attempt is min * factor^(attempt-1), capped at max, and randomized by the jitter:
+// "full" waits between 0 and the wait, "equal" between half the wait and the wait, "none" exactly the wait.
+type retryBackoff struct {
+	min    time.Duration
+	max    time.Duration
+	factor float64
+	jitter string
+}
+
+func defaultRetryBackoff() retryBackoff {
+	return retryBackoff{min: defaultRetryMax, max: defaultRetryMax, factor: 1, jitter: RetryJitterFull}
+}
+
+// retryBackoff validates the retry settings and applies the defaults.
+func (r RetryConfig) backoff() (retryBackoff, error) {
+	b := defaultRetryBackoff()
+	if r.Min < 0 || r.Max < 0 {
+		return b, fmt.Errorf("retry_min and retry_max must not be negative")
+	}
+	if r.Min > 0 {
+		b.min = time.Duration(r.Min) * time.Second
+	} else if r.Max > 0 && time.Duration(r.Max)*time.Second < b.min {
+		b.min = time.Duration(r.Max) * time.Second
+	}
+	b.max = b.min
+	if b.max < defaultRetryMax {
+		b.max = defaultRetryMax
+	}
+	if r.Max > 0 {
+		b.max = time.Duration(r.Max) * time.Second
+	}
+	if b.max < b.min {
+		return b, fmt.Errorf("retry_max (%d) must not be smaller than retry_min (%d)", r.Max, r.Min)
+	}
+
+	switch {
+	case r.Factor == 0:
+	case r.Factor < 1:
+		return b, fmt.Errorf("retry_factor must be at least 1, got %v", r.Factor)
+	default:
+		b.factor = r.Factor
+	}
+
+	switch r.Jitter {
+	case "":
+	case RetryJitterFull, RetryJitterEqual, RetryJitterNone:
+		b.jitter = r.Jitter
+	default:
+		return b, fmt.Errorf("invalid retry_jitter %q", r.Jitter)
+	}
+	return b, nil
+}
+
+// wait returns the wait before the attempt (starting at 1).
+func (b retryBackoff) wait(attempt int) time.Duration {
+	d := float64(b.min) * math.Pow(b.factor, float64(attempt-1))
+	if d > float64(b.max) || math.IsInf(d, 0) {
+		d = float64(b.max)
+	}
+	wait := time.Duration(d)
+	if wait <= 0 {
+		return 0
+	}
+
+	switch b.jitter {
+	case RetryJitterFull:
+		return time.Duration(rand.Int63n(int64(wait)))
+	case RetryJitterEqual:
+		return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+	}
+	return wait
+}
@@ -0,0 +1,66 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryConfigBackoff(t *testing.T) {
+	tests := []struct {
+		config   RetryConfig
+		expected retryBackoff
+		valid    bool
+	}{
+		{RetryConfig{}, retryBackoff{30 * time.Second, 30 * time.Second, 1, RetryJitterFull}, true},
+		{RetryConfig{Min: 1, Factor: 2}, retryBackoff{time.Second, 30 * time.Second, 2, RetryJitterFull}, true},
+		{RetryConfig{Min: 60}, retryBackoff{60 * time.Second, 60 * time.Second, 1, RetryJitterFull}, true},
+		{RetryConfig{Max: 5}, retryBackoff{5 * time.Second, 5 * time.Second, 1, RetryJitterFull}, true},
+		{RetryConfig{Min: 1, Max: 120, Jitter: RetryJitterEqual}, retryBackoff{time.Second, 120 * time.Second, 1, RetryJitterEqual}, true},
+		{RetryConfig{Min: 10, Max: 5}, retryBackoff{}, false},
+		{RetryConfig{Min: -1}, retryBackoff{}, false},
+		{RetryConfig{Factor: 0.5}, retryBackoff{}, false},
+		{RetryConfig{Jitter: "random"}, retryBackoff{}, false},
+	}
+
+	for i, test := range tests {
+		b, err := test.config.backoff()
+		if (err == nil) != test.valid {
+			t.Errorf("test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if test.valid && b != test.expected {
+			t.Errorf("test %d: expected %+v, got %+v", i, test.expected, b)
+		}
+	}
+}
+
+func TestRetryBackoffWait(t *testing.T) {
+	b := retryBackoff{min: time.Second, max: 10 * time.Second, factor: 2, jitter: RetryJitterNone}
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		if wait := b.wait(attempt + 1); wait != expected {
+			t.Errorf("attempt %d: expected %s, got %s", attempt+1, expected, wait)
+		}
+	}
+	if wait := b.wait(10000); wait != b.max {
+		t.Errorf("expected the wait to be capped at %s, got %s", b.max, wait)
+	}
+
+	for i := 0; i < 100; i++ {
+		b.jitter = RetryJitterFull
+		if wait := b.wait(3); wait < 0 || wait >= 4*time.Second {
+			t.Errorf("full jitter: %s is out of range", wait)
+		}
+		b.jitter = RetryJitterEqual
+		if wait := b.wait(3); wait < 2*time.Second || wait > 4*time.Second {
+			t.Errorf("equal jitter: %s is out of range", wait)
+		}
+	}
+}
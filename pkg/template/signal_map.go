@@ -0,0 +1,57 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"os"
+
+	"github.com/hashicorp/consul-template/signals"
+	"github.com/pkg/errors"
+)
+
+// SignalMapIgnore is the value of an ExecConfig.SignalMap entry that isn't forwarded to the child.
+const SignalMapIgnore = "ignore"
+
+// signalMap parses the signal map of the configuration.
+// Ignored signals are mapped to nil.
+func (c ExecConfig) signalMap() (map[os.Signal]os.Signal, error) {
+	if len(c.SignalMap) == 0 {
+		return nil, nil
+	}
+	m := make(map[os.Signal]os.Signal, len(c.SignalMap))
+	for from, to := range c.SignalMap {
+		sig, err := signals.Parse(from)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid signal_map")
+		}
+		if to == SignalMapIgnore {
+			m[sig] = nil
+			continue
+		}
+		if m[sig], err = signals.Parse(to); err != nil {
+			return nil, errors.Wrapf(err, "invalid signal_map entry %s", from)
+		}
+	}
+	return m, nil
+}
+
+// mapSignal returns the signal that is forwarded to the child instead of s.
+// It returns false if s is ignored.
+func (t *Resource) mapSignal(s os.Signal) (os.Signal, bool) {
+	to, ok := t.signalMap[s]
+	if !ok {
+		return s, true
+	}
+	if to == nil {
+		t.logger.WithField("signal", s.String()).Debug("ignoring the signal")
+		return nil, false
+	}
+	t.logger.WithField("signal", s.String()).Debugf("forwarding the signal as %s", to)
+	return to, true
+}
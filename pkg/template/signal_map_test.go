@@ -0,0 +1,121 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv/mock"
+	"github.com/hashicorp/consul-template/signals"
+)
+
+func mustParseSignal(t *testing.T, name string) os.Signal {
+	s, err := signals.Parse(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestSignalMapConfig(t *testing.T) {
+	m, err := ExecConfig{}.signalMap()
+	if m != nil || err != nil {
+		t.Errorf("expected no signal map, got %v %v", m, err)
+	}
+
+	m, err = ExecConfig{SignalMap: map[string]string{"SIGUSR1": "ignore", "sigwinch": "SIGHUP"}}.signalMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if to, ok := m[mustParseSignal(t, "SIGUSR1")]; !ok || to != nil {
+		t.Errorf("expected SIGUSR1 to be ignored, got %v", to)
+	}
+	if to := m[mustParseSignal(t, "SIGWINCH")]; to != mustParseSignal(t, "SIGHUP") {
+		t.Errorf("expected SIGWINCH to be mapped to SIGHUP, got %v", to)
+	}
+
+	for _, invalid := range []map[string]string{
+		{"SIGFOO": "SIGHUP"},
+		{"SIGHUP": "SIGFOO"},
+		{"SIGHUP": ""},
+	} {
+		if _, err := (ExecConfig{SignalMap: invalid}).signalMap(); err == nil {
+			t.Errorf("expected an error for %v", invalid)
+		}
+	}
+}
+
+func TestMonitorSignalMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-signals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "signals")
+
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte("static"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := Backend{Name: "mock", Onetime: true, Keys: []string{"/"}}
+	b.ReadWatcher, _ = mock.New(nil, nil)
+
+	// the child dies on SIGUSR1 and records SIGHUP
+	child := filepath.Join(dir, "child.sh")
+	script := "#!/bin/sh\ntrap 'echo hup >> " + out + "' HUP\necho started >> " + out + "\nwhile true; do sleep 0.05; done\n"
+	if err := ioutil.WriteFile(child, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	exec := NewExecutor(child, "", "SIGKILL", 1, 0, newTestLogger())
+	res, err := NewResource([]Backend{b}, []*Renderer{{Src: src, Dst: filepath.Join(dir, "dst")}}, "test", exec, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.signalMap, err = ExecConfig{SignalMap: map[string]string{"SIGUSR1": "ignore", "SIGWINCH": "SIGHUP"}}.signalMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		res.Monitor(ctx)
+		close(done)
+	}()
+	waitForFile(t, out)
+
+	res.SignalChan <- mustParseSignal(t, "SIGUSR1")
+	res.SignalChan <- mustParseSignal(t, "SIGWINCH")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := ioutil.ReadFile(out)
+		if string(data) == "started\nhup\n" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected signals %q", string(data))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Monitor didn't return")
+	}
+	if res.Failed {
+		t.Error("the ignored SIGUSR1 killed the child")
+	}
+}
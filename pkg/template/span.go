@@ -0,0 +1,54 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/sirupsen/logrus"
+)
+
+// spanLoggerKey is the context key of the logger of a process call.
+type spanLoggerKey struct{}
+
+// newSpanID returns a random 8 character hex string that correlates the log entries of a process call.
+func newSpanID() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// withSpanLogger returns a copy of ctx that carries logger.
+func withSpanLogger(ctx context.Context, logger *logrus.Entry) context.Context {
+	return context.WithValue(ctx, spanLoggerKey{}, logger)
+}
+
+// loggerFrom returns the logger of the process call of ctx (with its span_id)
+// or the logger of the resource outside of a process call.
+func (t *Resource) loggerFrom(ctx context.Context) *logrus.Entry {
+	if logger, ok := ctx.Value(spanLoggerKey{}).(*logrus.Entry); ok {
+		return logger
+	}
+	return t.logger
+}
+
+// setRendererLogger sets the logger of all templates (and their fan-out items).
+// The logger is swapped under the store lock, which RenderToWriter holds while it renders.
+func (t *Resource) setRendererLogger(logger *logrus.Entry) {
+	t.storeMutex.Lock()
+	defer t.storeMutex.Unlock()
+	for _, s := range t.sources {
+		s.logger = logger
+		for _, item := range s.fanout {
+			item.logger = logger
+		}
+	}
+}
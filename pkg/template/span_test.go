@@ -0,0 +1,98 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/HeavyHorst/easykv/mock"
+	"github.com/sirupsen/logrus"
+)
+
+func TestNewSpanID(t *testing.T) {
+	valid := regexp.MustCompile(`^[0-9a-f]{8}$`)
+	ids := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := newSpanID()
+		if !valid.MatchString(id) {
+			t.Errorf("%q isn't an 8 character hex string", id)
+		}
+		ids[id] = true
+	}
+	if len(ids) < 99 {
+		t.Errorf("the span IDs should be random, got %d distinct IDs", len(ids))
+	}
+}
+
+func TestProcessSpanID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-span")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte(`{{ getv("/key") }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b := Backend{Name: "mock", Onetime: true, Keys: []string{"/"}}
+	b.ReadWatcher, _ = mock.New(nil, map[string]string{"/key": "value"})
+	exec := NewExecutor("", "", "", 0, 0, newTestLogger())
+	res, err := NewResource([]Backend{b}, []*Renderer{{Src: src, Dst: filepath.Join(dir, "dst")}}, "test", exec, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, buf := newJSONLogger()
+	logger.Logger.SetLevel(logrus.DebugLevel)
+	res.logger = logger
+	res.setRendererLogger(logger)
+
+	spans := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		if _, err := res.process(context.Background(), res.backends, true); err != nil {
+			t.Fatal(err)
+		}
+		res.recordRender(TriggerStartup, "", nil, nil)
+		spans[res.spanID] = true
+	}
+	if len(spans) != 2 {
+		t.Fatalf("every process call should have its own span ID, got %v", spans)
+	}
+
+	// every log entry of the process calls (including the ones of the templates) carries the span ID
+	entries := logEntries(t, buf)
+	var rendererEntries int
+	for _, e := range entries {
+		id, _ := e["span_id"].(string)
+		if !spans[id] {
+			t.Errorf("the log entry %v has no valid span ID", e)
+		}
+		if _, ok := e["config"]; ok {
+			rendererEntries++
+		}
+	}
+	if rendererEntries == 0 {
+		t.Error("expected log entries of the template")
+	}
+
+	history := res.Status().History
+	if len(history) != 2 || !spans[history[0].SpanID] || history[0].SpanID == history[1].SpanID {
+		t.Errorf("the render events should carry the span IDs, got %v", history)
+	}
+
+	// the templates log without span ID outside of process calls
+	if _, ok := res.sources[0].logger.Data["span_id"]; ok {
+		t.Error("the span logger of the templates wasn't reset")
+	}
+}
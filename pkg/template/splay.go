@@ -0,0 +1,64 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// randomSplay returns a random duration between 0 (inclusive) and max (exclusive).
+// It returns 0 if max isn't positive.
+func randomSplay(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// SetStartupSplay configures the maximum random delay in seconds before the child process is started,
+// e.g. to prevent all instances of a service from starting at the same time. 0 disables it.
+func (e *Executor) SetStartupSplay(splay int) {
+	e.startupSplay = time.Duration(splay) * time.Second
+}
+
+// waitStartupSplay waits a random time up to the startup splay before the child process is started.
+// It returns false if ctx is canceled during the wait.
+func (e *Executor) waitStartupSplay(ctx context.Context) bool {
+	if e.execCommand == "" {
+		return true
+	}
+	wait := randomSplay(e.startupSplay)
+	if wait == 0 {
+		return ctx.Err() == nil
+	}
+	e.logger.Info(fmt.Sprintf("waiting %s for the random startup splay", wait.Round(time.Millisecond)))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// waitReloadSplay waits a random time up to the splay before the child process is reloaded
+// and records it.
+func (e *Executor) waitReloadSplay() {
+	wait := randomSplay(e.splay)
+	if wait == 0 {
+		return
+	}
+	observeSeconds(reloadSplayKey, e.resource, wait)
+	e.logger.Debug(fmt.Sprintf("waiting %s for the random splay", wait.Round(time.Millisecond)))
+	time.Sleep(wait)
+}
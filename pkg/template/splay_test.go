@@ -0,0 +1,92 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRandomSplay(t *testing.T) {
+	for _, max := range []time.Duration{-time.Second, 0, time.Second, 10 * time.Second} {
+		for i := 0; i < 100; i++ {
+			d := randomSplay(max)
+			if max <= 0 && d != 0 {
+				t.Fatalf("the splay of %s should be 0, got %s", max, d)
+			}
+			if max > 0 && (d < 0 || d >= max) {
+				t.Fatalf("the splay of %s should be in [0, %s), got %s", max, max, d)
+			}
+		}
+	}
+}
+
+func TestExecutorSplay(t *testing.T) {
+	for _, splay := range []int{-1, 0, 1, 10} {
+		exec := NewExecutor("sleep 30", "SIGHUP", "", 0, splay, newTestLogger())
+		want := time.Duration(splay) * time.Second
+		if splay < 0 {
+			want = 0
+		}
+		if exec.splay != want {
+			t.Errorf("the splay %d should be %s, got %s", splay, want, exec.splay)
+		}
+	}
+
+	// the reload of a child without a splay doesn't wait
+	exec := NewExecutor("sleep 30", "SIGHUP", "", 1, 0, newTestLogger())
+	if err := exec.SpawnChild(); err != nil {
+		t.Fatal(err)
+	}
+	defer exec.StopChild()
+	start := time.Now()
+	if err := exec.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the reload without a splay took %s", elapsed)
+	}
+}
+
+func TestWaitStartupSplay(t *testing.T) {
+	exec := NewExecutor("sleep 30", "", "", 0, 0, newTestLogger())
+	if !exec.waitStartupSplay(context.Background()) {
+		t.Error("the child should be started without a startup splay")
+	}
+
+	exec.SetStartupSplay(1)
+	start := time.Now()
+	if !exec.waitStartupSplay(context.Background()) {
+		t.Error("the child should be started after the startup splay")
+	}
+	if elapsed := time.Since(start); elapsed >= 2*time.Second {
+		t.Errorf("the startup splay should be less than a second, took %s", elapsed)
+	}
+
+	// the wait is aborted if the resource is canceled
+	exec.SetStartupSplay(3600)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if exec.waitStartupSplay(ctx) {
+		t.Error("the wait should be aborted")
+	}
+}
+
+func TestWaitReloadSplay(t *testing.T) {
+	exec := NewExecutor("sleep 30", "SIGHUP", "", 0, 0, newTestLogger())
+	exec.splay = 200 * time.Millisecond
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		exec.waitReloadSplay()
+		if elapsed := time.Since(start); elapsed >= exec.splay+100*time.Millisecond {
+			t.Errorf("the reload splay should be less than %s, took %s", exec.splay, elapsed)
+		}
+	}
+}
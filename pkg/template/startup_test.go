@@ -0,0 +1,110 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv/mock"
+)
+
+// newFailingResource returns a resource whose only backend always fails, with a fast retry backoff.
+func newFailingResource(t *testing.T, dir, command string) *Resource {
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte("static"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b := Backend{Name: "mock", Keys: []string{"/"}}
+	b.ReadWatcher, _ = mock.New(fmt.Errorf("wrong prefix"), nil)
+
+	exec := NewExecutor(command, "", "SIGKILL", 1, 0, newTestLogger())
+	res, err := NewResource([]Backend{b}, []*Renderer{{Src: src, Dst: filepath.Join(dir, "dst")}}, "test", exec, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.logger, _ = newJSONLogger()
+	res.retry = retryBackoff{min: 10 * time.Millisecond, max: 10 * time.Millisecond, factor: 1, jitter: RetryJitterNone}
+	return res
+}
+
+// monitorUntilDone runs Monitor until it returns or the timeout expires.
+func monitorUntilDone(t *testing.T, res *Resource, timeout time.Duration) time.Duration {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	res.Monitor(ctx)
+	if ctx.Err() != nil {
+		t.Fatal("Monitor didn't give up")
+	}
+	return time.Since(start)
+}
+
+func TestStartupMaxRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-startup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	res := newFailingResource(t, dir, "")
+	res.startupMaxRetries = 2
+	monitorUntilDone(t, res, 5*time.Second)
+	if !res.Failed {
+		t.Error("the resource should fail after the startup retries are exhausted")
+	}
+}
+
+func TestStartupTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-startup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	res := newFailingResource(t, dir, "")
+	res.startupTimeout = 300 * time.Millisecond
+	if elapsed := monitorUntilDone(t, res, 5*time.Second); elapsed > 2*time.Second {
+		t.Errorf("the startup timeout should end the retries, took %s", elapsed)
+	}
+	if !res.Failed {
+		t.Error("the resource should fail after the startup timeout")
+	}
+}
+
+func TestStartChildOnFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-startup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	started := filepath.Join(dir, "started")
+
+	res := newFailingResource(t, dir, "sh -c 'echo started > "+started+"; sleep 60'")
+	res.startupMaxRetries = 1
+	res.startChildOnFailure = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		res.Monitor(ctx)
+		close(done)
+	}()
+	waitForFile(t, started)
+	cancel()
+	<-done
+	if res.Failed {
+		t.Error("the resource shouldn't fail with start_child_on_failure")
+	}
+}
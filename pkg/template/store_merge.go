@@ -0,0 +1,191 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"sync"
+
+	"github.com/HeavyHorst/memkv"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// A storeDiff collects the keys that changed in the backend stores since the last merge.
+// The backends may be fetched concurrently, so it is safe for concurrent use.
+type storeDiff struct {
+	mu   sync.Mutex
+	keys map[string]struct{}
+	// full is set until the first merge, which rebuilds the store.
+	full bool
+}
+
+func newStoreDiff() *storeDiff {
+	return &storeDiff{keys: make(map[string]struct{}), full: true}
+}
+
+// add records the changed keys. It is a no-op on a nil diff.
+func (d *storeDiff) add(keys []string) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, k := range keys {
+		d.keys[k] = struct{}{}
+	}
+}
+
+// take returns and clears the changed keys.
+// full is true if the store has to be rebuilt (always for a nil diff).
+func (d *storeDiff) take() (keys []string, full bool) {
+	if d == nil {
+		return nil, true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	keys = make([]string, 0, len(d.keys))
+	for k := range d.keys {
+		keys = append(keys, k)
+	}
+	full = d.full
+	d.keys = make(map[string]struct{})
+	d.full = false
+	return keys, full
+}
+
+// reset makes the next merge rebuild the store, e.g. after a merge failed halfway.
+func (d *storeDiff) reset() {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.full = true
+}
+
+// updateStore applies kvs to store: the keys missing in kvs are deleted,
+// the new and changed keys are set. It returns the keys that changed.
+func updateStore(store *memkv.Store, kvs map[string]string) []string {
+	var changed []string
+	current := storeValues(store)
+	for k := range current {
+		if _, ok := kvs[k]; !ok {
+			store.Del(k)
+			changed = append(changed, k)
+		}
+	}
+	for k, v := range kvs {
+		if cur, ok := current[k]; !ok || cur != v {
+			store.Set(k, v)
+			changed = append(changed, k)
+		}
+	}
+	return changed
+}
+
+// equalValues reports whether a and b hold the same KV-Pairs.
+func equalValues(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+// storeValues returns the KV-Pairs of store as a map.
+func storeValues(store *memkv.Store) map[string]string {
+	kvs := store.GetAllKVs()
+	values := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		values[kv.Key] = kv.Value
+	}
+	return values
+}
+
+// mergeChanges applies the changes of the backend stores since the last merge to the instance wide store.
+// The first merge rebuilds the store with mergeStores.
+//
+// The value of a key is resolved with the merge strategy of the resource, as in mergeStores.
+// Key collisions are only logged for the changed keys.
+// It returns an error if the merge strategy rejects a collision, the store is left unchanged
+// and the next merge rebuilds it then.
+func (t *Resource) mergeChanges(logger *logrus.Entry) error {
+	keys, full := t.diff.take()
+	if full {
+		return t.mergeStores(logger)
+	}
+
+	t.storeMutex.Lock()
+	defer t.storeMutex.Unlock()
+
+	// the changes are only applied if all collisions are resolved
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		var value string
+		var found int
+		for _, store := range t.sourceStores() {
+			kv, err := store.Get(key)
+			if err != nil {
+				continue
+			}
+			found++
+			if found == 1 {
+				value = kv.Value
+				continue
+			}
+			if value, err = t.resolveCollision(logger, key, value, kv.Value); err != nil {
+				t.diff.reset()
+				return err
+			}
+		}
+		if found > 0 {
+			values[key] = value
+		}
+	}
+
+	var updated int
+	for _, key := range keys {
+		value, found := values[key]
+		cur, err := t.store.Get(key)
+		switch {
+		case !found && err == nil:
+			t.store.Del(key)
+			updated++
+		case found && (err != nil || cur.Value != value):
+			t.store.Set(key, value)
+			updated++
+		}
+	}
+	if updated > 0 {
+		t.revision++
+	}
+
+	logger.WithFields(logrus.Fields{
+		"changed_keys": updated,
+	}).Debug("backend store changes merged")
+	return nil
+}
+
+// resolveCollision logs the collision of key and resolves it with the merge strategy of the resource.
+func (t *Resource) resolveCollision(logger *logrus.Entry, key, existing, incoming string) (string, error) {
+	logger.Warning("key collision - " + key)
+	strategy := t.mergeStrategy
+	if strategy == nil {
+		strategy = lastWins{}
+	}
+	value, _, err := strategy.Resolve(key, existing, incoming)
+	if err != nil {
+		return existing, withFailure(FailureBackend, errors.Wrap(err, "merging the backend stores failed"))
+	}
+	return value, nil
+}
@@ -0,0 +1,223 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/HeavyHorst/easykv/mock"
+	"github.com/HeavyHorst/memkv"
+	"github.com/sirupsen/logrus"
+)
+
+func newMergeTestResource(backends ...string) *Resource {
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	res := &Resource{store: memkv.New(), diff: newStoreDiff(), logger: logrus.NewEntry(logger)}
+	for _, name := range backends {
+		res.backends = append(res.backends, Backend{Name: name, store: memkv.New()})
+	}
+	return res
+}
+
+// update sets the values of the i-th backend like fetchVars.
+func (t *Resource) update(i int, kvs map[string]string) {
+	t.diff.add(updateStore(t.backends[i].store, kvs))
+}
+
+func TestUpdateStore(t *testing.T) {
+	store := memkv.New()
+	changed := updateStore(store, map[string]string{"/a": "1", "/b": "2"})
+	sort.Strings(changed)
+	if !reflect.DeepEqual(changed, []string{"/a", "/b"}) {
+		t.Errorf("all keys should be new, got %v", changed)
+	}
+
+	changed = updateStore(store, map[string]string{"/a": "1", "/b": "3", "/c": "4"})
+	sort.Strings(changed)
+	if !reflect.DeepEqual(changed, []string{"/b", "/c"}) {
+		t.Errorf("expected the changed key /b and the new key /c, got %v", changed)
+	}
+
+	changed = updateStore(store, map[string]string{"/c": "4"})
+	sort.Strings(changed)
+	if !reflect.DeepEqual(changed, []string{"/a", "/b"}) {
+		t.Errorf("expected the removed keys /a and /b, got %v", changed)
+	}
+	if kvs := store.GetAllKVs(); len(kvs) != 1 || kvs[0].Key != "/c" {
+		t.Errorf("unexpected store %v", kvs)
+	}
+}
+
+func TestMergeChanges(t *testing.T) {
+	incremental := newMergeTestResource("a", "b")
+	full := newMergeTestResource("a", "b")
+
+	updates := []struct {
+		backend int
+		kvs     map[string]string
+	}{
+		{0, map[string]string{"/a": "1", "/shared": "a"}},
+		{1, map[string]string{"/b": "1", "/shared": "b"}},
+		// the last backend wins a collision
+		{0, map[string]string{"/a": "2", "/shared": "a2"}},
+		// the key of the first backend is visible again
+		{1, map[string]string{"/b": "1"}},
+		{0, map[string]string{}},
+		{1, map[string]string{"/b": "2", "/c": "3"}},
+	}
+	for i, u := range updates {
+		incremental.update(u.backend, u.kvs)
+		incremental.mergeChanges(incremental.logger)
+		full.update(u.backend, u.kvs)
+		full.mergeStores(full.logger)
+		if got, expected := incremental.Snapshot(), full.Snapshot(); !reflect.DeepEqual(got, expected) {
+			t.Errorf("update %d: the incremental merge %v differs from the full merge %v", i, got, expected)
+		}
+	}
+	if v, _ := incremental.store.GetValue("/shared", "missing"); v != "missing" {
+		t.Errorf("/shared should have been removed, got %q", v)
+	}
+}
+
+// benchmarkMerge merges a backend with 10,000 keys after 1% of the keys changed.
+func benchmarkMerge(b *testing.B, incremental bool) {
+	const keys = 10000
+	res := newMergeTestResource("a")
+	kvs := make(map[string]string, keys)
+	for i := 0; i < keys; i++ {
+		kvs[fmt.Sprintf("/app/key%05d", i)] = "value"
+	}
+	res.update(0, kvs)
+	res.mergeStores(res.logger)
+	res.diff.take()
+
+	r := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		changed := make(map[string]string, len(kvs))
+		for k, v := range kvs {
+			changed[k] = v
+		}
+		for j := 0; j < keys/100; j++ {
+			changed[fmt.Sprintf("/app/key%05d", r.Intn(keys))] = fmt.Sprint(i)
+		}
+		b.StartTimer()
+
+		// fetchVars and the merge
+		res.update(0, changed)
+		if incremental {
+			res.mergeChanges(res.logger)
+		} else {
+			res.diff.take()
+			res.mergeStores(res.logger)
+		}
+	}
+}
+
+func BenchmarkMergeStoresFull(b *testing.B)        { benchmarkMerge(b, false) }
+func BenchmarkMergeStoresIncremental(b *testing.B) { benchmarkMerge(b, true) }
+
+func TestStoreRevision(t *testing.T) {
+	res := newMergeTestResource("a")
+	merge := func() uint64 {
+		t.Helper()
+		if err := res.mergeChanges(res.logger); err != nil {
+			t.Fatal(err)
+		}
+		return res.storeRevision()
+	}
+
+	res.update(0, map[string]string{"/a": "1"})
+	first := merge()
+	if first == 0 {
+		t.Error("the first merge should change the revision")
+	}
+
+	// a merge without changes keeps the revision
+	res.update(0, map[string]string{"/a": "1"})
+	if rev := merge(); rev != first {
+		t.Errorf("expected the revision %d, got %d", first, rev)
+	}
+	res.diff.reset()
+	if rev := merge(); rev != first {
+		t.Errorf("a rebuild of an unchanged store should keep the revision %d, got %d", first, rev)
+	}
+
+	res.update(0, map[string]string{"/a": "2"})
+	if rev := merge(); rev == first {
+		t.Error("a changed value should change the revision")
+	}
+}
+
+func TestBackendOnChange(t *testing.T) {
+	type change struct{ key, oldValue, newValue string }
+	var changes []change
+
+	client, _ := mock.New(nil, map[string]string{"/app/a": "1", "/app/b": "2"})
+	b := Backend{Name: "mock", Prefix: "/app", Keys: []string{"/"}, Onetime: true, ReadWatcher: client}
+	b.OnChange = func(key, oldValue, newValue string) {
+		changes = append(changes, change{key, oldValue, newValue})
+	}
+	res, err := NewResource([]Backend{b}, nil, "test", NewExecutor("", "", "", 0, 0, nil), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		data     map[string]string
+		expected []change
+	}{
+		{nil, []change{{"/a", "", "1"}, {"/b", "", "2"}}},
+		{map[string]string{"/app/a": "1", "/app/b": "2"}, nil},
+		{map[string]string{"/app/a": "3", "/app/c": "4"}, []change{{"/a", "1", "3"}, {"/b", "2", ""}, {"/c", "", "4"}}},
+	}
+	for i, s := range steps {
+		if s.data != nil {
+			client.Data = s.data
+		}
+		changes = nil
+		if err := res.setVars(context.Background(), res.backends[0]); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(changes, s.expected) {
+			t.Errorf("step %d: expected %v, got %v", i, s.expected, changes)
+		}
+	}
+}
+
+func TestBackendMaxKeys(t *testing.T) {
+	client, _ := mock.New(nil, map[string]string{"/app/a": "1", "/app/b": "2"})
+	b := Backend{Name: "mock", Prefix: "/app", Keys: []string{"/"}, Onetime: true, MaxKeys: 2, ReadWatcher: client}
+	res, err := NewResource([]Backend{b}, nil, "test", NewExecutor("", "", "", 0, 0, nil), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := res.setVars(context.Background(), res.backends[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// the keys of a fetch above the limit aren't loaded
+	client.Data = map[string]string{"/app/a": "3", "/app/b": "2", "/app/c": "4"}
+	err = res.setVars(context.Background(), res.backends[0])
+	if err == nil || !strings.Contains(err.Error(), "backend mock returned 3 keys, more than max_keys (2)") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if got := res.Snapshot(); !reflect.DeepEqual(got, map[string]string{"/a": "1", "/b": "2"}) {
+		t.Errorf("the store shouldn't have been updated, got %v", got)
+	}
+}
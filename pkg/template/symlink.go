@@ -0,0 +1,225 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/HeavyHorst/remco/pkg/template/fileutil"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Valid values for Renderer.SyncMode.
+const (
+	SyncModeReplace = "replace"
+	SyncModeSymlink = "symlink"
+)
+
+// versionDir returns the directory that holds the versioned files of Dst.
+func (s *Renderer) versionDir() string {
+	return s.Dst + ".d"
+}
+
+// syncSymlink is the syncFiles implementation for SyncMode "symlink".
+//
+// The stage file is moved to a versioned file named after its checksum.
+// The check command is executed against the versioned file before Dst is
+// atomically repointed to it. The reload command gets the new target as {{.dst}}
+// and the previous target as {{.prev}}.
+// It returns a boolean indicating if the symlink has changed and an error if any.
+func (s *Renderer) syncSymlink(runCommands bool) (bool, error) {
+	staged := s.stageFile.Name()
+	defer os.Remove(staged)
+
+	sum, err := fileutil.Checksum(staged, fileutil.CompareSHA256)
+	if err != nil {
+		return false, errors.Wrap(err, "checksum of stage file failed")
+	}
+
+	dir := s.versionDir()
+	target := filepath.Join(dir, sum)
+	// the symlink is relative to the directory of Dst
+	link := filepath.Join(filepath.Base(dir), sum)
+
+	prev, _ := os.Readlink(s.Dst)
+	if prev == link {
+		ok, err := fileutil.SameFileWith(staged, target, fileutil.CompareSHA256, s.logger)
+		if err != nil {
+			s.logger.Error(err.Error())
+		}
+		if ok {
+			s.logger.WithFields(logrus.Fields{
+				"config": s.Dst,
+				"target": target,
+			}).Debug("target config in sync")
+			return false, nil
+		}
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"config": s.Dst,
+		"target": target,
+	}).Info("target config out of sync")
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, errors.Wrap(err, "MkdirAll failed")
+	}
+
+	if err := s.preserveRegularFile(dir); err != nil {
+		return false, err
+	}
+
+	s.applyXattrs(staged)
+	if s.Fsync {
+		if err := fileutil.SyncFile(staged); err != nil {
+			return false, errors.Wrap(err, "fsync stage file failed")
+		}
+	}
+	if err := os.Rename(staged, target); err != nil {
+		return false, errors.Wrap(err, "couldn't move stage file to versioned file")
+	}
+
+	if runCommands {
+		if err := s.check(target); err != nil {
+			if prev != link {
+				os.Remove(target)
+			}
+			return false, errors.Wrap(err, "config check failed")
+		}
+	}
+
+	if err := s.switchSymlink(link); err != nil {
+		return false, err
+	}
+
+	if s.Fsync {
+		if err := fileutil.SyncDir(dir); err != nil {
+			return true, errors.Wrap(err, "fsync version directory failed")
+		}
+		if err := fileutil.SyncDir(filepath.Dir(s.Dst)); err != nil {
+			return true, errors.Wrap(err, "fsync target directory failed")
+		}
+	}
+
+	if err := s.pruneVersions(dir, filepath.Base(target), filepath.Base(prev)); err != nil {
+		s.logger.WithFields(logrus.Fields{
+			"config": s.Dst,
+		}).Error(errors.Wrap(err, "pruning old versions failed"))
+	}
+
+	if runCommands {
+		prevTarget := prev
+		if prev != "" && !filepath.IsAbs(prev) {
+			prevTarget = filepath.Join(filepath.Dir(s.Dst), prev)
+		}
+		if err := s.reloadWith(map[string]string{"dst": target, "prev": prevTarget}); err != nil {
+			return true, errors.Wrap(err, "reload command failed")
+		}
+	}
+
+	s.logger.WithFields(logrus.Fields{
+		"config": s.Dst,
+		"target": target,
+	}).Info("target config has been updated")
+
+	return true, nil
+}
+
+// preserveRegularFile moves a regular file at Dst into the version directory,
+// so that it isn't lost once Dst is replaced by the symlink.
+// The file stays in place until the symlink replaces it.
+func (s *Renderer) preserveRegularFile(dir string) error {
+	fi, err := os.Lstat(s.Dst)
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil
+	}
+
+	sum, err := fileutil.Checksum(s.Dst, fileutil.CompareSHA256)
+	if err != nil {
+		return errors.Wrap(err, "checksum of existing config failed")
+	}
+	backup := filepath.Join(dir, sum)
+
+	s.logger.WithFields(logrus.Fields{
+		"config": s.Dst,
+		"backup": backup,
+	}).Info("replacing regular file with symlink")
+
+	if fileutil.IsFileExist(backup) {
+		return nil
+	}
+	if err := os.Link(s.Dst, backup); err == nil {
+		return nil
+	}
+	// hard links are not supported - copy the file instead
+	contents, err := ioutil.ReadFile(s.Dst)
+	if err != nil {
+		return errors.Wrap(err, "couldn't read existing config")
+	}
+	if err := ioutil.WriteFile(backup, contents, fi.Mode()); err != nil {
+		return errors.Wrap(err, "couldn't preserve existing config")
+	}
+	return nil
+}
+
+// switchSymlink atomically points Dst to link.
+// A temporary symlink is created next to Dst and renamed over it.
+func (s *Renderer) switchSymlink(link string) error {
+	tmp := filepath.Join(filepath.Dir(s.Dst), fmt.Sprintf(".%s.%d.symlink", filepath.Base(s.Dst), os.Getpid()))
+	os.Remove(tmp)
+	if err := os.Symlink(link, tmp); err != nil {
+		return errors.Wrap(err, "couldn't create symlink")
+	}
+	if err := os.Rename(tmp, s.Dst); err != nil {
+		os.Remove(tmp)
+		return errors.Wrap(err, "couldn't replace target config with symlink")
+	}
+	return nil
+}
+
+// pruneVersions removes the oldest versioned files so that only KeepVersions files are left.
+// The current and the previous version are never removed.
+func (s *Renderer) pruneVersions(dir, current, prev string) error {
+	// the current version may be a reused version, mark it as the newest one
+	now := time.Now()
+	os.Chtimes(filepath.Join(dir, current), now, now)
+
+	keep := s.KeepVersions
+	if keep <= 0 {
+		keep = 5
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+
+	for i, f := range files {
+		if i < keep || f.Name() == current || f.Name() == prev {
+			continue
+		}
+		s.logger.WithFields(logrus.Fields{
+			"config":  s.Dst,
+			"version": f.Name(),
+		}).Debug("removing old version")
+		if err := os.Remove(filepath.Join(dir, f.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func stageContent(t *testing.T, r *Renderer, content string) {
+	f, err := ioutil.TempFile(filepath.Dir(r.Dst), ".stage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(content)
+	f.Close()
+	r.stageFile = f
+}
+
+func TestSyncSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-symlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dst := filepath.Join(dir, "app.conf")
+	// a pre-existing regular file must be preserved
+	if err := ioutil.WriteFile(dst, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Renderer{
+		Dst:          dst,
+		SyncMode:     SyncModeSymlink,
+		KeepVersions: 2,
+		ReloadCmd:    "test -f {{.dst}} && test -f {{.prev}}",
+		logger:       newTestLogger(),
+	}
+
+	for i, content := range []string{"v1", "v2", "v3"} {
+		stageContent(t, r, content)
+		changed, err := r.syncFiles(true)
+		if err != nil {
+			t.Fatalf("sync %d failed: %v", i, err)
+		}
+		if !changed {
+			t.Errorf("sync %d should change the target", i)
+		}
+		data, err := ioutil.ReadFile(dst)
+		if err != nil || string(data) != content {
+			t.Errorf("dst should contain %q, got %q (%v)", content, data, err)
+		}
+	}
+
+	fi, err := os.Lstat(dst)
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("dst should be a symlink")
+	}
+
+	// unchanged content doesn't touch the symlink
+	stageContent(t, r, "v3")
+	changed, err := r.syncFiles(true)
+	if err != nil || changed {
+		t.Errorf("sync of unchanged content should be a no-op, got changed=%v err=%v", changed, err)
+	}
+
+	files, _ := ioutil.ReadDir(r.versionDir())
+	if len(files) != 2 {
+		t.Errorf("%d versions should be kept, got %d", 2, len(files))
+	}
+}
+
+func TestSyncSymlinkCheckFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-symlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := &Renderer{
+		Dst:      filepath.Join(dir, "app.conf"),
+		SyncMode: SyncModeSymlink,
+		CheckCmd: "grep -q ^valid {{.src}}",
+		logger:   newTestLogger(),
+	}
+
+	stageContent(t, r, "invalid")
+	if _, err := r.syncFiles(true); err == nil {
+		t.Error("sync should fail if the check command fails")
+	}
+	if _, err := os.Lstat(r.Dst); !os.IsNotExist(err) {
+		t.Error("the symlink should not be created if the check command fails")
+	}
+}
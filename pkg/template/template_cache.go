@@ -0,0 +1,129 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/HeavyHorst/pongo2"
+)
+
+// templateCache holds the parsed templates of a Renderer by src.
+// A template is parsed again once one of the files it has been parsed from (src, the base templates
+// and the included templates) has a different modification time. It is safe for concurrent use.
+type templateCache struct {
+	mu        sync.RWMutex
+	templates map[string]*cachedTemplate
+}
+
+// cachedTemplate is a parsed template and the modification times of the files it has been parsed from.
+type cachedTemplate struct {
+	tmpl  *pongo2.Template
+	files map[string]time.Time
+}
+
+func newTemplateCache() *templateCache {
+	return &templateCache{templates: make(map[string]*cachedTemplate)}
+}
+
+// lookup returns the cached template of src if none of its files changed.
+// A nil cache never holds a template.
+func (c *templateCache) lookup(src string) (*pongo2.Template, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.RLock()
+	entry, ok := c.templates[src]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	for path, modTime := range entry.files {
+		fi, err := os.Stat(path)
+		if err != nil || !fi.ModTime().Equal(modTime) {
+			return nil, false
+		}
+	}
+	return entry.tmpl, true
+}
+
+// store caches the template of src. It is a no-op on a nil cache.
+func (c *templateCache) store(src string, tmpl *pongo2.Template, files map[string]time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.templates[src] = &cachedTemplate{tmpl: tmpl, files: files}
+}
+
+// statefulTag matches the pongo2 tags that keep their state in the parsed template between two executions.
+// The output of a template with these tags depends on the previous renders, so it is never cached.
+var statefulTag = regexp.MustCompile(`{%-?\s*(cycle|ifchanged)\b`)
+
+// recordingLoader records the modification times of the files read by a template set.
+// The file is stat'ed before it is read, so that a change during the parse is detected by the next lookup.
+type recordingLoader struct {
+	pongo2.TemplateLoader
+
+	mu    sync.Mutex
+	files map[string]time.Time
+	// stateful is set if one of the files uses a statefulTag.
+	stateful bool
+}
+
+func newRecordingLoader(loader pongo2.TemplateLoader) *recordingLoader {
+	return &recordingLoader{TemplateLoader: loader, files: make(map[string]time.Time)}
+}
+
+// Get records the modification time of path and returns its content.
+func (l *recordingLoader) Get(path string) (io.Reader, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return l.TemplateLoader.Get(path)
+	}
+	r, err := l.TemplateLoader.Get(path)
+	if err != nil {
+		return r, err
+	}
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.files[path] = fi.ModTime()
+	l.stateful = l.stateful || statefulTag.Match(content)
+	return bytes.NewReader(content), nil
+}
+
+// cacheable reports whether the parsed template may be reused.
+func (l *recordingLoader) cacheable() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return !l.stateful
+}
+
+// modTimes returns a copy of the recorded modification times.
+func (l *recordingLoader) modTimes() map[string]time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	files := make(map[string]time.Time, len(l.files))
+	for path, modTime := range l.files {
+		files[path] = modTime
+	}
+	return files
+}
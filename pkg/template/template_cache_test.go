@@ -0,0 +1,144 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTemplate writes content to path and sets its modification time to age ago,
+// so that a later change is detected even within the resolution of the file system.
+func writeTemplate(t testing.TB, path, content string, age time.Duration) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Now().Add(-age)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func renderString(t *testing.T, s *Renderer, funcMap map[string]interface{}) string {
+	var buf bytes.Buffer
+	if err := s.render(s.Src, funcMap, &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestTemplateCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-template-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	base := filepath.Join(dir, "base")
+	include := filepath.Join(dir, "include")
+	writeTemplate(t, base, "base {% block content %}{% endblock %}", time.Hour)
+	writeTemplate(t, include, "include", time.Hour)
+	writeTemplate(t, src, `{% block content %}{{ value }}|{% include "include" %}{% endblock %}`, time.Hour)
+
+	s := &Renderer{Src: src, BaseTemplate: "base", logger: newTestLogger(), templates: newTemplateCache()}
+	if out := renderString(t, s, map[string]interface{}{"value": "1"}); out != "base 1|include" {
+		t.Errorf("unexpected output %q", out)
+	}
+	cached, ok := s.templates.lookup(src)
+	if !ok {
+		t.Fatal("the template should be cached")
+	}
+	// the values aren't cached
+	if out := renderString(t, s, map[string]interface{}{"value": "2"}); out != "base 2|include" {
+		t.Errorf("unexpected output %q", out)
+	}
+	if tmpl, _ := s.templates.lookup(src); tmpl != cached {
+		t.Error("the unchanged template shouldn't be parsed again")
+	}
+
+	// every file the template has been parsed from is checked
+	for _, change := range []struct{ path, content, expected string }{
+		{src, `{% block content %}{{ value }}!|{% include "include" %}{% endblock %}`, "base 2!|include"},
+		{base, "new base {% block content %}{% endblock %}", "new base 2!|include"},
+		{include, "new include", "new base 2!|new include"},
+	} {
+		writeTemplate(t, change.path, change.content, 0)
+		if _, ok := s.templates.lookup(src); ok {
+			t.Errorf("the cached template should be stale after %s changed", change.path)
+		}
+		if out := renderString(t, s, map[string]interface{}{"value": "2"}); out != change.expected {
+			t.Errorf("after changing %s: expected %q, got %q", change.path, change.expected, out)
+		}
+	}
+
+	os.Remove(include)
+	if _, ok := s.templates.lookup(src); ok {
+		t.Error("the cached template should be stale after the include has been removed")
+	}
+}
+
+func TestTemplateCacheStatefulTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-template-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	writeTemplate(t, src, `{% for i in items %}{% cycle "a" "b" "c" %}{% endfor %}`, time.Hour)
+
+	// the cycle continues in the next execution of a parsed template
+	s := &Renderer{Src: src, logger: newTestLogger(), templates: newTemplateCache()}
+	funcMap := map[string]interface{}{"items": []int{1, 2}}
+	for i := 0; i < 3; i++ {
+		if out := renderString(t, s, funcMap); out != "ab" {
+			t.Errorf("render %d: expected %q, got %q", i, "ab", out)
+		}
+	}
+	if _, ok := s.templates.lookup(src); ok {
+		t.Error("a template with the cycle tag shouldn't be cached")
+	}
+}
+
+// benchmarkRender renders a template with 500 lines.
+func benchmarkRender(b *testing.B, cache *templateCache) {
+	dir, err := ioutil.TempDir("", "remco-template-cache")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var tmpl strings.Builder
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&tmpl, "[section%d]\n", i)
+		tmpl.WriteString("{% if enabled %}enabled = true{% else %}enabled = false{% endif %}\n")
+		tmpl.WriteString("{% for v in values %}value{{ forloop.Counter }} = {{ v|upper }}\n{% endfor %}\n")
+		tmpl.WriteString("name = {{ name }}\n")
+		tmpl.WriteString("# {{ name|lower }}\n")
+	}
+	src := filepath.Join(dir, "src")
+	writeTemplate(b, src, tmpl.String(), time.Hour)
+
+	s := &Renderer{Src: src, logger: newTestLogger(), templates: cache}
+	funcMap := map[string]interface{}{"enabled": true, "name": "Remco", "values": []string{"a", "b"}}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.render(src, funcMap, ioutil.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRenderUncached(b *testing.B) { benchmarkRender(b, nil) }
+func BenchmarkRenderCached(b *testing.B)   { benchmarkRender(b, newTemplateCache()) }
@@ -0,0 +1,94 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/HeavyHorst/pongo2"
+	"github.com/HeavyHorst/remco/pkg/template/fileutil"
+)
+
+// CheckTemplates parses the templates of the resource without rendering them.
+// It checks the syntax of the src, on_exit_src and condition templates,
+// the dst template in fan-out mode and the check and reload commands (with /bin/sh -n).
+// Template functions are only resolved when a template is rendered, so no backend is needed.
+// It returns all problems, a syntax error of a template file holds the file and the line.
+func (r ResourceConfig) CheckTemplates() []error {
+	var errs []error
+	addErr := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	for i, s := range r.Template {
+		// a missing src is reported by Validate
+		if s.isTemplate() && fileutil.IsFileExist(s.Src) {
+			if err := s.checkSyntax(s.Src); err != nil {
+				addErr("template[%d]: %v", i, err)
+			}
+		}
+		if s.OnExit == OnExitWriteTemplate && fileutil.IsFileExist(s.OnExitSrc) {
+			if err := s.checkSyntax(s.OnExitSrc); err != nil {
+				addErr("template[%d].on_exit_src: %v", i, err)
+			}
+		}
+		if s.Condition != "" {
+			if _, err := pongo2.FromString(s.Condition); err != nil {
+				addErr("template[%d].condition: %v", i, err)
+			}
+		}
+		if strings.Contains(s.Dst, "{{") {
+			if _, err := renderTemplate(s.Dst, map[string]string{"name": "name", "path": "/path"}); err != nil {
+				addErr("template[%d].dst: %v", i, err)
+			}
+		}
+		if s.CheckCmd != "" {
+			if err := checkShellSyntax(s.CheckCmd, map[string]string{"src": s.Dst}); err != nil {
+				addErr("template[%d].check_cmd: %v", i, err)
+			}
+		}
+		if s.ReloadCmd != "" {
+			if err := checkShellSyntax(s.ReloadCmd, map[string]string{"dst": s.Dst}); err != nil {
+				addErr("template[%d].reload_cmd: %v", i, err)
+			}
+		}
+	}
+	return errs
+}
+
+// checkSyntax parses the src template (and its base templates) like render does.
+func (s *Renderer) checkSyntax(src string) error {
+	set := pongo2.NewSet("check", newTemplateLoader(src, s.BaseTemplate))
+	set.Options = &pongo2.Options{
+		TrimBlocks:   true,
+		LStripBlocks: true,
+	}
+	if _, err := set.FromFile(src); err != nil {
+		return s.baseTemplateError(src, templateError(src, err))
+	}
+	return nil
+}
+
+// checkShellSyntax renders the command with data and checks its syntax with /bin/sh -n.
+func checkShellSyntax(command string, data map[string]string) error {
+	cmd, err := renderTemplate(command, data)
+	if err != nil {
+		return err
+	}
+	output, err := exec.Command("/bin/sh", "-n", "-c", cmd).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(output)); msg != "" {
+			return fmt.Errorf("%s", msg)
+		}
+		return err
+	}
+	return nil
+}
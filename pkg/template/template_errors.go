@@ -0,0 +1,145 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/HeavyHorst/pongo2"
+)
+
+// templateErrorContext is the number of lines shown before and after the failing line.
+const templateErrorContext = 2
+
+// sourceError is a template error annotated with its position and the surrounding template source.
+type sourceError struct {
+	filename string
+	line     int
+	column   int
+	excerpt  string
+	err      error
+}
+
+func (e *sourceError) Error() string {
+	msg := fmt.Sprintf("%s:%d:%d: %v", e.filename, e.line, e.column, e.err)
+	if e.excerpt != "" {
+		msg += "\n" + e.excerpt
+	}
+	return msg
+}
+
+// Cause returns the underlying pongo2 error.
+func (e *sourceError) Cause() error {
+	return e.err
+}
+
+// templateError annotates a pongo2 error with its position and the surrounding lines of the template source.
+// The failing line is marked with a caret under the column of the error.
+// Errors without line information are returned unchanged.
+func templateError(src string, err error) error {
+	perr, ok := err.(*pongo2.Error)
+	if !ok || perr.Line <= 0 {
+		return err
+	}
+
+	filename := perr.Filename
+	if filename == "" || filename == "<string>" {
+		filename = src
+	}
+
+	excerpt, _ := sourceExcerpt(filename, perr.Line, perr.Column)
+	return &sourceError{
+		filename: filename,
+		line:     perr.Line,
+		column:   perr.Column,
+		excerpt:  excerpt,
+		err:      err,
+	}
+}
+
+// sourceExcerpt returns the lines around line of the named file, prefixed with their line numbers.
+func sourceExcerpt(filename string, line, column int) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var b strings.Builder
+	scanner := bufio.NewScanner(f)
+	for l := 1; scanner.Scan() && l <= line+templateErrorContext; l++ {
+		if l < line-templateErrorContext {
+			continue
+		}
+		prefix := fmt.Sprintf("%5d | ", l)
+		b.WriteString(prefix + scanner.Text() + "\n")
+		if l == line && column > 0 {
+			b.WriteString(strings.Repeat(" ", len(prefix)+column-1) + "^\n")
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n"), scanner.Err()
+}
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// annotateFuncs wraps all functions of funcMap that return an error as their last value,
+// so that the error contains the function name and its arguments.
+// The arguments are replaced by <redacted> if redact is true.
+func annotateFuncs(funcMap map[string]interface{}, redact bool) {
+	for name, fn := range funcMap {
+		v := reflect.ValueOf(fn)
+		t := v.Type()
+		if t.Kind() != reflect.Func || t.NumOut() == 0 || t.Out(t.NumOut()-1) != errorType {
+			continue
+		}
+		funcMap[name] = reflect.MakeFunc(t, annotatedFunc(name, v, redact)).Interface()
+	}
+}
+
+func annotatedFunc(name string, fn reflect.Value, redact bool) func([]reflect.Value) []reflect.Value {
+	variadic := fn.Type().IsVariadic()
+	return func(args []reflect.Value) []reflect.Value {
+		var out []reflect.Value
+		if variadic {
+			out = fn.CallSlice(args)
+		} else {
+			out = fn.Call(args)
+		}
+
+		last := out[len(out)-1]
+		if last.IsNil() {
+			return out
+		}
+
+		var formatted []string
+		for i, a := range args {
+			if variadic && i == len(args)-1 {
+				for j := 0; j < a.Len(); j++ {
+					formatted = append(formatted, formatArg(a.Index(j), redact))
+				}
+				continue
+			}
+			formatted = append(formatted, formatArg(a, redact))
+		}
+		err := fmt.Errorf("%s(%s): %v", name, strings.Join(formatted, ", "), last.Interface())
+		out[len(out)-1] = reflect.ValueOf(&err).Elem()
+		return out
+	}
+}
+
+func formatArg(v reflect.Value, redact bool) string {
+	if redact {
+		return "<redacted>"
+	}
+	return fmt.Sprintf("%#v", v.Interface())
+}
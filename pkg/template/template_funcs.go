@@ -24,6 +24,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/HeavyHorst/memkv"
 	"github.com/HeavyHorst/remco/pkg/template/fileutil"
 )
 
@@ -93,11 +94,73 @@ func newFuncMap() map[string]interface{} {
 		"dateRFC3339": dateRFC3339Now,
 		"createMap":   createMap,
 		"createSet":   createSet,
+
+		"renderContextValue": renderContextValue,
 	}
 
 	return m
 }
 
+// newStoreFuncMap returns the template functions that query the given store
+// in addition to the functions of store.FuncMap.
+func newStoreFuncMap(store *memkv.Store) map[string]interface{} {
+	return map[string]interface{}{
+		"glob": func(pattern string) (memkv.KVPairs, error) {
+			return glob(store, pattern)
+		},
+		"globKeys": func(pattern string) ([]string, error) {
+			return globKeys(store, pattern)
+		},
+		"globValues": func(pattern string) ([]string, error) {
+			return globValues(store, pattern)
+		},
+		"getTree": func(prefix string) map[string]string {
+			return getTree(store, prefix)
+		},
+		"getTreeNested": func(prefix string) map[string]interface{} {
+			return getTreeNested(store, prefix)
+		},
+	}
+}
+
+// glob returns all KV-Pairs whose key matches pattern, sorted by key.
+// The pattern syntax is the one of path.Match, so "*" and "?" don't match "/".
+func glob(store *memkv.Store, pattern string) (memkv.KVPairs, error) {
+	kvs, err := store.GetAll(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(kvs)
+	return kvs, nil
+}
+
+// globKeys returns the keys of all KV-Pairs whose key matches pattern, sorted by key.
+func globKeys(store *memkv.Store, pattern string) ([]string, error) {
+	kvs, err := glob(store, pattern)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, len(kvs))
+	for i, kv := range kvs {
+		keys[i] = kv.Key
+	}
+	return keys, nil
+}
+
+// globValues returns the values of all KV-Pairs whose key matches pattern, sorted by key.
+// Unlike getvs the values are not sorted themselves, so they line up with globKeys.
+func globValues(store *memkv.Store, pattern string) ([]string, error) {
+	kvs, err := glob(store, pattern)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, len(kvs))
+	for i, kv := range kvs {
+		values[i] = kv.Value
+	}
+	return values, nil
+}
+
 func addFuncs(out, in map[string]interface{}) {
 	for name, fn := range in {
 		out[name] = fn
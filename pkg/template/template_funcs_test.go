@@ -12,6 +12,7 @@ import (
 	"net"
 	"os"
 
+	"github.com/HeavyHorst/memkv"
 	. "gopkg.in/check.v1"
 )
 
@@ -31,6 +32,67 @@ func (s *FunctionTestSuite) TestAddFuncs(t *C) {
 	t.Check(len(out), Equals, len(in))
 }
 
+func newGlobTestStore() *memkv.Store {
+	store := memkv.New()
+	for k, v := range map[string]string{
+		"/backends/web1/host":     "10.0.0.1",
+		"/backends/web1/port":     "80",
+		"/backends/web2/host":     "10.0.0.2",
+		"/backends/web2/port":     "8080",
+		"/backends/db1/host":      "10.0.1.1",
+		"/backends/db1/port":      "5432",
+		"/backends/db1/tls/cert":  "cert",
+		"/services/web/instances": "2",
+	} {
+		store.Set(k, v)
+	}
+	return store
+}
+
+func (s *FunctionTestSuite) TestGlob(t *C) {
+	store := newGlobTestStore()
+
+	kvs, err := glob(store, "/backends/web*/host")
+	t.Assert(err, IsNil)
+	t.Check(kvs, DeepEquals, memkv.KVPairs{
+		{Key: "/backends/web1/host", Value: "10.0.0.1"},
+		{Key: "/backends/web2/host", Value: "10.0.0.2"},
+	})
+
+	// * doesn't match the path separator
+	kvs, err = glob(store, "/backends/*")
+	t.Assert(err, IsNil)
+	t.Check(kvs, HasLen, 0)
+
+	kvs, err = glob(store, "/backends/*/*/*")
+	t.Assert(err, IsNil)
+	t.Check(kvs, DeepEquals, memkv.KVPairs{{Key: "/backends/db1/tls/cert", Value: "cert"}})
+
+	_, err = glob(store, "/backends/[")
+	t.Check(err, NotNil)
+}
+
+func (s *FunctionTestSuite) TestGlobKeysAndValues(t *C) {
+	store := newGlobTestStore()
+
+	keys, err := globKeys(store, "/backends/*/port")
+	t.Assert(err, IsNil)
+	t.Check(keys, DeepEquals, []string{"/backends/db1/port", "/backends/web1/port", "/backends/web2/port"})
+
+	// the values are in key order
+	values, err := globValues(store, "/backends/*/port")
+	t.Assert(err, IsNil)
+	t.Check(values, DeepEquals, []string{"5432", "80", "8080"})
+
+	keys, err = globKeys(store, "/backends/web?/host")
+	t.Assert(err, IsNil)
+	t.Check(keys, DeepEquals, []string{"/backends/web1/host", "/backends/web2/host"})
+
+	values, err = globValues(store, "/missing/*")
+	t.Assert(err, IsNil)
+	t.Check(values, HasLen, 0)
+}
+
 func (s *FunctionTestSuite) TestLookupIP(t *C) {
 	ips, err := lookupIP("localhost")
 	if err != nil {
@@ -110,10 +172,10 @@ func (s *FunctionTestSuite) TestInterfaceSet(t *C) {
 	set.Append(true)
 	set.Append(false)
 
-	t.Check(len(set.S), Equals, 4)
+	t.Check(len(set), Equals, 4)
 	t.Check(set.Contains("Hallo"), Equals, true)
 	set.Remove("Hallo")
-	t.Check(len(set.S), Equals, 3)
+	t.Check(len(set), Equals, 3)
 	t.Check(set.Contains("Hallo"), Equals, false)
 	t.Check(set.Contains(false), Equals, true)
 }
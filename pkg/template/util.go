@@ -13,12 +13,34 @@ package template
 
 import (
 	"path"
+	"strings"
 )
 
+// appendPrefix joins prefix and every key to an absolute, cleaned key.
+// Duplicate and trailing slashes are removed, so an empty prefix
+// or "/" doesn't produce keys like "//key".
 func appendPrefix(prefix string, keys []string) []string {
 	s := make([]string, len(keys))
 	for i, k := range keys {
-		s[i] = path.Join(prefix, k)
+		s[i] = path.Join("/", prefix, k)
 	}
 	return s
 }
+
+// stripPrefix removes prefix from key and returns the remaining absolute key.
+// The prefix is only removed on a path segment boundary, so the prefix "/app"
+// is not stripped from "/application".
+func stripPrefix(prefix, key string) string {
+	prefix = path.Join("/", prefix)
+	key = path.Join("/", key)
+	if prefix == "/" {
+		return key
+	}
+	if key == prefix {
+		return "/"
+	}
+	if strings.HasPrefix(key, prefix+"/") {
+		return key[len(prefix):]
+	}
+	return key
+}
@@ -0,0 +1,48 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"path"
+
+	"github.com/HeavyHorst/memkv"
+)
+
+// DefaultVariablesPrefix is the key prefix of the static variables if no prefix is configured.
+const DefaultVariablesPrefix = "/vars/"
+
+// variablesStore returns a store with the static variables under prefix (DefaultVariablesPrefix if empty).
+// It returns nil if there are no variables.
+func variablesStore(prefix string, vars map[string]string) *memkv.Store {
+	if len(vars) == 0 {
+		return nil
+	}
+	if prefix == "" {
+		prefix = DefaultVariablesPrefix
+	}
+	store := memkv.New()
+	for k, v := range vars {
+		store.Set(path.Join("/", prefix, k), v)
+	}
+	return store
+}
+
+// sourceStores returns the stores that are merged into the instance wide store, in the order of their precedence:
+// the value of a key is the value of the last store that holds it.
+// The static variables come first, so that the backends override them.
+func (t *Resource) sourceStores() []*memkv.Store {
+	stores := make([]*memkv.Store, 0, len(t.backends)+1)
+	if t.variables != nil {
+		stores = append(stores, t.variables)
+	}
+	for _, b := range t.backends {
+		stores = append(stores, b.store)
+	}
+	return stores
+}
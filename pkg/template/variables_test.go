@@ -0,0 +1,67 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"testing"
+
+	"github.com/HeavyHorst/easykv/mock"
+)
+
+func TestVariables(t *testing.T) {
+	b := Backend{Name: "mock", Keys: []string{"/"}}
+	client, _ := mock.New(nil, map[string]string{"/vars/role": "lb", "/app/port": "80"})
+	b.ReadWatcher = client
+
+	exec := NewExecutor("", "", "", 0, 0, nil)
+	res, err := NewResource([]Backend{b}, nil, "test", exec, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.variables = variablesStore("", map[string]string{"dc": "eu-west", "role": "worker", "tuning/max_conn": "100"})
+
+	if _, err := res.process(context.Background(), res.backends, false); err != nil {
+		t.Fatal(err)
+	}
+	// the backends override the static variables
+	expected := map[string]string{
+		"/vars/dc":              "eu-west",
+		"/vars/role":            "lb",
+		"/vars/tuning/max_conn": "100",
+		"/app/port":             "80",
+	}
+	if snapshot := res.Snapshot(); len(snapshot) != len(expected) {
+		t.Fatalf("unexpected store %v", snapshot)
+	}
+	for k, v := range expected {
+		if got, _ := res.store.GetValue(k); got != v {
+			t.Errorf("%s: expected %q, got %q", k, v, got)
+		}
+	}
+
+	// the static variable is used again once the backend key is removed
+	client.Data = map[string]string{"/app/port": "80"}
+	if _, err := res.process(context.Background(), res.backends, false); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := res.store.GetValue("/vars/role"); got != "worker" {
+		t.Errorf("expected the static variable, got %q", got)
+	}
+}
+
+func TestVariablesStorePrefix(t *testing.T) {
+	if variablesStore("", nil) != nil {
+		t.Error("expected no store without variables")
+	}
+	store := variablesStore("static", map[string]string{"/dc": "eu-west"})
+	if v, err := store.GetValue("/static/dc"); err != nil || v != "eu-west" {
+		t.Errorf("expected the variable under the prefix, got %q (%v)", v, err)
+	}
+}
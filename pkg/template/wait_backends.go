@@ -0,0 +1,110 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultWaitForBackendsInterval is the interval at which the backends are probed during wait_for_backends.
+const defaultWaitForBackendsInterval = 1 * time.Second
+
+// Pinger is implemented by backend clients that can check their connectivity cheaply.
+// Ping is used instead of reading a key to probe the backend during wait_for_backends,
+// it must return once ctx is done.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// probe checks whether the backend responds.
+// It pings the backend if the client implements Pinger and reads the first key of the backend otherwise.
+func (s Backend) probe(ctx context.Context) error {
+	if p, ok := s.ReadWatcher.(Pinger); ok {
+		ctx, cancel := context.WithTimeout(ctx, time.Duration(s.Timeout)*time.Second)
+		defer cancel()
+		return p.Ping(ctx)
+	}
+	keys := appendPrefix(s.Prefix, s.Keys)
+	if len(keys) > 1 {
+		keys = keys[:1]
+	}
+	_, err := s.getValues(ctx, keys)
+	return err
+}
+
+// waitForBackends probes the backends every waitInterval until all of them respond,
+// waitForBackendsTimeout has passed or ctx is done. The readiness of every backend is logged.
+// It returns the names of the backends that didn't respond in time, the retries of Monitor take over for them.
+func (t *Resource) waitForBackends(ctx context.Context) []string {
+	if t.waitForBackendsTimeout <= 0 {
+		return nil
+	}
+	parentCtx := ctx
+	ctx, cancel := context.WithTimeout(ctx, t.waitForBackendsTimeout)
+	defer cancel()
+
+	start := time.Now()
+	pending := make([]bool, len(t.backends))
+	for i := range pending {
+		pending[i] = true
+	}
+	ticker := time.NewTicker(t.waitInterval)
+	defer ticker.Stop()
+
+	for {
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for i, b := range t.backends {
+			if !pending[i] {
+				continue
+			}
+			wg.Add(1)
+			go func(i int, b Backend) {
+				defer wg.Done()
+				logger := t.logger.WithField("backend", b.Name)
+				if err := b.probe(ctx); err != nil {
+					logger.WithField("error", err).Debug("backend is not ready")
+					return
+				}
+				logger.WithField("waited", time.Since(start).Round(time.Millisecond).String()).Info("backend is ready")
+				mu.Lock()
+				pending[i] = false
+				mu.Unlock()
+			}(i, b)
+		}
+		wg.Wait()
+
+		var notReady []string
+		for i, p := range pending {
+			if p {
+				notReady = append(notReady, t.backends[i].Name)
+			}
+		}
+		if len(notReady) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if parentCtx.Err() == nil {
+				t.logger.WithFields(logrus.Fields{
+					"backends": strings.Join(notReady, ","),
+				}).Warning(fmt.Sprintf("wait_for_backends (%s) exceeded - trying to process the templates anyway", t.waitForBackendsTimeout))
+			}
+			return notReady
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HeavyHorst/easykv"
+	"github.com/HeavyHorst/easykv/mock"
+)
+
+// flakyClient fails the first failures reads and every read before until.
+type flakyClient struct {
+	easykv.ReadWatcher
+	mu       sync.Mutex
+	failures int
+	until    time.Time
+	reads    int
+}
+
+func (c *flakyClient) GetValues(keys []string) (map[string]string, error) {
+	c.mu.Lock()
+	c.reads++
+	failed := c.reads <= c.failures || time.Now().Before(c.until)
+	c.mu.Unlock()
+	if failed {
+		return nil, fmt.Errorf("connection refused")
+	}
+	return c.ReadWatcher.GetValues(keys)
+}
+
+// pingClient counts the pings, it never reads a key.
+type pingClient struct {
+	easykv.ReadWatcher
+	pings int
+}
+
+func (c *pingClient) Ping(ctx context.Context) error {
+	c.pings++
+	return nil
+}
+
+func (c *pingClient) GetValues(keys []string) (map[string]string, error) {
+	return nil, fmt.Errorf("the probe should ping the backend")
+}
+
+func newWaitTestResource(t *testing.T, dir string, clients ...easykv.ReadWatcher) *Resource {
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, []byte(`{{ getv("/key") }}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var backends []Backend
+	for i, c := range clients {
+		backends = append(backends, Backend{ReadWatcher: c, Name: fmt.Sprintf("mock%d", i), Onetime: true, Keys: []string{"/"}})
+	}
+	res, err := NewResource(backends, []*Renderer{{Src: src, Dst: filepath.Join(dir, "dst")}}, "test", NewExecutor("", "", "", 0, 0, newTestLogger()), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.logger = newTestLogger()
+	res.waitInterval = 10 * time.Millisecond
+	return res
+}
+
+func TestWaitForBackends(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-wait")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kv, _ := mock.New(nil, map[string]string{"/key": "value"})
+	flaky := &flakyClient{ReadWatcher: kv, failures: 3}
+	ping := &pingClient{ReadWatcher: kv}
+	res := newWaitTestResource(t, dir, flaky, ping)
+	res.waitForBackendsTimeout = 5 * time.Second
+
+	start := time.Now()
+	if notReady := res.waitForBackends(context.Background()); notReady != nil {
+		t.Errorf("all backends should be ready, got %v", notReady)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the backends should be probed at the wait interval, took %s", elapsed)
+	}
+	if flaky.reads != 4 {
+		t.Errorf("the flaky backend should be probed until it responds, got %d reads", flaky.reads)
+	}
+	if ping.pings != 1 {
+		t.Errorf("the ready backend should be pinged once, got %d pings", ping.pings)
+	}
+}
+
+func TestWaitForBackendsDeadline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-wait")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kv, _ := mock.New(nil, map[string]string{"/key": "value"})
+	down := &flakyClient{ReadWatcher: kv, until: time.Now().Add(time.Hour)}
+	res := newWaitTestResource(t, dir, down, kv)
+	res.waitForBackendsTimeout = 100 * time.Millisecond
+
+	if notReady := res.waitForBackends(context.Background()); !reflect.DeepEqual(notReady, []string{"mock0"}) {
+		t.Errorf("mock0 shouldn't be ready, got %v", notReady)
+	}
+
+	// the retries take over after the deadline
+	down.mu.Lock()
+	down.until = time.Now().Add(300 * time.Millisecond)
+	down.mu.Unlock()
+	res.startupMaxRetries = 100
+	res.retry = retryBackoff{min: 50 * time.Millisecond, max: 50 * time.Millisecond, factor: 1, jitter: RetryJitterNone}
+	monitorUntilDone(t, res, 5*time.Second)
+	if res.Failed || !res.Changed {
+		t.Errorf("the templates should be processed after the deadline: %v", res.Err)
+	}
+}
@@ -0,0 +1,235 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// A FileWatcher watches files for changes and emits debounced change events.
+//
+// The parent directories of the registered paths are watched instead of the files themselves,
+// so that atomic renames (write to a temp file, rename it over the original) and files that are
+// deleted and recreated are noticed. Directories that don't exist (yet) or that were removed
+// are added again as soon as they exist.
+type FileWatcher struct {
+	watcher  *fsnotify.Watcher
+	debounce time.Duration
+	maxWait  time.Duration
+	logger   *logrus.Entry
+	events   chan []string
+
+	mu       sync.Mutex
+	patterns []string
+	// dirs holds the watched directories, the value is false if the directory couldn't be watched (yet)
+	dirs map[string]bool
+}
+
+// maxWaitFactor limits the delay of a change event to maxWaitFactor times the debounce.
+const maxWaitFactor = 10
+
+// NewFileWatcher creates a new FileWatcher.
+// All events that occur within debounce are combined into one change event.
+// A steady stream of events is emitted at least every 10 times debounce.
+func NewFileWatcher(debounce time.Duration, logger *logrus.Entry) (*FileWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't create the fsnotify watcher")
+	}
+	if logger == nil {
+		logger = logrus.NewEntry(logrus.New())
+	}
+	return &FileWatcher{
+		watcher:  w,
+		debounce: debounce,
+		maxWait:  maxWaitFactor * debounce,
+		logger:   logger,
+		events:   make(chan []string),
+		dirs:     make(map[string]bool),
+	}, nil
+}
+
+// Add registers a path or a glob pattern (filepath.Match syntax) to watch.
+// Only the last path element may contain a pattern.
+func (w *FileWatcher) Add(pattern string) error {
+	pattern, err := filepath.Abs(pattern)
+	if err != nil {
+		return errors.Wrap(err, "couldn't get the absolute path")
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return errors.Wrapf(err, "invalid pattern %q", pattern)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.patterns = append(w.patterns, pattern)
+	dir := filepath.Dir(pattern)
+	if _, ok := w.dirs[dir]; !ok {
+		w.dirs[dir] = false
+		w.addDir(dir)
+	}
+	return nil
+}
+
+// Events returns the channel of the debounced change events.
+// Every event holds the sorted list of the changed paths.
+func (w *FileWatcher) Events() <-chan []string {
+	return w.events
+}
+
+// addDir tries to watch dir. w.mu must be held.
+func (w *FileWatcher) addDir(dir string) {
+	if err := w.watcher.Add(dir); err != nil {
+		w.logger.WithFields(logrus.Fields{
+			"dir": dir,
+		}).Debug("couldn't watch the directory (yet): ", err)
+		return
+	}
+	w.dirs[dir] = true
+}
+
+// retryDirs tries to watch all directories that aren't watched.
+// It returns true if a directory was added, changes inside it might have been missed.
+func (w *FileWatcher) retryDirs() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var added bool
+	for dir, watched := range w.dirs {
+		if !watched {
+			w.addDir(dir)
+			added = added || w.dirs[dir]
+		}
+	}
+	return added
+}
+
+// dirRemoved marks dir as not watched, inotify drops the watch of a removed directory.
+func (w *FileWatcher) dirRemoved(dir string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if watched, ok := w.dirs[dir]; ok && watched {
+		w.watcher.Remove(dir)
+		w.dirs[dir] = false
+	}
+}
+
+// matches reports whether name matches any registered pattern.
+func (w *FileWatcher) matches(name string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, p := range w.patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// existing returns all existing files that match a pattern.
+func (w *FileWatcher) existing() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var names []string
+	for _, p := range w.patterns {
+		matches, _ := filepath.Glob(p)
+		names = append(names, matches...)
+	}
+	return names
+}
+
+// Run processes the file system events until ctx is canceled.
+// The underlying fsnotify watcher is closed afterwards, the FileWatcher must not be used again.
+func (w *FileWatcher) Run(ctx context.Context) {
+	defer w.watcher.Close()
+
+	retry := time.NewTicker(time.Second)
+	defer retry.Stop()
+
+	pending := make(map[string]struct{})
+	// debounce fires once the events stopped, maxWait at the latest after the first pending event
+	var debounce, maxWait <-chan time.Time
+	touch := func() {
+		if len(pending) == 0 {
+			return
+		}
+		if maxWait == nil {
+			maxWait = time.After(w.maxWait)
+		}
+		debounce = time.After(w.debounce)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			name := filepath.Clean(event.Name)
+			if event.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				w.dirRemoved(name)
+			}
+			if event.Op == fsnotify.Chmod || !w.matches(name) {
+				continue
+			}
+			pending[name] = struct{}{}
+			touch()
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.logger.Error(errors.Wrap(err, "file watcher error"))
+		case <-retry.C:
+			if w.retryDirs() {
+				// the directory was (re)created, the watched files might have changed in the meantime
+				for _, name := range w.existing() {
+					pending[name] = struct{}{}
+				}
+				touch()
+			}
+		case <-debounce:
+			if !w.emit(ctx, pending) {
+				return
+			}
+			pending = make(map[string]struct{})
+			debounce, maxWait = nil, nil
+		case <-maxWait:
+			if !w.emit(ctx, pending) {
+				return
+			}
+			pending = make(map[string]struct{})
+			debounce, maxWait = nil, nil
+		}
+	}
+}
+
+// emit sends the sorted pending paths as a change event.
+// It returns false if ctx was canceled before the event was received.
+func (w *FileWatcher) emit(ctx context.Context, pending map[string]struct{}) bool {
+	changed := make([]string, 0, len(pending))
+	for name := range pending {
+		changed = append(changed, name)
+	}
+	sort.Strings(changed)
+	select {
+	case w.events <- changed:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
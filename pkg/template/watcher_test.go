@@ -0,0 +1,178 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestFileWatcher(t *testing.T, patterns ...string) (*FileWatcher, context.CancelFunc) {
+	w, err := NewFileWatcher(100*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range patterns {
+		if err := w.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go w.Run(ctx)
+	return w, cancel
+}
+
+func expectChange(t *testing.T, w *FileWatcher, expected ...string) {
+	t.Helper()
+	select {
+	case changed := <-w.Events():
+		if !reflect.DeepEqual(changed, expected) {
+			t.Errorf("changed files should be %v, got %v", expected, changed)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("no change event for %v", expected)
+	}
+}
+
+func expectNoChange(t *testing.T, w *FileWatcher) {
+	t.Helper()
+	select {
+	case changed := <-w.Events():
+		t.Errorf("there should be no change event, got %v", changed)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestFileWatcherDebounce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a, b := filepath.Join(dir, "a.toml"), filepath.Join(dir, "b.toml")
+	w, cancel := newTestFileWatcher(t, filepath.Join(dir, "*.toml"))
+	defer cancel()
+
+	for i := 0; i < 5; i++ {
+		ioutil.WriteFile(a, []byte{byte(i)}, 0644)
+	}
+	ioutil.WriteFile(b, []byte("b"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "c.json"), []byte("c"), 0644)
+
+	expectChange(t, w, a, b)
+	expectNoChange(t, w)
+}
+
+func TestFileWatcherMaxWait(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := filepath.Join(dir, "a.toml")
+	w, cancel := newTestFileWatcher(t, a)
+	defer cancel()
+
+	// the file is written more often than the debounce, the change is emitted after the max wait of a second
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(20 * time.Millisecond):
+				ioutil.WriteFile(a, []byte{byte(i)}, 0644)
+			}
+		}
+	}()
+	start := time.Now()
+	expectChange(t, w, a)
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("the change should be emitted after the max wait, took %s", elapsed)
+	}
+}
+
+func TestFileWatcherAtomicRename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config")
+	ioutil.WriteFile(file, []byte("1"), 0644)
+	w, cancel := newTestFileWatcher(t, file)
+	defer cancel()
+
+	tmp := filepath.Join(dir, ".config.tmp")
+	ioutil.WriteFile(tmp, []byte("2"), 0644)
+	if err := os.Rename(tmp, file); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(t, w, file)
+
+	// the file is still watched after it was replaced
+	ioutil.WriteFile(file, []byte("3"), 0644)
+	expectChange(t, w, file)
+}
+
+func TestFileWatcherDeleteAndRecreate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remco-watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sub := filepath.Join(dir, "conf.d")
+	file := filepath.Join(sub, "config")
+	os.Mkdir(sub, 0755)
+	ioutil.WriteFile(file, []byte("1"), 0644)
+	w, cancel := newTestFileWatcher(t, file)
+	defer cancel()
+
+	os.Remove(file)
+	expectChange(t, w, file)
+	ioutil.WriteFile(file, []byte("2"), 0644)
+	expectChange(t, w, file)
+
+	// the watched directory is recreated
+	os.RemoveAll(sub)
+	expectChange(t, w, file)
+	os.Mkdir(sub, 0755)
+	ioutil.WriteFile(file, []byte("3"), 0644)
+	expectChange(t, w, file)
+}
+
+func TestFileWatcherCancel(t *testing.T) {
+	w, err := NewFileWatcher(time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Run should return after the context is canceled")
+	}
+}
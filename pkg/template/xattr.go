@@ -0,0 +1,88 @@
+/*
+ * This file is part of remco.
+ * © 2016 The Remco Authors
+ *
+ * For the full copyright and license information, please view the LICENSE
+ * file that was distributed with this source code.
+ */
+
+package template
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/HeavyHorst/remco/pkg/template/fileutil"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	selinuxXattr  = "security.selinux"
+	posixACLXattr = "system.posix_acl_access"
+
+	// SELinuxLabelAuto looks up the default label of Dst with matchpathcon.
+	SELinuxLabelAuto = "auto"
+)
+
+// preserveXattr reports whether the extended attribute attr of Dst is applied to the stage file.
+// The SELinux label is always preserved, user attributes and POSIX ACLs only if PreserveXattrs is set.
+func (s *Renderer) preserveXattr(attr string) bool {
+	if attr == selinuxXattr {
+		return true
+	}
+	return s.PreserveXattrs && (strings.HasPrefix(attr, "user.") || attr == posixACLXattr)
+}
+
+// applyXattrs applies the SELinux label and, if enabled, the extended attributes
+// of the existing Dst to the stage file, so that they survive the rename.
+// If Dst doesn't exist SELinuxLabel is applied.
+// Failures are logged but never fail the sync, see reportXattrError.
+func (s *Renderer) applyXattrs(staged string) {
+	var err error
+	if fileutil.IsFileExist(s.Dst) {
+		err = fileutil.CopyXattrs(s.Dst, staged, s.preserveXattr)
+	} else if s.SELinuxLabel != "" {
+		err = s.applySELinuxLabel(staged)
+	}
+	s.reportXattrError(err)
+}
+
+// reportXattrError logs a failure of applyXattrs.
+// Unsupported filesystems are only reported once. Other errors, e.g. a missing permission to set the SELinux label,
+// are reported once until they change or the extended attributes were applied successfully, repetitions are logged at debug level.
+func (s *Renderer) reportXattrError(err error) {
+	switch {
+	case err == nil:
+		s.xattrErr = ""
+	case err == fileutil.ErrXattrNotSupported:
+		if !s.xattrWarned {
+			s.xattrWarned = true
+			s.logger.WithFields(logrus.Fields{
+				"config": s.Dst,
+			}).Warning("extended attributes are not supported - the SELinux label and xattrs are not preserved")
+		}
+	case err.Error() == s.xattrErr:
+		s.logger.WithFields(logrus.Fields{
+			"config": s.Dst,
+		}).Debug(errors.Wrap(err, "couldn't preserve extended attributes"))
+	default:
+		s.xattrErr = err.Error()
+		s.logger.WithFields(logrus.Fields{
+			"config": s.Dst,
+		}).Error(errors.Wrap(err, "couldn't preserve extended attributes"))
+	}
+}
+
+// applySELinuxLabel sets SELinuxLabel (or the label looked up by matchpathcon) on the stage file.
+func (s *Renderer) applySELinuxLabel(staged string) error {
+	label := s.SELinuxLabel
+	if label == SELinuxLabelAuto {
+		out, err := exec.Command("matchpathcon", "-n", s.Dst).Output()
+		if err != nil {
+			return errors.Wrap(err, "matchpathcon failed")
+		}
+		label = strings.TrimSpace(string(out))
+	}
+	return fileutil.SetXattr(staged, selinuxXattr, []byte(label))
+}